@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+	opts, outcome := options.NewProberOptions(os.Args)
+	if outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
+	}
+	// What's printed is the output.
+	logging.ReserveStdout()
+	logging.SetFileOptions(opts.LogAppend, opts.LogMaxSize, opts.LogKeep)
+	if err := logging.Initialize(ctx, opts.LogFile, opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := newProber(ctx, opts, os.Stdout).Run(); err != nil {
+		logging.Fatalln(err)
+	}
+}
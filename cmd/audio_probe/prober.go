@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Prints what's in the files given on the command line, or with -R, every
+// media file under the directories given. A file that can't be probed is
+// reported and doesn't stop the rest.
+type prober struct {
+	ctx    context.Context
+	opts   *options.ProberOptions
+	out    io.Writer
+	probe  func(context.Context, string) (*ffmpeg.MediaInfo, error)
+	failed int
+}
+
+// What's printed about a file. Fields that ffprobe didn't report are left out
+// of the JSON.
+type result struct {
+	File       string            `json:"file"`
+	Codec      string            `json:"codec,omitempty"`
+	SampleRate int               `json:"sample_rate,omitempty"`
+	Channels   string            `json:"channels,omitempty"`
+	BitRate    string            `json:"bit_rate,omitempty"`
+	Duration   float64           `json:"duration,omitempty"` // In seconds.
+	Tags       map[string]string `json:"tags,omitempty"`
+	CoverArt   bool              `json:"cover_art"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func newProber(ctx context.Context, opts *options.ProberOptions, out io.Writer) *prober {
+	return &prober{
+		ctx:  ctx,
+		opts: opts,
+		out:  out,
+		probe: func(ctx context.Context, name string) (*ffmpeg.MediaInfo, error) {
+			return ffmpeg.Probe(ctx, opts.FFprobe(), name)
+		},
+	}
+}
+
+// Probes each file in order, printing a table for each as it goes, or with
+// -json, an array of them all at the end. Returns an error if any file
+// couldn't be probed.
+func (p *prober) Run() error {
+	var results []result
+	tables := 0
+	for _, name := range p.files() {
+		if p.ctx.Err() != nil {
+			return context.Cause(p.ctx)
+		}
+		r := p.result(name)
+		if r.Error != "" {
+			p.fail(r.Error)
+		} else if !p.opts.JSON {
+			if err := p.writeTable(r, tables == 0); err != nil {
+				return err
+			}
+			tables++
+		}
+		results = append(results, r)
+	}
+	if p.opts.JSON {
+		enc := json.NewEncoder(p.out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	}
+	if p.failed > 0 {
+		return fmt.Errorf("%d errors probing %d files", p.failed, len(results))
+	}
+	return nil
+}
+
+// Returns the files to probe: those given, or with -R, the media files under
+// the directories given.
+func (p *prober) files() []string {
+	if !p.opts.Recursive {
+		return p.opts.Files
+	}
+	var files []string
+	for _, dir := range p.opts.Files {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				p.fail(err.Error())
+			} else if !d.IsDir() && ffmpeg.IsMediaFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// Returns what ffprobe says about name, or the error it gave.
+func (p *prober) result(name string) result {
+	r := result{File: name}
+	info, err := p.probe(p.ctx, name)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Codec = info.Codec
+	r.SampleRate = info.SampleRate
+	r.Channels = info.Channels
+	r.BitRate = info.BitRate
+	r.Duration = info.Duration.Seconds()
+	r.Tags = info.Tags
+	r.CoverArt = info.CoverArt
+	return r
+}
+
+// Reports a file that couldn't be probed.
+func (p *prober) fail(msg string) {
+	logging.Warnf("%s\n", msg)
+	p.failed++
+}
+
+// Writes r as a table of its fields then its tags, e.g.,
+//
+//	01 Song.flac
+//	  codec        flac
+//	  sample rate  44100 Hz
+//	  ...
+//	  artist       Someone
+//
+// Tables after the first are separated by a blank line.
+func (p *prober) writeTable(r result, first bool) error {
+	if !first {
+		fmt.Fprintln(p.out)
+	}
+	fmt.Fprintln(p.out, r.File)
+	tw := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	row := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "  %s\t%s\n", key, value)
+		}
+	}
+	row("codec", r.Codec)
+	if r.SampleRate > 0 {
+		row("sample rate", fmt.Sprintf("%d Hz", r.SampleRate))
+	}
+	row("channels", r.Channels)
+	row("bit rate", r.BitRate)
+	if r.Duration > 0 {
+		row("duration", time.Duration(r.Duration*float64(time.Second)).Round(time.Millisecond).String())
+	}
+	if r.CoverArt {
+		row("cover art", "yes")
+	} else {
+		row("cover art", "no")
+	}
+	for _, key := range slices.Sorted(maps.Keys(r.Tags)) {
+		row(key, r.Tags[key])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/options"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a prober for opts that writes to out instead of running ffprobe.
+// Files with "bad" in their name can't be probed.
+func newTestProber(t *testing.T, opts *options.ProberOptions, out *bytes.Buffer) *prober {
+	p := newProber(t.Context(), opts, out)
+	p.probe = func(_ context.Context, name string) (*ffmpeg.MediaInfo, error) {
+		if strings.Contains(filepath.Base(name), "bad") {
+			return nil, errors.New("probing " + name + " failed: exit status 1")
+		}
+		return &ffmpeg.MediaInfo{
+			InputInfo: ffmpeg.InputInfo{Codec: "flac", SampleRate: 44100, Channels: "stereo", Duration: 90500 * time.Millisecond, BitRate: "900 kb/s"},
+			Tags:      map[string]string{"title": filepath.Base(name), "artist": "Someone"},
+			CoverArt:  true,
+		}, nil
+	}
+	return p
+}
+
+func TestProberTable(t *testing.T) {
+	var out bytes.Buffer
+	p := newTestProber(t, &options.ProberOptions{Files: []string{"01.flac", "02.flac"}}, &out)
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := `01.flac
+  codec        flac
+  sample rate  44100 Hz
+  channels     stereo
+  bit rate     900 kb/s
+  duration     1m30.5s
+  cover art    yes
+  artist       Someone
+  title        01.flac
+
+02.flac
+  codec        flac
+  sample rate  44100 Hz
+  channels     stereo
+  bit rate     900 kb/s
+  duration     1m30.5s
+  cover art    yes
+  artist       Someone
+  title        02.flac
+`
+	if out.String() != expected {
+		t.Errorf("Bad table:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
+
+func TestProberJSON(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"A/01.flac", "A/02 bad.flac", "A/cover.jpg", "B/01.mp3"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	p := newTestProber(t, &options.ProberOptions{Files: []string{root}, Recursive: true, JSON: true}, &out)
+	if err := p.Run(); err == nil || !strings.Contains(err.Error(), "1 errors probing 3 files") {
+		t.Errorf("Expected an error for the bad file: %v", err)
+	}
+	var results []result
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil {
+		t.Fatalf("Bad JSON: %v\n%s", err, out.String())
+	}
+	var files []string
+	for _, r := range results {
+		files = append(files, strings.TrimPrefix(filepath.ToSlash(r.File), filepath.ToSlash(root)+"/"))
+	}
+	if strings.Join(files, ",") != "A/01.flac,A/02 bad.flac,B/01.mp3" {
+		t.Fatalf("Bad files: %q", files)
+	}
+	if r := results[0]; r.Codec != "flac" || r.Duration != 90.5 || !r.CoverArt || r.Tags["artist"] != "Someone" || r.Error != "" {
+		t.Errorf("Bad result: %+v", r)
+	}
+	if r := results[1]; r.Error == "" || r.Codec != "" {
+		t.Errorf("The bad file should only have an error: %+v", r)
+	}
+}
@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+	opts, outcome := options.NewEmbedderOptions(os.Args)
+	if outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
+	}
+	if err := logging.Initialize(ctx, "-", opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := ffmpeg.CheckFFmpeg(ctx, &opts.GlobalOptions); err != nil {
+		logging.Fatalln(err)
+	}
+	e := newEmbedder(ctx, opts)
+	run := e.Files
+	if opts.Recursive {
+		run = e.Tree
+	}
+	if err := run(); err != nil {
+		logging.Fatalln(err)
+	}
+}
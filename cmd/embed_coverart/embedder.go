@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Embeds cover art into the files given on the command line, or with -R, into
+// every album of a tree. A file that fails doesn't stop the rest.
+type embedder struct {
+	ctx    context.Context
+	opts   *options.EmbedderOptions
+	hasArt func(context.Context, string) (bool, error)
+	embed  func(ctx context.Context, image, audio string) ([]byte, error)
+	// Asks whether to replace the art of a file, when neither -n nor -y was
+	// given. Files aren't replaced without asking, so -R skips them.
+	confirm  func(name string) bool
+	embedded atomic.Int64 // Files given new art.
+	skipped  atomic.Int64 // Files that kept the art they had.
+	failed   atomic.Int64
+}
+
+func newEmbedder(ctx context.Context, opts *options.EmbedderOptions) *embedder {
+	e := &embedder{
+		ctx:  ctx,
+		opts: opts,
+		hasArt: func(ctx context.Context, name string) (bool, error) {
+			return ffmpeg.HasCoverArt(ctx, opts.FFprobe(), name)
+		},
+		embed: func(ctx context.Context, image, audio string) ([]byte, error) {
+			return ffmpeg.EmbedCoverArt(ctx, opts, image, audio)
+		},
+	}
+	if !opts.Recursive {
+		e.confirm = func(name string) bool {
+			return confirmReplace(name, os.Stdin, os.Stderr)
+		}
+	}
+	return e
+}
+
+// Embeds the image into each of the audio files, in order.
+func (e *embedder) Files() error {
+	var errs []error
+	for _, audio := range e.opts.AudioFiles {
+		if e.ctx.Err() != nil {
+			return context.Cause(e.ctx)
+		}
+		errs = append(errs, e.file(e.opts.ImageFile, audio))
+	}
+	e.report()
+	return errors.Join(errs...)
+}
+
+// Walks the -R directory, embedding the cover in each directory into the
+// media files next to it, one task on the work pool per file. Directories
+// without a cover are left alone.
+func (e *embedder) Tree() error {
+	root := filesystem.NewFileSystem(e.opts.Root)
+	pool := workpool.NewWorkPool(e.ctx, e.opts.MaxJobs, 0)
+	pool.Start()
+	defer pool.Stop()
+	err := fs.WalkDir(root, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logging.Printf("Skipping %q: %v", path, err)
+			return nil
+		} else if d.IsDir() || !ffmpeg.IsMediaFile(path) {
+			return nil
+		}
+		cover := pathpkg.Join(pathpkg.Dir(path), e.opts.CoverName)
+		if _, err := root.Stat(cover); err != nil {
+			logging.Verbosef("No %s for %q", e.opts.CoverName, path)
+			return nil
+		}
+		image := filepath.Join(e.opts.Root, cover)
+		audio := filepath.Join(e.opts.Root, path)
+		return pool.AddNamedContext(e.ctx, path, func() error { return e.file(image, audio) })
+	})
+	err = errors.Join(err, pool.Wait())
+	e.report()
+	return err
+}
+
+// Embeds image into audio, unless it already has art that isn't to be
+// replaced.
+func (e *embedder) file(image, audio string) error {
+	has, err := e.hasArt(e.ctx, audio)
+	if err != nil {
+		// Better to leave the art alone than to replace it without asking.
+		logging.Printf("Assuming %q has cover art: %v", audio, err)
+		has = true
+	}
+	if has && !e.opts.Overwrite && (e.opts.NoClobber || e.confirm == nil || !e.confirm(audio)) {
+		logging.Verbosef("Not replacing the cover art of %q", audio)
+		e.skipped.Add(1)
+		return nil
+	}
+	output, err := e.embed(e.ctx, image, audio)
+	if err != nil {
+		logging.Printf("!!! FAILED: %v !!!\n%s", err, output)
+		e.failed.Add(1)
+		return err
+	}
+	logging.Printf("Embedded %q into %q", image, audio)
+	e.embedded.Add(1)
+	return nil
+}
+
+// Logs how many files were done.
+func (e *embedder) report() {
+	logging.Printf("Embedded cover art into %d files, skipped %d that already had art, and failed %d",
+		e.embedded.Load(), e.skipped.Load(), e.failed.Load())
+}
+
+// Asks whether to replace the art of name, defaulting to no.
+func confirmReplace(name string, in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "File '%s' already has cover art. Replace it? [y/N] ", name)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
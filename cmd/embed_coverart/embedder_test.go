@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Returns an embedder for opts that records what it embeds instead of running
+// ffmpeg. Files with "art" in their name already have art, and embedding into
+// ones with "bad" in their name fails.
+func newTestEmbedder(t *testing.T, opts *options.EmbedderOptions) (*embedder, func() []string) {
+	var mutex sync.Mutex
+	var embedded []string
+	e := newEmbedder(t.Context(), opts)
+	e.hasArt = func(_ context.Context, name string) (bool, error) {
+		return strings.Contains(filepath.Base(name), "art"), nil
+	}
+	e.embed = func(_ context.Context, image, audio string) ([]byte, error) {
+		if strings.Contains(audio, "bad") {
+			return []byte("Invalid data found when processing input"), errors.New("exit status 1")
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		embedded = append(embedded, filepath.Base(filepath.Dir(image))+"/"+filepath.Base(image)+" -> "+filepath.Base(audio))
+		return nil, nil
+	}
+	return e, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		slices.Sort(embedded)
+		return embedded
+	}
+}
+
+func TestEmbedderFiles(t *testing.T) {
+	audio := []string{"01 Song.m4a", "02 Song art.m4a", "03 Song bad.m4a", "04 Song art.mp3"}
+	for _, tc := range []struct {
+		name      string
+		noClobber bool
+		overwrite bool
+		answer    bool
+		expected  []string
+	}{
+		{"ask no", false, false, false, []string{"01 Song.m4a"}},
+		{"ask yes", false, false, true, []string{"01 Song.m4a", "02 Song art.m4a", "04 Song art.mp3"}},
+		{"no clobber", true, false, true, []string{"01 Song.m4a"}},
+		{"overwrite", false, true, false, []string{"01 Song.m4a", "02 Song art.m4a", "04 Song art.mp3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &options.EmbedderOptions{ImageFile: "Album/cover.jpg", AudioFiles: audio}
+			opts.NoClobber, opts.Overwrite = tc.noClobber, tc.overwrite
+			e, embedded := newTestEmbedder(t, opts)
+			var asked []string
+			e.confirm = func(name string) bool {
+				asked = append(asked, name)
+				return tc.answer
+			}
+			if err := e.Files(); err == nil {
+				t.Error("Expected the bad file to fail")
+			}
+			var want []string
+			for _, name := range tc.expected {
+				want = append(want, "Album/cover.jpg -> "+name)
+			}
+			if got := embedded(); !slices.Equal(got, want) {
+				t.Errorf("Bad files embedded:\n got: %q\nwant: %q", got, want)
+			}
+			if !tc.noClobber && !tc.overwrite && !slices.Equal(asked, []string{"02 Song art.m4a", "04 Song art.mp3"}) {
+				t.Errorf("Should only ask about files with art: %q", asked)
+			} else if (tc.noClobber || tc.overwrite) && len(asked) > 0 {
+				t.Errorf("Shouldn't ask with -n or -y: %q", asked)
+			}
+			if e.failed.Load() != 1 {
+				t.Errorf("Expected 1 failure, have %d", e.failed.Load())
+			}
+		})
+	}
+}
+
+func TestEmbedderTree(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"A/cover.jpg", "A/01 Song.flac", "A/02 Song art.flac", "A/notes.txt",
+		"B/01 Song.mp3", "B/folder.jpg",
+		"C/Disc 1/cover.jpg", "C/Disc 1/01 Song.m4a",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := &options.EmbedderOptions{Root: root, CoverName: "cover.jpg", Recursive: true}
+	e, embedded := newTestEmbedder(t, opts)
+	if e.confirm != nil {
+		t.Error("-R shouldn't ask before replacing art")
+	}
+	if err := e.Tree(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"A/cover.jpg -> 01 Song.flac", "Disc 1/cover.jpg -> 01 Song.m4a"}
+	if got := embedded(); !slices.Equal(got, want) {
+		t.Errorf("Bad files embedded:\n got: %q\nwant: %q", got, want)
+	}
+	if e.skipped.Load() != 1 {
+		t.Errorf("The file with art should be skipped, have %d", e.skipped.Load())
+	}
+}
+
+func TestConfirmReplace(t *testing.T) {
+	for answer, expected := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var out strings.Builder
+		if got := confirmReplace("song.m4a", strings.NewReader(answer), &out); got != expected {
+			t.Errorf("confirmReplace with %q = %v", answer, got)
+		} else if !strings.Contains(out.String(), "song.m4a") {
+			t.Errorf("Bad prompt: %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The SHA-256 of a source, and the size and time it had when hashed.
+type sourceHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// Returns the SHA-256 of path in the input root. Sources are only hashed again
+// if their size or time has changed since, so that -checksum and
+// -report-duplicates can share the work.
+func (p *Exporter) hashSource(path string) (string, error) {
+	st, err := p.InRoot.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := p.hashes.Load(path); ok {
+		if h := v.(sourceHash); h.size == st.Size() && h.modTime.Equal(st.ModTime()) {
+			return h.sum, nil
+		}
+	}
+	sum, err := filesystem.HashFile(p.InRoot, path)
+	if err != nil {
+		return "", fmt.Errorf("failed hashing %q: %w", path, err)
+	}
+	p.hashes.Store(path, sourceHash{size: st.Size(), modTime: st.ModTime(), sum: sum})
+	return sum, nil
+}
+
+// Reports whether path has the same contents as when it was exported to
+// opath, according to -checksum.
+func (p *Exporter) sameContents(path, opath string) bool {
+	if p.checksums == nil {
+		return false
+	}
+	recorded, ok := p.checksums.Get(opath)
+	if !ok {
+		return false
+	}
+	sum, err := p.hashSource(path)
+	if err != nil {
+		logging.Println(err)
+		return false
+	}
+	return sum == recorded
+}
+
+// Records the checksum of path as the source of opath, for -checksum. Failures
+// are only logged, since the output itself is fine.
+func (p *Exporter) recordChecksum(path, opath string) {
+	if p.checksums == nil {
+		return
+	}
+	if sum, err := p.hashSource(path); err != nil {
+		logging.Println(err)
+	} else {
+		p.checksums.Set(opath, sum)
+	}
+}
+
+// Hashes path for -report-duplicates, if it's a media file. Called from tasks,
+// so that the hashing is spread over the pool rather than slowing the walk.
+func (p *Exporter) hashForDuplicates(path string) {
+	if !p.opts.ReportDuplicates || !p.isMedia(path) {
+		return
+	}
+	if _, err := p.hashSource(path); err != nil {
+		logging.Println(err)
+	}
+}
+
+// Returns the sources hashed so far that have the same contents as another,
+// grouped by contents. Each group is sorted, and so are the groups.
+func (p *Exporter) duplicates() [][]string {
+	bySum := make(map[string][]string)
+	p.hashes.Range(func(path, v any) bool {
+		sum := v.(sourceHash).sum
+		bySum[sum] = append(bySum[sum], path.(string))
+		return true
+	})
+	var groups [][]string
+	for _, paths := range bySum {
+		if len(paths) > 1 {
+			slices.Sort(paths)
+			groups = append(groups, paths)
+		}
+	}
+	slices.SortFunc(groups, func(a, b []string) int {
+		return strings.Compare(a[0], b[0])
+	})
+	return groups
+}
+
+// Logs the duplicate sources found by -report-duplicates.
+func (p *Exporter) reportDuplicates() {
+	groups := p.duplicates()
+	for _, paths := range groups {
+		logging.Printf("Duplicate sources: %q", paths)
+	}
+	logging.Printf("Found %d groups of duplicate sources", len(groups))
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExporterChecksum(t *testing.T) {
+	first := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.Checksum = true
+	})
+	writeFiles(t, first.opts.InRoot, "song.flac", "cover.jpg")
+	if err := first.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	sums, err := LoadChecksums(first.OutRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sums.Get("song.m4a"); !ok {
+		t.Errorf("Expected a checksum for song.m4a")
+	}
+
+	rerun := func(checksum bool) *Exporter {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.InRoot = first.opts.InRoot
+			opts.OutRoot = first.opts.OutRoot
+			opts.Update = true
+			opts.Checksum = checksum
+		})
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		return p
+	}
+	assert := func(p *Exporter, converted, copied, skipped int) {
+		t.Helper()
+		stats := p.Summary.Stats()
+		if stats.Converted != converted || stats.Copied != copied || stats.Skipped != skipped {
+			t.Errorf("Expected %d converted, %d copied, %d skipped: %+v", converted, copied, skipped, stats)
+		}
+	}
+	touch := func(name string) {
+		t.Helper()
+		future := time.Now().Add(time.Hour)
+		if err := os.Chtimes(filepath.Join(first.opts.InRoot, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the time changed, which -checksum sees through.
+	touch("song.flac")
+	touch("cover.jpg")
+	assert(rerun(true), 0, 0, 2)
+	assert(rerun(false), 1, 1, 0)
+
+	// The contents changed too.
+	if err := os.WriteFile(filepath.Join(first.opts.InRoot, "song.flac"), []byte("remastered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	touch("song.flac")
+	assert(rerun(true), 1, 0, 1)
+}
+
+func TestExporterReportDuplicates(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.ReportDuplicates = true
+	})
+	writeFiles(t, p.opts.InRoot, "A/song.flac", "B/song.flac", "C/other.flac", "A/cover.jpg", "B/cover.jpg")
+	for _, name := range []string{"B/song.flac", "C/other.flac"} {
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, name), []byte("A/song.flac"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"A/cover.jpg", "B/cover.jpg"} {
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, name), []byte("cover"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Identical art is normal, so only media files are compared.
+	expected := [][]string{{"A/song.flac", "B/song.flac", "C/other.flac"}}
+	if got := p.duplicates(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected duplicates %q, got %q", expected, got)
+	}
+}
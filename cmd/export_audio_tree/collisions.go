@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Tracks which source claimed each output name, so that two sources mapping to
+// the same output can be caught before one clobbers the other. E.g., with
+// -cleanpaths both "A:B.flac" and "A?B.flac" become "A_B.m4a".
+type nameTracker struct {
+	mutex    sync.Mutex
+	foldCase bool                // Treat names differing only by case as the same.
+	dedupe   bool                // Resolve collisions by renaming, rather than failing.
+	owners   map[string]string   // Output name (folded if foldCase) to source.
+	outputs  map[string]string   // Source to output name, when claimed.
+	fit      func(string) string // Shortens deduped names that got too long, if set.
+}
+
+func newNameTracker(foldCase, dedupe bool) *nameTracker {
+	return &nameTracker{
+		foldCase: foldCase,
+		dedupe:   dedupe,
+		owners:   make(map[string]string),
+		outputs:  make(map[string]string),
+	}
+}
+
+func (t *nameTracker) key(output string) string {
+	if t.foldCase {
+		return strings.ToLower(output)
+	}
+	return output
+}
+
+// Claims output for source and returns the name source should be written to.
+// Claiming again for the same source returns the same name. If another source
+// already claimed output, either an error is returned or, when deduping, the
+// first free name from dedupeName.
+func (t *nameTracker) claim(source, output string) (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if name, ok := t.outputs[source]; ok {
+		return name, nil
+	}
+	name := output
+	for n := 2; ; n++ {
+		owner, taken := t.owners[t.key(name)]
+		if !taken {
+			break
+		}
+		if !t.dedupe {
+			return "", fmt.Errorf("output %q for %q collides with %q", output, source, owner)
+		}
+		name = dedupeName(output, n)
+		if t.fit != nil {
+			name = t.fit(name)
+		}
+	}
+	t.owners[t.key(name)] = source
+	t.outputs[source] = name
+	return name, nil
+}
+
+// Claims output for source, like claim, unless another source already claimed
+// it. Then, nothing is claimed, and that source is returned as the owner.
+func (t *nameTracker) tryClaim(source, output string) (name, owner string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if name, ok := t.outputs[source]; ok {
+		return name, ""
+	} else if owner, taken := t.owners[t.key(output)]; taken {
+		return "", owner
+	}
+	t.owners[t.key(output)] = source
+	t.outputs[source] = output
+	return output, ""
+}
+
+// Returns the name claimed by source, if any.
+func (t *nameTracker) lookup(source string) (string, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	name, ok := t.outputs[source]
+	return name, ok
+}
+
+// Inserts " (n)" before the extension of name. E.g., "A_B.m4a" becomes
+// "A_B (2).m4a".
+func dedupeName(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", name[:len(name)-len(ext)], n, ext)
+}
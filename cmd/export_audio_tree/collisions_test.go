@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/options"
+	"slices"
+	"testing"
+)
+
+func TestNameTracker(t *testing.T) {
+	t.Run("collision", func(t *testing.T) {
+		names := newNameTracker(false, false)
+		if name, err := names.claim("A:B.flac", "A_B.m4a"); err != nil || name != "A_B.m4a" {
+			t.Fatalf("First claim: %q %v", name, err)
+		}
+		if name, err := names.claim("A:B.flac", "A_B.m4a"); err != nil || name != "A_B.m4a" {
+			t.Errorf("Claiming again should return the same name: %q %v", name, err)
+		}
+		if _, err := names.claim("A?B.flac", "A_B.m4a"); err == nil {
+			t.Errorf("Collision should have failed")
+		}
+		if _, ok := names.lookup("A?B.flac"); ok {
+			t.Errorf("Failed claim should not be recorded")
+		}
+	})
+	t.Run("dedupe", func(t *testing.T) {
+		names := newNameTracker(false, true)
+		for _, tc := range []struct{ source, expected string }{
+			{"A:B.flac", "A_B.m4a"},
+			{"A?B.flac", "A_B (2).m4a"},
+			{"A*B.flac", "A_B (3).m4a"},
+		} {
+			if name, err := names.claim(tc.source, "A_B.m4a"); err != nil || name != tc.expected {
+				t.Errorf("%s: got: %q %v expected: %q", tc.source, name, err, tc.expected)
+			}
+			if name, _ := names.lookup(tc.source); name != tc.expected {
+				t.Errorf("%s: lookup: %q expected: %q", tc.source, name, tc.expected)
+			}
+		}
+	})
+	t.Run("case", func(t *testing.T) {
+		sensitive := newNameTracker(false, false)
+		insensitive := newNameTracker(true, false)
+		for _, names := range []*nameTracker{sensitive, insensitive} {
+			if _, err := names.claim("Song.flac", "Song.m4a"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := sensitive.claim("song.flac", "song.m4a"); err != nil {
+			t.Errorf("Case sensitive targets should not collide: %v", err)
+		}
+		if _, err := insensitive.claim("song.flac", "song.m4a"); err == nil {
+			t.Errorf("Case insensitive targets should collide")
+		}
+	})
+}
+
+func TestDedupeName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		n        int
+		expected string
+	}{
+		{"A_B.m4a", 2, "A_B (2).m4a"},
+		{"dir/A_B.m4a", 10, "dir/A_B (10).m4a"},
+		{"README", 2, "README (2)"},
+	} {
+		if actual := dedupeName(tc.name, tc.n); actual != tc.expected {
+			t.Errorf("dedupeName(%q, %d): %q expected: %q", tc.name, tc.n, actual, tc.expected)
+		}
+	}
+}
+
+func TestExporterCollisions(t *testing.T) {
+	input := []string{"A:B.flac", "A?B.flac"}
+	clean := func(opts *options.ExporterOptions) {
+		opts.CleanPaths = "_"
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, clean)
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); err == nil {
+			t.Fatalf("Run should have failed")
+		}
+		if actual := listTree(t, p.opts.OutRoot); len(actual) != 0 {
+			t.Errorf("Nothing should have been exported: %q", actual)
+		}
+	})
+	t.Run("dedupe", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, clean, func(opts *options.ExporterOptions) {
+			opts.DedupeSuffix = true
+		})
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expected := []string{"A_B (2).m4a", "A_B.m4a"}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+	})
+	t.Run("normalized", func(t *testing.T) {
+		// Composed and decomposed é, as made on Linux and macOS.
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.NormalizeNames = filesystem.NormalizeNFC
+			opts.DedupeSuffix = true
+		})
+		writeFiles(t, p.opts.InRoot, "Caf\u00e9.flac", "Cafe\u0301.flac")
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expected := []string{"Caf\u00e9 (2).m4a", "Caf\u00e9.m4a"}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/cue"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"bytes"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"strconv"
+	"strings"
+)
+
+// The media files in a directory that -split-cue splits into tracks, going by
+// the CUE sheets there.
+type cueSplits struct {
+	tracks map[string][]cue.Track // Media file to its tracks.
+	sheets map[string]bool        // The sheets that split a media file.
+}
+
+// Returns the splits for dir, reading its sheets the first time.
+func (p *Exporter) cueSplits(dir string) *cueSplits {
+	if splits, ok := p.cues.Load(dir); ok {
+		return splits.(*cueSplits)
+	}
+	splits, _ := p.cues.LoadOrStore(dir, p.readCueSheets(dir))
+	return splits.(*cueSplits)
+}
+
+// Reads the CUE sheets in dir. A sheet that can't be read, or that names files
+// that aren't there, is left alone, and copied like any other file.
+func (p *Exporter) readCueSheets(dir string) *cueSplits {
+	splits := &cueSplits{tracks: make(map[string][]cue.Track), sheets: make(map[string]bool)}
+	entries, err := p.InRoot.ReadDir(dir)
+	if err != nil {
+		logging.Printf("Failed looking for CUE sheets in %q: %v", dir, err)
+		return splits
+	}
+	// Rippers often name the .wav they ripped to, rather than the .flac it
+	// was compressed to afterward, so the stem alone will do.
+	media := make(map[string]string)
+	for _, entry := range entries {
+		if name := entry.Name(); !entry.IsDir() && p.isMedia(name) {
+			media[name] = name
+			if stem := strings.TrimSuffix(name, pathpkg.Ext(name)); media[stem] == "" {
+				media[stem] = name
+			}
+		}
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(pathpkg.Ext(entry.Name()), ".cue") {
+			continue
+		}
+		name := pathpkg.Join(dir, entry.Name())
+		sheet, err := p.readCueSheet(name)
+		if err != nil {
+			logging.Printf("Not splitting with %q: %v", name, err)
+			continue
+		}
+		for _, file := range sheet.Files() {
+			found, ok := media[file]
+			if !ok {
+				found, ok = media[strings.TrimSuffix(file, pathpkg.Ext(file))]
+			}
+			path := pathpkg.Join(dir, found)
+			if !ok {
+				logging.Verbosef("Not splitting %q from %q, which isn't in the same directory", file, name)
+				continue
+			} else if _, ok := splits.tracks[path]; ok || p.lossyPolicy(path, options.LossyCopy) {
+				// Split by another sheet already, or copied as is.
+				continue
+			}
+			splits.tracks[path] = sheet.TracksOf(file)
+			splits.sheets[name] = true
+		}
+	}
+	return splits
+}
+
+// Reads and parses the CUE sheet at name in the input root.
+func (p *Exporter) readCueSheet(name string) (*cue.Sheet, error) {
+	data, err := p.InRoot.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return cue.Parse(bytes.NewReader(data))
+}
+
+// Adds a step to plan for each of tracks, the parts of the media file at path
+// that -split-cue converts on their own.
+func (p *Exporter) planTracks(plan *Plan, path string, d fs.DirEntry, tracks []cue.Track) error {
+	var size int64
+	if info, err := d.Info(); err == nil {
+		// Shared evenly, since how long each track is isn't known yet.
+		size = info.Size() / int64(len(tracks))
+	}
+	p.planParent(plan, path)
+	for i := range tracks {
+		track := &tracks[i]
+		opath, err := p.names.claim(trackKey(path, track), p.trackName(path, track))
+		if err != nil {
+			return err
+		}
+		step := Step{RelPath: path, Action: ActionConvert, OutPath: opath, Size: size, Track: track}
+		plan.Needed += p.estimate(step)
+		plan.Steps = append(plan.Steps, step)
+	}
+	return nil
+}
+
+// Returns what track of the media file at path is known by, e.g., in the
+// summary, since every track has the same source. E.g., "Album/Album.flac#03".
+func trackKey(path string, track *cue.Track) string {
+	return fmt.Sprintf("%s#%02d", path, track.Number)
+}
+
+// Maps track of the media file at path to its name in the output root, e.g.,
+// "Album/03 - Title.m4a".
+func (p *Exporter) trackName(path string, track *cue.Track) string {
+	title := track.Title
+	if title == "" {
+		title = "Track " + strconv.Itoa(track.Number)
+	}
+	// Otherwise, "AC/DC" would be a directory.
+	title = strings.ReplaceAll(title, "/", "-")
+	name := fmt.Sprintf("%02d - %s", track.Number, title)
+	return p.limitPath(p.cleanPath(pathpkg.Join(pathpkg.Dir(p.layoutPath(path)), name)) + "." + p.opts.Format)
+}
+
+// Returns the tags for track, as key=value for ffmpeg's -metadata.
+func trackMetadata(track *cue.Track) []string {
+	tags := []string{"track=" + strconv.Itoa(track.Number)}
+	for _, tag := range []struct{ key, value string }{
+		{"title", track.Title},
+		{"artist", track.Performer},
+		{"album", track.Album},
+	} {
+		if tag.value != "" {
+			tags = append(tags, tag.key+"="+tag.value)
+		}
+	}
+	return tags
+}
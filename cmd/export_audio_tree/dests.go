@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/options"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// Returns an exporter for each -dest of opts, in order. Each has a copy of
+// opts with the output root and format of its -dest, and the defaults of that
+// format merged in. They share a pool, so that -j limits them all together,
+// and the tags probed from the input.
+func newDestExporters(ctx context.Context, opts *options.ExporterOptions) []*Exporter {
+	var exporters []*Exporter
+	for _, dest := range opts.Dests {
+		dopts := *opts
+		dopts.Format, dopts.OutRoot = dest.Format, dest.OutRoot
+		dopts.Merge(ffmpeg.GetDefaultOptions("." + dest.Format))
+		ffmpeg.WarnGapless(&dopts.ConverterOptions)
+		e := newExporter(ctx, &dopts)
+		e.dest = dest.String()
+		if len(exporters) > 0 {
+			first := exporters[0]
+			e.pool, e.tags, e.ioSlots = first.pool, first.tags, first.ioSlots
+		}
+		exporters = append(exporters, e)
+	}
+	return exporters
+}
+
+// The -diff report for one -dest, as JSON.
+type destDiff struct {
+	Dest string `json:"dest"`
+	*Diff
+}
+
+// Writes the -diff report of each of exporters, as for -dest, to w as a JSON
+// array.
+func writeDestDiffs(w io.Writer, exporters []*Exporter, diffs []*Diff) error {
+	var reports []destDiff
+	for i, diff := range diffs {
+		reports = append(reports, destDiff{Dest: exporters[i].dest, Diff: diff})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
+
+// The statistics for one -dest, as JSON.
+type destStats struct {
+	Dest string `json:"dest"`
+	Stats
+}
+
+// Writes the statistics of the summary of each of exporters, as for -dest, to
+// w as a JSON array.
+func writeDestStats(w io.Writer, exporters []*Exporter) error {
+	var reports []destStats
+	for _, e := range exporters {
+		reports = append(reports, destStats{Dest: e.dest, Stats: e.Summary.Stats()})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/options"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExporterDests(t *testing.T) {
+	in, phone, sd := t.TempDir(), t.TempDir(), t.TempDir()
+	writeFiles(t, in, "Album/01.flac", "Album/02.mp3", "Album/cover.jpg")
+	opts := &options.ExporterOptions{
+		InRoot:         in,
+		CopyUnknown:    true,
+		RenameFromTags: true,
+		Manifest:       filepath.Join(t.TempDir(), "manifest.csv"),
+		Dests:          []options.Destination{{Format: "m4a", OutRoot: phone}, {Format: "mp3", OutRoot: sd}},
+	}
+	opts.CoverArtFormat = "copy"
+	exporters := newDestExporters(t.Context(), opts)
+	tags := map[string]map[string]string{
+		"01.flac": {"track": "1", "title": "One"},
+		"02.mp3":  {"track": "2", "title": "Two"},
+	}
+	var probes atomic.Int32
+	for _, e := range exporters {
+		e.convert = fakeConvert
+		e.probe = func(ctx context.Context, name string) (*ffmpeg.MediaInfo, error) {
+			probes.Add(1)
+			return &ffmpeg.MediaInfo{Tags: tags[filepath.Base(name)]}, nil
+		}
+	}
+	if err := runExport(exporters); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		root     string
+		expected []string
+	}{
+		{phone, []string{"Album", "Album/01 - One.m4a", "Album/02 - Two.m4a", "Album/cover.jpg"}},
+		{sd, []string{"Album", "Album/01 - One.mp3", "Album/02 - Two.mp3", "Album/cover.jpg"}},
+	} {
+		if actual := listTree(t, tc.root); !slices.Equal(actual, tc.expected) {
+			t.Errorf("Expected %q, have %q", tc.expected, actual)
+		}
+	}
+	if n := probes.Load(); n != 2 {
+		t.Errorf("Expected each media file to be probed once, have %d probes", n)
+	}
+	// The mp3 is copied as is to the mp3 destination.
+	for i, expected := range [][2]int{{2, 1}, {1, 2}} {
+		stats := exporters[i].Summary.Stats()
+		if stats.Converted != expected[0] || stats.Copied != expected[1] {
+			t.Errorf("%s: expected %d converted and %d copied, have %d and %d",
+				exporters[i].dest, expected[0], expected[1], stats.Converted, stats.Copied)
+		}
+	}
+
+	fp, err := os.Open(opts.Manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	rows, err := csv.NewReader(fp).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 7 || rows[0][len(rows[0])-1] != "dest" {
+		t.Fatalf("Expected a header with dest and 6 records, have %q", rows)
+	}
+	dests := make(map[string]int)
+	for _, row := range rows[1:] {
+		dests[row[len(row)-1]]++
+	}
+	for _, e := range exporters {
+		if dests[e.dest] != 3 {
+			t.Errorf("Expected 3 records for %s, have %d", e.dest, dests[e.dest])
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+)
+
+// How an output file compares to its source.
+type Change int
+
+const (
+	ChangeNew       Change = iota // There is no output yet.
+	ChangeStale                   // The source is newer than the output.
+	ChangeUnchanged               // The output is up to date.
+)
+
+func (c Change) String() string {
+	switch c {
+	case ChangeNew:
+		return "new"
+	case ChangeStale:
+		return "stale"
+	case ChangeUnchanged:
+		return "unchanged"
+	}
+	return fmt.Sprintf("Change(%d)", int(c))
+}
+
+// Compares a source file to its output, which is nil if the output doesn't
+// exist.
+func compareOutput(src, out fs.FileInfo) Change {
+	if out == nil {
+		return ChangeNew
+	}
+	if src.ModTime().After(out.ModTime()) {
+		return ChangeStale
+	}
+	return ChangeUnchanged
+}
+
+// Returns the members of outputs that are not in expected, sorted. These are
+// files that no longer have a source, and would be pruned.
+func findOrphans(expected map[string]bool, outputs []string) []string {
+	var orphans []string
+	for _, name := range outputs {
+		if !expected[name] {
+			orphans = append(orphans, name)
+		}
+	}
+	slices.Sort(orphans)
+	return orphans
+}
+
+// A source file and the output it maps to, relative to their roots.
+type DiffEntry struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
+}
+
+// What would change if the export were run now.
+type Diff struct {
+	New       []DiffEntry `json:"new"`
+	Stale     []DiffEntry `json:"stale"`
+	Unchanged []DiffEntry `json:"unchanged"`
+	Prune     []string    `json:"prune"`
+}
+
+// Compares the input and output roots without modifying anything.
+func (p *Exporter) Diff() (*Diff, error) {
+	diffs, err := diffAll([]*Exporter{p})
+	if err != nil {
+		return nil, err
+	}
+	return diffs[0], nil
+}
+
+// Like Diff, but for each of exporters, as for -dest. The input is only walked
+// once, and the diffs are in the same order as exporters.
+func diffAll(exporters []*Exporter) ([]*Diff, error) {
+	for _, e := range exporters {
+		var err error
+		if e.fingerprints, err = LoadFingerprints(e.OutRoot); err != nil {
+			return nil, err
+		}
+	}
+	plans, err := planAll(exporters[0].ctx, exporters)
+	if err != nil {
+		return nil, err
+	} else if err := plans[0].walkErr(); err != nil {
+		// Outputs of whatever couldn't be read would look like orphans.
+		return nil, err
+	}
+	var diffs []*Diff
+	for i, e := range exporters {
+		diff, err := e.diff(plans[i])
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+// Does the work of Diff for plan.
+func (p *Exporter) diff(plan *Plan) (*Diff, error) {
+	diff := &Diff{New: []DiffEntry{}, Stale: []DiffEntry{}, Unchanged: []DiffEntry{}, Prune: []string{}}
+	expected := map[string]bool{FingerprintsFile: true, ChecksumsFile: true}
+	for _, step := range plan.Steps {
+		entry := DiffEntry{Source: step.RelPath, Output: step.OutPath}
+		expected[entry.Output] = true
+		change, err := p.compare(step.RelPath, step.OutPath, false)
+		if err != nil {
+			return nil, err
+		}
+		switch change {
+		case ChangeNew:
+			diff.New = append(diff.New, entry)
+		case ChangeStale:
+			diff.Stale = append(diff.Stale, entry)
+		case ChangeUnchanged:
+			diff.Unchanged = append(diff.Unchanged, entry)
+		}
+	}
+
+	// Only the parts of the output root corresponding to the input are
+	// considered for pruning, which may not exist yet.
+	var outputs []string
+	var outRoots []string
+	for _, root := range p.roots() {
+		outRoots = append(outRoots, p.outPath(root))
+	}
+	err := p.walk(p.OutRoot, outRoots, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && slices.Contains(outRoots, path) {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if d.IsDir() && path == p.opts.CollectPlaylists {
+			// Written by -collect-playlists rather than from a source.
+			return fs.SkipDir
+		} else if d.IsDir() && filesystem.IsTrashDir(path) {
+			// Left by whatever serves the output, like a NAS.
+			return fs.SkipDir
+		} else if !d.IsDir() {
+			outputs = append(outputs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	diff.Prune = append(diff.Prune, findOrphans(expected, outputs)...)
+	return diff, nil
+}
+
+// Writes a human readable report. Unchanged files are only counted, since
+// there are usually a lot of them.
+func (d *Diff) WriteText(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	for _, section := range []struct {
+		name    string
+		entries []DiffEntry
+	}{
+		{"New", d.New},
+		{"Stale", d.Stale},
+	} {
+		printf("%s: %d\n", section.name, len(section.entries))
+		for _, e := range section.entries {
+			printf("  %s -> %s\n", e.Source, e.Output)
+		}
+	}
+	printf("Unchanged: %d\n", len(d.Unchanged))
+	printf("Prune: %d\n", len(d.Prune))
+	for _, name := range d.Prune {
+		printf("  %s\n", name)
+	}
+	return err
+}
+
+// Writes the report as JSON.
+func (d *Diff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCompareOutput(t *testing.T) {
+	now := time.Now()
+	mfs := fstest.MapFS{
+		"old": &fstest.MapFile{ModTime: now.Add(-time.Hour)},
+		"new": &fstest.MapFile{ModTime: now},
+	}
+	stat := func(name string) fs.FileInfo {
+		st, err := mfs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	for _, tc := range []struct {
+		src, out fs.FileInfo
+		expected Change
+	}{
+		{stat("new"), nil, ChangeNew},
+		{stat("new"), stat("old"), ChangeStale},
+		{stat("old"), stat("new"), ChangeUnchanged},
+		{stat("new"), stat("new"), ChangeUnchanged},
+	} {
+		if actual := compareOutput(tc.src, tc.out); actual != tc.expected {
+			t.Errorf("compareOutput(%v, %v): actual: %s expected: %s", tc.src, tc.out, actual, tc.expected)
+		}
+	}
+}
+
+func TestFindOrphans(t *testing.T) {
+	expected := map[string]bool{"a/1.m4a": true, "a/cover.jpg": true}
+	outputs := []string{"b/gone.m4a", "a/1.m4a", "a/cover.jpg", "a/2.m4a"}
+	if actual := findOrphans(expected, outputs); !slices.Equal(actual, []string{"a/2.m4a", "b/gone.m4a"}) {
+		t.Errorf("Bad orphans: %q", actual)
+	}
+	if actual := findOrphans(expected, nil); len(actual) != 0 {
+		t.Errorf("Orphans from nothing: %q", actual)
+	}
+}
+
+func TestExporterDiff(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.CleanPaths = "_"
+	})
+	writeFiles(t, p.opts.InRoot, "A?/new.flac", "A?/stale.flac", "A?/same.flac", "A?/cover.jpg", "A?/.DS_Store")
+	writeFiles(t, p.opts.OutRoot, "A_/stale.m4a", "A_/same.m4a", "A_/cover.jpg", "B/gone.m4a")
+
+	// Make the stale output older than its source, and the rest newer.
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	touch := func(path string, when time.Time) {
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch(filepath.Join(p.opts.OutRoot, "A_/stale.m4a"), past)
+	touch(filepath.Join(p.opts.OutRoot, "A_/same.m4a"), future)
+	touch(filepath.Join(p.opts.OutRoot, "A_/cover.jpg"), future)
+
+	diff, err := p.Diff()
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	assert := func(name string, actual []DiffEntry, expected ...DiffEntry) {
+		if !slices.Equal(actual, expected) {
+			t.Errorf("Bad %s entries:\nactual  : %+v\nexpected: %+v", name, actual, expected)
+		}
+	}
+	assert("new", diff.New, DiffEntry{"A?/new.flac", "A_/new.m4a"})
+	assert("stale", diff.Stale, DiffEntry{"A?/stale.flac", "A_/stale.m4a"})
+	assert("unchanged", diff.Unchanged, DiffEntry{"A?/cover.jpg", "A_/cover.jpg"}, DiffEntry{"A?/same.flac", "A_/same.m4a"})
+	if !slices.Equal(diff.Prune, []string{"B/gone.m4a"}) {
+		t.Errorf("Bad prune entries: %q", diff.Prune)
+	}
+
+	var b bytes.Buffer
+	if err := diff.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"New: 1", "A?/new.flac -> A_/new.m4a", "Unchanged: 2", "Prune: 1", "B/gone.m4a"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("Text report is missing %q:\n%s", s, b.String())
+		}
+	}
+	b.Reset()
+	if err := diff.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Diff
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil || len(decoded.Unchanged) != 2 {
+		t.Errorf("JSON report did not round trip: %v\n%s", err, b.String())
+	}
+
+	// Nothing should have been touched.
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, []string{"A_", "A_/cover.jpg", "A_/same.m4a", "A_/stale.m4a", "B", "B/gone.m4a"}) {
+		t.Errorf("Diff modified the output tree: %q", actual)
+	}
+}
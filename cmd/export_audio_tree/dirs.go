@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import "sync"
+
+// Creates each output directory exactly once, no matter how many tasks need it
+// at the same time. Tasks asking for a directory that's being created block
+// until it's done. The outcome, including failure, is remembered for the rest
+// of the run, so asking again is cheap.
+type dirEnsurer struct {
+	mutex sync.Mutex
+	dirs  map[string]*dirCall
+}
+
+// A directory that has been or is being created.
+type dirCall struct {
+	done chan struct{} // Closed once err is set.
+	err  error
+}
+
+func newDirEnsurer() *dirEnsurer {
+	return &dirEnsurer{dirs: make(map[string]*dirCall)}
+}
+
+// Ensures the directory dir exists by calling create, unless another call for
+// dir already has. Returns the error from create.
+func (e *dirEnsurer) ensure(dir string, create func() error) error {
+	e.mutex.Lock()
+	if call, ok := e.dirs[dir]; ok {
+		e.mutex.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &dirCall{done: make(chan struct{})}
+	e.dirs[dir] = call
+	e.mutex.Unlock()
+
+	call.err = create()
+	close(call.done)
+	return call.err
+}
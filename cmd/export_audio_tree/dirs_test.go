@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDirEnsurer(t *testing.T) {
+	t.Run("once", func(t *testing.T) {
+		e := newDirEnsurer()
+		var calls [10]atomic.Int32
+		var created [10]atomic.Bool
+		var wg sync.WaitGroup
+		for range 100 {
+			for i := range calls {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := e.ensure(fmt.Sprintf("dir%d", i), func() error {
+						calls[i].Add(1)
+						created[i].Store(true)
+						return nil
+					})
+					if err != nil {
+						t.Errorf("dir%d: %v", i, err)
+					} else if !created[i].Load() {
+						t.Errorf("dir%d: returned before it was created", i)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+		for i := range calls {
+			if n := calls[i].Load(); n != 1 {
+				t.Errorf("dir%d created %d times", i, n)
+			}
+		}
+	})
+	t.Run("error", func(t *testing.T) {
+		e := newDirEnsurer()
+		failure := errors.New("read-only file system")
+		var calls atomic.Int32
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := e.ensure("dir", func() error {
+					calls.Add(1)
+					return failure
+				})
+				if !errors.Is(err, failure) {
+					t.Errorf("Expected the error to be shared: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := calls.Load(); n != 1 {
+			t.Errorf("Failed directory attempted %d times", n)
+		}
+	})
+}
+
+func TestExporterEnsureParent(t *testing.T) {
+	p := newTestExporter(t, fakeConvert)
+	writeFiles(t, p.opts.InRoot, "Artist/Album/cover.jpg", "Artist/Album/song.flac")
+	if err := os.Chmod(filepath.Join(p.opts.InRoot, "Artist/Album"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing was planned, so none of the directories exist yet.
+	if err := p.Copy("Artist/Album/cover.jpg"); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	// Named into a directory that isn't the output of its own.
+	if _, err := p.names.claim("Artist/Album/song.flac", "Other/song.m4a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Convert("Artist/Album/song.flac"); err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	expected := []string{"Artist", "Artist/Album", "Artist/Album/cover.jpg", "Other", "Other/song.m4a"}
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+		t.Errorf("Expected %q, have %q", expected, actual)
+	}
+	for _, dir := range []string{"Artist/Album", "Other"} {
+		if st, err := os.Stat(filepath.Join(p.opts.OutRoot, dir)); err != nil {
+			t.Error(err)
+		} else if st.Mode().Perm() != 0750 {
+			t.Errorf("%s should have the permissions of the source directory: %v", dir, st.Mode())
+		}
+	}
+}
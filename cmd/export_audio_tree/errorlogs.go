@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"errors"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns the name of the -error-logs file for the output opath.
+func errorLogName(opath string) string {
+	return opath + ".ffmpeg.log"
+}
+
+// Writes the output of a failed conversion to opath into its own file under
+// -error-logs, along with the command line and the error. Returns the path of
+// the file, or "" if there's no -error-logs or writing failed, which is only
+// logged.
+func (p *Exporter) writeErrorLog(opath string, args []string, output []byte, failure error) string {
+	if p.ErrorLogs == nil {
+		return ""
+	}
+	name := errorLogName(opath)
+	err := p.ErrorLogs.MkDirAll(pathpkg.Dir(name), 0755)
+	var fp filesystem.File
+	if err == nil {
+		fp, err = p.ErrorLogs.Create(name)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(fp, "Command: %s\nError: %v\n\n%s", quoteArgs(args), failure, output)
+		err = errors.Join(err, fp.Close())
+	}
+	if err != nil {
+		logging.Printf("Failed writing the error log for %q: %v", opath, err)
+		return ""
+	}
+	return filepath.Join(p.opts.ErrorLogs, filepath.FromSlash(name))
+}
+
+// Removes the -error-logs file for opath left by an earlier run, now that it
+// converted successfully.
+func (p *Exporter) removeErrorLog(opath string) {
+	if p.ErrorLogs == nil {
+		return
+	}
+	if err := p.ErrorLogs.Remove(errorLogName(opath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logging.Printf("Failed removing the old error log for %q: %v", opath, err)
+	}
+}
+
+// Joins args into a command line, quoting those that need it to be pasted into
+// a shell.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`*?[]{}()<>|&;#~!") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
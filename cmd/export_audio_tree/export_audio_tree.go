@@ -7,12 +7,21 @@ import (
 	"audio_converter/internal/filesystem"
 	"audio_converter/internal/logging"
 	"audio_converter/internal/options"
+	"audio_converter/internal/proc"
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"runtime"
 )
 
+// Exit status when -spot-check finds a bad file, as opposed to 1 for errors
+// that stopped the export.
+const exitSpotCheck = 3
+
 var opts *options.ExporterOptions
 var InRoot filesystem.FS
 var OutRoot filesystem.FS
@@ -21,26 +30,137 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
 
-	if opts = options.NewExporterOptions(os.Args, nil); opts == nil {
-		// Arg parsing error. Usage, etc is handled by the constructor.
-		os.Exit(1)
+	var outcome options.Outcome
+	if opts, outcome = options.NewExporterOptions(os.Args, nil); outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
 	}
 
-	if err := logging.Initialize(ctx, opts.LogFile, opts.Verbose); err != nil {
+	logging.SetFileOptions(opts.LogAppend, opts.LogMaxSize, opts.LogKeep)
+	if err := logging.Initialize(ctx, opts.LogFile, opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
 		log.Fatalln(err)
 	}
 
 	// Look up the default options for the current format.  This is done here,
 	// because the format specific defaults live in the ffmpeg package, which
-	// imports options to provide the same data type.
-	defs := ffmpeg.GetDefaultOptions("." + opts.Format)
-	opts.Merge(defs)
+	// imports options to provide the same data type. With -dest, it's done for
+	// each of their formats instead.
+	if len(opts.Dests) == 0 {
+		defs := ffmpeg.GetDefaultOptions("." + opts.Format)
+		opts.Merge(defs)
+		ffmpeg.WarnGapless(&opts.ConverterOptions)
+	}
+
+	if opts.MemoryLimit > 0 && !ffmpeg.MemoryLimitSupported {
+		logging.Warnf("Warning: -rlimit-mem is not supported on %s, running without a limit\n", runtime.GOOS)
+		opts.MemoryLimit = 0
+	}
+	if opts.Nice > 0 && !proc.PrioritySupported {
+		logging.Warnf("Warning: -nice is not supported on %s, running at the usual priority\n", runtime.GOOS)
+		opts.Nice = 0
+	}
+	if opts.IONice != "" && !proc.IOPrioritySupported {
+		logging.Warnf("Warning: -ionice is not supported on %s, running in the usual I/O class\n", runtime.GOOS)
+		opts.IONice = ""
+	}
+	if opts.BandwidthLimit > 0 {
+		filesystem.CopyRate = filesystem.NewRateLimiter(opts.BandwidthLimit)
+	}
+
+	if opts.NoSkipTrash {
+		filesystem.SetTrashPatterns(filesystem.TrashPatterns{})
+	}
+	for _, pattern := range opts.SkipTrash {
+		// Already validated with the options.
+		filesystem.AddTrashPattern(pattern)
+	}
 
 	done := logging.When("export", logging.Verbose)
 	defer done()
 
-	exporter := newExporter(ctx, opts)
-	if err := exporter.Run(); err != nil {
+	var exporters []*Exporter
+	if len(opts.Dests) > 0 {
+		exporters = newDestExporters(ctx, opts)
+	} else {
+		exporters = []*Exporter{newExporter(ctx, opts)}
+	}
+	if opts.Diff {
+		if err := printDiffs(exporters, opts.JSON); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+	// Not needed for -diff, which never runs it.
+	if err := ffmpeg.CheckFFmpeg(ctx, &opts.GlobalOptions); err != nil {
 		log.Fatalln(err)
 	}
+	err := runExport(exporters)
+	for _, exporter := range exporters {
+		if exporter.dest != "" {
+			logging.Reportf("Summary for %s:\n%s", exporter.dest, exporter.Summary)
+			logging.Event(slog.LevelInfo, "summary", "Summary for "+exporter.dest, "dest", exporter.dest, "stats", exporter.Summary.Stats())
+		} else {
+			logging.Reportf("%s", exporter.Summary)
+			logging.Event(slog.LevelInfo, "summary", "Summary", "stats", exporter.Summary.Stats())
+		}
+	}
+	if opts.StatsFile != "" {
+		if serr := writeStats(opts.StatsFile, exporters); serr != nil {
+			logging.Println(serr)
+			err = errors.Join(err, serr)
+		}
+	}
+	if errors.Is(err, ErrSpotCheck) {
+		log.Println(err)
+		os.Exit(exitSpotCheck)
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// Prints the report for -diff to stdout. With -dest, there's a report for
+// each, under its name, or as a JSON array.
+func printDiffs(exporters []*Exporter, asJSON bool) error {
+	diffs, err := diffAll(exporters)
+	if err != nil {
+		return err
+	}
+	if exporters[0].dest == "" && asJSON {
+		return diffs[0].WriteJSON(os.Stdout)
+	} else if exporters[0].dest == "" {
+		return diffs[0].WriteText(os.Stdout)
+	} else if asJSON {
+		return writeDestDiffs(os.Stdout, exporters, diffs)
+	}
+	for i, diff := range diffs {
+		if _, err := fmt.Printf("%s:\n", exporters[i].dest); err != nil {
+			return err
+		} else if err := diff.WriteText(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the statistics of the summary of each exporter to the named file as
+// JSON. With -dest, that's an array of them, each with its dest.
+func writeStats(name string, exporters []*Exporter) error {
+	fp, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed creating stats file: %w", err)
+	}
+	if exporters[0].dest != "" {
+		err = writeDestStats(fp, exporters)
+	} else {
+		err = exporters[0].Summary.WriteJSON(fp)
+	}
+	if err != nil {
+		fp.Close()
+		return fmt.Errorf("failed writing stats file %s: %w", name, err)
+	}
+	// The last of the data may not be written until it's closed.
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("failed writing stats file %s: %w", name, err)
+	}
+	return nil
 }
@@ -3,189 +3,1084 @@
 package main
 
 import (
+	"audio_converter/internal/cue"
 	"audio_converter/internal/ffmpeg"
 	"audio_converter/internal/filesystem"
+	"audio_converter/internal/layout"
 	"audio_converter/internal/logging"
 	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
 	"context"
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
+	pathpkg "path"
 	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Exporter struct {
 	ctx     context.Context
 	opts    *options.ExporterOptions
-	pool    *WorkPool
+	pool    *workpool.WorkPool
 	InRoot  filesystem.FS
 	OutRoot filesystem.FS
+	Summary *Summary
 	cleaner *filesystem.Cleaner
+	limiter *filesystem.PathLimiter
+	media   ffmpeg.MediaExtensions // With -media-ext and -ignore-ext applied.
+	names   *nameTracker
+	dirs    *dirEnsurer
+	layout  layout.Layout // For -layout, or nil.
+	layouts sync.Map      // Source path to where -layout puts it, once mapped.
+	tags    *sync.Map     // Source path to its tags, once probed. Shared by each -dest.
+	dest    string        // The -dest this exports to, or "" without one.
+	// Settings each output was converted with. Loaded by Run and Diff.
+	fingerprints *Fingerprints
+	checksums    *Fingerprints              // Checksums of the sources of outputs for -checksum, or nil.
+	state        *State                     // Journal of exported files for -state, or nil.
+	manifest     *Manifest                  // Record of the files processed for -manifest, or nil.
+	ErrorLogs    filesystem.FS              // Where -error-logs are written, or nil.
+	spill        atomic.Pointer[spillQueue] // Overflow of the queue for -watch-spill, while watching.
+	albums       atomic.Int64               // Queued by -by-album.
+	albumsDone   atomic.Int64               // Of albums, those finished.
+	runner       ffmpeg.Runner              // Runs ffmpeg and ffprobe, unless the functions below are replaced.
+	convert      func(context.Context, *options.ConverterOptions) ([]byte, error)
+	copyFile     func(filesystem.FS, string, filesystem.FS, string) (int64, filesystem.CopyTiming, error)
+	verify       func(context.Context, string) ([]byte, error)
+	analyze      func(context.Context, string) (ffmpeg.Loudness, []byte, error)
+	tag          func(context.Context, string, string, []string) ([]byte, error)
+	probe        func(context.Context, string) (*ffmpeg.MediaInfo, error)
+	freeSpace    func() (uint64, error)
+	ioSlots      semaphore       // Limits concurrent copies to -io-jobs.
+	queueWaits   sync.Map        // Source path to how long its task waited to start.
+	sidecars     sync.Map        // Directory to its -sidecar-art, or "" for none.
+	cues         sync.Map        // Directory to its *cueSplits, for -split-cue.
+	gains        sync.Map        // Output to its trackGain, until its album is tagged.
+	hashes       sync.Map        // Source path to its sourceHash, once hashed.
+	progress     *exportProgress // Shown while Run executes the plan, or nil.
 }
 
 func newExporter(ctx context.Context, opts *options.ExporterOptions) *Exporter {
-	return &Exporter{
-		ctx:     ctx,
-		opts:    opts,
-		pool:    NewWorkPool(ctx, opts.MaxJobs, opts.MaxQueue),
-		InRoot:  filesystem.NewFileSystem(opts.InRoot),
-		OutRoot: filesystem.NewFileSystem(opts.OutRoot),
-		cleaner: filesystem.NewCleaner(opts.CleanPaths, filesystem.ReservedCharacters),
+	var cleaner *filesystem.Cleaner
+	if opts.CleanPaths != "" || opts.NormalizeNames != filesystem.NormalizeNone {
+		// Without -cleanpaths, there's no replacement text to clean with.
+		cleaner = filesystem.NewCleaner(opts.CleanPaths, filesystem.ReservedCharacters)
+		cleaner.DeviceNames = opts.CleanPathsStrict
+		cleaner.TrailingDots = opts.CleanPathsStrict
+		cleaner.Normalize = opts.NormalizeNames
 	}
+	var limiter *filesystem.PathLimiter
+	if opts.MaxNameBytes > 0 || opts.MaxPathBytes > 0 {
+		limiter = filesystem.NewPathLimiter(opts.MaxNameBytes, opts.MaxPathBytes)
+	}
+	names := newNameTracker(opts.CaseInsensitiveTarget, opts.DedupeSuffix)
+	if limiter != nil {
+		names.fit = limiter.LimitPath
+	}
+	outRoot := filesystem.NewFileSystem(opts.OutRoot)
+	var errorLogs filesystem.FS
+	if opts.ErrorLogs != "" {
+		errorLogs = filesystem.NewFileSystem(opts.ErrorLogs)
+	}
+	p := &Exporter{
+		ctx:          ctx,
+		opts:         opts,
+		pool:         workpool.NewWorkPool(ctx, opts.MaxJobs, opts.MaxQueue),
+		InRoot:       filesystem.NewFileSystem(opts.InRoot),
+		OutRoot:      outRoot,
+		ErrorLogs:    errorLogs,
+		Summary:      &Summary{},
+		cleaner:      cleaner,
+		limiter:      limiter,
+		media:        ffmpeg.NewMediaExtensions(opts.MediaExts, opts.IgnoreExts),
+		names:        names,
+		dirs:         newDirEnsurer(),
+		layout:       opts.Layout,
+		tags:         &sync.Map{},
+		fingerprints: NewFingerprints(),
+		runner:       ffmpeg.ExecRunner{MemoryLimit: opts.MemoryLimit, Nice: opts.Nice, IONice: opts.IONice},
+		copyFile:     filesystem.CopyFileTimed,
+		freeSpace:    outRoot.FreeSpace,
+		ioSlots:      newSemaphore(max(opts.IOJobs, 1)),
+	}
+	// Every ffmpeg of the export is limited alike, not just the conversions.
+	// These look up p.runner when they're called, so that tests can replace it
+	// after the fact.
+	p.convert = func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		return ffmpeg.ConvertInBackgroundWith(ctx, p.runner, opts)
+	}
+	p.verify = func(ctx context.Context, name string) ([]byte, error) {
+		return ffmpeg.VerifyDecodeWith(ctx, p.runner, opts.FFmpeg, name)
+	}
+	p.analyze = func(ctx context.Context, name string) (ffmpeg.Loudness, []byte, error) {
+		return ffmpeg.MeasureLoudnessWith(ctx, p.runner, opts.FFmpeg, name)
+	}
+	p.tag = func(ctx context.Context, in, out string, tags []string) ([]byte, error) {
+		return ffmpeg.WriteTagsWith(ctx, p.runner, opts.FFmpeg, in, out, tags)
+	}
+	p.probe = func(ctx context.Context, name string) (*ffmpeg.MediaInfo, error) {
+		return ffmpeg.ProbeWith(ctx, p.runner, opts.FFprobe(), name)
+	}
+	return p
 }
 
 // Make the magic happen, or return the error code.
 func (p *Exporter) Run() error {
-	// First execute WalkDir to ensure that all directories are created. This
-	// will allow us to run the remaining tasks asyncronously without having
-	// data races over "hey, I was just about to create that directory."
-	if err := fs.WalkDir(p.InRoot, ".", p.visitDir); err != nil {
+	return runExport([]*Exporter{p})
+}
+
+// Runs the export of each of exporters, which share a pool, an input root, and
+// all the options but the output root and format, as for -dest. The input is
+// walked once, and each file fans out into a task per exporter. Only a single
+// exporter may -watch.
+func runExport(exporters []*Exporter) (err error) {
+	p := exporters[0]
+	for _, e := range exporters {
+		closeFn, oerr := e.open()
+		if oerr != nil {
+			return oerr
+		}
+		defer func() {
+			err = errors.Join(err, closeFn())
+		}()
+	}
+	if p.opts.Manifest != "" {
+		manifest, merr := CreateManifest(p.opts.Manifest, len(exporters) > 1)
+		if merr != nil {
+			return merr
+		}
+		defer func() {
+			err = errors.Join(err, manifest.Close())
+		}()
+		for _, e := range exporters {
+			e.manifest = manifest
+		}
+	}
+
+	var watcher Watcher
+	if p.opts.Watch {
+		// Started before planning, so that nothing added during the initial
+		// export is missed.
+		watcher = p.newWatcher()
+		defer watcher.Close()
+	}
+
+	// First work out what to do, so that problems like colliding output names
+	// or a lack of space are found before anything is written.
+	plans, err := planAll(p.ctx, exporters)
+	if err != nil {
 		return err
 	}
+	for i, e := range exporters {
+		if err := e.checkSpace(plans[i].Needed); err != nil {
+			return err
+		}
+	}
+
+	// Create all the directories up front. This will allow us to run the
+	// remaining tasks asyncronously without having data races over "hey, I
+	// was just about to create that directory." With -layout, there are none,
+	// since the output isn't arranged like the input.
+	for i, e := range exporters {
+		for _, dir := range plans[i].Dirs {
+			if err := e.ensureDir(dir); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Spin up the work pool.
 	p.pool.Start()
 
-	// Periodically log the status of the pool.
-	go func() {
-		for {
-			time.Sleep(time.Second * 30)
-			logging.Printf("WorkPool %p: size: %d limit: %d buffer: %d (%f %%)",
-				p.pool, p.pool.Size(), p.pool.Limit(), p.pool.Remaining(), p.pool.PercentFull())
+	// Periodically log the status of the pool, until we're done.
+	statusCtx, stopStatus := context.WithCancel(p.ctx)
+	defer stopStatus()
+	go p.logStatus(statusCtx, p.opts.StatusInterval)
+
+	// Show the progress until the pool is done, so that the line is gone
+	// before anything else is printed. With -dest, it's the progress of every
+	// destination together.
+	all := &Plan{}
+	for _, plan := range plans {
+		all.Steps = append(all.Steps, plan.Steps...)
+	}
+	progress := p.newExportProgress(all)
+	for _, e := range exporters {
+		e.progress = progress
+	}
+	stopProgress := progress.run(p.ctx)
+	defer stopProgress()
+
+	// Now feed the beast. This will block until all items are in the queue,
+	// which may require blocking until there's room.
+	for i, e := range exporters {
+		if err = e.execute(plans[i]); err != nil {
+			break
+		}
+	}
+
+	// Now wait for everyone to finish. This is done even if queuing was
+	// interrupted, so that tasks killed by the context can clean up after
+	// themselves. A task failing doesn't stop the others, so the failures are
+	// reported together.
+	tasksErr := p.pool.Wait()
+	stopProgress()
+	if p.ctx.Err() == nil && p.opts.ReportDuplicates {
+		// The sources are the same for every destination.
+		p.reportDuplicates()
+	}
+
+	for i, e := range exporters {
+		if p.ctx.Err() == nil && err == nil {
+			err = e.makeLinks(plans[i])
+		}
+		if p.ctx.Err() == nil && err == nil {
+			err = e.collectPlaylists(plans[i].Playlists)
+		}
+	}
+	// The walk failing is reported once, rather than for every destination.
+	err = errors.Join(err, tasksErr, plans[0].walkErr())
+	for _, e := range exporters {
+		if p.ctx.Err() == nil && err == nil && p.opts.SpotCheck > 0 {
+			err = e.spotCheck()
+		}
+	}
+	watched := false
+	if watcher != nil && p.ctx.Err() == nil {
+		// Failures so far are reported once watching is interrupted, which is
+		// the only way it ends.
+		p.watch(watcher, plans[0].Playlists)
+		err = errors.Join(err, p.pool.Wait())
+		watched = true
+	}
+	for _, e := range exporters {
+		// The pool is shared, so each summary has the totals of all of them.
+		e.Summary.SetPanics(p.pool.Panics())
+		e.Summary.SetPool(p.pool.Stats())
+	}
+	if stats := p.Summary.Stats(); watched && stats.Aborted == 0 && stats.NotStarted == 0 {
+		// Interrupted while idle, rather than in the middle of anything.
+		logging.Printf("Stopped watching %s", p.opts.InRoot)
+	} else if p.ctx.Err() != nil {
+		return fmt.Errorf("export interrupted: %w", context.Cause(p.ctx))
+	}
+	return err
+}
+
+// Loads what earlier exports recorded in the output root, and opens the
+// -state journal, if any. The returned function saves and closes them again,
+// returning the first failure.
+func (p *Exporter) open() (closeFn func() error, err error) {
+	var closers []func() error
+	closeFn = func() error {
+		var errs []error
+		for _, fn := range slices.Backward(closers) {
+			errs = append(errs, fn())
+		}
+		return errors.Join(errs...)
+	}
+	// Whatever was opened is closed again if the rest fails.
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, closeFn())
 		}
 	}()
+	if p.fingerprints, err = LoadFingerprints(p.OutRoot); err != nil {
+		return nil, err
+	}
+	closers = append(closers, func() error {
+		if err := p.fingerprints.Save(p.OutRoot); err != nil {
+			return fmt.Errorf("failed saving %s: %w", FingerprintsFile, err)
+		}
+		return nil
+	})
+	if p.opts.Checksum {
+		if p.checksums, err = LoadChecksums(p.OutRoot); err != nil {
+			return nil, err
+		}
+		closers = append(closers, func() error {
+			if err := p.checksums.Save(p.OutRoot); err != nil {
+				return fmt.Errorf("failed saving %s: %w", ChecksumsFile, err)
+			}
+			return nil
+		})
+	}
+	if p.opts.StateFile != "" {
+		if p.state, err = OpenState(p.opts.StateFile); err != nil {
+			return nil, err
+		}
+		closers = append(closers, p.state.Close)
+	}
+	return closeFn, nil
+}
 
-	// Now execute WalkDir to feed the beast. This will block until all items are in the queue, which may require blocking until
-	if err := fs.WalkDir(p.InRoot, ".", p.visitFile); err != nil {
-		return err
+// Logs the status of the pool every interval until ctx is done. Does nothing if
+// interval is 0.
+func (p *Exporter) logStatus(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := p.pool.Stats()
+			logging.Printf("WorkPool %p: size: %d limit: %d remaining: %d (%.1f%% full) submitted: %d in flight: %d completed: %d failed: %d max queued: %d",
+				p.pool, p.pool.Size(), p.pool.Limit(), p.pool.Remaining(), p.pool.PercentFull(),
+				stats.Submitted, stats.InFlight(), stats.Completed, stats.Failed, stats.MaxQueued)
+			if spill := p.spill.Load(); spill != nil {
+				logging.Printf("WorkPool %p: spilled to disk: %d", p.pool, spill.Len())
+			}
+			if albums := p.albums.Load(); albums > 0 {
+				logging.Printf("WorkPool %p: albums done: %d of %d", p.pool, p.albumsDone.Load(), albums)
+			}
+			for _, task := range slowTasks(p.pool.Running(), time.Now()) {
+				logging.Printf("Worker %d has been running %q for %v", task.Worker, task.Name, time.Since(task.Started).Round(time.Second))
+			}
+		}
 	}
+}
+
+// How long a task runs before logStatus reports it as possibly hung.
+const slowTaskAge = 10 * time.Minute
 
-	// Now wait for everyone to finish.
-	p.pool.Wait()
+// Returns the tasks of running that started at least slowTaskAge before now.
+func slowTasks(running []workpool.TaskInfo, now time.Time) []workpool.TaskInfo {
+	var slow []workpool.TaskInfo
+	for _, task := range running {
+		if now.Sub(task.Started) >= slowTaskAge {
+			slow = append(slow, task)
+		}
+	}
+	return slow
+}
 
+// Returns the directories of the input root to export: those given by -only, or
+// else the whole thing.
+func (p *Exporter) roots() []string {
+	if len(p.opts.Only) == 0 {
+		return []string{"."}
+	}
+	return p.opts.Only
+}
+
+// Walks each of the roots of fsys in turn, stopping at the first error.
+func (p *Exporter) walk(fsys fs.FS, roots []string, fn fs.WalkDirFunc) error {
+	for _, root := range roots {
+		if err := fs.WalkDir(fsys, root, fn); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Walk function for creating directories in the output root.
-//
-// Called with <path> <base name of dir if its a dir> <err>
-//
-// E.g., if there is ${root}/artist/album/song, this would be called the
-// following ways:
-//
-// - path=.                 dir=.
-// - path=artist            dir=artest
-// - path=artist/album      dir=album
-// - path=artist/album/song dir=.
-//
-// return fs.SkipDir to skip the current directory (parent if called for a file)
-//
-// return fs.SkipAll to abort the entire walk.
-//
-// return any other non-nil error to abort and return the error code.
-func (p *Exporter) visitDir(path string, d fs.DirEntry, err error) error {
-	logging.Printf("Visiting path: %q d.Name: %q err: %v", path, d, err)
-
-	if !d.IsDir() || path == "." {
+// Ensures the directory path exists in the output root. Safe to call from
+// tasks, since each directory is only created once.
+func (p *Exporter) ensureDir(path string) error {
+	if path == "." {
 		return nil
 	}
+	return p.dirs.ensure(p.outPath(path), func() error {
+		return p.makeDir(path)
+	})
+}
 
+// Creates the directory path in the output root, with the same permissions as
+// in the input root.
+func (p *Exporter) makeDir(path string) error {
 	// We can't count on d.Type().Perm() to be populated by fs.WalkDir, we
 	// need to do a stat of our own.
 	st, err := p.InRoot.Stat(path)
 	if err != nil {
 		return fmt.Errorf("stat failed: %w", err)
 	}
-	logging.Printf("Mkdirs %q", path)
-	return p.OutRoot.MkDirAll(path, st.Mode().Perm())
+	opath := p.outPath(path)
+	logging.Printf("Mkdirs %q", opath)
+	return p.OutRoot.MkDirAll(opath, st.Mode().Perm())
 }
 
-// Walk function for converting files.
-//
-// See walkDir for a description of how the parameters will be populated by fs.WalkDir().
-//
-// Since directories are created by the initial walk, we only need concern
-// ourselves with valid files. These are either copied or converted as
-// appropriate.
-func (p *Exporter) visitFile(path string, d fs.DirEntry, err error) error {
-	logging.Printf("Visiting path: %q d.Name: %q err: %v", path, d, err)
-
-	// Handle exclusions.
-	if d.IsDir() {
-		// Created by the initial walk using visitDir().
-		return nil
-	} else if path == "." {
-		// We don't care about the root itself.
-		return nil
-	} else if filesystem.IsTrashFile(path) {
-		logging.Verbosef("Skipping %q", path)
+// Ensures the directory that opath, the output of path, goes in exists. The
+// plan makes most of them up front, but this is the safety net for any it
+// didn't, e.g., with -layout, or when a name ends up somewhere else. Normally
+// that's the output of the directory of path, made with the same permissions.
+// Otherwise, the directory is made with the permissions of the one path is in,
+// or 0755 if that can't be read. Either way, each is only made once.
+func (p *Exporter) ensureParent(path, opath string) error {
+	dir := pathpkg.Dir(opath)
+	if p.layout == nil && dir == p.outPath(pathpkg.Dir(path)) {
+		return p.ensureDir(pathpkg.Dir(path))
+	} else if dir == "." {
 		return nil
 	}
-
-	if ffmpeg.IsMediaFile(path) {
-		// Add the conversion to the queue.
-		p.pool.Add(func() {
-			if output, err := p.Convert(path); err != nil {
-				logging.Fatalf("!!! FATAL: %v !!!\n=== Start Output %q ===\n%s\n=== End Output %q ===\n", err, path, output, path)
-			} else {
-				logging.Printf("=== Start Output %q ===\n%s\n=== End Output %q ===\n", path, output, path)
-			}
-		})
-	} else if p.opts.CopyUnknown && !d.IsDir() {
-		// Add copying the file to the queue.
-		p.pool.Add(func() {
-			if err := p.Copy(path); err != nil {
-				logging.Fatalln(err)
-			}
-		})
-	}
-	return nil
+	return p.dirs.ensure(dir, func() error {
+		mode := fs.FileMode(0755)
+		if st, err := p.InRoot.Stat(pathpkg.Dir(path)); err == nil {
+			mode = st.Mode().Perm()
+		}
+		logging.Printf("Mkdirs %q", dir)
+		return p.OutRoot.MkDirAll(dir, mode)
+	})
 }
 
 // Handle copying path between roots. If no clobber is set, we silently ignore
 // the operation when it looks like the file exists.
 func (p *Exporter) Copy(path string) error {
-	opath := p.cleaner.CleanPath(path)
-	if p.opts.NoClobber {
-		if _, err := p.OutRoot.Stat(opath); !errors.Is(err, os.ErrNotExist) {
-			logging.Verbosef("Not clobbering %q", opath)
-			return nil
-		}
+	opath := p.outputName(path)
+	if p.opts.NoClobber && p.exists(opath) {
+		logging.Verbosef("Not clobbering %q", opath)
+		p.skip(path, ActionCopy, opath)
+		return nil
+	} else if p.upToDate(path, opath, true) {
+		logging.Verbosef("Up to date %q", opath)
+		p.skip(path, ActionCopy, opath)
+		return nil
 	}
 	logging.Verbosef("Copying %q to %q",
 		filepath.Join(p.opts.InRoot, path),
 		filepath.Join(p.opts.OutRoot, opath))
-	nb, err := filesystem.CopyFile(p.InRoot, path, p.OutRoot, opath)
-	logging.Printf("Copied %d bytes of %s", nb, opath)
+	var nb int64
+	var timing filesystem.CopyTiming
+	var slotWait time.Duration
+	start := time.Now()
+	err := p.ensureParent(path, opath)
+	mkdir := time.Since(start)
+	if err == nil {
+		// Waiting for a slot counts as queue time, since the copy hasn't
+		// started yet.
+		start = time.Now()
+		err = p.ioSlots.acquire(p.ctx)
+		slotWait = time.Since(start)
+		if err == nil {
+			nb, timing, err = p.copyFile(p.InRoot, path, p.OutRoot, opath)
+			p.ioSlots.release()
+		}
+	}
+	if err == nil {
+		logging.Printf("Copied %d bytes of %s", nb, opath)
+	} else if p.ctx.Err() == nil {
+		logging.Printf("Copying %q failed: %v", path, err)
+	}
+	start = time.Now()
+	if err == nil && (p.opts.PreserveTime == options.PreserveCopies || p.opts.PreserveTime == options.PreserveAll) {
+		p.preserve(path, opath, true)
+	}
+	r := Result{Path: path, Action: ActionCopy}
+	r.Timing.QueueWait = slotWait
+	r.Timing.Read = timing.Read
+	r.Timing.Write = mkdir + timing.Write + time.Since(start)
+	p.record(r, opath, err)
 	return err
 }
 
 func (p *Exporter) Convert(path string) (string, error) {
-	oldExt := filepath.Ext(path)
-	newExt := "." + p.opts.Format
-
-	if oldExt == newExt {
+	if filepath.Ext(path) == "."+p.opts.Format {
 		logging.Println(path, "already in target format")
 		return "", p.Copy(path)
 	}
+	return p.convertFile(path, path, nil)
+}
 
+// Converts track of the media file at path into a file of its own, for
+// -split-cue. Unlike Convert, this is done even if path is in the target
+// format already.
+func (p *Exporter) ConvertTrack(path string, track *cue.Track) (string, error) {
+	return p.convertFile(path, trackKey(path, track), track)
+}
+
+// Does the work of Convert and ConvertTrack. The result is recorded for name,
+// which is path itself unless converting a track.
+func (p *Exporter) convertFile(path, name string, track *cue.Track) (string, error) {
 	// A shallow copy is sufficent for our purposes. We just need to update the input/output fields.
 	copts := p.opts.ConverterOptions
 	if copts.Err != nil {
 		return "", copts.Err
 	}
+	opath := p.outputName(name)
+	if p.opts.NoClobber && p.exists(opath) {
+		logging.Verbosef("Not clobbering %q", opath)
+		p.skip(name, ActionConvert, opath)
+		return "", nil
+	} else if p.upToDate(path, opath, true) {
+		logging.Verbosef("Up to date %q", opath)
+		p.skip(name, ActionConvert, opath)
+		return "", nil
+	}
+
+	// ffmpeg writes to a temporary file that's only moved into place if the
+	// conversion succeeds. That way, anything at opath is complete. The
+	// temporary name is hidden and unique, so it can't be another output.
+	af := filesystem.NewAtomicFile(p.OutRoot, opath)
 	copts.InputFile = filepath.Join(p.opts.InRoot, path)
-	copts.OutputFile = filepath.Join(p.opts.OutRoot, p.cleaner.CleanPath(path[:len(path)-len(oldExt)])) + newExt
+	copts.OutputFile = filepath.Join(p.opts.OutRoot, af.Temp())
+	copts.NoClobber = false
+	copts.Overwrite = true
+	if p.opts.SidecarArt {
+		if art := p.sidecarArt(pathpkg.Dir(path)); art != "" {
+			copts.ArtFile = filepath.Join(p.opts.InRoot, art)
+		}
+	}
+	if track != nil {
+		copts.Start = track.Start
+		if track.End > 0 {
+			copts.Duration = track.End - track.Start
+		}
+		copts.Metadata = append(slices.Clone(copts.Metadata), trackMetadata(track)...)
+	}
 
-	logging.Verbosef("Converting %q -> %q", copts.InputFile, copts.OutputFile)
-	output, err := ffmpeg.ConvertInBackground(p.ctx, &copts)
-	if err != nil {
-		if output == nil {
-			output = []byte{}
+	logging.Verbosef("Converting %q -> %q", copts.InputFile, filepath.Join(p.opts.OutRoot, opath))
+	probed := ffmpeg.LogProbedInputInfoWith(p.ctx, p.runner, copts.FFprobe(), copts.InputFile)
+	var last *options.ConverterOptions
+	run := func(opts *options.ConverterOptions) ([]byte, error) {
+		last = opts
+		return p.convertWithTimeout(opts)
+	}
+	var output []byte
+	var noArt bool
+	var timing Timing
+	start := time.Now()
+	err := p.ensureParent(path, opath)
+	timing.Write = time.Since(start)
+	start = time.Now()
+	if err == nil && copts.ArtFallback {
+		output, noArt, err = ffmpeg.ConvertWithArtFallback(&copts, run)
+	} else if err == nil {
+		output, err = run(&copts)
+	}
+	if !probed {
+		// Without ffprobe, ffmpeg's own output has to do.
+		ffmpeg.LogInputInfo(copts.InputFile, output)
+	}
+	if err == nil && p.opts.Verify {
+		// Checked before the rename, so a bad output is removed like any
+		// other failure and a rerun tries again.
+		err = p.verifyOutput(af.Temp())
+	}
+	timing.Encode = time.Since(start)
+	start = time.Now()
+	if err != nil && p.ctx.Err() != nil {
+		err = fmt.Errorf("converting %q aborted: %w", copts.InputFile, context.Cause(p.ctx))
+	} else if err != nil {
+		err = fmt.Errorf("converting %q failed with error: %v", copts.InputFile, err)
+	} else {
+		if p.opts.PreserveTime == options.PreserveAll {
+			// Done before the rename, so the output never has the wrong time.
+			p.preserve(path, af.Temp(), false)
+		}
+		commit := af.Commit
+		if p.opts.NoClobber {
+			commit = af.CommitNoClobber
+		}
+		if err = commit(); errors.Is(err, fs.ErrExist) {
+			// Written by something else since it was checked for.
+			logging.Verbosef("Not clobbering %q", opath)
+			p.skip(name, ActionConvert, opath)
+			return "", nil
+		} else if err != nil {
+			err = fmt.Errorf("renaming %q into place failed: %w", af.Temp(), err)
+		} else {
+			p.fingerprints.Set(opath, p.settings())
 		}
-		return string(output), fmt.Errorf("converting %q failed with error: %v", copts.InputFile, err)
+	}
+	if err != nil {
+		p.discard(af)
+	}
+	var errorLog string
+	if err != nil && p.ctx.Err() == nil && last != nil {
+		errorLog = p.writeErrorLog(opath, ffmpeg.CommandLine(last), output, err)
+	} else if err == nil {
+		p.removeErrorLog(opath)
+	}
+	timing.Write += time.Since(start)
+	if err == nil && p.opts.ReplayGain {
+		start = time.Now()
+		p.replayGain(path, opath)
+		timing.Encode += time.Since(start)
+	}
+	if noArt {
+		logging.Verbosef("Converted %q without cover art", path)
+	}
+	r := Result{Path: name, Action: ActionConvert, WithoutArt: noArt, ErrorLog: errorLog, Timing: timing}
+	if err != nil {
+		r.Output = string(output)
+	}
+	p.record(r, opath, err)
+	if output == nil {
+		output = []byte{}
 	}
 	return string(output), err
 }
+
+// Runs p.convert, killing ffmpeg if it takes longer than -timeout. A timeout
+// is a failure of its own, unlike the export being interrupted, and the output
+// up to then is returned as usual for the error report.
+func (p *Exporter) convertWithTimeout(opts *options.ConverterOptions) ([]byte, error) {
+	if p.opts.Timeout <= 0 {
+		return p.convert(p.ctx, opts)
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, p.opts.Timeout)
+	defer cancel()
+	output, err := p.convert(ctx, opts)
+	if err != nil && p.ctx.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v: %w", p.opts.Timeout, err)
+	}
+	return output, err
+}
+
+// Checks a newly converted output for -verify. It has to exist, not be empty,
+// and decode without errors. Catches ffmpeg failing without saying so.
+func (p *Exporter) verifyOutput(name string) error {
+	st, err := p.OutRoot.Stat(name)
+	if err != nil {
+		return fmt.Errorf("verifying output: %w", err)
+	} else if st.Size() == 0 {
+		return fmt.Errorf("verifying output: %q is empty", name)
+	}
+	output, err := p.verify(p.ctx, filepath.Join(p.opts.OutRoot, name))
+	if err != nil {
+		p.logOutput(name, string(output), true)
+		return fmt.Errorf("verifying output: %w", err)
+	}
+	return nil
+}
+
+// Logs the output of ffmpeg for name, if -ffmpeg-log calls for it. With
+// errors, only the output of a run that failed is logged.
+func (p *Exporter) logOutput(name string, output string, failed bool) {
+	if p.logsOutput(failed) {
+		logging.Printf("=== Start Output %q ===\n%s\n=== End Output %q ===\n", name, output, name)
+	}
+}
+
+// Returns true if -ffmpeg-log calls for logging the output of a run that
+// failed or not.
+func (p *Exporter) logsOutput(failed bool) bool {
+	switch p.opts.FFmpegLog {
+	case options.FFmpegLogNone:
+		return false
+	case options.FFmpegLogErrors:
+		return failed
+	}
+	return true
+}
+
+// Maps path in the input root to the corresponding path in the output root.
+// Every path written to the output root must go through here, so that the
+// directories made for the plan match the files written into them later.
+func (p *Exporter) outPath(path string) string {
+	return p.limitPath(p.cleanPath(path))
+}
+
+// Applies -cleanpaths to path.
+func (p *Exporter) cleanPath(path string) string {
+	if p.cleaner == nil {
+		return path
+	}
+	return p.cleaner.CleanPath(path)
+}
+
+// Applies -max-name-bytes and -max-path-bytes to path, which must already be
+// cleaned. Done last, so that the extension of converted files counts.
+func (p *Exporter) limitPath(path string) string {
+	if p.limiter == nil {
+		return path
+	}
+	return p.limiter.LimitPath(path)
+}
+
+// Returns the name path will have in the output root once exported. This is
+// the name claimed by claimOutput, if any, or else mappedName.
+func (p *Exporter) outputName(path string) string {
+	if name, ok := p.names.lookup(path); ok {
+		return name
+	}
+	return p.mappedName(path)
+}
+
+// Claims the output name for path, resolving or reporting collisions with any
+// other file claiming the same name. With -rename-from-tags, a file named like
+// another by its tags keeps its own name instead.
+func (p *Exporter) claimOutput(path string) (string, error) {
+	if renamed := p.renamedName(path); renamed != "" {
+		name, owner := p.names.tryClaim(path, renamed)
+		if owner == "" {
+			return name, nil
+		}
+		logging.Warnf("Warning: %q would be %q by its tags, like %q, so it keeps its own name\n", path, renamed, owner)
+	}
+	return p.names.claim(path, p.mappedName(path))
+}
+
+// Maps path to its name in the output root. Media files take on the extension
+// of the output format.
+func (p *Exporter) mappedName(path string) string {
+	mapped := p.layoutPath(path)
+	if !p.isMedia(path) || p.lossyPolicy(path, options.LossyCopy) {
+		return p.outPath(mapped)
+	}
+	ext := filepath.Ext(mapped)
+	return p.limitPath(p.cleanPath(mapped[:len(mapped)-len(ext)]) + "." + p.opts.Format)
+}
+
+// Maps path to where -layout puts it, before the rest of mappedName is done.
+// Media files are probed for their tags, if the layout uses them. Other files,
+// like cover art, have no tags, so they go with the first media file in their
+// directory, or stay where they are if there's none. Without -layout, path is
+// returned as is.
+func (p *Exporter) layoutPath(path string) string {
+	if p.layout == nil {
+		return path
+	} else if mapped, ok := p.layouts.Load(path); ok {
+		return mapped.(string)
+	}
+	mapped := path
+	if !p.layout.NeedsTags() {
+		mapped = p.layout.Map(path, nil)
+	} else if p.isMedia(path) {
+		mapped = p.layout.Map(path, p.probeTags(path))
+	} else if media := p.firstMedia(pathpkg.Dir(path)); media != "" {
+		mapped = pathpkg.Join(pathpkg.Dir(p.layoutPath(media)), pathpkg.Base(path))
+	}
+	p.layouts.Store(path, mapped)
+	return mapped
+}
+
+// Returns the tags of the media file at path, for -layout and
+// -rename-from-tags, or nil if it can't be probed, which leaves them unknown.
+// Each file is only probed once.
+func (p *Exporter) probeTags(path string) map[string]string {
+	if tags, ok := p.tags.Load(path); ok {
+		return tags.(map[string]string)
+	}
+	info, err := p.probe(p.ctx, filepath.Join(p.opts.InRoot, path))
+	var tags map[string]string
+	if err != nil {
+		logging.Printf("Failed reading the tags of %q: %v", path, err)
+	} else {
+		tags = info.Tags
+	}
+	p.tags.Store(path, tags)
+	return tags
+}
+
+// Returns the first media file in dir that's exported, by name, or "" if
+// there's none.
+func (p *Exporter) firstMedia(dir string) string {
+	entries, err := fs.ReadDir(p.InRoot, dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		path := pathpkg.Join(dir, e.Name())
+		if e.Type().IsRegular() && p.isMedia(path) && !p.excluded(path, false) && !p.lossyPolicy(path, options.LossySkip) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Returns true if path is a lossy media file and -lossy-policy is policy.
+func (p *Exporter) lossyPolicy(path string, policy string) bool {
+	return p.opts.LossyPolicy == policy && p.isMedia(path) && ffmpeg.IsLossy(path)
+}
+
+// Returns true if path, or a directory containing it, matches -exclude. The
+// match closest to path wins, and -include wins over -exclude at the same
+// level. E.g., "-exclude __backup/ -include __backup/keep/" exports only the
+// keep directory from __backup.
+func (p *Exporter) excluded(path string, isDir bool) bool {
+	if len(p.opts.Excludes) == 0 {
+		return false
+	}
+	for ; path != "."; path, isDir = pathpkg.Dir(path), true {
+		if matchAny(p.opts.Includes, path, isDir) {
+			return false
+		} else if matchAny(p.opts.Excludes, path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the walk can skip the directory at path altogether. That's
+// only safe without -include, since it may match something inside.
+func (p *Exporter) skipDir(path string) bool {
+	return len(p.opts.Includes) == 0 && p.excluded(path, true)
+}
+
+// Returns true if any of the patterns match path.
+func matchAny(patterns []string, path string, isDir bool) bool {
+	for _, pattern := range patterns {
+		if filesystem.MatchGlob(pattern, path, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if path is a media file, as changed by -media-ext and
+// -ignore-ext.
+func (p *Exporter) isMedia(path string) bool {
+	return p.media.IsMediaFile(path)
+}
+
+// Returns true if path has one of the -ignore-ext extensions.
+func (p *Exporter) ignored(path string) bool {
+	return slices.Contains(p.opts.IgnoreExts, filepath.Ext(path))
+}
+
+// Returns true if path is converted rather than copied.
+func (p *Exporter) converts(path string) bool {
+	return p.isMedia(path) && filepath.Ext(path) != "."+p.opts.Format &&
+		!p.lossyPolicy(path, options.LossyCopy)
+}
+
+// Returns the fingerprint of the current conversion settings.
+func (p *Exporter) settings() string {
+	settings := ffmpeg.Fingerprint(&p.opts.ConverterOptions)
+	if p.opts.SidecarArt {
+		settings += " -sidecar-art"
+	}
+	if p.opts.ReplayGain {
+		settings += " -replaygain"
+	}
+	return settings
+}
+
+// Returns the image in dir to use as the cover art of the files converted
+// from it, or "" if there isn't one. Each directory is only searched once.
+func (p *Exporter) sidecarArt(dir string) string {
+	if art, ok := p.sidecars.Load(dir); ok {
+		return art.(string)
+	}
+	var name string
+	if art, err := ffmpeg.FindSidecarArt(p.InRoot, dir); err != nil {
+		logging.Printf("Failed looking for cover art in %q: %v", dir, err)
+	} else if art != nil {
+		name = art.Name
+	}
+	art, _ := p.sidecars.LoadOrStore(dir, name)
+	return art.(string)
+}
+
+// Compares the source at path to its output at opath. Conversions are stale if
+// they were done with different settings, unless -ignore-settings-change. If
+// hash is set, sources newer than their output are checked against -checksum.
+func (p *Exporter) compare(path, opath string, hash bool) (Change, error) {
+	src, err := p.InRoot.Stat(path)
+	if err != nil {
+		return ChangeNew, err
+	}
+	out, err := p.OutRoot.Stat(opath)
+	if errors.Is(err, fs.ErrNotExist) {
+		out = nil
+	} else if err != nil {
+		return ChangeNew, err
+	}
+	change := compareOutput(src, out)
+	if change == ChangeStale && hash && p.sameContents(path, opath) {
+		logging.Verbosef("Contents unchanged %q", path)
+		change = ChangeUnchanged
+	}
+	if change == ChangeUnchanged && p.converts(path) && !p.opts.IgnoreSettingsChange &&
+		p.fingerprints.Changed(opath, p.settings()) {
+		change = ChangeStale
+	}
+	return change, nil
+}
+
+// Returns true if -update is set and the output of path doesn't need to be
+// redone. With -checksum and hash set, sources may be hashed, so only tasks
+// should set it. Outputs that are up to date but have no checksum yet get one.
+func (p *Exporter) upToDate(path, opath string, hash bool) bool {
+	if !p.opts.Update {
+		return false
+	}
+	change, err := p.compare(path, opath, hash)
+	if err != nil || change != ChangeUnchanged {
+		return false
+	}
+	if hash && p.checksums != nil {
+		if _, ok := p.checksums.Get(opath); !ok {
+			p.recordChecksum(path, opath)
+		}
+	}
+	return true
+}
+
+// Gives name in the output root the modification time of path in the input
+// root, and its permissions too if mode is set. Failures are only logged,
+// since the output itself is fine.
+func (p *Exporter) preserve(path, name string, mode bool) {
+	st, err := p.InRoot.Stat(path)
+	if err == nil {
+		err = p.OutRoot.Chtimes(name, time.Now(), st.ModTime())
+	}
+	if err == nil && mode {
+		err = p.OutRoot.Chmod(name, st.Mode().Perm())
+	}
+	if err != nil {
+		logging.Printf("Failed preserving the time of %q: %v", name, err)
+	}
+}
+
+// Returns true if name appears to exist in the output root.
+func (p *Exporter) exists(name string) bool {
+	_, err := p.OutRoot.Stat(name)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+// Removes the partially written output of a failed or aborted task.
+func (p *Exporter) discard(af *filesystem.AtomicFile) {
+	if err := af.Abort(); err != nil {
+		logging.Printf("Failed removing partial output %q: %v", af.Temp(), err)
+	} else {
+		logging.Verbosef("Discarded partial output for %q", af.Name())
+	}
+}
+
+// Records the result of a task in the summary based on err. Errors caused by
+// the context being cancelled are counted as aborted rather than failed. On
+// success, the sizes of the input and output (opath) are filled in.
+func (p *Exporter) record(r Result, opath string, err error) {
+	r.Timing.QueueWait += p.queueWait(r.Path)
+	r.Status = StatusDone
+	r.Err = err
+	if err != nil && p.ctx.Err() != nil {
+		r.Status = StatusAborted
+	} else if err != nil {
+		r.Status = StatusFailed
+	} else {
+		if st, err := p.InRoot.Stat(r.Path); err == nil {
+			r.InputBytes = st.Size()
+			if p.state != nil && (r.Action == ActionConvert || r.Action == ActionCopy) {
+				if err := p.state.Add(p.stateRecord(r.Path, r.Action, opath, st)); err != nil {
+					logging.Println(err)
+				}
+			}
+			if r.Action == ActionConvert || r.Action == ActionCopy {
+				p.recordChecksum(r.Path, opath)
+			}
+		}
+		if st, err := p.OutRoot.Stat(opath); err == nil {
+			r.OutputBytes = st.Size()
+		}
+	}
+	p.finished(r, opath)
+	p.addToManifest(r, opath)
+	r.Output = ""
+	if p.progress != nil {
+		p.progress.add(r)
+	}
+	p.Summary.Add(r)
+}
+
+// Logs the event for the result of a task, for -log-format json. The output
+// of ffmpeg is only included when it failed.
+func (p *Exporter) finished(r Result, opath string) {
+	level := slog.LevelInfo
+	args := []any{
+		"action", r.Action.String(),
+		"status", r.Status.String(),
+		"path", r.Path,
+		"output", opath,
+	}
+	if r.Status != StatusSkipped {
+		args = append(args, "bytes", r.OutputBytes, "duration_ms", r.Timing.Busy().Milliseconds())
+	}
+	if r.Err != nil {
+		args = append(args, "error", r.Err.Error())
+	}
+	if r.Status == StatusFailed {
+		level = slog.LevelError
+		if r.Output != "" {
+			args = append(args, "ffmpeg_output", r.Output)
+		}
+	}
+	logging.Event(level, "finish", "Finished "+r.Path, args...)
+}
+
+// Returns what the -state journal records about exporting path to opath, where
+// st is the source.
+func (p *Exporter) stateRecord(path string, action Action, opath string, st fs.FileInfo) StateRecord {
+	r := StateRecord{Path: path, Output: opath, Size: st.Size(), ModTime: st.ModTime()}
+	if action == ActionConvert {
+		r.Settings = p.settings()
+	}
+	return r
+}
+
+// Adds r, the result of exporting to opath, to the -manifest, if any.
+func (p *Exporter) addToManifest(r Result, opath string) {
+	if p.manifest == nil || (r.Action != ActionConvert && r.Action != ActionCopy) || r.Status == StatusAborted {
+		return
+	}
+	m := ManifestRecord{
+		Path:       r.Path,
+		Output:     opath,
+		Size:       r.InputBytes,
+		OutputSize: r.OutputBytes,
+		DurationMS: r.Timing.Busy().Milliseconds(),
+		Dest:       p.dest,
+	}
+	switch {
+	case r.Status == StatusSkipped:
+		m.Action = "skipped"
+	case r.Status == StatusFailed:
+		m.Action = "failed"
+	case r.Action == ActionConvert:
+		m.Action = "converted"
+	default:
+		m.Action = "copied"
+	}
+	if r.Status != StatusDone {
+		// Only looked up for results done, but the manifest has them all.
+		if st, err := p.InRoot.Stat(r.Path); err == nil {
+			m.Size = st.Size()
+		}
+		if st, err := p.OutRoot.Stat(opath); err == nil {
+			m.OutputSize = st.Size()
+		}
+	}
+	p.manifest.Add(m)
+}
+
+// Records that the task for path, which would write opath, had nothing to do.
+func (p *Exporter) skip(path string, action Action, opath string) {
+	r := Result{
+		Path:   path,
+		Action: action,
+		Status: StatusSkipped,
+		Timing: Timing{QueueWait: p.queueWait(path)},
+	}
+	p.finished(r, opath)
+	p.addToManifest(r, opath)
+	if p.progress != nil {
+		p.progress.add(r)
+	}
+	p.Summary.Add(r)
+}
+
+// Notes that the task to do action to path, queued at the given time, has
+// started.
+func (p *Exporter) started(path string, action Action, queued time.Time) {
+	p.queueWaits.Store(path, time.Since(queued))
+	logging.Event(slog.LevelInfo, "start", "Started "+path, "action", action.String(), "path", path)
+}
+
+// Returns how long the task for path waited to start, if it was queued. Each
+// wait is only returned once, so that it's counted once.
+func (p *Exporter) queueWait(path string) time.Duration {
+	if d, ok := p.queueWaits.LoadAndDelete(path); ok {
+		return d.(time.Duration)
+	}
+	return 0
+}
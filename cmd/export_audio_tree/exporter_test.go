@@ -0,0 +1,1128 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/layout"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Creates an exporter between two temporary directories. Conversions go
+// through fake rather than ffmpeg. The configure functions may modify the
+// options before the exporter is created.
+func newTestExporter(t *testing.T, fake func(context.Context, *options.ConverterOptions) ([]byte, error), configure ...func(*options.ExporterOptions)) *Exporter {
+	opts := &options.ExporterOptions{
+		InRoot:      t.TempDir(),
+		OutRoot:     t.TempDir(),
+		Format:      "m4a",
+		CopyUnknown: true,
+	}
+	opts.CoverArtFormat = "copy"
+	opts.ArtFallback = true
+	for _, fn := range configure {
+		fn(opts)
+	}
+	p := newExporter(t.Context(), opts)
+	p.convert = fake
+	return p
+}
+
+// Creates the named files under root with some content.
+func writeFiles(t *testing.T, root string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExporterByAlbum(t *testing.T) {
+	var mutex sync.Mutex
+	running := make(map[string]bool)
+	converted := make(map[string][]string)
+	p := newTestExporter(t, func(c context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		album := filepath.Base(filepath.Dir(opts.InputFile))
+		mutex.Lock()
+		if running[album] {
+			t.Errorf("Two tracks of %s converted at once", album)
+		}
+		running[album] = true
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		running[album] = false
+		converted[album] = append(converted[album], filepath.Base(opts.InputFile))
+		mutex.Unlock()
+		return fakeConvert(c, opts)
+	}, func(opts *options.ExporterOptions) {
+		opts.ByAlbum = true
+		opts.MaxJobs = 4
+	})
+	songs := []string{"01 Song.flac", "02 Song.flac", "03 Song.flac", "04 Song.flac"}
+	for _, album := range []string{"A", "B"} {
+		for _, song := range songs {
+			writeFiles(t, p.opts.InRoot, album+"/"+song)
+		}
+		writeFiles(t, p.opts.InRoot, album+"/cover.jpg")
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, album := range []string{"A", "B"} {
+		if !slices.Equal(converted[album], songs) {
+			t.Errorf("Tracks of %s should convert in order: %q", album, converted[album])
+		}
+	}
+	if p.albums.Load() != 2 || p.albumsDone.Load() != 2 {
+		t.Errorf("Expected 2 of 2 albums done, have %d of %d", p.albumsDone.Load(), p.albums.Load())
+	}
+	if n := p.Summary.Count(StatusDone); n != 10 {
+		t.Errorf("Expected every file done: %+v", p.Summary.Results())
+	}
+}
+
+func TestExporterSplitCue(t *testing.T) {
+	var mutex sync.Mutex
+	var converted []*options.ConverterOptions
+	p := newTestExporter(t, func(c context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		mutex.Lock()
+		if filepath.Base(opts.InputFile) == "Live.flac" {
+			converted = append(converted, opts)
+		}
+		mutex.Unlock()
+		return fakeConvert(c, opts)
+	}, func(opts *options.ExporterOptions) {
+		opts.SplitCue = true
+	})
+	writeFiles(t, p.opts.InRoot, "Live/Live.flac", "Live/cover.jpg", "Other/Other.flac", "Other/Other.cue")
+	// Names the .wav it was ripped to, and has a slash in a title.
+	sheet := `PERFORMER "Band"
+TITLE "Live"
+FILE "Live.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "Intro"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "This/That"
+    INDEX 01 01:30:00
+`
+	if err := os.WriteFile(filepath.Join(p.opts.InRoot, "Live/Live.cue"), []byte(sheet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Live", "Live/01 - Intro.m4a", "Live/02 - This-That.m4a", "Live/cover.jpg",
+		"Other", "Other/Other.cue", "Other/Other.m4a",
+	}
+	if got := listTree(t, p.opts.OutRoot); !slices.Equal(got, want) {
+		t.Errorf("Bad output:\n got: %q\nwant: %q", got, want)
+	}
+	expected := []struct {
+		start, duration time.Duration
+		metadata        []string
+	}{
+		{0, 90 * time.Second, []string{"track=1", "title=Intro", "artist=Band", "album=Live"}},
+		{90 * time.Second, 0, []string{"track=2", "title=This/That", "artist=Band", "album=Live"}},
+	}
+	if len(converted) != len(expected) {
+		t.Fatalf("Expected %d tracks converted, have %d", len(expected), len(converted))
+	}
+	slices.SortFunc(converted, func(a, b *options.ConverterOptions) int { return int(a.Start - b.Start) })
+	for i, expect := range expected {
+		opts := converted[i]
+		if opts.Start != expect.start || opts.Duration != expect.duration {
+			t.Errorf("Bad trim for track %d: -start %v -duration %v", i+1, opts.Start, opts.Duration)
+		}
+		if !slices.Equal(opts.Metadata, expect.metadata) {
+			t.Errorf("Bad metadata for track %d: %q", i+1, opts.Metadata)
+		}
+	}
+	if r := p.Summary.Results(); !slices.ContainsFunc(r, func(r Result) bool { return r.Path == "Live/Live.flac#02" }) {
+		t.Errorf("Tracks should be summarized by their number: %+v", r)
+	}
+}
+
+func TestExporterReplayGain(t *testing.T) {
+	for _, byAlbum := range []bool{false, true} {
+		t.Run(fmt.Sprintf("by-album=%v", byAlbum), func(t *testing.T) {
+			var mutex sync.Mutex
+			tagged := make(map[string][]string)
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.ReplayGain = true
+				opts.ByAlbum = byAlbum
+			})
+			// The second song is 10 LU quieter, and just as long.
+			p.analyze = func(_ context.Context, name string) (ffmpeg.Loudness, []byte, error) {
+				if strings.HasPrefix(filepath.Base(name), "02") {
+					return ffmpeg.Loudness{Integrated: -20, Peak: 0.5, Duration: time.Minute}, nil, nil
+				}
+				return ffmpeg.Loudness{Integrated: -10, Peak: 0.9, Duration: time.Minute}, nil, nil
+			}
+			p.tag = func(_ context.Context, in, out string, tags []string) ([]byte, error) {
+				mutex.Lock()
+				tagged[filepath.Base(in)] = tags
+				mutex.Unlock()
+				data, err := os.ReadFile(in)
+				if err == nil {
+					err = os.WriteFile(out, data, 0644)
+				}
+				return nil, err
+			}
+			writeFiles(t, p.opts.InRoot, "A/01 Song.flac", "A/02 Song.flac", "A/cover.jpg")
+			if err := p.Run(); err != nil {
+				t.Fatal(err)
+			}
+
+			want := map[string][]string{
+				"01 Song.m4a": {"REPLAYGAIN_TRACK_GAIN=-8.00 dB", "REPLAYGAIN_TRACK_PEAK=0.900000"},
+				"02 Song.m4a": {"REPLAYGAIN_TRACK_GAIN=2.00 dB", "REPLAYGAIN_TRACK_PEAK=0.500000"},
+			}
+			if byAlbum {
+				for name := range want {
+					want[name] = append(want[name], "REPLAYGAIN_ALBUM_GAIN=-5.40 dB", "REPLAYGAIN_ALBUM_PEAK=0.900000")
+				}
+			}
+			if !maps.EqualFunc(tagged, want, slices.Equal) {
+				t.Errorf("Bad tags:\n got: %q\nwant: %q", tagged, want)
+			}
+			files := []string{"A", "A/01 Song.m4a", "A/02 Song.m4a", "A/cover.jpg"}
+			if got := listTree(t, p.opts.OutRoot); !slices.Equal(got, files) {
+				t.Errorf("Temporary files should be moved into place:\n got: %q\nwant: %q", got, files)
+			}
+			if data, err := os.ReadFile(filepath.Join(p.opts.OutRoot, "A/01 Song.m4a")); err != nil || !strings.HasSuffix(string(data), "01 Song.flac") {
+				t.Errorf("The tagged output should replace the original: %q %v", data, err)
+			}
+		})
+	}
+}
+
+func TestExporterArtFallback(t *testing.T) {
+	artErr := []byte("Error while decoding stream #0:1: Invalid data found when processing input")
+	failure := errors.New("exit status 1")
+
+	// Fails with an art error unless the art is dropped, in which case the
+	// result is retryErr.
+	fake := func(retryErr error) func(context.Context, *options.ConverterOptions) ([]byte, error) {
+		return func(_ context.Context, opts *options.ConverterOptions) ([]byte, error) {
+			if opts.CoverArtFormat != "none" {
+				return artErr, failure
+			}
+			if retryErr == nil {
+				return nil, os.WriteFile(opts.OutputFile, []byte("no art"), 0644)
+			}
+			return nil, retryErr
+		}
+	}
+
+	t.Run("retry succeeds", func(t *testing.T) {
+		p := newTestExporter(t, fake(nil))
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		if _, err := p.Convert("song.flac"); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		results := p.Summary.Results()
+		if len(results) != 1 || results[0].Status != StatusDone || !results[0].WithoutArt {
+			t.Errorf("Expected converted without art: %+v", results)
+		}
+	})
+	t.Run("retry fails", func(t *testing.T) {
+		p := newTestExporter(t, fake(failure))
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		if _, err := p.Convert("song.flac"); err == nil {
+			t.Fatalf("Convert should have failed")
+		}
+		results := p.Summary.Results()
+		if len(results) != 1 || results[0].Status != StatusFailed || results[0].WithoutArt {
+			t.Errorf("Expected failure: %+v", results)
+		}
+	})
+}
+
+func TestExporterSidecarArt(t *testing.T) {
+	var mutex sync.Mutex
+	art := make(map[string]string)
+	p := newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		mutex.Lock()
+		art[filepath.Base(opts.InputFile)] = opts.ArtFile
+		mutex.Unlock()
+		return fakeConvert(ctx, opts)
+	}, func(opts *options.ExporterOptions) {
+		opts.SidecarArt = true
+	})
+	writeFiles(t, p.opts.InRoot, "Album/01 Song.flac", "Album/02 Song.flac", "Single/03 Song.flac")
+	for name, size := range map[string]int{"Album/cover.png": 10, "Album/folder.png": 20} {
+		var b bytes.Buffer
+		if err := png.Encode(&b, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, filepath.FromSlash(name)), b.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	folder := filepath.Join(p.opts.InRoot, "Album", "folder.png")
+	expected := map[string]string{"01 Song.flac": folder, "02 Song.flac": folder, "03 Song.flac": ""}
+	if !maps.Equal(art, expected) {
+		t.Errorf("Bad art:\nactual  : %q\nexpected: %q", art, expected)
+	}
+	if !strings.HasSuffix(p.settings(), " -sidecar-art") {
+		t.Errorf("-sidecar-art should be part of the settings: %q", p.settings())
+	}
+}
+
+func TestExporterAtomicConvert(t *testing.T) {
+	// Writes something to the output, then returns err.
+	fake := func(err error) func(context.Context, *options.ConverterOptions) ([]byte, error) {
+		return func(_ context.Context, opts *options.ConverterOptions) ([]byte, error) {
+			if !filesystem.IsTempName(opts.OutputFile) {
+				t.Errorf("ffmpeg should write to a temporary file, not %q", opts.OutputFile)
+			}
+			if werr := os.WriteFile(opts.OutputFile, []byte("converted"), 0644); werr != nil {
+				t.Fatal(werr)
+			}
+			return nil, err
+		}
+	}
+	// No temporary files should be left behind.
+	assertTree := func(p *Exporter, expected ...string) {
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		p := newTestExporter(t, fake(nil))
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		if _, err := p.Convert("song.flac"); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		assertTree(p, "song.m4a")
+	})
+	t.Run("failure", func(t *testing.T) {
+		p := newTestExporter(t, fake(errors.New("exit status 1")))
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		if _, err := p.Convert("song.flac"); err == nil {
+			t.Fatalf("Convert should have failed")
+		}
+		assertTree(p)
+	})
+	t.Run("no clobber", func(t *testing.T) {
+		p := newTestExporter(t, func(context.Context, *options.ConverterOptions) ([]byte, error) {
+			t.Error("Should not have converted")
+			return nil, nil
+		})
+		p.opts.NoClobber = true
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		writeFiles(t, p.opts.OutRoot, "song.m4a")
+		if _, err := p.Convert("song.flac"); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if n := p.Summary.Count(StatusSkipped); n != 1 {
+			t.Errorf("Expected a skipped result: %+v", p.Summary.Results())
+		}
+	})
+	t.Run("no clobber race", func(t *testing.T) {
+		var p *Exporter
+		p = newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+			// Something else writes the output while converting.
+			writeFiles(t, p.opts.OutRoot, "song.m4a")
+			return fakeConvert(ctx, opts)
+		})
+		p.opts.NoClobber = true
+		writeFiles(t, p.opts.InRoot, "song.flac")
+		if _, err := p.Convert("song.flac"); err != nil {
+			t.Fatalf("Convert failed: %v", err)
+		}
+		if n := p.Summary.Count(StatusSkipped); n != 1 {
+			t.Errorf("Expected a skipped result: %+v", p.Summary.Results())
+		}
+		if data, err := os.ReadFile(filepath.Join(p.opts.OutRoot, "song.m4a")); err != nil || string(data) != "song.m4a" {
+			t.Errorf("Expected the other output to be kept, have %q: %v", data, err)
+		}
+		assertTree(p, "song.m4a")
+	})
+}
+
+func TestExporterVerify(t *testing.T) {
+	setup := func(t *testing.T, fake func(context.Context, *options.ConverterOptions) ([]byte, error)) *Exporter {
+		p := newTestExporter(t, fake, func(opts *options.ExporterOptions) {
+			opts.Verify = true
+		})
+		p.verify = func(_ context.Context, name string) ([]byte, error) {
+			if !filesystem.IsTempName(name) {
+				t.Errorf("Should verify before renaming, not %q", name)
+			}
+			if strings.Contains(name, "bad") {
+				return []byte("Truncated stream"), errors.New("decoding failed")
+			}
+			return nil, nil
+		}
+		writeFiles(t, p.opts.InRoot, "good.flac", "bad.flac")
+		return p
+	}
+
+	t.Run("decode", func(t *testing.T) {
+		p := setup(t, fakeConvert)
+		if _, err := p.Convert("good.flac"); err != nil {
+			t.Errorf("Convert failed: %v", err)
+		}
+		if _, err := p.Convert("bad.flac"); err == nil {
+			t.Errorf("Convert should have failed verification")
+		}
+		if actual, expected := listTree(t, p.opts.OutRoot), []string{"good.m4a"}; !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+		if n := p.Summary.Count(StatusFailed); n != 1 {
+			t.Errorf("Expected a failed result: %+v", p.Summary.Results())
+		}
+	})
+	t.Run("empty", func(t *testing.T) {
+		p := setup(t, func(_ context.Context, opts *options.ConverterOptions) ([]byte, error) {
+			return nil, os.WriteFile(opts.OutputFile, nil, 0644)
+		})
+		if _, err := p.Convert("good.flac"); err == nil || !strings.Contains(err.Error(), "empty") {
+			t.Errorf("Convert should have failed on an empty output: %v", err)
+		}
+		if actual := listTree(t, p.opts.OutRoot); len(actual) != 0 {
+			t.Errorf("Bad output should have been removed: %q", actual)
+		}
+	})
+}
+
+func TestExporterFailures(t *testing.T) {
+	p := newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if strings.Contains(opts.InputFile, "bad") {
+			return []byte("Invalid data found when processing input"), errors.New("exit status 1")
+		}
+		return fakeConvert(ctx, opts)
+	})
+	writeFiles(t, p.opts.InRoot, "bad.flac", "good.flac", "very bad.flac", "cover.jpg")
+	err := p.Run()
+	if err == nil {
+		t.Fatalf("Run should have failed")
+	}
+	for _, name := range []string{"bad.flac", "very bad.flac"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Error doesn't mention %s: %v", name, err)
+		}
+	}
+	// The failures don't stop everything else.
+	expected := []string{"cover.jpg", "good.m4a"}
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+		t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+	}
+	if stats := p.Summary.Stats(); stats.Failed != 2 || stats.Converted != 1 || stats.Copied != 1 {
+		t.Errorf("Bad stats: %+v", stats)
+	}
+}
+
+func TestExporterJSONLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "export.log")
+	if err := logging.Initialize(t.Context(), name, slog.LevelInfo, logging.FormatJSON, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Initialize(t.Context(), "", slog.LevelInfo, logging.FormatText, false) })
+	p := newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if strings.Contains(opts.InputFile, "bad") {
+			return []byte("Invalid data found when processing input"), errors.New("exit status 1")
+		}
+		return fakeConvert(ctx, opts)
+	})
+	writeFiles(t, p.opts.InRoot, "bad.flac", "good.flac", "cover.jpg")
+	p.Run()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(map[string]map[string]any)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Bad JSON %q: %v", line, err)
+		}
+		if event, ok := r["event"].(string); ok {
+			events[event+" "+r["path"].(string)] = r
+		}
+	}
+	for _, path := range []string{"bad.flac", "good.flac", "cover.jpg"} {
+		if events["start "+path] == nil || events["finish "+path] == nil {
+			t.Errorf("Missing the start or finish of %s: %v", path, events)
+		}
+	}
+	if r := events["finish good.flac"]; r == nil || r["status"] != "done" || r["bytes"] == nil || r["ffmpeg_output"] != nil {
+		t.Errorf("Bad finish for a conversion: %v", r)
+	}
+	if r := events["finish cover.jpg"]; r == nil || r["action"] != "copy" || r["bytes"] != float64(len("cover.jpg")) {
+		t.Errorf("Bad finish for a copy: %v", r)
+	}
+	// Only failures have the output of ffmpeg.
+	if r := events["finish bad.flac"]; r == nil || r["level"] != "ERROR" || r["error"] == nil || r["ffmpeg_output"] != "Invalid data found when processing input" {
+		t.Errorf("Bad finish for a failure: %v", r)
+	}
+}
+
+func TestExporterLogStatus(t *testing.T) {
+	p := newTestExporter(t, fakeConvert)
+	// Returns a channel closed once logStatus returns.
+	start := func(ctx context.Context, interval time.Duration) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			p.logStatus(ctx, interval)
+			close(done)
+		}()
+		return done
+	}
+	assertStops := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("logStatus did not return")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := start(ctx, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assertStops(done)
+
+	// Disabled, it shouldn't wait for the context at all.
+	assertStops(start(t.Context(), 0))
+}
+
+func TestSlowTasks(t *testing.T) {
+	now := time.Now()
+	running := []workpool.TaskInfo{
+		{Worker: 1, Name: "hung.flac", Started: now.Add(-time.Hour)},
+		{Worker: 2, Name: "slow.flac", Started: now.Add(-slowTaskAge)},
+		{Worker: 3, Name: "fine.flac", Started: now.Add(-time.Minute)},
+	}
+	slow := slowTasks(running, now)
+	if len(slow) != 2 || slow[0].Name != "hung.flac" || slow[1].Name != "slow.flac" {
+		t.Errorf("Bad slow tasks: %+v", slow)
+	}
+}
+
+// Fake conversion that just writes the output file.
+func TestExporterLogsOutput(t *testing.T) {
+	for _, tc := range []struct {
+		mode       string
+		ok, failed bool
+	}{
+		{options.FFmpegLogFull, true, true},
+		{options.FFmpegLogErrors, false, true},
+		{options.FFmpegLogNone, false, false},
+	} {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.FFmpegLog = tc.mode
+		})
+		if p.logsOutput(false) != tc.ok || p.logsOutput(true) != tc.failed {
+			t.Errorf("-ffmpeg-log %s: expected logging of successes %v and failures %v", tc.mode, tc.ok, tc.failed)
+		}
+	}
+}
+
+func TestExporterErrorLogs(t *testing.T) {
+	logs := filepath.Join(t.TempDir(), "logs")
+	p := newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if strings.Contains(opts.InputFile, "Bad") {
+			return []byte("boom\n"), errors.New("exit status 1")
+		}
+		return fakeConvert(ctx, opts)
+	}, func(opts *options.ExporterOptions) {
+		opts.ErrorLogs = logs
+	})
+	writeFiles(t, p.opts.InRoot, "Album/01 Bad.flac", "Album/02 Good.flac")
+	// Left over from when the good file failed before.
+	writeFiles(t, logs, "Album/02 Good.m4a.ffmpeg.log")
+	if err := p.Run(); err == nil {
+		t.Fatal("Expected the bad file to fail")
+	}
+
+	if got, want := listTree(t, logs), []string{"Album", "Album/01 Bad.m4a.ffmpeg.log"}; !slices.Equal(got, want) {
+		t.Errorf("Bad error logs:\n got: %q\nwant: %q", got, want)
+	}
+	name := filepath.Join(logs, "Album", "01 Bad.m4a.ffmpeg.log")
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Command: ffmpeg ", `"` + filepath.Join(p.opts.InRoot, "Album", "01 Bad.flac") + `"`, "Error: ", "exit status 1", "boom"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Error log is missing %q:\n%s", want, data)
+		}
+	}
+	if report := p.Summary.String(); !strings.Contains(report, name) {
+		t.Errorf("The summary should point to the error log:\n%s", report)
+	}
+}
+
+func TestExporterTimeout(t *testing.T) {
+	logs := filepath.Join(t.TempDir(), "logs")
+	// Hangs on the stuck file after writing part of it, until it's killed.
+	p := newTestExporter(t, func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if !strings.Contains(opts.InputFile, "Stuck") {
+			return fakeConvert(ctx, opts)
+		}
+		if err := os.WriteFile(opts.OutputFile, []byte("partial"), 0644); err != nil {
+			return nil, err
+		}
+		<-ctx.Done()
+		return []byte("size=1kB\n"), errors.New("signal: killed")
+	}, func(opts *options.ExporterOptions) {
+		opts.Timeout = 50 * time.Millisecond
+		opts.ErrorLogs = logs
+	})
+	writeFiles(t, p.opts.InRoot, "Album/01 Stuck.flac", "Album/02 Good.flac")
+	err := p.Run()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("Expected the stuck file to time out: %v", err)
+	}
+	// The partial output is removed, and the rest of the export carries on.
+	if got, want := listTree(t, p.opts.OutRoot), []string{"Album", "Album/02 Good.m4a"}; !slices.Equal(got, want) {
+		t.Errorf("Bad output:\n got: %q\nwant: %q", got, want)
+	}
+	if stats := p.Summary.Stats(); stats.Failed != 1 || stats.Converted != 1 || stats.Aborted != 0 {
+		t.Errorf("A timeout should count as failed: %+v", stats)
+	}
+	data, err := os.ReadFile(filepath.Join(logs, "Album", "01 Stuck.m4a.ffmpeg.log"))
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), "size=1kB") {
+		t.Errorf("The error log should have the output from before the timeout:\n%s", data)
+	}
+}
+
+func TestExporterRunner(t *testing.T) {
+	opts := &options.ExporterOptions{
+		InRoot:      t.TempDir(),
+		OutRoot:     t.TempDir(),
+		Format:      "m4a",
+		CopyUnknown: true,
+		Verify:      true,
+	}
+	opts.FFmpeg = "ffmpeg"
+	opts.CoverArtFormat = "copy"
+	p := newExporter(t.Context(), opts)
+	// Stands in for ffmpeg by writing the output, its last argument, unless
+	// that's "-" for decoding to nothing.
+	rec := &ffmpeg.Recorder{Fake: func(ctx context.Context, name string, args []string, stdio ffmpeg.Stdio) error {
+		if out := args[len(args)-1]; out != "-" {
+			return os.WriteFile(out, []byte("converted"), 0644)
+		}
+		return nil
+	}}
+	p.runner = rec
+	writeFiles(t, opts.InRoot, "Album/01 Song.flac", "Album/cover.jpg")
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listTree(t, opts.OutRoot), []string{"Album", "Album/01 Song.m4a", "Album/cover.jpg"}; !slices.Equal(got, want) {
+		t.Errorf("Bad output:\n got: %q\nwant: %q", got, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(opts.OutRoot, "Album", "01 Song.m4a")); err != nil || string(data) != "converted" {
+		t.Errorf("Bad conversion: %q err: %v", data, err)
+	}
+	// The conversion, then the -verify of its output.
+	calls := rec.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 commands: %q", calls)
+	}
+	if !slices.Contains(calls[0], filepath.Join(opts.InRoot, "Album", "01 Song.flac")) {
+		t.Errorf("Expected the conversion first: %q", calls[0])
+	} else if !slices.Contains(calls[1], "-xerror") {
+		t.Errorf("Expected the verification second: %q", calls[1])
+	}
+}
+
+func TestExporterSkipsTreeConfig(t *testing.T) {
+	p := newTestExporter(t, fakeConvert)
+	writeFiles(t, p.opts.InRoot, options.TreeConfigFile, "Album/"+options.TreeConfigFile, "Album/01 Song.flac")
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the one at the root is a config file.
+	want := []string{"Album", "Album/" + options.TreeConfigFile, "Album/01 Song.m4a"}
+	if got := listTree(t, p.opts.OutRoot); !slices.Equal(got, want) {
+		t.Errorf("Bad output:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func fakeConvert(_ context.Context, opts *options.ConverterOptions) ([]byte, error) {
+	return nil, os.WriteFile(opts.OutputFile, []byte(opts.InputFile), 0644)
+}
+
+// Returns the files and directories under root, relative to it. The
+// FingerprintsFile and ChecksumsFile are left out, since they're bookkeeping
+// rather than output.
+func listTree(t *testing.T, root string) []string {
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && d.Name() != FingerprintsFile && d.Name() != ChecksumsFile {
+			rel, _ := filepath.Rel(root, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestExporterLayout(t *testing.T) {
+	t.Run("flatten discs", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.Layout, _ = layout.Parse(layout.FlattenDiscs)
+			opts.DedupeSuffix = true
+		})
+		writeFiles(t, p.opts.InRoot,
+			"Artist/Album/Disc 1/01 Intro.flac",
+			"Artist/Album/Disc 1/cover.jpg",
+			"Artist/Album/Disc 2/01 Intro.flac",
+			"Artist/Album/Disc 2/02 Outro.flac",
+		)
+		if err := p.Run(); err != nil {
+			t.Fatal(err)
+		}
+		// The second intro collides with the first, and is numbered like any other
+		// collision.
+		expected := []string{
+			"Artist",
+			"Artist/Album",
+			"Artist/Album/01 Intro (2).m4a",
+			"Artist/Album/01 Intro.m4a",
+			"Artist/Album/02 Outro.m4a",
+			"Artist/Album/cover.jpg",
+		}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Expected %q, have %q", expected, actual)
+		}
+	})
+	t.Run("template", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.Layout, _ = layout.Parse("{artist}/{album}/{track:02d} {title}")
+		})
+		writeFiles(t, p.opts.InRoot, "rips/a.flac", "rips/b.flac", "rips/folder.jpg", "untagged/c.flac")
+		tags := map[string]map[string]string{
+			"a.flac": {"artist": "Artist", "album": "Album", "track": "1/2", "title": "One"},
+			"b.flac": {"artist": "Artist", "album": "Album", "track": "2/2", "title": "Two"},
+		}
+		p.probe = func(ctx context.Context, name string) (*ffmpeg.MediaInfo, error) {
+			if t := tags[filepath.Base(name)]; t != nil {
+				return &ffmpeg.MediaInfo{Tags: t}, nil
+			}
+			return nil, ffmpeg.ErrNoInputInfo
+		}
+		if err := p.Run(); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			"Artist",
+			"Artist/Album",
+			"Artist/Album/01 One.m4a",
+			"Artist/Album/02 Two.m4a",
+			"Artist/Album/folder.jpg",
+			"Unknown Artist",
+			"Unknown Artist/Unknown Album",
+			"Unknown Artist/Unknown Album/00 Unknown Title.m4a",
+		}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Expected %q, have %q", expected, actual)
+		}
+	})
+}
+
+func TestExporterMemFS(t *testing.T) {
+	p := newTestExporter(t, nil, func(opts *options.ExporterOptions) {
+		opts.LossyPolicy = options.LossyCopy
+	})
+	in := filesystem.NewMemFS(fstest.MapFS{
+		"Artist/Album/01 Song.flac": {Data: []byte("flac"), Mode: 0644},
+		"Artist/Album/02 Song.mp3":  {Data: []byte("mp3"), Mode: 0644},
+		"Artist/Album/cover.jpg":    {Data: []byte("jpeg"), Mode: 0600},
+		"Artist/Album/.DS_Store":    {Data: []byte("trash"), Mode: 0644},
+		"Booklet.pdf":               {Data: []byte("pdf"), Mode: 0644},
+	})
+	out := filesystem.NewMemFS(nil)
+	p.InRoot, p.OutRoot = in, out
+	p.freeSpace = func() (uint64, error) { return 1 << 30, nil }
+	p.convert = func(_ context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		// ffmpeg is given paths on disk, so map them back into the FS.
+		name, err := filepath.Rel(p.opts.OutRoot, opts.OutputFile)
+		if err != nil {
+			return nil, err
+		}
+		f, err := out.Create(filepath.ToSlash(name))
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write([]byte("converted " + filepath.Base(opts.InputFile)))
+		return nil, errors.Join(err, f.Close())
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	names, err := out.Glob("**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = slices.DeleteFunc(names, func(name string) bool { return name == FingerprintsFile })
+	expected := []string{
+		"Artist",
+		"Artist/Album",
+		"Artist/Album/01 Song.m4a",
+		"Artist/Album/02 Song.mp3",
+		"Artist/Album/cover.jpg",
+		"Booklet.pdf",
+	}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", names, expected)
+	}
+	for name, content := range map[string]string{
+		"Artist/Album/01 Song.m4a": "converted 01 Song.flac",
+		"Artist/Album/02 Song.mp3": "mp3",
+		"Artist/Album/cover.jpg":   "jpeg",
+	} {
+		if data, err := out.ReadFile(name); err != nil || string(data) != content {
+			t.Errorf("Bad %q: %q err: %v", name, data, err)
+		}
+	}
+	if st, err := out.Stat("Artist/Album/cover.jpg"); err != nil || st.Mode().Perm() != 0600 {
+		t.Errorf("The copy should keep the mode of its source: %v err: %v", st, err)
+	}
+	if actual := listTree(t, p.opts.OutRoot); len(actual) != 0 {
+		t.Errorf("Wrote to the disk: %q", actual)
+	}
+	if n := p.Summary.Count(StatusDone); n != 4 {
+		t.Errorf("Expected every file done: %+v", p.Summary.Results())
+	}
+}
+
+func TestExporterCleanPaths(t *testing.T) {
+	// Slashes can't survive in a name, so rippers usually swap AC/DC for AC:DC.
+	input := []string{
+		"AC:DC/Back in Black/01 Hells Bells.flac",
+		"AC:DC/Back in Black/cover.jpg",
+		"Who? What?/Why?.flac",
+		"Who? What?/notes*.txt",
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.CleanPaths = "_"
+		})
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expected := []string{
+			"AC_DC",
+			"AC_DC/Back in Black",
+			"AC_DC/Back in Black/01 Hells Bells.m4a",
+			"AC_DC/Back in Black/cover.jpg",
+			"Who_ What_",
+			"Who_ What_/Why_.m4a",
+			"Who_ What_/notes_.txt",
+		}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+	})
+	t.Run("not clean", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert)
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		expected := []string{
+			"AC:DC",
+			"AC:DC/Back in Black",
+			"AC:DC/Back in Black/01 Hells Bells.m4a",
+			"AC:DC/Back in Black/cover.jpg",
+			"Who? What?",
+			"Who? What?/Why?.m4a",
+			"Who? What?/notes*.txt",
+		}
+		if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+			t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+		}
+	})
+}
+
+func TestExporterMaxBytes(t *testing.T) {
+	// Classical tags make for long names that only differ near the end.
+	album := "Beethoven - Symphonies Nos. 5 & 7 - Wiener Philharmoniker, Carlos Kleiber (1975, Remastered)"
+	movement := "Symphony No. 5 in C minor, Op. 67 - "
+	input := []string{
+		album + "/" + movement + "I. Allegro con brio.flac",
+		album + "/" + movement + "II. Andante con moto.flac",
+		album + "/cover.jpg",
+	}
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.MaxNameBytes = 48
+		opts.MaxPathBytes = 80
+	})
+	writeFiles(t, p.opts.InRoot, input...)
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	actual := listTree(t, p.opts.OutRoot)
+	if len(actual) != len(input)+1 {
+		t.Fatalf("Truncated names weren't unique: %q", actual)
+	}
+	dir := actual[0]
+	if len(dir) > 48 || !strings.HasPrefix(album, dir[:strings.Index(dir, "~")]) {
+		t.Errorf("Bad directory: %q", dir)
+	}
+	for _, name := range actual[1:] {
+		if len(name) > 80 || pathpkg.Dir(name) != dir {
+			t.Errorf("Bad output %q for directory %q", name, dir)
+		} else if ext := pathpkg.Ext(name); ext != ".m4a" && ext != ".jpg" {
+			t.Errorf("Lost the extension of %q", name)
+		}
+	}
+	if !slices.Contains(actual, dir+"/cover.jpg") {
+		t.Errorf("Shortened a name that fits: %q", actual)
+	}
+}
+
+func TestExporterExclude(t *testing.T) {
+	input := []string{
+		"Artist/Album/01 Song.flac",
+		"Artist/Album/booklet.pdf",
+		"Artist/Album/scans/front.jpg",
+		"Booklets/liner notes.pdf",
+		"__backup/old.flac",
+		"__backup/keep/favorite.flac",
+	}
+	for _, tc := range []struct {
+		name     string
+		excludes []string
+		includes []string
+		expected []string
+	}{
+		{
+			name:     "exclude",
+			excludes: []string{"__backup/", "**/*.pdf", "**/scans/"},
+			// Like any other directory, Booklets is created up front even
+			// though there's nothing left in it to export.
+			expected: []string{
+				"Artist",
+				"Artist/Album",
+				"Artist/Album/01 Song.m4a",
+				"Booklets",
+			},
+		},
+		{
+			name:     "include",
+			excludes: []string{"__backup/", "**/*.pdf", "**/scans/"},
+			includes: []string{"Booklets/**", "__backup/keep/"},
+			expected: []string{
+				"Artist",
+				"Artist/Album",
+				"Artist/Album/01 Song.m4a",
+				"Booklets",
+				"Booklets/liner notes.pdf",
+				"__backup",
+				"__backup/keep",
+				"__backup/keep/favorite.m4a",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.Excludes = tc.excludes
+				opts.Includes = tc.includes
+			})
+			writeFiles(t, p.opts.InRoot, input...)
+			if err := p.Run(); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, tc.expected) {
+				t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExporterMediaExtensions(t *testing.T) {
+	input := []string{"Album/01 Song.ape", "Album/02 Song.flac", "Album/stems/vocals.wav"}
+	for _, tc := range []struct {
+		name     string
+		media    []string
+		ignore   []string
+		expected []string
+	}{
+		{
+			name: "default",
+			// The .ape is unknown, so it's copied as is.
+			expected: []string{"Album", "Album/01 Song.ape", "Album/02 Song.m4a", "Album/stems", "Album/stems/vocals.m4a"},
+		},
+		{
+			name:     "media-ext",
+			media:    []string{".ape"},
+			expected: []string{"Album", "Album/01 Song.m4a", "Album/02 Song.m4a", "Album/stems", "Album/stems/vocals.m4a"},
+		},
+		{
+			name:   "ignore-ext",
+			media:  []string{".ape"},
+			ignore: []string{".wav"},
+			// Like any other directory, stems is created up front.
+			expected: []string{"Album", "Album/01 Song.m4a", "Album/02 Song.m4a", "Album/stems"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.MediaExts = tc.media
+				opts.IgnoreExts = tc.ignore
+			})
+			writeFiles(t, p.opts.InRoot, input...)
+			if err := p.Run(); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, tc.expected) {
+				t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExporterOnly(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.Only = []string{"Artist A"}
+		opts.Excludes = []string{"**/*.pdf"}
+	})
+	writeFiles(t, p.opts.InRoot, "Artist A/Album/song.flac", "Artist A/Album/booklet.pdf", "Artist B/Album/song.flac")
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	expected := []string{"Artist A", "Artist A/Album", "Artist A/Album/song.m4a"}
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+		t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+	}
+
+	// Outputs outside of -only aren't candidates for pruning.
+	writeFiles(t, p.opts.OutRoot, "Artist A/gone.m4a", "Artist C/gone.m4a")
+	diff, err := p.Diff()
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if !slices.Equal(diff.Prune, []string{"Artist A/gone.m4a"}) || len(diff.Unchanged) != 1 {
+		t.Errorf("Bad diff: %+v", diff)
+	}
+}
+
+func TestExporterLossyPolicy(t *testing.T) {
+	input := []string{"a.flac", "b.mp3", "c.wav", "cover.jpg"}
+	for _, tc := range []struct {
+		policy   string
+		expected []string
+		stats    Stats
+	}{
+		{options.LossyConvert, []string{"a.m4a", "b.m4a", "c.m4a", "cover.jpg"}, Stats{Converted: 3, Copied: 1}},
+		{options.LossyCopy, []string{"a.m4a", "b.mp3", "c.m4a", "cover.jpg"}, Stats{Converted: 2, Copied: 2}},
+		{options.LossySkip, []string{"a.m4a", "c.m4a", "cover.jpg"}, Stats{Converted: 2, Copied: 1, Skipped: 1}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.LossyPolicy = tc.policy
+			})
+			writeFiles(t, p.opts.InRoot, input...)
+			if err := p.Run(); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, tc.expected) {
+				t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, tc.expected)
+			}
+			stats := p.Summary.Stats()
+			if stats.Converted != tc.stats.Converted || stats.Copied != tc.stats.Copied || stats.Skipped != tc.stats.Skipped {
+				t.Errorf("Bad stats: %+v expected: %+v", stats, tc.stats)
+			}
+		})
+	}
+}
+
+func TestExporterPreserveTimes(t *testing.T) {
+	past := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	for _, tc := range []struct {
+		preserve string
+		copied   bool
+		convert  bool
+	}{
+		{options.PreserveNone, false, false},
+		{options.PreserveCopies, true, false},
+		{options.PreserveAll, true, true},
+	} {
+		t.Run(tc.preserve, func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.PreserveTime = tc.preserve
+			})
+			writeFiles(t, p.opts.InRoot, "song.flac", "cover.jpg")
+			for _, name := range []string{"song.flac", "cover.jpg"} {
+				path := filepath.Join(p.opts.InRoot, name)
+				if err := os.Chtimes(path, past, past); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := p.Run(); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			assert := func(name string, preserved bool, mode fs.FileMode) {
+				st, err := os.Stat(filepath.Join(p.opts.OutRoot, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if actual := st.ModTime().Equal(past); actual != preserved {
+					t.Errorf("%s: mtime %v preserved: %v expected: %v", name, st.ModTime(), actual, preserved)
+				}
+				if preserved && mode != 0 && st.Mode().Perm() != mode {
+					t.Errorf("%s: mode %v expected: %v", name, st.Mode().Perm(), mode)
+				}
+			}
+			assert("cover.jpg", tc.copied, 0600)
+			assert("song.m4a", tc.convert, 0)
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// Name of the file in the output root that records the settings each output
+// was converted with.
+const FingerprintsFile = ".export_audio_tree.json"
+
+// Name of the file in the output root that records the SHA-256 of the source
+// each output was exported from, for -checksum.
+const ChecksumsFile = ".export_audio_tree.sha256.json"
+
+// Maps output names to the ffmpeg.Fingerprint of the settings they were
+// converted with, so that -update can tell when an output is stale because the
+// settings changed rather than the source. The same is done with the
+// checksums of sources for -checksum. Safe for concurrent use.
+type Fingerprints struct {
+	mutex   sync.Mutex
+	file    string // Where it's saved in the output root.
+	entries map[string]string
+}
+
+func NewFingerprints() *Fingerprints {
+	return &Fingerprints{file: FingerprintsFile, entries: make(map[string]string)}
+}
+
+// Loads FingerprintsFile from fsys. It's not an error if the file doesn't
+// exist, e.g., on the first export.
+func LoadFingerprints(fsys filesystem.FS) (*Fingerprints, error) {
+	return loadFingerprints(fsys, FingerprintsFile)
+}
+
+// Loads ChecksumsFile from fsys, like LoadFingerprints.
+func LoadChecksums(fsys filesystem.FS) (*Fingerprints, error) {
+	return loadFingerprints(fsys, ChecksumsFile)
+}
+
+func loadFingerprints(fsys filesystem.FS, file string) (*Fingerprints, error) {
+	f := NewFingerprints()
+	f.file = file
+	data, err := fsys.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &f.entries); err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", file, err)
+	}
+	return f, nil
+}
+
+// Returns the fingerprint recorded for name, if any.
+func (f *Fingerprints) Get(name string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	fp, ok := f.entries[name]
+	return fp, ok
+}
+
+// Records that name was converted with the settings in fingerprint.
+func (f *Fingerprints) Set(name, fingerprint string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.entries[name] = fingerprint
+}
+
+// Reports whether name is known to have been converted with settings other
+// than fingerprint. Outputs without a record are given the benefit of the
+// doubt.
+func (f *Fingerprints) Changed(name, fingerprint string) bool {
+	fp, ok := f.Get(name)
+	return ok && fp != fingerprint
+}
+
+// Writes the file it was loaded from to fsys, unless there's nothing to write.
+func (f *Fingerprints) Save(fsys filesystem.FS) error {
+	f.mutex.Lock()
+	n := len(f.entries)
+	data, err := json.MarshalIndent(f.entries, "", "  ")
+	f.mutex.Unlock()
+	if err != nil || n == 0 {
+		return err
+	}
+	af := filesystem.NewAtomicFile(fsys, f.file)
+	fp, err := af.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(data); err != nil {
+		return errors.Join(err, af.Abort())
+	}
+	return af.Commit()
+}
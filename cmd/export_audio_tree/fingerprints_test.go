@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/options"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFingerprints(t *testing.T) {
+	fsys := filesystem.NewFileSystem(t.TempDir())
+
+	f, err := LoadFingerprints(fsys)
+	if err != nil {
+		t.Fatalf("Missing file should not be an error: %v", err)
+	}
+	if err := f.Save(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.Stat(FingerprintsFile); err == nil {
+		t.Errorf("Nothing should be saved without entries")
+	}
+
+	f.Set("song.m4a", "-c:a aac -b:a 256k")
+	if f.Changed("song.m4a", "-c:a aac -b:a 256k") {
+		t.Errorf("Same settings should not be a change")
+	}
+	if !f.Changed("song.m4a", "-c:a aac -b:a 128k") {
+		t.Errorf("Different settings should be a change")
+	}
+	if f.Changed("other.m4a", "-c:a aac -b:a 128k") {
+		t.Errorf("Unknown outputs should not be a change")
+	}
+
+	if err := f.Save(fsys); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFingerprints(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp, ok := loaded.Get("song.m4a"); !ok || fp != "-c:a aac -b:a 256k" {
+		t.Errorf("Fingerprint did not round trip: %q %v", fp, ok)
+	}
+}
+
+func TestExporterUpdate(t *testing.T) {
+	first := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.BitRate = "256k"
+	})
+	writeFiles(t, first.opts.InRoot, "song.flac", "cover.jpg")
+	if err := first.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// Runs the export again over the same roots.
+	rerun := func(bitRate string, ignoreSettings bool) *Exporter {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.InRoot = first.opts.InRoot
+			opts.OutRoot = first.opts.OutRoot
+			opts.BitRate = bitRate
+			opts.Update = true
+			opts.IgnoreSettingsChange = ignoreSettings
+		})
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		return p
+	}
+	assert := func(p *Exporter, converted, copied, skipped int) {
+		t.Helper()
+		stats := p.Summary.Stats()
+		if stats.Converted != converted || stats.Copied != copied || stats.Skipped != skipped {
+			t.Errorf("Expected %d converted, %d copied, %d skipped: %+v", converted, copied, skipped, stats)
+		}
+	}
+
+	assert(rerun("256k", false), 0, 0, 2)
+	assert(rerun("128k", true), 0, 0, 2)
+	assert(rerun("128k", false), 1, 0, 1)
+	assert(rerun("128k", false), 0, 0, 2)
+
+	// A newer source is stale regardless of settings.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(first.opts.InRoot, "cover.jpg"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	assert(rerun("128k", false), 0, 1, 1)
+}
+
+func TestExporterDiffSettings(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.BitRate = "128k"
+	})
+	writeFiles(t, p.opts.InRoot, "song.flac")
+	writeFiles(t, p.opts.OutRoot, "song.m4a")
+	f := NewFingerprints()
+	f.Set("song.m4a", "-c:v copy -b:a 256k")
+	if err := f.Save(p.OutRoot); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := p.Diff()
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	if len(diff.Stale) != 1 || len(diff.Prune) != 0 {
+		t.Errorf("Expected song.m4a to be stale: %+v", diff)
+	}
+}
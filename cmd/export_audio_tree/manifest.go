@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// One record of a -manifest, for a file that was processed.
+type ManifestRecord struct {
+	Path       string `json:"path"`   // The source, relative to the input root.
+	Output     string `json:"output"` // Relative to the output root.
+	Action     string `json:"action"` // converted, copied, skipped, or failed.
+	Size       int64  `json:"size"`   // Of the source.
+	OutputSize int64  `json:"output_size"`
+	DurationMS int64  `json:"duration_ms"`    // Spent working on it, not waiting.
+	Dest       string `json:"dest,omitempty"` // The -dest it was exported to, if any.
+}
+
+// The column names of a CSV manifest, in the order of the fields.
+var manifestHeader = []string{"path", "output", "action", "size", "output_size", "duration_ms"}
+
+// Writes the records of a -manifest as they're added: as CSV for a file named
+// *.csv, or else as JSON, an object per line. Each record is flushed once it's
+// written, so that a crash leaves a usable manifest of what was done so far.
+// The records are written by a single goroutine, so Add is safe for
+// concurrent use. Close must be called once nothing else is added.
+type Manifest struct {
+	name    string
+	fp      *os.File
+	dests   bool // Whether CSV has a dest column.
+	records chan ManifestRecord
+	done    chan error
+}
+
+// Creates the manifest name, replacing any that exists, and starts writing.
+// With dests, a CSV manifest has a column for the -dest of each record.
+func CreateManifest(name string, dests bool) (*Manifest, error) {
+	fp, err := os.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating -manifest %s: %w", name, err)
+	}
+	m := &Manifest{name: name, fp: fp, dests: dests, records: make(chan ManifestRecord, 64), done: make(chan error, 1)}
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		go m.writeCSV()
+	} else {
+		go m.writeJSON()
+	}
+	return m, nil
+}
+
+// Queues r to be written.
+func (m *Manifest) Add(r ManifestRecord) {
+	m.records <- r
+}
+
+// Writes the records as CSV until the channel is closed.
+func (m *Manifest) writeCSV() {
+	w := csv.NewWriter(m.fp)
+	write := func(row []string) error {
+		w.Write(row)
+		w.Flush()
+		return w.Error()
+	}
+	header := manifestHeader
+	if m.dests {
+		header = append(slices.Clone(header), "dest")
+	}
+	err := write(header)
+	m.report(err)
+	for r := range m.records {
+		if err != nil {
+			continue
+		}
+		row := []string{
+			r.Path, r.Output, r.Action,
+			strconv.FormatInt(r.Size, 10), strconv.FormatInt(r.OutputSize, 10), strconv.FormatInt(r.DurationMS, 10),
+		}
+		if m.dests {
+			row = append(row, r.Dest)
+		}
+		err = write(row)
+		m.report(err)
+	}
+	m.done <- err
+}
+
+// Writes the records as JSON until the channel is closed.
+func (m *Manifest) writeJSON() {
+	// Writing straight to the file, so there's nothing to flush.
+	enc := json.NewEncoder(m.fp)
+	var err error
+	for r := range m.records {
+		if err != nil {
+			continue
+		}
+		err = enc.Encode(r)
+		m.report(err)
+	}
+	m.done <- err
+}
+
+// Logs err, the first failure to write a record. The rest are dropped, rather
+// than logging the same failure for every file.
+func (m *Manifest) report(err error) {
+	if err != nil {
+		logging.Printf("Failed writing -manifest %s, no more records will be written: %v", m.name, err)
+	}
+}
+
+// Waits for the records added so far to be written, and closes the file.
+// Returns the first error writing it.
+func (m *Manifest) Close() error {
+	close(m.records)
+	err := <-m.done
+	if cerr := m.fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing -manifest %s: %w", m.name, err)
+	}
+	return nil
+}
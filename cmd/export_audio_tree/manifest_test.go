@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "manifest.jsonl")
+	m, err := CreateManifest(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Workers add records concurrently.
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 50 {
+				m.Add(ManifestRecord{Path: fmt.Sprintf("%d/%02d.flac", i, j), Action: "converted"})
+			}
+		}()
+	}
+	wg.Wait()
+	// Each record is flushed as it's written, so they're there before Close.
+	m.Add(ManifestRecord{Path: "last.flac", Action: "copied"})
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		} else if lines := strings.Count(string(data), "\n"); lines == 401 {
+			break
+		} else if time.Since(start) > 5*time.Second {
+			t.Fatalf("Only %d records were written before Close", lines)
+		}
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	var n int
+	for ; scanner.Scan(); n++ {
+		var r ManifestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Path == "" {
+			t.Fatalf("Bad record %q: %v", scanner.Text(), err)
+		}
+	}
+	if n != 401 {
+		t.Errorf("Expected 401 records, not %d", n)
+	}
+}
+
+func TestExporterManifest(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "manifest.csv")
+	fake := func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if strings.Contains(opts.InputFile, "bad") {
+			return []byte("Invalid data found when processing input"), errors.New("exit status 1")
+		}
+		return fakeConvert(ctx, opts)
+	}
+	p := newTestExporter(t, fake, func(opts *options.ExporterOptions) {
+		opts.Manifest = name
+		opts.Update = true
+		opts.CleanPaths = "_"
+	})
+	writeFiles(t, p.opts.InRoot, "A:B/Song.flac", "A:B/bad.flac", "A:B/cover.jpg")
+	p.Run()
+
+	// Returns the rows of the manifest, sorted by path, after the header.
+	read := func() [][]string {
+		fp, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fp.Close()
+		rows, err := csv.NewReader(fp).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 || !slices.Equal(rows[0], manifestHeader) {
+			t.Fatalf("Bad header: %q", rows)
+		}
+		rows = rows[1:]
+		slices.SortFunc(rows, func(a, b []string) int { return strings.Compare(a[0], b[0]) })
+		return rows
+	}
+	rows := read()
+	// The fake conversion writes the name of the source.
+	converted := fmt.Sprint(len(filepath.Join(p.opts.InRoot, "A:B/Song.flac")))
+	expected := [][]string{
+		{"A:B/Song.flac", "A_B/Song.m4a", "converted", "13", converted},
+		{"A:B/bad.flac", "A_B/bad.m4a", "failed", "12", "0"},
+		{"A:B/cover.jpg", "A_B/cover.jpg", "copied", "13", "13"},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Bad manifest: %q", rows)
+	}
+	for i, row := range rows {
+		// The duration varies.
+		if !slices.Equal(row[:5], expected[i]) {
+			t.Errorf("Bad record:\nactual  : %q\nexpected: %q", row, expected[i])
+		}
+	}
+
+	// The manifest is replaced by the next run, where the rest are up to date.
+	p = newExporter(p.ctx, p.opts)
+	p.convert = fake
+	p.Run()
+	rows = read()
+	if len(rows) != 3 || rows[0][2] != "skipped" || rows[0][4] != converted || rows[1][2] != "failed" || rows[2][2] != "skipped" {
+		t.Errorf("Bad manifest of a rerun: %q", rows)
+	}
+}
@@ -0,0 +1,380 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/cue"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"time"
+)
+
+// What to do with one file of the input root.
+type Step struct {
+	RelPath string // The source, relative to the input root.
+	Action  Action // ActionConvert, ActionCopy, or ActionLink.
+	OutPath string // The output, relative to the output root.
+	Size    int64  // Of the source, in bytes.
+	Target  string // What a link points to, relative to the input root.
+	Done    bool   // Exported by a previous run, according to -state.
+	// For -split-cue, the track of RelPath to convert, or nil for all of it.
+	Track *cue.Track
+}
+
+// Everything an export would do, as decided by walking the input root. Nothing
+// is written until the plan is executed.
+type Plan struct {
+	Dirs      []string // To create up front, relative to the input root.
+	Steps     []Step   // In the order they were walked.
+	Skipped   []string // Left out by -lossy-policy skip, but still reported.
+	Playlists []string // To gather for -collect-playlists.
+	Failed    []Result // Paths that couldn't be read, reported as failures.
+	Needed    int64    // Estimated size of the export, for checkSpace.
+}
+
+// Returns the errors of plan.Failed joined together, or nil if the whole input
+// could be read.
+func (plan *Plan) walkErr() error {
+	var errs []error
+	for _, r := range plan.Failed {
+		errs = append(errs, r.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// Walks the input root and decides what to do with everything in it. Output
+// names are claimed along the way, so that collisions are detected before any
+// work is started.
+func (p *Exporter) Plan(ctx context.Context) (*Plan, error) {
+	plans, err := planAll(ctx, []*Exporter{p})
+	if err != nil {
+		return nil, err
+	}
+	return plans[0], nil
+}
+
+// Like Plan, but for each of exporters, which share the input root and the
+// options deciding what's exported, as for -dest. The input is only walked
+// once, and the plans are in the same order as exporters.
+func planAll(ctx context.Context, exporters []*Exporter) ([]*Plan, error) {
+	p := exporters[0]
+	plans := make([]*Plan, len(exporters))
+	for i := range plans {
+		plans[i] = &Plan{}
+	}
+	planFile := func(path string, d fs.DirEntry) error {
+		for i, e := range exporters {
+			if err := e.planFile(plans[i], path, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	progress := p.newWalkProgress("Planning")
+	var visit fs.WalkDirFunc
+	visit = progress.wrap(func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err != nil && d == nil {
+			// One of the roots doesn't exist, so there's nothing to plan.
+			return err
+		} else if err != nil {
+			// A directory that can't be read is visited again with the error.
+			// The rest of the input is still exported, but the export fails.
+			err = fmt.Errorf("reading %q failed: %w", path, err)
+			logging.Println(err)
+			for _, plan := range plans {
+				plan.Failed = append(plan.Failed, Result{Path: path, Action: ActionCopy, Status: StatusFailed, Err: err})
+			}
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return p.planLink(path, d, planFile, visit)
+		} else if !d.IsDir() {
+			return planFile(path, d)
+		} else if path == "." {
+			return nil
+		} else if filesystem.IsTrashDir(path) {
+			logging.Verbosef("Skipping %q", path)
+			return fs.SkipDir
+		} else if p.skipDir(path) {
+			logging.Verbosef("Excluding %q", path)
+			return fs.SkipDir
+		} else if !p.excluded(path, true) && p.layout == nil {
+			// Excluded directories aren't created up front, in case nothing
+			// inside them is included. With -layout, none are, since files
+			// don't go in the directories they came from.
+			for _, plan := range plans {
+				plan.Dirs = append(plan.Dirs, path)
+			}
+		}
+		return nil
+	})
+	err := p.walk(p.InRoot, p.roots(), visit)
+	progress.finish()
+	if err != nil {
+		return nil, err
+	}
+	return plans, nil
+}
+
+// Adds the step for the file at path to plan, if it's exported at all.
+func (p *Exporter) planFile(plan *Plan, path string, d fs.DirEntry) error {
+	if filesystem.IsTrashFile(path) || path == options.TreeConfigFile {
+		logging.Verbosef("Skipping %q", path)
+		return nil
+	} else if p.excluded(path, false) {
+		logging.Verbosef("Excluding %q", path)
+		return nil
+	} else if p.ignored(path) {
+		logging.Verbosef("Ignoring %q", path)
+		return nil
+	} else if p.lossyPolicy(path, options.LossySkip) {
+		logging.Verbosef("Skipping lossy %q", path)
+		plan.Skipped = append(plan.Skipped, path)
+		return nil
+	}
+	if p.opts.CollectPlaylists != "" && isPlaylist(path) {
+		// Gathered even if it isn't copied in place.
+		plan.Playlists = append(plan.Playlists, path)
+	}
+	if p.opts.SplitCue {
+		splits := p.cueSplits(pathpkg.Dir(path))
+		if splits.sheets[path] {
+			logging.Verbosef("Splitting with %q", path)
+			return nil
+		} else if tracks := splits.tracks[path]; len(tracks) > 0 {
+			return p.planTracks(plan, path, d, tracks)
+		}
+	}
+	if !p.isMedia(path) && !p.opts.CopyUnknown {
+		return nil
+	}
+
+	opath, err := p.claimOutput(path)
+	if err != nil {
+		return err
+	}
+	p.planParent(plan, path)
+	step := Step{RelPath: path, Action: ActionCopy, OutPath: opath}
+	info, err := d.Info()
+	if err == nil {
+		step.Size = info.Size()
+	}
+	if target, ok := p.preservedLink(path, d); ok {
+		step.Action = ActionLink
+		step.Target = target
+		plan.Steps = append(plan.Steps, step)
+		return nil
+	}
+	if p.converts(path) {
+		step.Action = ActionConvert
+	}
+	if p.state != nil && info != nil {
+		step.Done = p.state.Done(p.stateRecord(path, step.Action, opath, info))
+	}
+	if !step.Done {
+		plan.Needed += p.estimate(step)
+	}
+	plan.Steps = append(plan.Steps, step)
+	return nil
+}
+
+// Adds the directory containing path to plan.Dirs, if the walk left it out for
+// being excluded. Something in it is exported after all.
+func (p *Exporter) planParent(plan *Plan, path string) {
+	if dir := pathpkg.Dir(path); p.layout == nil && p.excluded(dir, true) && (len(plan.Dirs) == 0 || plan.Dirs[len(plan.Dirs)-1] != dir) {
+		plan.Dirs = append(plan.Dirs, dir)
+	}
+}
+
+// Queues the conversions and copies of plan on the pool. Links are left for
+// makeLinks, once their targets exist. With -by-album, the conversions in each
+// directory are queued together, after everything else. Stops early if
+// interrupted.
+func (p *Exporter) execute(plan *Plan) error {
+	for _, path := range plan.Skipped {
+		p.Summary.Queued()
+		p.Summary.Add(Result{Path: path, Action: ActionConvert, Status: StatusSkipped})
+	}
+	for _, r := range plan.Failed {
+		p.Summary.Queued()
+		p.Summary.Add(r)
+	}
+	var albums []string
+	tracks := make(map[string][]Step)
+	for _, step := range plan.Steps {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+		if p.opts.ByAlbum && step.Action == ActionConvert && !step.Done {
+			dir := pathpkg.Dir(step.RelPath)
+			if _, ok := tracks[dir]; !ok {
+				albums = append(albums, dir)
+			}
+			tracks[dir] = append(tracks[dir], step)
+			continue
+		}
+		if err := p.enqueue(step); err != nil {
+			return err
+		}
+	}
+	p.albums.Add(int64(len(albums)))
+	for _, dir := range albums {
+		if err := p.queueAlbum(dir, tracks[dir]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Adds one task to the pool that converts steps, the tracks of the album in
+// dir, one after the other. That way, the album is done as a whole rather than
+// bit by bit, and the source is read in order. A track failing doesn't stop
+// the rest, but being interrupted does. With -replaygain, the album is tagged
+// once every track is done.
+func (p *Exporter) queueAlbum(dir string, steps []Step) error {
+	queued := time.Now()
+	var fns []func() error
+	for _, step := range steps {
+		if fn, _ := p.task(step, queued); fn != nil {
+			p.Summary.Queued()
+			fns = append(fns, fn)
+		}
+	}
+	return p.add(dir, false, func() error {
+		var errs []error
+		for _, fn := range fns {
+			if p.ctx.Err() != nil {
+				return errors.Join(errs...)
+			}
+			errs = append(errs, fn())
+		}
+		if p.opts.ReplayGain {
+			p.tagAlbum(steps)
+		}
+		logging.Verbosef("Finished album %q (%d of %d)", dir, p.albumsDone.Add(1), p.albums.Load())
+		return errors.Join(errs...)
+	})
+}
+
+// Like queue, but with -watch-spill, steps that don't fit in the pool's queue
+// are spilled to disk rather than waiting for room. Once anything is spilled,
+// later steps are too until feedSpill catches up, so that they run in order.
+func (p *Exporter) enqueue(step Step) error {
+	spill := p.spill.Load()
+	if spill == nil || step.Done {
+		return p.queue(step)
+	}
+	queued := time.Now()
+	fn, high := p.task(step, queued)
+	if fn == nil {
+		return nil
+	}
+	p.Summary.Queued()
+	if spill.Len() == 0 && p.pool.TryAddNamed(step.RelPath, high, fn) {
+		return nil
+	}
+	if err := spill.push(step, queued); err != nil {
+		logging.Println(err)
+		return p.add(step.RelPath, high, fn)
+	}
+	return nil
+}
+
+// Replays the steps spilled by enqueue into the pool as room frees up, until
+// the context is done.
+func (p *Exporter) feedSpill(spill *spillQueue) {
+	for {
+		spilled, err := spill.next(p.ctx)
+		if err != nil && p.ctx.Err() != nil {
+			return
+		} else if err != nil {
+			logging.Println(err)
+			continue
+		}
+		fn, high := p.task(spilled.Step, spilled.Queued)
+		if err := p.add(spilled.Step.RelPath, high, fn); err != nil {
+			return
+		}
+		if err := spill.done(); err != nil {
+			logging.Printf("Failed truncating -watch-spill file: %v", err)
+		}
+	}
+}
+
+// Adds the task for step to the pool.
+func (p *Exporter) queue(step Step) error {
+	if step.Done {
+		logging.Verbosef("Already exported %q", step.RelPath)
+		p.Summary.Queued()
+		p.skip(step.RelPath, step.Action, step.OutPath)
+		return nil
+	}
+	fn, high := p.task(step, time.Now())
+	if fn == nil {
+		return nil
+	}
+	p.Summary.Queued()
+	return p.add(step.RelPath, high, fn)
+}
+
+// Adds fn, the task for path, to the pool. Blocks until there's room in the
+// queue or the context is done.
+func (p *Exporter) add(path string, high bool, fn func() error) error {
+	if high {
+		return p.pool.AddHighNamedContext(p.ctx, path, fn)
+	}
+	return p.pool.AddNamedContext(p.ctx, path, fn)
+}
+
+// Returns the task for step, queued at the given time, and whether it has a
+// high priority, or nil if there's nothing to run. Copies are quick next to
+// conversions, so they go first rather than leaving albums without their cover
+// art until the end.
+func (p *Exporter) task(step Step, queued time.Time) (fn func() error, high bool) {
+	path := step.RelPath
+	switch step.Action {
+	case ActionConvert:
+		name := path
+		convert := func() (string, error) { return p.Convert(path) }
+		if step.Track != nil {
+			name = trackKey(path, step.Track)
+			convert = func() (string, error) { return p.ConvertTrack(path, step.Track) }
+		}
+		return func() error {
+			p.started(name, ActionConvert, queued)
+			p.hashForDuplicates(path)
+			output, err := convert()
+			if err != nil && p.ctx.Err() != nil {
+				// Reported by Run as an interruption, rather than a failure.
+				logging.Verbosef("Aborted %q: %v", name, err)
+				return nil
+			} else if err != nil {
+				logging.Printf("!!! FAILED: %v !!!", err)
+				p.logOutput(name, output, true)
+				return err
+			}
+			logging.Printf("Converted %q", name)
+			p.logOutput(name, output, false)
+			return nil
+		}, false
+	case ActionCopy:
+		return func() error {
+			p.started(path, ActionCopy, queued)
+			p.hashForDuplicates(path)
+			// Copy logs its own failures.
+			if err := p.Copy(path); err != nil && p.ctx.Err() == nil {
+				return err
+			}
+			return nil
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExporterPlan(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.LossyPolicy = options.LossySkip
+		opts.Excludes = []string{"Scans/"}
+		opts.Includes = []string{"Scans/cover.jpg"}
+	})
+	writeFiles(t, p.opts.InRoot,
+		"Album/01 Song.flac",
+		"Album/02 Other.mp3",
+		"Album/03 Same.m4a",
+		"Album/cover.jpg",
+		"Album/.DS_Store",
+		"Album/Thumbs.db",
+		"Album/@eaDir/01 Song.flac@SynoEAStream",
+		"Scans/back.jpg",
+		"Scans/cover.jpg",
+	)
+	plan, err := p.Plan(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Step{
+		{RelPath: "Album/01 Song.flac", Action: ActionConvert, OutPath: "Album/01 Song.m4a", Size: 18},
+		{RelPath: "Album/cover.jpg", Action: ActionCopy, OutPath: "Album/cover.jpg", Size: 15},
+		{RelPath: "Scans/cover.jpg", Action: ActionCopy, OutPath: "Scans/cover.jpg", Size: 15},
+	}
+	if !slices.Equal(plan.Steps, expected) {
+		t.Errorf("Bad steps:\nactual  : %+v\nexpected: %+v", plan.Steps, expected)
+	}
+	// The excluded directory is only planned because something in it is included.
+	if expected := []string{"Album", "Scans"}; !slices.Equal(plan.Dirs, expected) {
+		t.Errorf("Bad dirs: %q expected: %q", plan.Dirs, expected)
+	}
+	// The m4a is lossy too, even though it's already in the output format.
+	if expected := []string{"Album/02 Other.mp3", "Album/03 Same.m4a"}; !slices.Equal(plan.Skipped, expected) {
+		t.Errorf("Bad skipped: %q expected: %q", plan.Skipped, expected)
+	}
+	if plan.Needed != 18*35/100+15+15 {
+		t.Errorf("Bad estimate: %d", plan.Needed)
+	}
+	if actual := listTree(t, p.opts.OutRoot); len(actual) != 0 {
+		t.Errorf("Planning wrote to the output: %q", actual)
+	}
+}
+
+func TestExporterPlanErrors(t *testing.T) {
+	t.Run("missing root", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+			opts.Only = []string{"Missing"}
+		})
+		if _, err := p.Plan(t.Context()); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Planning a missing root should fail: %v", err)
+		}
+	})
+	t.Run("unreadable directory", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can read anything")
+		}
+		p := newTestExporter(t, fakeConvert)
+		writeFiles(t, p.opts.InRoot, "Album/01 Song.flac", "Locked/02 Song.flac")
+		locked := filepath.Join(p.opts.InRoot, "Locked")
+		if err := os.Chmod(locked, 0); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(locked, 0755)
+
+		plan, err := p.Plan(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.Failed) != 1 || plan.Failed[0].Path != "Locked" || plan.walkErr() == nil {
+			t.Errorf("The locked directory should have failed: %+v", plan.Failed)
+		}
+		if err := p.Run(); err == nil {
+			t.Errorf("Run should fail when part of the input can't be read")
+		}
+		if stats := p.Summary.Stats(); stats.Failed != 1 || stats.Converted != 1 {
+			t.Errorf("Bad stats: %+v", stats)
+		}
+	})
+}
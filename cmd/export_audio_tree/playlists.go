@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Extensions of the playlists gathered by -collect-playlists.
+var PlaylistExtensions = []string{".m3u", ".m3u8"}
+
+// Returns true if path has one of PlaylistExtensions, ignoring case.
+func isPlaylist(path string) bool {
+	return slices.Contains(PlaylistExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Returns the name of each of the playlists within the -collect-playlists
+// directory. A playlist keeps its own name unless another
+// shares it, in which case both are prefixed with their directory. E.g.,
+// "Artist/Album/Best.m3u" becomes "Artist - Album - Best.m3u". The names only
+// depend on the set of playlists, not the order they were found in.
+func (p *Exporter) playlistNames(playlists []string) map[string]string {
+	key := func(name string) string {
+		if p.opts.CaseInsensitiveTarget {
+			return strings.ToLower(name)
+		}
+		return name
+	}
+	paths := slices.Sorted(slices.Values(playlists))
+	shared := make(map[string]int)
+	for _, path := range paths {
+		shared[key(pathpkg.Base(path))]++
+	}
+	names := make(map[string]string)
+	taken := make(map[string]bool)
+	for _, path := range paths {
+		name := pathpkg.Base(path)
+		if dir := pathpkg.Dir(path); shared[key(name)] > 1 && dir != "." {
+			name = strings.ReplaceAll(dir, "/", " - ") + " - " + name
+		}
+		name = p.outPath(name)
+		// Only a playlist named like the prefixed one of another gets here.
+		for n, base := 2, name; taken[key(name)]; n++ {
+			name = dedupeName(base, n)
+		}
+		taken[key(name)] = true
+		names[path] = name
+	}
+	return names
+}
+
+// Writes the playlists into the -collect-playlists directory. The playlists
+// themselves are still exported in place like any other file.
+func (p *Exporter) collectPlaylists(playlists []string) error {
+	if len(playlists) == 0 {
+		return nil
+	}
+	if err := p.OutRoot.MkDirAll(p.opts.CollectPlaylists, 0755); err != nil {
+		return fmt.Errorf("failed creating -collect-playlists directory: %w", err)
+	}
+	var errs []error
+	names := p.playlistNames(playlists)
+	for _, path := range slices.Sorted(maps.Keys(names)) {
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.collectPlaylist(path, pathpkg.Join(p.opts.CollectPlaylists, names[path])); err != nil {
+			logging.Println(err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Writes the playlist at path to opath, with its entries rewritten to point at
+// the exported files.
+func (p *Exporter) collectPlaylist(path, opath string) error {
+	if p.opts.NoClobber && p.exists(opath) {
+		logging.Verbosef("Not clobbering %q", opath)
+		return nil
+	}
+	data, err := p.InRoot.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading playlist %q failed: %w", path, err)
+	}
+	logging.Verbosef("Collecting playlist %q as %q", path, opath)
+	data = rewritePlaylist(data, func(entry string) (string, bool) {
+		return p.playlistEntry(path, entry)
+	})
+
+	af := filesystem.NewAtomicFile(p.OutRoot, opath)
+	fp, err := af.Create()
+	if err != nil {
+		return fmt.Errorf("writing playlist %q failed: %w", opath, err)
+	}
+	if _, err := fp.Write(data); err != nil {
+		return errors.Join(fmt.Errorf("writing playlist %q failed: %w", opath, err), af.Abort())
+	}
+	return af.Commit()
+}
+
+// Maps an entry of the playlist at path to the exported file it refers to,
+// relative to the -collect-playlists directory. Returns false for entries
+// outside the input root, such as URLs, which are left alone.
+func (p *Exporter) playlistEntry(path, entry string) (string, bool) {
+	if strings.Contains(entry, "://") {
+		return "", false
+	}
+	var src string
+	if filepath.IsAbs(entry) {
+		root, err := filepath.Abs(p.opts.InRoot)
+		if err != nil {
+			return "", false
+		}
+		rel, err := filepath.Rel(root, entry)
+		if err != nil {
+			return "", false
+		}
+		src = filepath.ToSlash(rel)
+	} else {
+		// Playlists made on Windows use backslashes.
+		src = pathpkg.Join(pathpkg.Dir(path), strings.ReplaceAll(entry, `\`, "/"))
+	}
+	if src == "." || !fs.ValidPath(src) {
+		return "", false
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(p.opts.CollectPlaylists), filepath.FromSlash(p.outputName(src)))
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// Rewrites each entry of an m3u playlist using resolve, keeping the entries it
+// returns false for. Comments, such as #EXTINF lines, and line endings are
+// kept as they are.
+func rewritePlaylist(data []byte, resolve func(entry string) (string, bool)) []byte {
+	var b bytes.Buffer
+	for line := range bytes.Lines(data) {
+		content := bytes.TrimRight(line, "\r\n")
+		ending := line[len(content):]
+		entry := string(bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\ufeff"))))
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			b.Write(line)
+			continue
+		}
+		if resolved, ok := resolve(entry); ok {
+			b.WriteString(resolved)
+		} else {
+			b.Write(content)
+		}
+		b.Write(ending)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRewritePlaylist(t *testing.T) {
+	input := "\ufeff#EXTM3U\r\n#EXTINF:215,Someone - Song\r\n01 Song.flac\r\n\r\nhttp://example.com/stream\r\n  02 Other.flac  \n"
+	expected := "\ufeff#EXTM3U\r\n#EXTINF:215,Someone - Song\r\n<01 Song.flac>\r\n\r\nhttp://example.com/stream\r\n<02 Other.flac>\n"
+	actual := rewritePlaylist([]byte(input), func(entry string) (string, bool) {
+		if strings.Contains(entry, "://") {
+			return "", false
+		}
+		return "<" + entry + ">", true
+	})
+	if string(actual) != expected {
+		t.Errorf("Bad rewrite:\nactual  : %q\nexpected: %q", actual, expected)
+	}
+}
+
+func TestPlaylistNames(t *testing.T) {
+	type testCase struct {
+		name      string
+		foldCase  bool
+		playlists []string
+		expected  map[string]string
+	}
+	for _, tc := range []testCase{
+		{
+			name:      "unique",
+			playlists: []string{"A/Album/one.m3u", "B/two.m3u8"},
+			expected:  map[string]string{"A/Album/one.m3u": "one.m3u", "B/two.m3u8": "two.m3u8"},
+		},
+		{
+			name:      "shared names get the album path",
+			playlists: []string{"B/Album/Best.m3u", "A/Album/Best.m3u", "Best.m3u"},
+			expected: map[string]string{
+				"A/Album/Best.m3u": "A - Album - Best.m3u",
+				"B/Album/Best.m3u": "B - Album - Best.m3u",
+				"Best.m3u":         "Best.m3u",
+			},
+		},
+		{
+			name:      "prefixed name is taken",
+			playlists: []string{"A/Best.m3u", "B/Best.m3u", "C/A - Best.m3u"},
+			expected: map[string]string{
+				"A/Best.m3u":     "A - Best.m3u",
+				"B/Best.m3u":     "B - Best.m3u",
+				"C/A - Best.m3u": "A - Best (2).m3u",
+			},
+		},
+		{
+			name:      "case insensitive",
+			foldCase:  true,
+			playlists: []string{"A/best.m3u", "B/BEST.m3u"},
+			expected:  map[string]string{"A/best.m3u": "A - best.m3u", "B/BEST.m3u": "B - BEST.m3u"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.CaseInsensitiveTarget = tc.foldCase
+			})
+			if actual := p.playlistNames(tc.playlists); !maps.Equal(actual, tc.expected) {
+				t.Errorf("Bad names:\nactual  : %q\nexpected: %q", actual, tc.expected)
+			}
+			// The order playlists are found in doesn't matter.
+			slices.Reverse(tc.playlists)
+			if actual := p.playlistNames(tc.playlists); !maps.Equal(actual, tc.expected) {
+				t.Errorf("Names depend on order:\nactual  : %q\nexpected: %q", actual, tc.expected)
+			}
+		})
+	}
+}
+
+func TestExporterCollectPlaylists(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.CollectPlaylists = "Playlists"
+	})
+	writeFiles(t, p.opts.InRoot, "Artist/Album/01 Song.flac", "Artist/Album/02 Other.mp3", "Other/Album/03 Third.flac")
+	playlists := map[string]string{
+		"Artist/Album/Album.m3u": "#EXTM3U\n01 Song.flac\n02 Other.mp3\n",
+		"Other/Album/Album.m3u":  "#EXTM3U\n03 Third.flac\n" + filepath.Join(p.opts.InRoot, "Artist", "Album", "01 Song.flac") + "\n",
+		"Mix.m3u8":               "Artist\\Album\\01 Song.flac\nhttp://example.com/stream\n",
+	}
+	for name, data := range playlists {
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, filepath.FromSlash(name)), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for name, expected := range map[string]string{
+		"Playlists/Artist - Album - Album.m3u": "#EXTM3U\n../Artist/Album/01 Song.m4a\n../Artist/Album/02 Other.m4a\n",
+		"Playlists/Other - Album - Album.m3u":  "#EXTM3U\n../Other/Album/03 Third.m4a\n../Artist/Album/01 Song.m4a\n",
+		"Playlists/Mix.m3u8":                   "../Artist/Album/01 Song.m4a\nhttp://example.com/stream\n",
+		// The originals are still copied in place.
+		"Artist/Album/Album.m3u": playlists["Artist/Album/Album.m3u"],
+	} {
+		data, err := os.ReadFile(filepath.Join(p.opts.OutRoot, filepath.FromSlash(name)))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != expected {
+			t.Errorf("Bad %s:\nactual  : %q\nexpected: %q", name, data, expected)
+		}
+	}
+
+	// Collected playlists don't have a source, but aren't orphans either.
+	diff, err := newExporter(t.Context(), p.opts).Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Prune) != 0 {
+		t.Errorf("Collected playlists would be pruned: %q", diff.Prune)
+	}
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Counts the entries of a walk, reporting progress every so many entries or
+// so much time, rather than logging each entry. A large tree has hundreds of
+// thousands of entries, and logging each one costs more than the walk.
+type walkProgress struct {
+	phase    string        // What the walk is doing, e.g., "Scanning".
+	every    int           // Report after this many entries...
+	interval time.Duration // ...or this long since the last report.
+	// Where to report, or nil to only count. When nil, nothing is formatted.
+	report func(format string, args ...any)
+
+	count int
+	dir   string // The last directory entered.
+	last  time.Time
+}
+
+// Returns progress for a walk of the given phase, reporting with -v.
+func (p *Exporter) newWalkProgress(phase string) *walkProgress {
+	w := &walkProgress{phase: phase, every: 50_000, interval: 5 * time.Second, last: time.Now()}
+	if p.opts.Verbose {
+		w.report = logging.Verbosef
+	}
+	return w
+}
+
+// Counts an entry, reporting if it's time.
+func (w *walkProgress) visit(path string, isDir bool) {
+	w.count++
+	if isDir {
+		w.dir = path
+	}
+	if w.report == nil {
+		return
+	}
+	if now := time.Now(); w.count%w.every == 0 || now.Sub(w.last) >= w.interval {
+		w.report("%s: walked %d entries, now under %s", w.phase, w.count, w.dir)
+		w.last = now
+	}
+}
+
+// Reports the total once the walk is done.
+func (w *walkProgress) finish() {
+	if w.report != nil {
+		w.report("%s: walked %d entries", w.phase, w.count)
+	}
+}
+
+// Returns fn, counting each entry it's called with.
+func (w *walkProgress) wrap(fn fs.WalkDirFunc) fs.WalkDirFunc {
+	return func(path string, d fs.DirEntry, err error) error {
+		w.visit(path, d != nil && d.IsDir())
+		return fn(path, d, err)
+	}
+}
+
+// How often the progress of an export is redrawn on a terminal, and printed
+// otherwise.
+const (
+	liveProgressInterval  = 250 * time.Millisecond
+	plainProgressInterval = time.Minute
+)
+
+// Shows the progress of executing a plan, e.g., "[#####.....] 1234/5021 files,
+// 3 failed, ETA 41m, 6 running". On a terminal, the line is redrawn in place.
+// Otherwise, or when the log is on the terminal too, a line is printed every
+// so often. Safe for concurrent use.
+type exportProgress struct {
+	w        io.Writer
+	live     bool // Redraw the line in place.
+	interval time.Duration
+	running  func() int // Returns the number of tasks running.
+	sizes    map[string]int64
+	start    time.Time
+
+	mutex      sync.Mutex
+	total      int
+	totalBytes int64
+	done       int
+	failed     int
+	workBytes  int64 // Of the sources done, those that took work, for the ETA.
+	skipBytes  int64 // Of the sources done, those that were skipped.
+}
+
+// Returns progress for the convert and copy steps of plan, shown on stderr,
+// or nil with -quiet.
+func (p *Exporter) newExportProgress(plan *Plan) *exportProgress {
+	if p.opts.Quiet {
+		return nil
+	}
+	e := newExportProgress(os.Stderr, isTerminal(os.Stderr) && !logging.ToConsole(), plan)
+	e.running = func() int { return len(p.pool.Running()) }
+	return e
+}
+
+func newExportProgress(w io.Writer, live bool, plan *Plan) *exportProgress {
+	e := &exportProgress{
+		w:        w,
+		live:     live,
+		interval: plainProgressInterval,
+		running:  func() int { return 0 },
+		sizes:    make(map[string]int64),
+		start:    time.Now(),
+	}
+	if live {
+		e.interval = liveProgressInterval
+	}
+	for _, step := range plan.Steps {
+		if step.Action != ActionConvert && step.Action != ActionCopy {
+			continue
+		}
+		name := step.RelPath
+		if step.Track != nil {
+			name = trackKey(step.RelPath, step.Track)
+		}
+		e.sizes[name] = step.Size
+		e.total++
+		e.totalBytes += step.Size
+	}
+	return e
+}
+
+// Returns true if f is a terminal, rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// Counts r, if it's the result of one of the steps.
+func (e *exportProgress) add(r Result) {
+	size, ok := e.sizes[r.Path]
+	if !ok {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.done++
+	switch r.Status {
+	case StatusFailed:
+		e.failed++
+	case StatusSkipped:
+		e.skipBytes += size
+		return
+	}
+	e.workBytes += size
+}
+
+// Starts showing the progress every interval until ctx is done or the
+// returned function is called, which waits for it to stop. On a terminal, the
+// line is then cleared, leaving room for the summary. Does nothing if e is nil.
+func (e *exportProgress) run(ctx context.Context) (stop func()) {
+	if e == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		e.show(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Does the work of run.
+func (e *exportProgress) show(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if e.live {
+				fmt.Fprint(e.w, "\r\033[K")
+			}
+			return
+		case now := <-ticker.C:
+			if e.live {
+				fmt.Fprintf(e.w, "\r%s\033[K", e.line(now))
+			} else {
+				fmt.Fprintln(e.w, e.line(now))
+			}
+		}
+	}
+}
+
+// Returns the progress as of now.
+func (e *exportProgress) line(now time.Time) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	s := fmt.Sprintf("%s %d/%d files, %d failed", progressBar(e.done, e.total, 20), e.done, e.total, e.failed)
+	if eta, ok := e.eta(now); ok {
+		s += ", ETA " + formatETA(eta)
+	}
+	return s + fmt.Sprintf(", %d running", e.running())
+}
+
+// Returns how long the rest should take, going by how many bytes of sources
+// have been worked through so far. Skipped sources take no time, so they don't
+// count toward the rate. Returns false until there's something to go by. The
+// mutex must be held.
+func (e *exportProgress) eta(now time.Time) (time.Duration, bool) {
+	elapsed := now.Sub(e.start)
+	if e.workBytes <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	remaining := max(0, e.totalBytes-e.workBytes-e.skipBytes)
+	return time.Duration(float64(remaining) / float64(e.workBytes) * float64(elapsed)), true
+}
+
+// Returns a bar of width characters, filled in for done of total, e.g.,
+// "[#####.....]".
+func progressBar(done, total, width int) string {
+	filled := width
+	if total > 0 {
+		filled = min(width, done*width/total)
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", width-filled) + "]"
+}
+
+// Formats d to the minute, or to the second under a minute, e.g., "1h05m",
+// "41m", or "30s".
+func formatETA(d time.Duration) string {
+	if d = d.Round(time.Second); d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	if d = d.Round(time.Minute); d < time.Hour {
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWalkProgress(t *testing.T) {
+	var reports []string
+	w := &walkProgress{
+		phase:    "Testing",
+		every:    3,
+		interval: time.Hour,
+		last:     time.Now(),
+		report: func(format string, args ...any) {
+			reports = append(reports, fmt.Sprintf(format, args...))
+		},
+	}
+	fsys := fstest.MapFS{
+		"A/1.flac": {},
+		"A/2.flac": {},
+		"B/1.flac": {},
+		"B/2.flac": {},
+	}
+	var visited int
+	err := fs.WalkDir(fsys, ".", w.wrap(func(path string, d fs.DirEntry, err error) error {
+		visited++
+		return err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.finish()
+	expected := []string{
+		"Testing: walked 3 entries, now under A",
+		"Testing: walked 6 entries, now under B",
+		"Testing: walked 7 entries",
+	}
+	if !slices.Equal(reports, expected) {
+		t.Errorf("Bad reports:\nactual  : %q\nexpected: %q", reports, expected)
+	}
+	if visited != 7 || w.count != 7 {
+		t.Errorf("Visited %d counted %d expected 7", visited, w.count)
+	}
+
+	// Time alone is enough to report.
+	reports = nil
+	w = &walkProgress{phase: "Testing", every: 1000, report: w.report}
+	w.visit("A", true)
+	if len(reports) != 1 {
+		t.Errorf("Expected a report once the interval passed: %q", reports)
+	}
+
+	// Without a report function, it only counts.
+	w = &walkProgress{phase: "Testing", every: 1}
+	w.visit("A", true)
+	w.finish()
+	if w.count != 1 {
+		t.Errorf("Counted %d expected 1", w.count)
+	}
+}
+
+func TestExportProgress(t *testing.T) {
+	plan := &Plan{Steps: []Step{
+		{RelPath: "a.flac", Action: ActionConvert, Size: 100},
+		{RelPath: "b.flac", Action: ActionConvert, Size: 100},
+		{RelPath: "c.flac", Action: ActionConvert, Size: 100},
+		{RelPath: "cover.jpg", Action: ActionCopy, Size: 100},
+		{RelPath: "link.flac", Action: ActionLink, Target: "a.flac"},
+	}}
+	var out bytes.Buffer
+	e := newExportProgress(&out, false, plan)
+	e.running = func() int { return 2 }
+	if line := e.line(e.start); line != "[....................] 0/4 files, 0 failed, 2 running" {
+		t.Errorf("Bad line before anything is done: %q", line)
+	}
+	e.add(Result{Path: "a.flac", Status: StatusDone})
+	e.add(Result{Path: "b.flac", Status: StatusFailed})
+	e.add(Result{Path: "cover.jpg", Status: StatusSkipped})
+	// Not a step, so not counted.
+	e.add(Result{Path: "link.flac", Status: StatusDone})
+	// 200 bytes in 10 minutes leaves 100 bytes for 5 minutes, since the
+	// skipped copy took no time.
+	if line := e.line(e.start.Add(10 * time.Minute)); line != "[###############.....] 3/4 files, 1 failed, ETA 5m, 2 running" {
+		t.Errorf("Bad line: %q", line)
+	}
+
+	// On a terminal, the line is redrawn and cleared at the end.
+	e.live, e.interval = true, time.Millisecond
+	stop := e.run(t.Context())
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	if s := out.String(); !strings.HasPrefix(s, "\r[###") || !strings.HasSuffix(s, "\r\033[K") || strings.Contains(s, "\n") {
+		t.Errorf("Bad live output: %q", s)
+	}
+	// Nothing is shown with -quiet.
+	var none *exportProgress
+	none.run(t.Context())()
+}
+
+func TestFormatETA(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		0:                               "0s",
+		30 * time.Second:                "30s",
+		41*time.Minute + 10*time.Second: "41m",
+		65 * time.Minute:                "1h05m",
+		26*time.Hour + 59*time.Minute:   "26h59m",
+		59*time.Minute + 59*time.Second: "1h00m",
+		1*time.Hour + 59*time.Minute + 59*time.Second: "2h00m",
+	} {
+		if actual := formatETA(d); actual != expected {
+			t.Errorf("formatETA(%v) actual: %q expected: %q", d, actual, expected)
+		}
+	}
+	if bar := progressBar(1, 3, 6); bar != "[##....]" {
+		t.Errorf("Bad bar: %q", bar)
+	}
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"fmt"
+	pathpkg "path"
+	"strconv"
+	"strings"
+)
+
+// Returns the name of path in the output root with -rename-from-tags, e.g.,
+// "Album/03 - Title.m4a" for "Album/03.flac", or "" to keep the name
+// mappedName gives it. Only media files are renamed, and only when they have
+// track and title tags.
+func (p *Exporter) renamedName(path string) string {
+	if !p.opts.RenameFromTags || !p.isMedia(path) {
+		return ""
+	}
+	name := tagName(p.probeTags(path))
+	if name == "" {
+		return ""
+	}
+	ext := pathpkg.Ext(p.mappedName(path))
+	return p.limitPath(p.cleanPath(pathpkg.Join(pathpkg.Dir(p.layoutPath(path)), name)) + ext)
+}
+
+// Returns the name for a file with tags, without the extension: "NN - Title",
+// or "D-NN - Title" for an album of more than one disc. Returns "" if the
+// track or title is missing.
+func tagName(tags map[string]string) string {
+	track, _ := tagNumber(tags["track"])
+	title := strings.TrimSpace(tags["title"])
+	if track <= 0 || title == "" {
+		return ""
+	}
+	// Otherwise, "AC/DC" would be a directory.
+	title = strings.ReplaceAll(title, "/", "-")
+	if disc, discs := tagNumber(tags["disc"]); disc > 1 || discs > 1 {
+		return fmt.Sprintf("%d-%02d - %s", disc, track, title)
+	}
+	return fmt.Sprintf("%02d - %s", track, title)
+}
+
+// Parses a track or disc tag, e.g., "3" or "3/12", into the number and the
+// total, which are 0 if missing or bad.
+func tagNumber(value string) (n, total int) {
+	number, of, _ := strings.Cut(strings.TrimSpace(value), "/")
+	n, _ = strconv.Atoi(strings.TrimSpace(number))
+	total, _ = strconv.Atoi(strings.TrimSpace(of))
+	return max(n, 0), max(total, 0)
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/options"
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTagName(t *testing.T) {
+	for _, tc := range []struct {
+		tags     map[string]string
+		expected string
+	}{
+		{map[string]string{"track": "3", "title": "Title"}, "03 - Title"},
+		{map[string]string{"track": "3/12", "title": "AC/DC"}, "03 - AC-DC"},
+		{map[string]string{"track": "12", "disc": "1/1", "title": "Title"}, "12 - Title"},
+		{map[string]string{"track": "1/9", "disc": "2/2", "title": "Title"}, "2-01 - Title"},
+		{map[string]string{"track": "3"}, ""},
+		{map[string]string{"title": "Title"}, ""},
+		{map[string]string{"track": "0", "title": "Title"}, ""},
+		{map[string]string{"track": "side A", "title": "Title"}, ""},
+		{nil, ""},
+	} {
+		if actual := tagName(tc.tags); actual != tc.expected {
+			t.Errorf("%v: expected %q, have %q", tc.tags, tc.expected, actual)
+		}
+	}
+}
+
+func TestExporterRenameFromTags(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.RenameFromTags = true
+		opts.CleanPaths = "_"
+	})
+	writeFiles(t, p.opts.InRoot, "Album/01.flac", "Album/02.flac", "Album/03.flac", "Album/04.flac", "Album/cover.jpg")
+	tags := map[string]map[string]string{
+		"01.flac": {"track": "1/4", "title": "Who? Me"},
+		"02.flac": {"track": "2/4", "title": "Two"},
+		// Claims the same name as the first, so keeps its own.
+		"03.flac": {"track": "1/4", "title": "Who? Me"},
+	}
+	p.probe = func(ctx context.Context, name string) (*ffmpeg.MediaInfo, error) {
+		if t := tags[filepath.Base(name)]; t != nil {
+			return &ffmpeg.MediaInfo{Tags: t}, nil
+		}
+		return nil, ffmpeg.ErrNoInputInfo
+	}
+	if err := p.Run(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"Album",
+		"Album/01 - Who_ Me.m4a",
+		"Album/02 - Two.m4a",
+		"Album/03.m4a",
+		"Album/04.m4a",
+		"Album/cover.jpg",
+	}
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+		t.Errorf("Expected %q, have %q", expected, actual)
+	}
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"path/filepath"
+)
+
+// The loudness of a converted track waiting for the rest of its album, for
+// -replaygain with -by-album.
+type trackGain struct {
+	path     string // The source, for -preserve-time.
+	loudness ffmpeg.Loudness
+}
+
+// Measures the loudness of opath, newly converted from path, for -replaygain.
+// Without -by-album, it's tagged right away. With it, the loudness is kept for
+// tagAlbum, since the album gain needs every track. Failures are only logged,
+// since the output itself is fine.
+//
+// This runs on the same job as the conversion, so -j still bounds the number
+// of ffmpeg processes.
+func (p *Exporter) replayGain(path, opath string) {
+	loudness, err := p.measure(opath)
+	if err != nil {
+		return
+	} else if p.opts.ByAlbum {
+		p.gains.Store(opath, trackGain{path, loudness})
+		return
+	}
+	p.writeGain(path, opath, loudness, nil)
+}
+
+// Returns the loudness of opath, logging why if it can't be measured.
+func (p *Exporter) measure(opath string) (ffmpeg.Loudness, error) {
+	loudness, output, err := p.analyze(p.ctx, filepath.Join(p.opts.OutRoot, opath))
+	if err != nil && p.ctx.Err() == nil {
+		logging.Printf("Not tagging %q with ReplayGain: %v", opath, err)
+		p.logOutput(opath, string(output), true)
+	}
+	return loudness, err
+}
+
+// Tags the tracks converted by steps, an album, with their ReplayGain once
+// they're all measured. Outputs that were up to date are measured too, so the
+// album gain covers them, and are tagged again. Nothing is done unless at
+// least one track was converted.
+func (p *Exporter) tagAlbum(steps []Step) {
+	tracks := make(map[string]trackGain)
+	for _, step := range steps {
+		if gain, ok := p.gains.LoadAndDelete(step.OutPath); ok {
+			tracks[step.OutPath] = gain.(trackGain)
+		}
+	}
+	if len(tracks) == 0 {
+		return
+	}
+	var album []ffmpeg.Loudness
+	for _, step := range steps {
+		if p.ctx.Err() != nil {
+			return
+		}
+		gain, ok := tracks[step.OutPath]
+		converted := step.Track != nil || p.converts(step.RelPath)
+		if !ok && converted && p.upToDate(step.RelPath, step.OutPath, true) {
+			loudness, err := p.measure(step.OutPath)
+			if ok = err == nil; ok {
+				gain = trackGain{step.RelPath, loudness}
+				tracks[step.OutPath] = gain
+			}
+		}
+		if ok {
+			album = append(album, gain.loudness)
+		}
+	}
+	loudness := ffmpeg.AlbumLoudness(album)
+	for _, step := range steps {
+		if gain, ok := tracks[step.OutPath]; ok && p.ctx.Err() == nil {
+			p.writeGain(gain.path, step.OutPath, gain.loudness, &loudness)
+		}
+	}
+}
+
+// Rewrites opath, converted from path, with the ReplayGain tags for track, and
+// album unless it's nil. Like a conversion, the new file is only moved into
+// place once it's complete.
+func (p *Exporter) writeGain(path, opath string, track ffmpeg.Loudness, album *ffmpeg.Loudness) {
+	af := filesystem.NewAtomicFile(p.OutRoot, opath)
+	in := filepath.Join(p.opts.OutRoot, opath)
+	output, err := p.tag(p.ctx, in, filepath.Join(p.opts.OutRoot, af.Temp()), ffmpeg.ReplayGainTags(track, album))
+	if err == nil {
+		if p.opts.PreserveTime == options.PreserveAll {
+			p.preserve(path, af.Temp(), false)
+		}
+		err = af.Commit()
+	}
+	if err != nil {
+		p.discard(af)
+		if p.ctx.Err() == nil {
+			logging.Printf("Tagging %q with ReplayGain failed: %v", opath, err)
+			p.logOutput(opath, string(output), true)
+		}
+		return
+	}
+	logging.Verbosef("Tagged %q with a ReplayGain of %.2f dB", opath, track.Gain())
+}
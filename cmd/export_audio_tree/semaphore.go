@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import "context"
+
+// Limits how many goroutines do something at once. Used to keep the workers
+// from all copying files at the same time, which thrashes spinning disks,
+// without needing a second WorkPool.
+type semaphore chan struct{}
+
+// Returns a semaphore allowing n holders at a time.
+func newSemaphore(n int) semaphore {
+	return make(semaphore, n)
+}
+
+// Blocks until a slot is free or ctx is done. Returns the context's error in
+// the latter case, otherwise release must be called when finished.
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// Frees the slot taken by acquire.
+func (s semaphore) release() {
+	<-s
+}
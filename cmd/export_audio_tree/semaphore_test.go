@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := s.acquire(ctx); err == nil {
+		t.Errorf("acquire should fail when full and cancelled")
+	}
+	s.release()
+	if err := s.acquire(t.Context()); err != nil {
+		t.Errorf("acquire after release failed: %v", err)
+	}
+}
+
+func TestExporterIOJobs(t *testing.T) {
+	const ioJobs = 2
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.MaxJobs = 8
+		opts.IOJobs = ioJobs
+	})
+	var names []string
+	for i := range 16 {
+		names = append(names, fmt.Sprintf("%02d.jpg", i))
+	}
+	writeFiles(t, p.opts.InRoot, names...)
+
+	var running, peak atomic.Int32
+	p.copyFile = func(srcFS filesystem.FS, source string, dstFS filesystem.FS, destination string) (int64, filesystem.CopyTiming, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return filesystem.CopyFileTimed(srcFS, source, dstFS, destination)
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if n := peak.Load(); n > ioJobs {
+		t.Errorf("%d copies ran at once, expected at most %d", n, ioJobs)
+	} else if n == 0 {
+		t.Errorf("Nothing was copied")
+	}
+	if stats := p.Summary.Stats(); stats.Copied != len(names) {
+		t.Errorf("Copied %d of %d files", stats.Copied, len(names))
+	}
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"errors"
+	"fmt"
+)
+
+// Rough size of a converted file relative to a lossless source, by output
+// format, assuming the default bit rates. Used to estimate how much space an
+// export needs. Formats not listed are assumed to be the same size.
+var EstimatedRatios = map[string]float64{
+	"flac": 0.6,
+	"m4a":  0.35,
+	"m4r":  0.35,
+	"mp3":  0.3,
+}
+
+// Returns the estimated size of the output of step. Outputs that -update will
+// skip don't need any space. Sources aren't hashed for -checksum here, since
+// that would hold up planning, so the estimate errs on the high side.
+func (p *Exporter) estimate(step Step) int64 {
+	if p.upToDate(step.RelPath, step.OutPath, false) {
+		return 0
+	}
+	if step.Action == ActionConvert {
+		return int64(float64(step.Size) * p.sizeRatio())
+	}
+	return step.Size
+}
+
+// Returns -size-ratio, or else the estimate for the output format.
+func (p *Exporter) sizeRatio() float64 {
+	if p.opts.SizeRatio > 0 {
+		return p.opts.SizeRatio
+	}
+	if ratio, ok := EstimatedRatios[p.opts.Format]; ok {
+		return ratio
+	}
+	return 1
+}
+
+// Returns an error if the output root doesn't have room for the estimated size
+// of the export, unless -ignore-space. Since it's an estimate, it's better to
+// catch a full memory card before starting than partway through an album.
+func (p *Exporter) checkSpace(needed int64) error {
+	if p.opts.IgnoreSpace {
+		return nil
+	}
+	free, err := p.freeSpace()
+	if errors.Is(err, errors.ErrUnsupported) {
+		logging.Verbosef("Not checking free space: %v", err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed checking free space: %w", err)
+	}
+	logging.Verbosef("Export needs about %s of %s free", byteSize(needed), byteSize(int64(free)))
+	if uint64(needed) > free {
+		return fmt.Errorf("not enough free space in %q: the export needs about %s, but only %s is free (use -ignore-space to export anyway)",
+			p.opts.OutRoot, byteSize(needed), byteSize(int64(free)))
+	}
+	return nil
+}
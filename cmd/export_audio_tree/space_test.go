@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExporterCheckSpace(t *testing.T) {
+	// A 1000 byte song converts to about 350 bytes of m4a, plus a 100 byte copy.
+	setup := func(t *testing.T, free uint64, configure ...func(*options.ExporterOptions)) *Exporter {
+		p := newTestExporter(t, fakeConvert, configure...)
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, "song.flac"), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p.opts.InRoot, "cover.jpg"), make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p.freeSpace = func() (uint64, error) { return free, nil }
+		return p
+	}
+
+	t.Run("enough", func(t *testing.T) {
+		p := setup(t, 450)
+		if plan, err := p.Plan(t.Context()); err != nil {
+			t.Fatal(err)
+		} else if plan.Needed != 450 {
+			t.Errorf("Estimated %d bytes, expected 450", plan.Needed)
+		}
+		p = setup(t, 450)
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	})
+	t.Run("not enough", func(t *testing.T) {
+		p := setup(t, 449)
+		if err := p.Run(); err == nil || !strings.Contains(err.Error(), "-ignore-space") {
+			t.Fatalf("Expected a free space error: %v", err)
+		}
+		if actual := listTree(t, p.opts.OutRoot); len(actual) != 0 {
+			t.Errorf("Exported despite the error: %q", actual)
+		}
+	})
+	t.Run("ignore space", func(t *testing.T) {
+		p := setup(t, 0, func(opts *options.ExporterOptions) {
+			opts.IgnoreSpace = true
+		})
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	})
+	t.Run("size ratio", func(t *testing.T) {
+		p := setup(t, 1100, func(opts *options.ExporterOptions) {
+			opts.SizeRatio = 1
+		})
+		if plan, err := p.Plan(t.Context()); err != nil {
+			t.Fatal(err)
+		} else if plan.Needed != 1100 {
+			t.Errorf("Estimated %d bytes, expected 1100", plan.Needed)
+		}
+	})
+	t.Run("up to date", func(t *testing.T) {
+		p := setup(t, 450, func(opts *options.ExporterOptions) {
+			opts.Update = true
+		})
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		p = newExporter(t.Context(), p.opts)
+		p.convert = fakeConvert
+		p.freeSpace = func() (uint64, error) { return 0, nil }
+		if err := p.Run(); err != nil {
+			t.Fatalf("Second run failed: %v", err)
+		}
+		if plan, err := p.Plan(t.Context()); err != nil {
+			t.Fatal(err)
+		} else if plan.Needed != 0 {
+			t.Errorf("Up to date outputs need no space: %d", plan.Needed)
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		p := setup(t, 0)
+		p.freeSpace = func() (uint64, error) { return 0, errors.ErrUnsupported }
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	})
+}
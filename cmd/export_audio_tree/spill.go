@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A step waiting in a spillQueue, with when it was queued so that the time
+// spent spilled counts as queue wait.
+type spilledStep struct {
+	Step   Step      `json:"step"`
+	Queued time.Time `json:"queued"`
+}
+
+// An overflow for the steps that don't fit in the pool's queue, for
+// -watch-spill. Steps are appended to a temporary file, one JSON line each,
+// and read back in the same order. It's only there to keep memory in check
+// when thousands of files show up at once, so nothing survives Close. Safe for
+// concurrent use.
+type spillQueue struct {
+	mutex  sync.Mutex
+	writer *os.File
+	file   *os.File // The same file, for reading.
+	reader *bufio.Reader
+	depth  int           // Steps pushed but not yet done.
+	ready  chan struct{} // Receives a value when a step is pushed.
+}
+
+// Creates the temporary file for a spillQueue in dir.
+func newSpillQueue(dir string) (*spillQueue, error) {
+	writer, err := os.CreateTemp(dir, ".export_audio_tree-*.spill")
+	if err != nil {
+		return nil, fmt.Errorf("failed creating -watch-spill file: %w", err)
+	}
+	file, err := os.Open(writer.Name())
+	if err != nil {
+		writer.Close()
+		os.Remove(writer.Name())
+		return nil, fmt.Errorf("failed opening -watch-spill file: %w", err)
+	}
+	return &spillQueue{
+		writer: writer,
+		file:   file,
+		reader: bufio.NewReader(file),
+		ready:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Returns the number of steps spilled that haven't been replayed yet.
+func (q *spillQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.depth
+}
+
+// Appends step, queued at the given time, to the end of the queue.
+func (q *spillQueue) push(step Step, queued time.Time) error {
+	data, err := json.Marshal(spilledStep{Step: step, Queued: queued})
+	if err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, err := q.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed writing -watch-spill file: %w", err)
+	}
+	q.depth++
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Returns the step at the front of the queue, waiting for one to be pushed if
+// it's empty, or ctx's error once it's done. The step stays in Len until done
+// is called, so that pushes keep going to the queue while it's replayed.
+func (q *spillQueue) next(ctx context.Context) (spilledStep, error) {
+	for {
+		q.mutex.Lock()
+		if q.depth > 0 {
+			defer q.mutex.Unlock()
+			var s spilledStep
+			line, err := q.reader.ReadBytes('\n')
+			if err == nil {
+				err = json.Unmarshal(line, &s)
+			} else if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				// Without knowing where the next step starts, the rest are lost.
+				dropped := q.depth
+				q.depth = 0
+				return s, errors.Join(fmt.Errorf("failed reading -watch-spill file, dropping %d files: %w", dropped, err), q.reset())
+			}
+			return s, nil
+		}
+		q.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return spilledStep{}, context.Cause(ctx)
+		case <-q.ready:
+		}
+	}
+}
+
+// Removes the step returned by next from the queue. Once the queue is empty,
+// the file is truncated so that it doesn't grow forever.
+func (q *spillQueue) done() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.depth--; q.depth > 0 {
+		return nil
+	}
+	return q.reset()
+}
+
+// Empties the file. This must be called while holding q.mutex, with nothing
+// left to read.
+func (q *spillQueue) reset() error {
+	if err := q.writer.Truncate(0); err != nil {
+		return err
+	} else if _, err := q.writer.Seek(0, io.SeekStart); err != nil {
+		return err
+	} else if _, err := q.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	q.reader.Reset(q.file)
+	return nil
+}
+
+// Closes and removes the file, dropping any steps left in it.
+func (q *spillQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return errors.Join(q.file.Close(), q.writer.Close(), os.Remove(q.writer.Name()))
+}
@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpillQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := newSpillQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := time.Now().Round(0)
+	steps := []Step{
+		{RelPath: "A/01 Song.flac", Action: ActionConvert, OutPath: "A/01 Song.m4a", Size: 1},
+		{RelPath: "A/cover.jpg", Action: ActionCopy, OutPath: "A/cover.jpg", Size: 2},
+		{RelPath: "A/02 Song.flac", Action: ActionConvert, OutPath: "A/02 Song.m4a", Size: 3},
+	}
+	replay := func(n int) []Step {
+		t.Helper()
+		var got []Step
+		for range n {
+			s, err := q.next(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			} else if !s.Queued.Equal(queued) {
+				t.Errorf("Bad queued time %v, expected %v", s.Queued, queued)
+			}
+			got = append(got, s.Step)
+			if err := q.done(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return got
+	}
+
+	for _, step := range steps[:2] {
+		if err := q.push(step, queued); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := replay(1); !slices.Equal(got, steps[:1]) {
+		t.Errorf("Bad first replay: %+v", got)
+	}
+	// Pushed while the queue is being replayed.
+	if err := q.push(steps[2], queued); err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Expected 2 spilled, have %d", q.Len())
+	}
+	if got := replay(2); !slices.Equal(got, steps[1:]) {
+		t.Errorf("Bad second replay: %+v", got)
+	}
+	if st, err := os.Stat(q.writer.Name()); err != nil {
+		t.Fatal(err)
+	} else if st.Size() != 0 {
+		t.Errorf("An empty queue should truncate its file, have %d bytes", st.Size())
+	}
+
+	// Used again after truncating.
+	if err := q.push(steps[0], queued); err != nil {
+		t.Fatal(err)
+	}
+	if got := replay(1); !slices.Equal(got, steps[:1]) {
+		t.Errorf("Bad replay after truncating: %+v", got)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.next(ctx); err == nil {
+		t.Error("next should give up once the context is done")
+	}
+
+	// A damaged file loses what's in it, but not what comes after.
+	if err := q.push(steps[0], queued); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.writer.WriteString("garbage\n"); err != nil {
+		t.Fatal(err)
+	}
+	q.depth++
+	if got := replay(1); !slices.Equal(got, steps[:1]) {
+		t.Errorf("Bad replay before the damage: %+v", got)
+	}
+	if _, err := q.next(t.Context()); err == nil || !strings.Contains(err.Error(), "dropping 1 files") {
+		t.Errorf("Expected an error dropping the rest: %v", err)
+	} else if q.Len() != 0 {
+		t.Errorf("The rest should be dropped, have %d", q.Len())
+	}
+	if err := q.push(steps[2], queued); err != nil {
+		t.Fatal(err)
+	}
+	if got := replay(1); !slices.Equal(got, steps[2:]) {
+		t.Errorf("Bad replay after the damage: %+v", got)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("Close should remove the file: %v %v", entries, err)
+	}
+}
+
+func TestExporterWatchSpill(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	var converted []string
+	spillDir := t.TempDir()
+	p := newTestExporter(t, func(c context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if strings.Contains(opts.InputFile, "New") {
+			<-release
+		}
+		mutex.Lock()
+		converted = append(converted, filepath.Base(opts.InputFile))
+		mutex.Unlock()
+		return fakeConvert(c, opts)
+	}, func(opts *options.ExporterOptions) {
+		opts.Watch = true
+		opts.WatchSettle = 20 * time.Millisecond
+		opts.WatchPoll = 10 * time.Millisecond
+		opts.WatchSpill = spillDir
+		opts.MaxJobs = 1
+		opts.MaxQueue = 1
+		opts.StatusInterval = 0
+	})
+	p.ctx = ctx
+	writeFiles(t, p.opts.InRoot, "Old/01 Song.flac")
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Run()
+	}()
+
+	// More than the worker and the queue can hold, all at once.
+	var songs []string
+	for i := range 10 {
+		songs = append(songs, fmt.Sprintf("%02d Song.flac", i+1))
+	}
+	for deadline := time.Now().Add(10 * time.Second); p.spill.Load() == nil; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting to watch")
+		}
+	}
+	for _, song := range songs {
+		writeFiles(t, p.opts.InRoot, "New/"+song)
+	}
+	spill := p.spill.Load()
+	for deadline := time.Now().Add(10 * time.Second); spill.Len() < 5; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting to spill, have %d", spill.Len())
+		}
+	}
+	close(release)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		mutex.Lock()
+		n := len(converted)
+		mutex.Unlock()
+		if n == len(songs)+1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the spilled files: %q", converted)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Interrupting while idle should stop cleanly: %v", err)
+	}
+
+	if !slices.Equal(converted[1:], songs) {
+		t.Errorf("Spilled files should be converted in order: %q", converted)
+	}
+	if entries, err := os.ReadDir(spillDir); err != nil || len(entries) != 0 {
+		t.Errorf("The spill file should be removed: %v %v", entries, err)
+	}
+}
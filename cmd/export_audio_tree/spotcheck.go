@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+)
+
+// Returned by Run when -spot-check finds a bad file.
+var ErrSpotCheck = errors.New("spot check failed")
+
+// Decodes a random sample of -spot-check converted files to make sure they're
+// intact. The seed is logged, so the same sample can be checked again with
+// -spot-check-seed.
+func (p *Exporter) spotCheck() error {
+	var converted []Result
+	for _, r := range p.Summary.Results() {
+		if r.Action == ActionConvert && r.Status == StatusDone {
+			converted = append(converted, r)
+		}
+	}
+	seed := p.opts.SpotCheckSeed
+	if seed == 0 {
+		seed = rand.Int64()
+	}
+	sample := weightedSample(converted, p.opts.SpotCheck, rand.New(rand.NewPCG(uint64(seed), 0)))
+	logging.Reportf("Spot checking %d of %d converted files with seed %d\n", len(sample), len(converted), seed)
+
+	var bad atomic.Int32
+	for _, r := range sample {
+		p.Summary.Queued()
+		p.pool.Add(func() {
+			if err := p.Verify(r.Path); err != nil {
+				logging.Println(err)
+				bad.Add(1)
+			}
+		})
+	}
+	p.pool.Wait()
+	if n := bad.Load(); n > 0 {
+		return fmt.Errorf("%w: %d of %d sampled files are bad", ErrSpotCheck, n, len(sample))
+	}
+	return nil
+}
+
+// Checks that the output of path decodes without errors.
+func (p *Exporter) Verify(path string) error {
+	opath := p.outputName(path)
+	output, err := p.verify(p.ctx, filepath.Join(p.opts.OutRoot, opath))
+	if err != nil {
+		p.logOutput(opath, string(output), true)
+	}
+	p.record(Result{Path: path, Action: ActionVerify}, opath, err)
+	return err
+}
+
+// Picks up to n results at random without replacement. Larger outputs are more
+// likely to be picked, since they're more likely to have been truncated.
+//
+// Each result gets a key of u^(1/weight) for a uniform random u, and the n
+// largest keys win. Logarithms are used to keep small weights from underflowing.
+func weightedSample(results []Result, n int, rng *rand.Rand) []Result {
+	type keyed struct {
+		Result
+		key float64
+	}
+	keys := make([]keyed, len(results))
+	for i, r := range results {
+		keys[i] = keyed{r, math.Log(rng.Float64()) / float64(r.OutputBytes+1)}
+	}
+	slices.SortFunc(keys, func(a, b keyed) int {
+		return cmp.Compare(b.key, a.key)
+	})
+	var sample []Result
+	for _, k := range keys[:min(n, len(keys))] {
+		sample = append(sample, k.Result)
+	}
+	return sample
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWeightedSample(t *testing.T) {
+	var results []Result
+	for i := range 100 {
+		results = append(results, Result{Path: fmt.Sprintf("%d.flac", i), OutputBytes: 1000})
+	}
+	results[42].OutputBytes = 1_000_000_000
+
+	t.Run("reproducible", func(t *testing.T) {
+		a := weightedSample(results, 5, rand.New(rand.NewPCG(1234, 0)))
+		b := weightedSample(results, 5, rand.New(rand.NewPCG(1234, 0)))
+		if len(a) != 5 || !slices.EqualFunc(a, b, func(x, y Result) bool { return x.Path == y.Path }) {
+			t.Errorf("Same seed gave different samples:\n%+v\n%+v", a, b)
+		}
+	})
+	t.Run("more than available", func(t *testing.T) {
+		if sample := weightedSample(results[:3], 10, rand.New(rand.NewPCG(1, 0))); len(sample) != 3 {
+			t.Errorf("Expected all 3 results, got %d", len(sample))
+		}
+	})
+	t.Run("weighted", func(t *testing.T) {
+		hits := 0
+		for seed := range uint64(100) {
+			sample := weightedSample(results, 1, rand.New(rand.NewPCG(seed, 0)))
+			if sample[0].Path == "42.flac" {
+				hits++
+			}
+		}
+		if hits < 90 {
+			t.Errorf("The largest file should almost always be chosen, but was chosen %d times out of 100", hits)
+		}
+	})
+}
+
+func TestExporterSpotCheck(t *testing.T) {
+	input := []string{"a.flac", "b.flac", "c.flac", "cover.jpg"}
+	spotCheck := func(opts *options.ExporterOptions) {
+		opts.SpotCheck = 10
+		opts.SpotCheckSeed = 1
+	}
+
+	t.Run("good", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, spotCheck)
+		p.verify = func(context.Context, string) ([]byte, error) {
+			return nil, nil
+		}
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if stats := p.Summary.Stats(); stats.Verified != 3 || stats.Converted != 3 || stats.Copied != 1 {
+			t.Errorf("Expected the 3 conversions to be verified: %+v", stats)
+		}
+	})
+	t.Run("bad", func(t *testing.T) {
+		p := newTestExporter(t, fakeConvert, spotCheck)
+		p.verify = func(_ context.Context, name string) ([]byte, error) {
+			if strings.HasSuffix(name, "b.m4a") {
+				return []byte("truncated"), errors.New("exit status 1")
+			}
+			return nil, nil
+		}
+		writeFiles(t, p.opts.InRoot, input...)
+		if err := p.Run(); !errors.Is(err, ErrSpotCheck) {
+			t.Fatalf("Expected ErrSpotCheck, got: %v", err)
+		}
+		if stats := p.Summary.Stats(); stats.Verified != 2 || stats.Failed != 1 {
+			t.Errorf("Expected one failed verification: %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// One line of a -state journal, recording a file that was exported.
+type StateRecord struct {
+	Path     string    `json:"path"`   // The source, relative to the input root.
+	Output   string    `json:"output"` // Relative to the output root.
+	Size     int64     `json:"size"`   // Of the source.
+	ModTime  time.Time `json:"mtime"`  // Of the source.
+	Settings string    `json:"settings,omitempty"`
+}
+
+// An append-only journal of the files exported, for -state. A rerun with the
+// same journal skips the files whose source hasn't changed since, without
+// looking at the output at all. Safe for concurrent use.
+type State struct {
+	mutex   sync.Mutex
+	name    string
+	fp      *os.File
+	records map[string]StateRecord // By source path. Later lines win.
+}
+
+// Opens the journal name, creating it if it doesn't exist. Lines that can't be
+// read, e.g., one cut short by a crash, are ignored.
+func OpenState(name string) (*State, error) {
+	s := &State{name: name, records: make(map[string]StateRecord)}
+	data, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed reading -state %s: %w", name, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		var r StateRecord
+		if line := scanner.Bytes(); len(bytes.TrimSpace(line)) == 0 {
+			continue
+		} else if err := json.Unmarshal(line, &r); err != nil || r.Path == "" {
+			logging.Verbosef("Ignoring line %d of %s: %q", n, name, line)
+			continue
+		}
+		s.records[r.Path] = r
+	}
+	if s.fp, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+		return nil, fmt.Errorf("failed opening -state %s: %w", name, err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// Finish the truncated line, so that the next record starts fresh.
+		if _, err := io.WriteString(s.fp, "\n"); err != nil {
+			s.fp.Close()
+			return nil, fmt.Errorf("failed writing -state %s: %w", name, err)
+		}
+	}
+	return s, nil
+}
+
+// Returns true if the journal says r.Path was already exported to r.Output
+// with the same settings, and the source hasn't changed since.
+func (s *State) Done(r StateRecord) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, ok := s.records[r.Path]
+	return ok && old.Output == r.Output && old.Size == r.Size &&
+		old.ModTime.Equal(r.ModTime) && old.Settings == r.Settings
+}
+
+// Appends r to the journal. Each record is written as soon as it's added, so
+// that it survives the export being interrupted.
+func (s *State) Add(r StateRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records[r.Path] = r
+	if _, err := s.fp.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed writing -state %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// Closes the journal.
+func (s *State) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.fp.Close()
+}
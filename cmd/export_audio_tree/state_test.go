@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "export.state")
+	mtime := time.Date(2025, 8, 19, 12, 0, 0, 0, time.UTC)
+	song := StateRecord{Path: "Album/01 Song.flac", Output: "Album/01 Song.m4a", Size: 100, ModTime: mtime, Settings: "-c:a aac"}
+	cover := StateRecord{Path: "Album/cover.jpg", Output: "Album/cover.jpg", Size: 10, ModTime: mtime}
+
+	s, err := OpenState(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Done(song) {
+		t.Errorf("Nothing should be done in a new journal")
+	}
+	for _, r := range []StateRecord{song, cover} {
+		if err := s.Add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash in the middle of writing a record leaves half a line.
+	fp, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.WriteString(`{"path":"Album/02 Other.fl`)
+	fp.Close()
+
+	s, err = OpenState(name)
+	if err != nil {
+		t.Fatalf("A truncated line should be ignored: %v", err)
+	}
+	defer s.Close()
+	if !s.Done(song) || !s.Done(cover) {
+		t.Errorf("Records should survive reopening")
+	}
+	changed := song
+	changed.ModTime = mtime.Add(time.Second)
+	if s.Done(changed) {
+		t.Errorf("A changed source is not done")
+	}
+	changed = song
+	changed.Settings = "-c:a libmp3lame"
+	if s.Done(changed) {
+		t.Errorf("Different settings are not done")
+	}
+	other := StateRecord{Path: "Album/02 Other.flac", Output: "Album/02 Other.m4a", Size: 5, ModTime: mtime}
+	if err := s.Add(other); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := OpenState(name); err != nil || !s.Done(other) {
+		t.Errorf("Record after a truncated line should be readable: %v", err)
+	} else {
+		s.Close()
+	}
+}
+
+func TestExporterState(t *testing.T) {
+	songs := []string{"01 Song.flac", "02 Song.flac", "03 Song.flac", "04 Song.flac", "05 Song.flac"}
+	state := filepath.Join(t.TempDir(), "export.state")
+	configure := func(opts *options.ExporterOptions) {
+		opts.StateFile = state
+		opts.MaxJobs = 1
+	}
+	var mutex sync.Mutex
+	var converted []string
+	convert := func(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		mutex.Lock()
+		converted = append(converted, filepath.Base(opts.InputFile))
+		mutex.Unlock()
+		return fakeConvert(ctx, opts)
+	}
+
+	// Interrupted after the second conversion.
+	ctx, cancel := context.WithCancel(t.Context())
+	p := newTestExporter(t, func(c context.Context, opts *options.ConverterOptions) ([]byte, error) {
+		if len(converted) == 2 {
+			cancel()
+			return nil, context.Canceled
+		}
+		return convert(c, opts)
+	}, configure)
+	p.ctx = ctx
+	writeFiles(t, p.opts.InRoot, songs...)
+	if err := p.Run(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run should have been interrupted: %v", err)
+	}
+	if !slices.Equal(converted, songs[:2]) {
+		t.Fatalf("Bad first run: %q", converted)
+	}
+
+	// The rerun only does the rest, even though the first outputs are gone.
+	converted = nil
+	if err := os.Remove(filepath.Join(p.opts.OutRoot, "01 Song.m4a")); err != nil {
+		t.Fatal(err)
+	}
+	p = newExporter(t.Context(), p.opts)
+	p.convert = convert
+	if err := p.Run(); err != nil {
+		t.Fatalf("Rerun failed: %v", err)
+	}
+	if !slices.Equal(converted, songs[2:]) {
+		t.Errorf("Rerun should only convert the rest: %q", converted)
+	}
+	if n := p.Summary.Count(StatusSkipped); n != 2 {
+		t.Errorf("Expected 2 skipped: %+v", p.Summary.Results())
+	}
+}
@@ -0,0 +1,322 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/workpool"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// What a task was asked to do with a file.
+type Action int
+
+const (
+	ActionConvert Action = iota
+	ActionCopy
+	ActionVerify
+	ActionLink
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionConvert:
+		return "convert"
+	case ActionCopy:
+		return "copy"
+	case ActionVerify:
+		return "verify"
+	case ActionLink:
+		return "link"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// How a task turned out.
+type Status int
+
+const (
+	StatusDone    Status = iota // Completed successfully.
+	StatusSkipped               // Nothing to do, e.g., not clobbering.
+	StatusFailed                // Completed with an error.
+	StatusAborted               // Interrupted by cancellation.
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusDone:
+		return "done"
+	case StatusSkipped:
+		return "skipped"
+	case StatusFailed:
+		return "failed"
+	case StatusAborted:
+		return "aborted"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// The outcome of a single task, relative to the input root.
+type Result struct {
+	Path        string
+	SourceExt   string // Lower case extension of Path, filled in by Summary.Add.
+	Action      Action
+	Status      Status
+	Err         error
+	InputBytes  int64
+	OutputBytes int64
+	WithoutArt  bool   // Converted, but the cover art had to be dropped.
+	ErrorLog    string // Where -error-logs wrote the output of a failure.
+	Output      string // What ffmpeg printed when it failed, for the log. Not kept by the Summary.
+	Timing      Timing
+}
+
+// Collects results from the workers for reporting at the end of the run. Safe
+// for concurrent use.
+type Summary struct {
+	mutex   sync.Mutex
+	queued  int
+	panics  int
+	pool    workpool.PoolStats
+	results []Result
+}
+
+// Notes that a task was added to the queue. Tasks that were queued but never
+// produced a result are reported as not started.
+func (s *Summary) Queued() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queued++
+}
+
+// Records the result of a task.
+func (s *Summary) Add(r Result) {
+	if r.SourceExt == "" {
+		r.SourceExt = strings.ToLower(filepath.Ext(r.Path))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results = append(s.results, r)
+}
+
+// Returns a copy of the results recorded so far.
+func (s *Summary) Results() []Result {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Result(nil), s.results...)
+}
+
+// Returns the number of results with the given status.
+func (s *Summary) Count(status Status) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := 0
+	for _, r := range s.results {
+		if r.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Totals for one source extension and action.
+type FormatStats struct {
+	Extension   string  `json:"extension"`
+	Action      string  `json:"action"`
+	Count       int     `json:"count"`
+	InputBytes  int64   `json:"input_bytes"`
+	OutputBytes int64   `json:"output_bytes"`
+	Ratio       float64 `json:"ratio"` // Output size relative to input, e.g., 0.35.
+}
+
+// Totals for the whole run. Only successful results are bucketed by format,
+// since failures don't have a meaningful output size.
+type Stats struct {
+	Converted  int                `json:"converted"`
+	Copied     int                `json:"copied"`
+	Skipped    int                `json:"skipped"`
+	Failed     int                `json:"failed"`
+	Aborted    int                `json:"aborted"`
+	Panicked   int                `json:"panicked"`
+	NotStarted int                `json:"not_started"`
+	Verified   int                `json:"verified"`
+	Linked     int                `json:"linked"`
+	WithoutArt int                `json:"without_art"`
+	Formats    []FormatStats      `json:"formats"`
+	Timing     Timing             `json:"timing"` // Summed over every task, whatever its status.
+	Pool       workpool.PoolStats `json:"pool"`
+}
+
+// Aggregates the results recorded so far.
+func (s *Summary) Stats() Stats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := aggregate(s.results, s.queued, s.panics)
+	stats.Pool = s.pool
+	return stats
+}
+
+// Records the work pool's counts of the tasks it handled.
+func (s *Summary) SetPool(stats workpool.PoolStats) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pool = stats
+}
+
+// Records the number of tasks that panicked, and so have no result.
+func (s *Summary) SetPanics(n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.panics = n
+}
+
+// Does the work of Stats. Formats are sorted by extension and action.
+func aggregate(results []Result, queued int, panics int) Stats {
+	var stats Stats
+	buckets := make(map[[2]string]*FormatStats)
+	for _, r := range results {
+		stats.Timing = stats.Timing.Add(r.Timing)
+		switch r.Status {
+		case StatusDone:
+			switch r.Action {
+			case ActionConvert:
+				stats.Converted++
+			case ActionCopy:
+				stats.Copied++
+			case ActionVerify:
+				// Not a new output, so there's nothing to bucket.
+				stats.Verified++
+				continue
+			case ActionLink:
+				// Points at an output counted on its own.
+				stats.Linked++
+				continue
+			}
+			if r.WithoutArt {
+				stats.WithoutArt++
+			}
+		case StatusSkipped:
+			stats.Skipped++
+			continue
+		case StatusFailed:
+			stats.Failed++
+			continue
+		case StatusAborted:
+			stats.Aborted++
+			continue
+		}
+		key := [2]string{r.SourceExt, r.Action.String()}
+		b := buckets[key]
+		if b == nil {
+			b = &FormatStats{Extension: key[0], Action: key[1]}
+			buckets[key] = b
+		}
+		b.Count++
+		b.InputBytes += r.InputBytes
+		b.OutputBytes += r.OutputBytes
+	}
+	stats.Panicked = panics
+	stats.NotStarted = max(0, queued-len(results)-panics)
+
+	stats.Formats = []FormatStats{}
+	for _, b := range buckets {
+		if b.InputBytes > 0 {
+			b.Ratio = float64(b.OutputBytes) / float64(b.InputBytes)
+		}
+		stats.Formats = append(stats.Formats, *b)
+	}
+	slices.SortFunc(stats.Formats, func(a, b FormatStats) int {
+		return cmp.Or(cmp.Compare(a.Extension, b.Extension), cmp.Compare(a.Action, b.Action))
+	})
+	return stats
+}
+
+// Writes the aggregated statistics as JSON to w.
+func (s *Summary) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.Stats())
+}
+
+// Formats a human readable report. Files that failed or were aborted are
+// listed individually, since those are the ones needing attention.
+func (s *Summary) String() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := aggregate(s.results, s.queued, s.panics)
+	var failed, aborted, noArt []string
+	for _, r := range s.results {
+		switch {
+		case r.Status == StatusFailed && r.ErrorLog != "":
+			failed = append(failed, fmt.Sprintf("  %s: %v (see %s)", r.Path, r.Err, r.ErrorLog))
+		case r.Status == StatusFailed:
+			failed = append(failed, fmt.Sprintf("  %s: %v", r.Path, r.Err))
+		case r.Status == StatusAborted:
+			aborted = append(aborted, "  "+r.Path)
+		case r.WithoutArt:
+			noArt = append(noArt, "  "+r.Path)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Converted: %d Copied: %d Skipped: %d Failed: %d Aborted: %d",
+		stats.Converted, stats.Copied, stats.Skipped, stats.Failed, stats.Aborted)
+	if stats.NotStarted > 0 {
+		fmt.Fprintf(&b, " Not started: %d", stats.NotStarted)
+	}
+	if stats.Panicked > 0 {
+		fmt.Fprintf(&b, " Panicked: %d", stats.Panicked)
+	}
+	if stats.Verified > 0 {
+		fmt.Fprintf(&b, " Verified: %d", stats.Verified)
+	}
+	if stats.Linked > 0 {
+		fmt.Fprintf(&b, " Linked: %d", stats.Linked)
+	}
+	b.WriteString("\n")
+	if len(stats.Formats) > 0 {
+		fmt.Fprintf(&b, "%-8s %-8s %8s %12s %12s %6s\n", "Format", "Action", "Files", "Input", "Output", "Ratio")
+		for _, f := range stats.Formats {
+			fmt.Fprintf(&b, "%-8s %-8s %8d %12s %12s %6.2f\n",
+				cmp.Or(f.Extension, "(none)"), f.Action, f.Count, byteSize(f.InputBytes), byteSize(f.OutputBytes), f.Ratio)
+		}
+	}
+	if breakdown := stats.Timing.Breakdown(); breakdown != "" {
+		fmt.Fprintf(&b, "Time: %s\n", breakdown)
+	}
+	if pool := s.pool; pool.Submitted > 0 {
+		fmt.Fprintf(&b, "Tasks: %d submitted, %d completed, %d failed, queue peaked at %d\n",
+			pool.Submitted, pool.Completed, pool.Failed, pool.MaxQueued)
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "Failed:\n%s\n", strings.Join(failed, "\n"))
+	}
+	if len(aborted) > 0 {
+		fmt.Fprintf(&b, "Aborted:\n%s\n", strings.Join(aborted, "\n"))
+	}
+	if len(noArt) > 0 {
+		fmt.Fprintf(&b, "Converted without art:\n%s\n", strings.Join(noArt, "\n"))
+	}
+	return b.String()
+}
+
+// Formats n bytes in human friendly units, e.g., 1.5 GiB.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/workpool"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSummary(t *testing.T) {
+	var s Summary
+	for range 5 {
+		s.Queued()
+	}
+	s.Add(Result{Path: "a.flac", Action: ActionConvert, Status: StatusDone})
+	s.Add(Result{Path: "b.jpg", Action: ActionCopy, Status: StatusDone})
+	s.Add(Result{Path: "c.flac", Action: ActionConvert, Status: StatusFailed, Err: errors.New("boom")})
+	s.Add(Result{Path: "d.flac", Action: ActionConvert, Status: StatusAborted, Err: context.Canceled})
+
+	if n := s.Count(StatusDone); n != 2 {
+		t.Errorf("Bad done count: actual: %d expected: 2", n)
+	}
+	if n := s.Count(StatusAborted); n != 1 {
+		t.Errorf("Bad aborted count: actual: %d expected: 1", n)
+	}
+	str := s.String()
+	t.Logf("Summary:\n%s", str)
+	for _, expected := range []string{
+		"Converted: 1 Copied: 1 Skipped: 0 Failed: 1 Aborted: 1 Not started: 1",
+		"c.flac: boom",
+		"d.flac",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Errorf("Summary missing %q", expected)
+		}
+	}
+}
+
+func TestSummaryStats(t *testing.T) {
+	var s Summary
+	for _, r := range []Result{
+		{Path: "a/1.flac", Action: ActionConvert, Status: StatusDone, InputBytes: 1000, OutputBytes: 300},
+		{Path: "a/2.FLAC", Action: ActionConvert, Status: StatusDone, InputBytes: 3000, OutputBytes: 1100},
+		{Path: "b/1.mp3", Action: ActionConvert, Status: StatusDone, InputBytes: 500, OutputBytes: 450},
+		{Path: "b/2.mp3", Action: ActionConvert, Status: StatusFailed, InputBytes: 500},
+		{Path: "b/1.m4a", Action: ActionCopy, Status: StatusDone, InputBytes: 200, OutputBytes: 200},
+		{Path: "b/cover.jpg", Action: ActionCopy, Status: StatusDone, InputBytes: 50, OutputBytes: 50},
+		{Path: "b/notes", Action: ActionCopy, Status: StatusSkipped},
+	} {
+		s.Add(r)
+	}
+	stats := s.Stats()
+	// Skipped and failed files aren't bucketed.
+	expected := []FormatStats{
+		{Extension: ".flac", Action: "convert", Count: 2, InputBytes: 4000, OutputBytes: 1400, Ratio: 0.35},
+		{Extension: ".jpg", Action: "copy", Count: 1, InputBytes: 50, OutputBytes: 50, Ratio: 1},
+		{Extension: ".m4a", Action: "copy", Count: 1, InputBytes: 200, OutputBytes: 200, Ratio: 1},
+		{Extension: ".mp3", Action: "convert", Count: 1, InputBytes: 500, OutputBytes: 450, Ratio: 0.9},
+	}
+	if len(stats.Formats) != len(expected) {
+		t.Fatalf("Bad buckets:\nactual  : %+v\nexpected: %+v", stats.Formats, expected)
+	}
+	for i := range expected {
+		a, e := stats.Formats[i], expected[i]
+		if a.Extension != e.Extension || a.Action != e.Action || a.Count != e.Count ||
+			a.InputBytes != e.InputBytes || a.OutputBytes != e.OutputBytes || math.Abs(a.Ratio-e.Ratio) > 0.001 {
+			t.Errorf("Bad bucket %d:\nactual  : %+v\nexpected: %+v", i, a, e)
+		}
+	}
+	if stats.Converted != 3 || stats.Copied != 2 || stats.Failed != 1 || stats.Skipped != 1 {
+		t.Errorf("Bad totals: %+v", stats)
+	}
+
+	var b bytes.Buffer
+	if err := s.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Stats
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("Bad JSON: %v\n%s", err, b.String())
+	}
+	if len(decoded.Formats) != len(expected) || decoded.Formats[0].Extension != ".flac" {
+		t.Errorf("JSON did not round trip:\n%s", b.String())
+	}
+	if str := s.String(); !strings.Contains(str, ".flac") || !strings.Contains(str, "0.35") {
+		t.Errorf("Summary is missing the format breakdown:\n%s", str)
+	}
+}
+
+func TestSummaryPanics(t *testing.T) {
+	var s Summary
+	for range 3 {
+		s.Queued()
+	}
+	s.Add(Result{Path: "a.flac", Action: ActionConvert, Status: StatusDone})
+	s.SetPanics(1)
+	stats := s.Stats()
+	if stats.Panicked != 1 || stats.NotStarted != 1 {
+		t.Errorf("Bad totals: %+v", stats)
+	}
+	if str := s.String(); !strings.Contains(str, "Panicked: 1") {
+		t.Errorf("Summary is missing the panics:\n%s", str)
+	}
+}
+
+func TestSummaryPool(t *testing.T) {
+	var s Summary
+	if str := s.String(); strings.Contains(str, "Tasks:") {
+		t.Errorf("An empty pool shouldn't be reported:\n%s", str)
+	}
+	s.SetPool(workpool.PoolStats{Submitted: 5, Started: 5, Completed: 5, Failed: 1, MaxQueued: 3})
+	if stats := s.Stats(); stats.Pool.Submitted != 5 || stats.Pool.MaxQueued != 3 {
+		t.Errorf("Bad pool stats: %+v", stats.Pool)
+	}
+	if str := s.String(); !strings.Contains(str, "Tasks: 5 submitted, 5 completed, 1 failed, queue peaked at 3") {
+		t.Errorf("Summary is missing the pool stats:\n%s", str)
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/logging"
+	"errors"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+)
+
+// Returned by linkTarget for symlinks that point outside of the input root.
+var errOutsideRoot = errors.New("target is outside of the input root")
+
+// Returned by linkCycle for symlinks that would be followed forever.
+var errLinkCycle = errors.New("following it would loop")
+
+// Plans the symlink at path. Links recreated by -preserve-symlinks are planned
+// like any other file with planFile, and links to files are planned as the
+// file. Links to directories are walked with visit if -follow-symlinks is set,
+// and skipped otherwise, as are broken links.
+func (p *Exporter) planLink(path string, d fs.DirEntry, planFile func(string, fs.DirEntry) error, visit fs.WalkDirFunc) error {
+	if _, ok := p.preservedLink(path, d); ok {
+		return planFile(path, d)
+	}
+	st, err := p.InRoot.Stat(path)
+	if err != nil {
+		logging.Verbosef("Skipping broken symlink %q: %v", path, err)
+		return nil
+	} else if !st.IsDir() {
+		// Planned as the file it points to, so that its size is right.
+		return planFile(path, fs.FileInfoToDirEntry(st))
+	} else if !p.opts.FollowSymlinks {
+		logging.Verbosef("Skipping symlinked directory %q", path)
+		return nil
+	} else if err := p.linkCycle(path); err != nil {
+		logging.Printf("Skipping symlinked directory %q: %v", path, err)
+		return nil
+	}
+	return fs.WalkDir(p.InRoot, path, visit)
+}
+
+// Returns errLinkCycle if path is a symlink to a directory containing it, or
+// one of the directories it's walked through to get there. Those directories
+// are compared by their absolute, resolved paths, since they may be symlinks
+// that were followed themselves.
+func (p *Exporter) linkCycle(path string) error {
+	resolve := func(path string) (string, error) {
+		name, err := filepath.EvalSymlinks(filepath.Join(p.opts.InRoot, filepath.FromSlash(path)))
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(name)
+	}
+	target, err := resolve(path)
+	if err != nil {
+		return err
+	}
+	for dir := pathpkg.Dir(path); ; dir = pathpkg.Dir(dir) {
+		visited, err := resolve(dir)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(target, visited); err == nil && filepath.IsLocal(rel) {
+			return fmt.Errorf("%q is within %q: %w", dir, target, errLinkCycle)
+		}
+		if dir == "." {
+			return nil
+		}
+	}
+}
+
+// Returns the target of path, relative to the input root, if it's a symlink
+// that -preserve-symlinks should recreate rather than export. Links that point
+// outside the input root are exported like anything else.
+func (p *Exporter) preservedLink(path string, d fs.DirEntry) (string, bool) {
+	if !p.opts.PreserveSymlinks || d.Type()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	target, err := p.linkTarget(path)
+	if err != nil {
+		logging.Warnf("Warning: exporting %q instead of linking it: %v\n", path, err)
+		return "", false
+	}
+	return target, true
+}
+
+// Returns the target of the symlink at path, relative to the input root.
+func (p *Exporter) linkTarget(path string) (string, error) {
+	link, err := p.InRoot.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	var target string
+	if filepath.IsAbs(link) {
+		root, err := filepath.Abs(p.opts.InRoot)
+		if err != nil {
+			return "", err
+		}
+		if target, err = filepath.Rel(root, link); err != nil {
+			return "", fmt.Errorf("%q: %w", link, errOutsideRoot)
+		}
+		target = filepath.ToSlash(target)
+	} else {
+		target = pathpkg.Join(pathpkg.Dir(path), filepath.ToSlash(link))
+	}
+	if target == "." || !fs.ValidPath(target) {
+		return "", fmt.Errorf("%q: %w", link, errOutsideRoot)
+	}
+	return target, nil
+}
+
+// Recreates the symlinks of plan. This is done after everything else is
+// exported, so that the targets exist when the links are created.
+func (p *Exporter) makeLinks(plan *Plan) error {
+	for _, step := range plan.Steps {
+		if step.Action != ActionLink {
+			continue
+		}
+		if err := p.ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.Link(step.RelPath, step.Target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Creates the output of the symlink path as a relative link to the output of
+// target. E.g., with -f m4a, "B/song.flac -> ../A/song.flac" becomes
+// "B/song.m4a -> ../A/song.m4a".
+func (p *Exporter) Link(path, target string) error {
+	opath := p.outputName(path)
+	otarget := p.outputName(target)
+	if st, err := p.InRoot.Stat(target); err == nil && st.IsDir() {
+		otarget = p.outPath(target)
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(pathpkg.Dir(opath)), filepath.FromSlash(otarget))
+	if err != nil {
+		return fmt.Errorf("linking %q: %w", path, err)
+	}
+
+	if existing, err := p.OutRoot.Readlink(opath); err == nil && existing == rel {
+		logging.Verbosef("Up to date %q", opath)
+		p.skip(path, ActionLink, opath)
+		return nil
+	} else if _, err := p.OutRoot.Lstat(opath); err == nil {
+		if p.opts.NoClobber {
+			logging.Verbosef("Not clobbering %q", opath)
+			p.skip(path, ActionLink, opath)
+			return nil
+		}
+		if err := p.OutRoot.Remove(opath); err != nil {
+			p.record(Result{Path: path, Action: ActionLink}, opath, err)
+			return fmt.Errorf("replacing %q with a link failed: %w", opath, err)
+		}
+	}
+	logging.Verbosef("Linking %q to %q", opath, rel)
+	err = p.ensureParent(path, opath)
+	if err == nil {
+		err = p.OutRoot.Symlink(rel, opath)
+	}
+	if err != nil {
+		err = fmt.Errorf("linking %q to %q failed: %w", opath, rel, err)
+	}
+	p.record(Result{Path: path, Action: ActionLink}, opath, err)
+	return err
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExporterSymlinks(t *testing.T) {
+	p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+		opts.PreserveSymlinks = true
+	})
+	outside := filepath.Join(t.TempDir(), "outside.flac")
+	writeFiles(t, p.opts.InRoot, "Master/song.flac", "Master/cover.jpg", "Compilation/other.flac")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"Compilation/song.flac": filepath.FromSlash("../Master/song.flac"),
+		"Compilation/Master":    filepath.FromSlash("../Master"),
+		"Compilation/abs.flac":  filepath.Join(p.opts.InRoot, "Master", "song.flac"),
+		"Compilation/ext.flac":  outside,
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(p.opts.InRoot, filepath.FromSlash(name))); err != nil {
+			t.Skipf("Can't create symlinks here: %v", err)
+		}
+	}
+	if err := p.Run(); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	expected := []string{
+		"Compilation",
+		"Compilation/Master",
+		"Compilation/abs.m4a",
+		"Compilation/ext.m4a",
+		"Compilation/other.m4a",
+		"Compilation/song.m4a",
+		"Master",
+		"Master/cover.jpg",
+		"Master/song.m4a",
+	}
+	if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+		t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+	}
+	for name, expected := range map[string]string{
+		"Compilation/song.m4a": "../Master/song.m4a",
+		"Compilation/abs.m4a":  "../Master/song.m4a",
+		"Compilation/Master":   "../Master",
+	} {
+		if actual, err := os.Readlink(filepath.Join(p.opts.OutRoot, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s should be a link: %v", name, err)
+		} else if actual != filepath.FromSlash(expected) {
+			t.Errorf("%s links to %q expected %q", name, actual, expected)
+		}
+	}
+	// Links outside of the input are exported as usual.
+	if st, err := os.Lstat(filepath.Join(p.opts.OutRoot, "Compilation", "ext.m4a")); err != nil || !st.Mode().IsRegular() {
+		t.Errorf("ext.m4a should be a regular file: %v err: %v", st, err)
+	}
+	if stats := p.Summary.Stats(); stats.Linked != 3 || stats.Converted != 3 {
+		t.Errorf("Bad stats: %+v", stats)
+	}
+
+	// Running again leaves the links alone.
+	p = newExporter(t.Context(), p.opts)
+	p.convert = fakeConvert
+	if err := p.Run(); err != nil {
+		t.Fatalf("Second run failed: %v", err)
+	}
+	if stats := p.Summary.Stats(); stats.Linked != 0 || stats.Skipped < 3 {
+		t.Errorf("Bad stats on the second run: %+v", stats)
+	}
+}
+
+func TestExporterFollowSymlinks(t *testing.T) {
+	for _, follow := range []bool{false, true} {
+		t.Run(fmt.Sprint("follow=", follow), func(t *testing.T) {
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.FollowSymlinks = follow
+			})
+			writeFiles(t, p.opts.InRoot, "Artist/Album/song.flac", "Various/other.flac")
+			links := map[string]string{
+				"Various/Album":     filepath.FromSlash("../Artist/Album"),
+				"Various/song.flac": filepath.FromSlash("../Artist/Album/song.flac"),
+				"Various/gone.flac": "missing.flac",
+				// Loops straight away, and again when reached through Various.
+				"Artist/Album/Artist": "..",
+			}
+			for name, target := range links {
+				if err := os.Symlink(target, filepath.Join(p.opts.InRoot, filepath.FromSlash(name))); err != nil {
+					t.Skipf("Can't create symlinks here: %v", err)
+				}
+			}
+			if err := p.Run(); err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+
+			expected := []string{
+				"Artist",
+				"Artist/Album",
+				"Artist/Album/song.m4a",
+				"Various",
+				"Various/other.m4a",
+				"Various/song.m4a",
+			}
+			if follow {
+				expected = slices.Insert(expected, 4, "Various/Album", "Various/Album/song.m4a")
+			}
+			if actual := listTree(t, p.opts.OutRoot); !slices.Equal(actual, expected) {
+				t.Errorf("Bad output tree:\nactual  : %q\nexpected: %q", actual, expected)
+			}
+			if st, err := os.Lstat(filepath.Join(p.opts.OutRoot, "Various", "song.m4a")); err != nil || !st.Mode().IsRegular() {
+				t.Errorf("song.m4a should be a regular file: %v err: %v", st, err)
+			}
+			if stats := p.Summary.Stats(); stats.Failed != 0 {
+				t.Errorf("Skipped links shouldn't fail: %+v", p.Summary.Results())
+			}
+		})
+	}
+}
+
+func TestLinkCycle(t *testing.T) {
+	p := newTestExporter(t, fakeConvert)
+	writeFiles(t, p.opts.InRoot, "A/song.flac", "B/song.flac")
+	// A/B and B/A lead to each other, so A/B/A is where it loops.
+	for name, target := range map[string]string{"A/B": "../B", "B/A": "../A", "A/self": "."} {
+		if err := os.Symlink(filepath.FromSlash(target), filepath.Join(p.opts.InRoot, filepath.FromSlash(name))); err != nil {
+			t.Skipf("Can't create symlinks here: %v", err)
+		}
+	}
+	for path, loops := range map[string]bool{"A/B": false, "B/A": false, "A/B/A": true, "A/self": true} {
+		if err := p.linkCycle(path); loops != errors.Is(err, errLinkCycle) {
+			t.Errorf("linkCycle(%q) = %v", path, err)
+		}
+	}
+}
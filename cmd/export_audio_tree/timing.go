@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Wall time a task spent in each phase of the pipeline. Summed over a run,
+// this shows whether an export is waiting on ffmpeg, the source, or the
+// destination.
+//
+// ffmpeg reads its own input, so reading the source of a conversion is counted
+// as encoding. Only copies measure reading separately.
+type Timing struct {
+	QueueWait time.Duration `json:"queue_wait"` // Between being queued and a worker starting it.
+	Read      time.Duration `json:"read"`       // Reading the source.
+	Encode    time.Duration `json:"encode"`     // Running ffmpeg.
+	Write     time.Duration `json:"write"`      // Creating directories, writing, and renaming the output.
+}
+
+// Returns the sum of t and o.
+func (t Timing) Add(o Timing) Timing {
+	return Timing{
+		QueueWait: t.QueueWait + o.QueueWait,
+		Read:      t.Read + o.Read,
+		Encode:    t.Encode + o.Encode,
+		Write:     t.Write + o.Write,
+	}
+}
+
+// Returns the time spent in all phases.
+func (t Timing) Total() time.Duration {
+	return t.QueueWait + t.Read + t.Encode + t.Write
+}
+
+// Returns the time spent working, leaving out the wait in the queue.
+func (t Timing) Busy() time.Duration {
+	return t.Read + t.Encode + t.Write
+}
+
+// Formats the share of each phase, largest first, e.g., "encode 71%, output
+// I/O 19%, queue wait 8%, source read 2%". Phases that took no time are left
+// out. Returns "" if nothing was timed.
+func (t Timing) Breakdown() string {
+	total := t.Total()
+	if total <= 0 {
+		return ""
+	}
+	type phase struct {
+		name string
+		d    time.Duration
+	}
+	phases := []phase{
+		{"encode", t.Encode},
+		{"output I/O", t.Write},
+		{"queue wait", t.QueueWait},
+		{"source read", t.Read},
+	}
+	slices.SortStableFunc(phases, func(a, b phase) int {
+		return cmp.Compare(b.d, a.d)
+	})
+	var parts []string
+	for _, phase := range phases {
+		if phase.d > 0 {
+			parts = append(parts, fmt.Sprintf("%s %.0f%%", phase.name, float64(phase.d)/float64(total)*100))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
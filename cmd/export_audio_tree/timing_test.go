@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingBreakdown(t *testing.T) {
+	for _, tc := range []struct {
+		timing   Timing
+		expected string
+	}{
+		{Timing{}, ""},
+		{Timing{Encode: 71, Write: 19, QueueWait: 8, Read: 2}, "encode 71%, output I/O 19%, queue wait 8%, source read 2%"},
+		{Timing{Read: 3, Write: 1}, "source read 75%, output I/O 25%"},
+		// Ties keep the usual order.
+		{Timing{QueueWait: 1, Encode: 1}, "encode 50%, queue wait 50%"},
+	} {
+		if actual := tc.timing.Breakdown(); actual != tc.expected {
+			t.Errorf("Bad breakdown of %+v:\nactual  : %q\nexpected: %q", tc.timing, actual, tc.expected)
+		}
+	}
+}
+
+func TestSummaryTiming(t *testing.T) {
+	var s Summary
+	for _, r := range []Result{
+		{Path: "1.flac", Action: ActionConvert, Status: StatusDone, Timing: Timing{QueueWait: time.Second, Encode: 30 * time.Second, Write: 5 * time.Second}},
+		{Path: "2.flac", Action: ActionConvert, Status: StatusFailed, Timing: Timing{QueueWait: 3 * time.Second, Encode: 40 * time.Second}},
+		{Path: "cover.jpg", Action: ActionCopy, Status: StatusDone, Timing: Timing{QueueWait: time.Second, Read: 2 * time.Second, Write: 14 * time.Second}},
+		{Path: "3.flac", Action: ActionConvert, Status: StatusSkipped, Timing: Timing{QueueWait: 3 * time.Second}},
+		{Path: "3.m4a", Action: ActionVerify, Status: StatusDone, Timing: Timing{Encode: time.Second}},
+	} {
+		s.Add(r)
+	}
+	expected := Timing{QueueWait: 8 * time.Second, Read: 2 * time.Second, Encode: 71 * time.Second, Write: 19 * time.Second}
+	if actual := s.Stats().Timing; actual != expected {
+		t.Errorf("Bad totals:\nactual  : %+v\nexpected: %+v", actual, expected)
+	}
+	if str := s.String(); !strings.Contains(str, "Time: encode 71%, output I/O 19%, queue wait 8%, source read 2%") {
+		t.Errorf("Summary is missing the time breakdown:\n%s", str)
+	}
+}
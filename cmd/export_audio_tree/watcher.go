@@ -0,0 +1,454 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Reports the paths under the input root that may have been added or changed,
+// for -watch. Paths are relative to the input root and may include
+// directories, or files that have since been removed.
+type Watcher interface {
+	// Receives a value whenever Changed has paths to return.
+	Ready() <-chan struct{}
+	// Returns and forgets the paths that changed since the last call.
+	Changed() []string
+	Close() error
+}
+
+// The paths a Watcher has yet to return. Adding never blocks, so the watcher
+// doesn't fall behind the file system while the exporter is busy.
+type changeSet struct {
+	mutex sync.Mutex
+	paths map[string]bool
+	ready chan struct{}
+}
+
+func newChangeSet() *changeSet {
+	return &changeSet{paths: make(map[string]bool), ready: make(chan struct{}, 1)}
+}
+
+func (c *changeSet) add(path string) {
+	c.mutex.Lock()
+	c.paths[path] = true
+	c.mutex.Unlock()
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *changeSet) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Returns the paths in sorted order, so that albums are exported in order.
+func (c *changeSet) Changed() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	paths := make([]string, 0, len(c.paths))
+	for path := range c.paths {
+		paths = append(paths, path)
+	}
+	clear(c.paths)
+	slices.Sort(paths)
+	return paths
+}
+
+// Watches the roots of fsys with fsnotify. Since inotify isn't recursive, each
+// directory is watched on its own, including those created later.
+type notifyWatcher struct {
+	*changeSet
+	fsys    fs.FS
+	root    string // Where fsys is on disk, for fsnotify.
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// Starts watching roots, relative to fsys at root on disk.
+func newNotifyWatcher(fsys fs.FS, root string, roots []string) (*notifyWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &notifyWatcher{
+		changeSet: newChangeSet(),
+		fsys:      fsys,
+		root:      root,
+		watcher:   watcher,
+		done:      make(chan struct{}),
+	}
+	for _, dir := range roots {
+		if err := w.addTree(dir, false); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+	return w, nil
+}
+
+// Watches dir and every directory below it. If report is set, everything found
+// is reported as changed, since it appeared before the watch was in place.
+func (w *notifyWatcher) addTree(dir string, report bool) error {
+	return fs.WalkDir(w.fsys, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() && filesystem.IsTrashDir(path) {
+			return fs.SkipDir
+		}
+		if report {
+			w.add(path)
+		}
+		if d.IsDir() {
+			return w.watcher.Add(filepath.Join(w.root, filepath.FromSlash(path)))
+		}
+		return nil
+	})
+}
+
+func (w *notifyWatcher) run() {
+	defer close(w.done)
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			logging.Printf("Watching %s: %v", w.root, err)
+		}
+	}
+}
+
+func (w *notifyWatcher) handle(event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		// Removals don't need exporting, and renames are also a create.
+		return
+	}
+	rel, err := filepath.Rel(w.root, event.Name)
+	if err != nil || !filepath.IsLocal(rel) {
+		return
+	}
+	path := filepath.ToSlash(rel)
+	if st, err := fs.Stat(w.fsys, path); err == nil && st.IsDir() && event.Has(fsnotify.Create) {
+		// A new album folder. It may already have files in it, e.g., if it was
+		// moved here, and those won't have events of their own.
+		if err := w.addTree(path, true); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logging.Printf("Watching %q: %v", path, err)
+		}
+		return
+	}
+	w.add(path)
+}
+
+func (w *notifyWatcher) Close() error {
+	err := w.watcher.Close()
+	<-w.done
+	return err
+}
+
+// Rescans the roots of fsys every interval, reporting the files whose size or
+// modification time changed. For file systems without change notifications,
+// like most network shares.
+type scanWatcher struct {
+	*changeSet
+	fsys   fs.FS
+	roots  []string
+	seen   map[string]fileState
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// The size and modification time of a file, to tell if it changed.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Scans roots now, so that only later changes are reported, and every
+// interval after.
+func newScanWatcher(fsys fs.FS, roots []string, interval time.Duration) *scanWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &scanWatcher{
+		changeSet: newChangeSet(),
+		fsys:      fsys,
+		roots:     roots,
+		seen:      make(map[string]fileState),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	w.scan(false)
+	go w.run(ctx, interval)
+	return w
+}
+
+func (w *scanWatcher) run(ctx context.Context, interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.scan(true)
+		}
+	}
+}
+
+// Walks the roots, reporting what changed since the last scan if report is
+// set. Files that can't be read now are tried again next time.
+func (w *scanWatcher) scan(report bool) {
+	for _, root := range w.roots {
+		fs.WalkDir(w.fsys, root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() && filesystem.IsTrashDir(path) {
+				return fs.SkipDir
+			} else if err != nil || d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			if old, ok := w.seen[path]; !ok || old != state {
+				w.seen[path] = state
+				if report {
+					w.add(path)
+				}
+			}
+			return nil
+		})
+	}
+}
+
+func (w *scanWatcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+// Starts the Watcher for -watch: a scanWatcher if -watch-poll is set, or else
+// a notifyWatcher. Falls back to scanning every minute if change
+// notifications aren't available, e.g., because the inotify limit was reached.
+func (p *Exporter) newWatcher() Watcher {
+	if p.opts.WatchPoll > 0 {
+		return newScanWatcher(p.InRoot, p.roots(), p.opts.WatchPoll)
+	}
+	w, err := newNotifyWatcher(p.InRoot, p.opts.InRoot, p.roots())
+	if err == nil {
+		return w
+	}
+	logging.Warnf("Warning: watching %s failed, rescanning it every %v instead: %v\n", p.opts.InRoot, defaultWatchPoll, err)
+	return newScanWatcher(p.InRoot, p.roots(), defaultWatchPoll)
+}
+
+// How often to rescan for -watch when change notifications aren't available.
+const defaultWatchPoll = time.Minute
+
+// Tracks the paths reported by a Watcher until they've stopped changing for
+// the -watch-settle delay, so that files still being written, like half
+// ripped tracks, aren't exported.
+type settler struct {
+	fsys    fs.FS
+	delay   time.Duration
+	pending map[string]settling
+}
+
+// A path waiting to settle.
+type settling struct {
+	state fileState
+	since time.Time // When state was last seen to change.
+}
+
+func newSettler(fsys fs.FS, delay time.Duration) *settler {
+	return &settler{fsys: fsys, delay: delay, pending: make(map[string]settling)}
+}
+
+// Notes that path changed at now.
+func (s *settler) touch(path string, now time.Time) {
+	s.pending[path] = settling{since: now}
+}
+
+// Returns the number of paths waiting to settle.
+func (s *settler) len() int {
+	return len(s.pending)
+}
+
+// Returns the pending paths that have settled by now, in sorted order, along
+// with their info. Paths that no longer exist are forgotten.
+func (s *settler) settled(now time.Time) ([]string, map[string]fs.FileInfo) {
+	var paths []string
+	infos := make(map[string]fs.FileInfo)
+	for path, pending := range s.pending {
+		info, err := fs.Stat(s.fsys, path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				logging.Printf("Watching %q: %v", path, err)
+			}
+			delete(s.pending, path)
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if info.IsDir() {
+			// Only the files inside need to settle.
+			state = fileState{}
+		}
+		if state != pending.state {
+			s.pending[path] = settling{state: state, since: now}
+		} else if now.Sub(pending.since) >= s.delay {
+			paths = append(paths, path)
+			infos[path] = info
+			delete(s.pending, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths, infos
+}
+
+// Exports the files that appear or change under the input root once they've
+// settled, until the context is done. Each is planned and queued on its own,
+// as the initial export would have. Playlists are the ones already gathered
+// for -collect-playlists, which are gathered again along with any new ones.
+func (p *Exporter) watch(w Watcher, playlists []string) {
+	logging.Printf("Watching %s for changes", p.opts.InRoot)
+	if p.opts.WatchSpill != "" {
+		spill, err := newSpillQueue(p.opts.WatchSpill)
+		if err != nil {
+			logging.Warnf("Warning: %v, waiting for room in the queue instead\n", err)
+		} else {
+			feeding := make(chan struct{})
+			go func() {
+				defer close(feeding)
+				p.feedSpill(spill)
+			}()
+			p.spill.Store(spill)
+			defer func() {
+				// The feeder stops with the context, which is what ends watch.
+				<-feeding
+				p.spill.Store(nil)
+				if err := spill.Close(); err != nil {
+					logging.Printf("Failed removing -watch-spill file: %v", err)
+				}
+			}()
+		}
+	}
+	pending := newSettler(p.InRoot, p.opts.WatchSettle)
+	ticker := time.NewTicker(min(max(p.opts.WatchSettle/2, 10*time.Millisecond), time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-w.Ready():
+			now := time.Now()
+			for _, path := range w.Changed() {
+				pending.touch(path, now)
+			}
+		case now := <-ticker.C:
+			paths, infos := pending.settled(now)
+			for _, path := range paths {
+				if p.ctx.Err() != nil {
+					return
+				}
+				plan := p.watchFile(path, infos[path])
+				if plan != nil && len(plan.Playlists) > 0 {
+					for _, playlist := range plan.Playlists {
+						if !slices.Contains(playlists, playlist) {
+							playlists = append(playlists, playlist)
+						}
+					}
+					if err := p.collectPlaylists(playlists); err != nil {
+						logging.Println(err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Exports the file or directory at path, with the given info, for watch.
+// Brand new directories are created along with everything above them, since
+// the initial export never saw them. Returns the plan for path, or nil if
+// there wasn't one.
+func (p *Exporter) watchFile(path string, info fs.FileInfo) *Plan {
+	if p.ownFile(path) {
+		// Exporting them would change them again, forever.
+		return nil
+	} else if info.IsDir() {
+		if !p.excluded(path, true) && p.layout == nil {
+			if err := p.ensureDirs(path); err != nil {
+				logging.Printf("Creating %q failed: %v", p.outPath(path), err)
+			}
+		}
+		return nil
+	}
+	logging.Verbosef("Changed %q", path)
+	plan := &Plan{}
+	err := p.planFile(plan, path, fs.FileInfoToDirEntry(info))
+	if err == nil && len(plan.Steps) > 0 && p.layout == nil {
+		err = p.ensureDirs(pathpkg.Dir(path))
+	}
+	if err == nil {
+		err = p.checkSpace(plan.Needed)
+	}
+	if err != nil {
+		err = fmt.Errorf("exporting %q failed: %w", path, err)
+		logging.Println(err)
+		plan.Steps = nil
+		plan.Failed = append(plan.Failed, Result{Path: path, Action: ActionCopy, Status: StatusFailed, Err: err})
+	}
+	if err := p.execute(plan); err != nil {
+		return plan
+	}
+	if err := p.makeLinks(plan); err != nil && p.ctx.Err() == nil {
+		logging.Println(err)
+	}
+	return plan
+}
+
+// Ensures dir and the directories above it exist in the output root. They're
+// created from the top down, so that each gets the permissions of its source.
+func (p *Exporter) ensureDirs(dir string) error {
+	if dir == "." {
+		return nil
+	}
+	if err := p.ensureDirs(pathpkg.Dir(dir)); err != nil {
+		return err
+	}
+	return p.ensureDir(dir)
+}
+
+// Returns true if path is a file the exporter writes to itself, such as the
+// -state journal or the -log-file, should they be within the input root.
+func (p *Exporter) ownFile(path string) bool {
+	name, err := filepath.Abs(filepath.Join(p.opts.InRoot, filepath.FromSlash(path)))
+	if err != nil {
+		return false
+	}
+	for _, own := range []string{p.opts.StateFile, p.opts.LogFile} {
+		if own == "" {
+			continue
+		} else if abs, err := filepath.Abs(own); err == nil && abs == name {
+			return true
+		}
+	}
+	return false
+}
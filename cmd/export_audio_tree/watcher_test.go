@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSettler(t *testing.T) {
+	start := time.Now()
+	fsys := fstest.MapFS{
+		"Album/01 Song.flac": {Data: []byte("half"), ModTime: start},
+		"Album/02 Song.flac": {Data: []byte("whole"), ModTime: start},
+	}
+	s := newSettler(fsys, time.Minute)
+	s.touch("Album/01 Song.flac", start)
+	s.touch("Album/02 Song.flac", start)
+	s.touch("Album/gone.jpg", start)
+
+	if paths, _ := s.settled(start); len(paths) != 0 {
+		t.Fatalf("Nothing should settle before it's been seen: %q", paths)
+	} else if s.len() != 2 {
+		t.Fatalf("Removed files should be forgotten, have %d pending", s.len())
+	}
+
+	// Still being written.
+	fsys["Album/01 Song.flac"] = &fstest.MapFile{Data: []byte("halfway"), ModTime: start.Add(30 * time.Second)}
+	paths, infos := s.settled(start.Add(time.Minute))
+	if !slices.Equal(paths, []string{"Album/02 Song.flac"}) {
+		t.Fatalf("Only the unchanged file should settle: %q", paths)
+	} else if infos[paths[0]].Size() != 5 {
+		t.Errorf("Bad info: %v", infos[paths[0]])
+	}
+	if paths, _ := s.settled(start.Add(90 * time.Second)); len(paths) != 0 {
+		t.Fatalf("Should wait a minute since the last change: %q", paths)
+	}
+	if paths, _ := s.settled(start.Add(2 * time.Minute)); !slices.Equal(paths, []string{"Album/01 Song.flac"}) {
+		t.Fatalf("Should settle after a minute unchanged: %q", paths)
+	}
+	if s.len() != 0 {
+		t.Errorf("Settled paths should be forgotten, have %d pending", s.len())
+	}
+}
+
+func TestScanWatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "Old/01 Song.flac")
+	w := newScanWatcher(os.DirFS(root), []string{"."}, 10*time.Millisecond)
+	defer w.Close()
+	writeFiles(t, root, "New/01 Song.flac")
+	select {
+	case <-w.Ready():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for changes")
+	}
+	if changed := w.Changed(); !slices.Equal(changed, []string{"New/01 Song.flac"}) {
+		t.Errorf("Only the new file should be reported: %q", changed)
+	}
+}
+
+func TestExporterWatch(t *testing.T) {
+	for _, poll := range []time.Duration{0, 10 * time.Millisecond} {
+		t.Run("poll="+poll.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			defer cancel()
+			p := newTestExporter(t, fakeConvert, func(opts *options.ExporterOptions) {
+				opts.Watch = true
+				opts.WatchSettle = 50 * time.Millisecond
+				opts.WatchPoll = poll
+				opts.StatusInterval = 0
+			})
+			p.ctx = ctx
+			writeFiles(t, p.opts.InRoot, "Old/01 Song.flac")
+			done := make(chan error, 1)
+			go func() {
+				done <- p.Run()
+			}()
+
+			waitFor := func(name string) {
+				t.Helper()
+				for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+					if _, err := os.Stat(filepath.Join(p.opts.OutRoot, name)); err == nil {
+						return
+					}
+				}
+				t.Fatalf("Timed out waiting for %q", name)
+			}
+			waitFor("Old/01 Song.m4a")
+
+			// A brand new album, ripped after the initial export.
+			if err := os.MkdirAll(filepath.Join(p.opts.InRoot, "New/Disc 1"), 0750); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, p.opts.InRoot, "New/Disc 1/01 Song.flac", "New/Disc 1/cover.jpg")
+			waitFor("New/Disc 1/01 Song.m4a")
+			waitFor("New/Disc 1/cover.jpg")
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatalf("Interrupting while idle should stop cleanly: %v", err)
+			}
+
+			want := []string{
+				"New", "New/Disc 1", "New/Disc 1/01 Song.m4a", "New/Disc 1/cover.jpg",
+				"Old", "Old/01 Song.m4a",
+			}
+			if got := listTree(t, p.opts.OutRoot); !slices.Equal(got, want) {
+				t.Errorf("Bad output:\n got: %q\nwant: %q", got, want)
+			}
+			if st, err := os.Stat(filepath.Join(p.opts.OutRoot, "New/Disc 1")); err != nil {
+				t.Error(err)
+			} else if st.Mode().Perm() != 0750 {
+				t.Errorf("New directories should keep their permissions: %v", st.Mode())
+			}
+			if n := p.Summary.Count(StatusDone); n != 3 {
+				t.Errorf("Expected 3 done: %+v", p.Summary.Results())
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Extracts the cover art of a whole tree, for -R. Each album, meaning a
+// directory with media files in it, or each track with -per-track, is a task
+// on the work pool. A file without art or that ffmpeg can't read doesn't stop
+// the rest.
+type batch struct {
+	ctx     context.Context
+	opts    *options.ExtracterOptions
+	in      filesystem.FS
+	out     filesystem.FS
+	pool    *workpool.WorkPool
+	extract func(context.Context, *options.ExtracterOptions) ([]byte, error)
+	written atomic.Int64 // Covers written.
+	skipped atomic.Int64 // Covers that already existed.
+	missing atomic.Int64 // Albums or tracks without art.
+}
+
+func newBatch(ctx context.Context, opts *options.ExtracterOptions) *batch {
+	return &batch{
+		ctx:     ctx,
+		opts:    opts,
+		in:      filesystem.NewFileSystem(opts.InputFile),
+		out:     filesystem.NewFileSystem(opts.OutputFile),
+		pool:    workpool.NewWorkPool(ctx, opts.MaxJobs, 0),
+		extract: ffmpeg.ExtractCoverArtInBackground,
+	}
+}
+
+// Walks the input tree and extracts the art of everything in it. Returns the
+// errors that stopped a cover from being written, other than there being no
+// art, joined together.
+func (b *batch) Run() error {
+	var dirs []string
+	tracks := make(map[string][]string)
+	err := fs.WalkDir(b.in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			logging.Printf("Skipping %q: %v", path, err)
+			return nil
+		} else if d.IsDir() || !ffmpeg.IsMediaFile(path) {
+			return nil
+		}
+		dir := pathpkg.Dir(path)
+		if _, ok := tracks[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		tracks[dir] = append(tracks[dir], path)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.pool.Start()
+	defer b.pool.Stop()
+	for _, dir := range dirs {
+		if b.opts.PerTrack {
+			for _, track := range tracks[dir] {
+				name := strings.TrimSuffix(track, pathpkg.Ext(track)) + filepath.Ext(b.opts.CoverName)
+				if err := b.pool.AddNamedContext(b.ctx, track, func() error { return b.cover(name, track) }); err != nil {
+					return err
+				}
+			}
+		} else {
+			name := pathpkg.Join(dir, b.opts.CoverName)
+			if err := b.pool.AddNamedContext(b.ctx, dir, func() error { return b.cover(name, tracks[dir]...) }); err != nil {
+				return err
+			}
+		}
+	}
+	err = b.pool.Wait()
+	logging.Printf("Wrote %d covers, skipped %d that already existed, and found no art for %d",
+		b.written.Load(), b.skipped.Load(), b.missing.Load())
+	return err
+}
+
+// Writes name in the output from the art of the first of tracks that has any.
+// Unless -y is given, a cover that already exists is left alone.
+func (b *batch) cover(name string, tracks ...string) error {
+	if _, err := b.out.Stat(name); err == nil && !b.opts.Overwrite {
+		logging.Verbosef("Already have %q", name)
+		b.skipped.Add(1)
+		return nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := b.out.MkDirAll(pathpkg.Dir(name), 0755); err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		if ok, err := b.extractTo(track, name); err != nil {
+			return err
+		} else if ok {
+			logging.Printf("Wrote %q from %q", name, track)
+			b.written.Add(1)
+			return nil
+		}
+	}
+	logging.Printf("No cover art for %q in %q", name, pathpkg.Dir(tracks[0]))
+	b.missing.Add(1)
+	return nil
+}
+
+// Extracts the art of track into name, returning false if there isn't any.
+// ffmpeg writes to a temporary file, so that a track without art doesn't
+// replace a cover that's already there.
+func (b *batch) extractTo(track, name string) (bool, error) {
+	af := filesystem.NewAtomicFile(b.out, name)
+	opts := *b.opts
+	opts.InputFile = filepath.Join(b.opts.InputFile, track)
+	opts.OutputFile = filepath.Join(b.opts.OutputFile, af.Temp())
+	opts.NoClobber = false
+	opts.Overwrite = true
+	output, err := b.extract(b.ctx, &opts)
+	if err == nil {
+		if err = af.Commit(); err != nil {
+			return false, fmt.Errorf("renaming %q into place failed: %w", af.Temp(), err)
+		}
+		return true, nil
+	}
+	if aerr := af.Abort(); aerr != nil {
+		logging.Printf("Failed removing partial output %q: %v", af.Temp(), aerr)
+	}
+	if b.ctx.Err() != nil {
+		return false, context.Cause(b.ctx)
+	}
+	// ffmpeg fails the same way for a track without art as for one it can't
+	// read, so neither is an error.
+	logging.Verbosef("No cover art in %q: %v\n%s", track, err, output)
+	return false, nil
+}
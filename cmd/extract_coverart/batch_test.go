@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"context"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates the named files under root, each containing its own name.
+func writeFiles(t *testing.T, root string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the files under root, relative to it, with their contents.
+func readTree(t *testing.T, root string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Extracts "art" from tracks with "art" in their name, and fails like ffmpeg
+// on the rest after leaving a partial output behind.
+func fakeExtract(_ context.Context, opts *options.ExtracterOptions) ([]byte, error) {
+	if !strings.Contains(filepath.Base(opts.InputFile), "art") {
+		os.WriteFile(opts.OutputFile, nil, 0644)
+		return []byte("Output file does not contain any stream"), errors.New("exit status 1")
+	}
+	return nil, os.WriteFile(opts.OutputFile, []byte("art of "+filepath.Base(opts.InputFile)), 0644)
+}
+
+func TestBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		perTrack  bool
+		overwrite bool
+		expected  map[string]string
+	}{
+		{
+			name: "per album",
+			expected: map[string]string{
+				"A/cover.jpg": "art of 02 Song art.flac",
+				"B/cover.jpg": "old",
+			},
+		},
+		{
+			name:      "overwrite",
+			overwrite: true,
+			expected: map[string]string{
+				"A/cover.jpg": "art of 02 Song art.flac",
+				"B/cover.jpg": "art of 01 art.flac",
+			},
+		},
+		{
+			name:     "per track",
+			perTrack: true,
+			expected: map[string]string{
+				"A/02 Song art.jpg": "art of 02 Song art.flac",
+				"B/01 art.jpg":      "art of 01 art.flac",
+				"B/cover.jpg":       "old",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, out := t.TempDir(), filepath.Join(t.TempDir(), "covers")
+			writeFiles(t, in, "A/01 Song.flac", "A/02 Song art.flac", "B/01 art.flac", "C/01 Song.flac", "D/notes.txt")
+			if err := os.MkdirAll(filepath.Join(out, "B"), 0755); err != nil {
+				t.Fatal(err)
+			} else if err := os.WriteFile(filepath.Join(out, "B/cover.jpg"), []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			opts := &options.ExtracterOptions{InputFile: in, OutputFile: out, CoverName: "cover.jpg", PerTrack: tc.perTrack}
+			opts.Overwrite = tc.overwrite
+			b := newBatch(t.Context(), opts)
+			b.extract = fakeExtract
+			if err := b.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := readTree(t, out); !maps.Equal(got, tc.expected) {
+				t.Errorf("Bad covers:\n got: %q\nwant: %q", got, tc.expected)
+			}
+			written := int64(len(tc.expected))
+			if !tc.overwrite {
+				written--
+			}
+			if b.written.Load() != written {
+				t.Errorf("Expected %d written, have %d", written, b.written.Load())
+			}
+		})
+	}
+}
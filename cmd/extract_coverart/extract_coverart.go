@@ -14,15 +14,25 @@ import (
 func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
-	opts := options.NewExtracterOptions(os.Args)
-	if opts == nil {
-		// Arg parsing error. Usage, etc is handled by the constructor.
-		os.Exit(1)
+	opts, outcome := options.NewExtracterOptions(os.Args)
+	if outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
 	}
-	if err := logging.Initialize(ctx, "-", opts.Verbose); err != nil {
+	if opts.OutputFile == "-" {
+		logging.ReserveStdout()
+	}
+	if err := logging.Initialize(ctx, "-", opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := ffmpeg.CheckFFmpeg(ctx, &opts.GlobalOptions); err != nil {
 		logging.Fatalln(err)
 	}
-	if err := ffmpeg.ExtractCoverArt(ctx, opts); err != nil {
+	if opts.Recursive {
+		if err := newBatch(ctx, opts).Run(); err != nil {
+			logging.Fatalln(err)
+		}
+	} else if err := ffmpeg.ExtractCoverArt(ctx, opts); err != nil {
 		logging.Fatalln(err)
 	}
 }
@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Written into the output directory by export_audio_tree -update and
+// -checksum, so they're not extra files.
+const (
+	fingerprintsFile = ".export_audio_tree.json"
+	checksumsFile    = ".export_audio_tree.sha256.json"
+)
+
+// An output that's missing or bad, and the source it was exported from.
+// Paths are relative to their roots.
+type Discrepancy struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"` // Why it couldn't be decoded.
+}
+
+// What verifying an export found.
+type Report struct {
+	Checked     int           `json:"checked"` // Source media files.
+	Missing     []Discrepancy `json:"missing"`
+	Empty       []Discrepancy `json:"empty"`
+	Undecodable []Discrepancy `json:"undecodable"`
+	Extra       []string      `json:"extra"` // Outputs without a source.
+}
+
+// Returns the number of problems found.
+func (r *Report) Discrepancies() int {
+	return len(r.Missing) + len(r.Empty) + len(r.Undecodable) + len(r.Extra)
+}
+
+// Writes a human readable summary, listing each problem.
+func (r *Report) WriteText(w io.Writer) error {
+	var err error
+	printf := func(format string, args ...any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	printf("Checked: %d\n", r.Checked)
+	for _, section := range []struct {
+		name    string
+		entries []Discrepancy
+	}{
+		{"Missing", r.Missing},
+		{"Empty", r.Empty},
+		{"Undecodable", r.Undecodable},
+	} {
+		printf("%s: %d\n", section.name, len(section.entries))
+		for _, e := range section.entries {
+			if e.Error != "" {
+				printf("  %s -> %s: %s\n", e.Source, e.Output, e.Error)
+			} else {
+				printf("  %s -> %s\n", e.Source, e.Output)
+			}
+		}
+	}
+	printf("Extra: %d\n", len(r.Extra))
+	for _, name := range r.Extra {
+		printf("  %s\n", name)
+	}
+	return err
+}
+
+// Writes the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Compares an input tree to the output export_audio_tree made from it.
+type verifier struct {
+	ctx     context.Context
+	opts    *options.VerifierOptions
+	in      filesystem.FS
+	out     filesystem.FS
+	cleaner *filesystem.Cleaner
+	limiter *filesystem.PathLimiter
+	decode  func(ctx context.Context, name string) ([]byte, error)
+}
+
+func newVerifier(ctx context.Context, opts *options.VerifierOptions) *verifier {
+	v := &verifier{
+		ctx:  ctx,
+		opts: opts,
+		in:   filesystem.NewFileSystem(opts.InRoot),
+		out:  filesystem.NewFileSystem(opts.OutRoot),
+		decode: func(ctx context.Context, name string) ([]byte, error) {
+			return ffmpeg.VerifyDecode(ctx, opts.FFmpeg, name)
+		},
+	}
+	if opts.CleanPaths != "" || opts.NormalizeNames != filesystem.NormalizeNone {
+		v.cleaner = filesystem.NewCleaner(opts.CleanPaths, filesystem.ReservedCharacters)
+		v.cleaner.DeviceNames = opts.CleanPathsStrict
+		v.cleaner.TrailingDots = opts.CleanPathsStrict
+		v.cleaner.Normalize = opts.NormalizeNames
+	}
+	if opts.MaxNameBytes > 0 || opts.MaxPathBytes > 0 {
+		v.limiter = filesystem.NewPathLimiter(opts.MaxNameBytes, opts.MaxPathBytes)
+	}
+	return v
+}
+
+// Walks both trees, checking that each source media file has a non-empty
+// output, and with -decode, that it decodes. The decoding is done on the
+// work pool. Returns an error if either tree couldn't be read, since the
+// report would be wrong.
+func (v *verifier) Run() (*Report, error) {
+	report := &Report{Missing: []Discrepancy{}, Empty: []Discrepancy{}, Undecodable: []Discrepancy{}, Extra: []string{}}
+	expected := map[string]bool{fingerprintsFile: true, checksumsFile: true}
+	var decode []Discrepancy
+	err := fs.WalkDir(v.in, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() && filesystem.IsTrashDir(path) {
+			return fs.SkipDir
+		} else if d.IsDir() || filesystem.IsTrashFile(path) {
+			return nil
+		}
+		if !ffmpeg.IsMediaFile(path) {
+			// Copied, unless exported with -N, so it's not checked.
+			expected[v.outPath(path)] = true
+			return nil
+		} else if ffmpeg.IsLossy(path) && v.opts.LossyPolicy == options.LossySkip {
+			return nil
+		}
+		entry := Discrepancy{Source: path, Output: v.mappedName(path)}
+		expected[entry.Output] = true
+		report.Checked++
+		if st, err := v.out.Stat(entry.Output); errors.Is(err, fs.ErrNotExist) {
+			report.Missing = append(report.Missing, entry)
+		} else if err != nil {
+			return err
+		} else if st.Size() == 0 {
+			report.Empty = append(report.Empty, entry)
+		} else if v.opts.Decode {
+			decode = append(decode, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the input directory: %w", err)
+	}
+
+	err = fs.WalkDir(v.out, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() && filesystem.IsTrashDir(path) {
+			return fs.SkipDir
+		} else if !d.IsDir() && !expected[path] && !filesystem.IsTrashFile(path) {
+			report.Extra = append(report.Extra, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the output directory: %w", err)
+	}
+
+	if err := v.decodeAll(decode, report); err != nil {
+		return nil, err
+	}
+	for _, entries := range [][]Discrepancy{report.Missing, report.Empty, report.Undecodable} {
+		slices.SortFunc(entries, func(a, b Discrepancy) int {
+			return strings.Compare(a.Output, b.Output)
+		})
+	}
+	slices.Sort(report.Extra)
+	return report, nil
+}
+
+// Decodes each of entries' outputs on the work pool, adding those that fail
+// to report.Undecodable.
+func (v *verifier) decodeAll(entries []Discrepancy, report *Report) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	pool := workpool.NewWorkPool(v.ctx, v.opts.MaxJobs, 0)
+	pool.Start()
+	defer pool.Stop()
+	var mutex sync.Mutex
+	for _, entry := range entries {
+		name := filepath.Join(v.opts.OutRoot, filepath.FromSlash(entry.Output))
+		err := pool.AddNamedContext(v.ctx, entry.Output, func() error {
+			if _, err := v.decode(v.ctx, name); err != nil {
+				if v.ctx.Err() != nil {
+					return context.Cause(v.ctx)
+				}
+				entry.Error = err.Error()
+				mutex.Lock()
+				report.Undecodable = append(report.Undecodable, entry)
+				mutex.Unlock()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return pool.Wait()
+}
+
+// Maps path in the input root to its name in the output root, the same way
+// export_audio_tree does. Media files take on the extension of -f, unless
+// lossy ones were copied.
+func (v *verifier) mappedName(path string) string {
+	if ffmpeg.IsLossy(path) && v.opts.LossyPolicy == options.LossyCopy {
+		return v.outPath(path)
+	}
+	ext := pathpkg.Ext(path)
+	return v.limitPath(v.cleanPath(path[:len(path)-len(ext)]) + "." + v.opts.Format)
+}
+
+// Applies -cleanpaths, -max-name-bytes, and -max-path-bytes to path.
+func (v *verifier) outPath(path string) string {
+	return v.limitPath(v.cleanPath(path))
+}
+
+// Applies -cleanpaths to path.
+func (v *verifier) cleanPath(path string) string {
+	if v.cleaner == nil {
+		return path
+	}
+	return filepath.ToSlash(v.cleaner.CleanPath(path))
+}
+
+// Applies -max-name-bytes and -max-path-bytes to path, after cleaning it.
+func (v *verifier) limitPath(path string) string {
+	if v.limiter == nil {
+		return path
+	}
+	return v.limiter.LimitPath(path)
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/options"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Creates each of names under root, empty if the name has "empty" in it.
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := []byte("data")
+		if strings.Contains(name, "empty") {
+			data = nil
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns a verifier comparing two new trees, which decodes anything without
+// "bad" in its name.
+func newTestVerifier(t *testing.T, configure func(*options.VerifierOptions)) *verifier {
+	opts := &options.VerifierOptions{InRoot: t.TempDir(), OutRoot: t.TempDir(), Format: "m4a", LossyPolicy: options.LossyConvert}
+	if configure != nil {
+		configure(opts)
+	}
+	v := newVerifier(t.Context(), opts)
+	v.decode = func(_ context.Context, name string) ([]byte, error) {
+		if strings.Contains(filepath.Base(name), "bad") {
+			return []byte("Invalid data found when processing input"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	return v
+}
+
+func outputs(entries []Discrepancy) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Output)
+	}
+	return names
+}
+
+func TestVerifier(t *testing.T) {
+	v := newTestVerifier(t, func(opts *options.VerifierOptions) {
+		opts.Decode = true
+	})
+	writeFiles(t, v.opts.InRoot,
+		"A/01 Song.flac", "A/02 Song.flac", "A/03 empty.flac", "A/04 bad.flac", "A/cover.jpg", "A/.DS_Store",
+		"B/01 Song.mp3", "B/notes.txt")
+	writeFiles(t, v.opts.OutRoot,
+		"A/01 Song.m4a", "A/03 empty.m4a", "A/04 bad.m4a", "A/cover.jpg", "A/._cover.jpg",
+		"B/01 Song.m4a", "B/old.m4a", fingerprintsFile, checksumsFile)
+	report, err := v.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 5 {
+		t.Errorf("Expected 5 media files checked, have %d", report.Checked)
+	}
+	if got := outputs(report.Missing); !slices.Equal(got, []string{"A/02 Song.m4a"}) {
+		t.Errorf("Bad missing: %q", got)
+	}
+	if got := outputs(report.Empty); !slices.Equal(got, []string{"A/03 empty.m4a"}) {
+		t.Errorf("Bad empty: %q", got)
+	}
+	if got := outputs(report.Undecodable); !slices.Equal(got, []string{"A/04 bad.m4a"}) {
+		t.Errorf("Bad undecodable: %q", got)
+	} else if report.Undecodable[0].Source != "A/04 bad.flac" || report.Undecodable[0].Error == "" {
+		t.Errorf("Bad undecodable entry: %+v", report.Undecodable[0])
+	}
+	if !slices.Equal(report.Extra, []string{"B/old.m4a"}) {
+		t.Errorf("Bad extra: %q", report.Extra)
+	}
+	if n := report.Discrepancies(); n != 4 {
+		t.Errorf("Expected 4 discrepancies, have %d", n)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(text.String(), "Missing: 1\n  A/02 Song.flac -> A/02 Song.m4a\n") {
+		t.Errorf("Bad summary:\n%s", text.String())
+	}
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded.Checked != report.Checked || len(decoded.Extra) != 1 {
+		t.Errorf("Bad JSON: %s", buf.String())
+	}
+}
+
+func TestVerifierMapping(t *testing.T) {
+	v := newTestVerifier(t, func(opts *options.VerifierOptions) {
+		opts.Format = "flac"
+		opts.CleanPaths = "_"
+		opts.LossyPolicy = options.LossyCopy
+	})
+	writeFiles(t, v.opts.InRoot, "A:B/01 Song?.wav", "A:B/02 Song.mp3", "A:B/cover.jpg")
+	writeFiles(t, v.opts.OutRoot, "A_B/01 Song_.flac", "A_B/02 Song.mp3", "A_B/cover.jpg")
+	report, err := v.Run()
+	if err != nil {
+		t.Fatal(err)
+	} else if n := report.Discrepancies(); n != 0 {
+		t.Errorf("Expected no discrepancies: %+v", report)
+	}
+
+	v.opts.LossyPolicy = options.LossySkip
+	if report, err = v.Run(); err != nil {
+		t.Fatal(err)
+	} else if report.Checked != 1 || !slices.Equal(report.Extra, []string{"A_B/02 Song.mp3"}) {
+		t.Errorf("Skipped lossy files should be neither checked nor expected: %+v", report)
+	}
+}
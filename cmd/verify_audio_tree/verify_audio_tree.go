@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package main
+
+import (
+	"audio_converter/internal/ffmpeg"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+	defer cancel()
+	opts, outcome := options.NewVerifierOptions(os.Args)
+	if outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
+	}
+	logging.SetFileOptions(opts.LogAppend, opts.LogMaxSize, opts.LogKeep)
+	if err := logging.Initialize(ctx, opts.LogFile, opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
+		logging.Fatalln(err)
+	}
+	// Only -decode runs ffmpeg.
+	if opts.Decode {
+		if err := ffmpeg.CheckFFmpeg(ctx, &opts.GlobalOptions); err != nil {
+			logging.Fatalln(err)
+		}
+	}
+	report, err := newVerifier(ctx, opts).Run()
+	if err != nil {
+		logging.Fatalln(err)
+	}
+	if err := report.WriteText(os.Stdout); err != nil {
+		logging.Fatalln(err)
+	}
+	if opts.ReportFile != "" {
+		if err := writeReport(opts.ReportFile, report); err != nil {
+			logging.Fatalln(err)
+		}
+	}
+	if n := report.Discrepancies(); n > 0 {
+		logging.Fatalf("Found %d discrepancies\n", n)
+	}
+}
+
+// Writes the report to the named file as JSON.
+func writeReport(name string, report *Report) error {
+	fp, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed creating report file: %w", err)
+	}
+	if err := report.WriteJSON(fp); err != nil {
+		fp.Close()
+		return fmt.Errorf("failed writing report file %s: %w", name, err)
+	}
+	// The last of the data may not be written until it's closed.
+	if err := fp.Close(); err != nil {
+		return fmt.Errorf("failed writing report file %s: %w", name, err)
+	}
+	return nil
+}
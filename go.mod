@@ -1,3 +1,9 @@
 module audio_converter
 
 go 1.24.2
+
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	golang.org/x/sys v0.40.0
+	golang.org/x/text v0.34.0
+)
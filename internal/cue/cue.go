@@ -0,0 +1,213 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+// Package cue reads CUE sheets, which describe the tracks of an album ripped
+// to one big file. Only what's needed to split the file into tracks is kept:
+// the titles, performers, files, and where each track starts.
+package cue
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The CD frames in a second, which INDEX times count in.
+const FramesPerSecond = 75
+
+// A parsed CUE sheet.
+type Sheet struct {
+	Title     string // Of the album.
+	Performer string // Of the album.
+	Tracks    []Track
+}
+
+// One audio track of a Sheet.
+type Track struct {
+	Number    int    `json:"number"`
+	Title     string `json:"title,omitempty"`
+	Performer string `json:"performer,omitempty"` // The album's, if the track has none.
+	Album     string `json:"album,omitempty"`     // The sheet's title.
+	// The media file the track is in, as named by the sheet, which is relative
+	// to the sheet's directory.
+	File  string        `json:"file"`
+	Start time.Duration `json:"start"` // INDEX 01 within File.
+	// Where the next track in File starts, or 0 for the end of File.
+	End time.Duration `json:"end,omitempty"`
+}
+
+// Returns the tracks in file, in order.
+func (s *Sheet) TracksOf(file string) []Track {
+	var tracks []Track
+	for _, t := range s.Tracks {
+		if t.File == file {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+// Returns the files the sheet's tracks are in, in order.
+func (s *Sheet) Files() []string {
+	var files []string
+	for _, t := range s.Tracks {
+		if len(files) == 0 || files[len(files)-1] != t.File {
+			files = append(files, t.File)
+		}
+	}
+	return files
+}
+
+// Parses the sheet read from r. Sheets written by various rippers and by hand
+// are taken as they come: a byte order mark, CRLF line endings, unquoted
+// values, lower case commands, and Latin-1 instead of UTF-8 are all fine.
+// Commands other than TITLE, PERFORMER, FILE, TRACK, and INDEX are ignored, as
+// are tracks that aren't audio.
+func Parse(r io.Reader) (*Sheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	text := string(data)
+	if !utf8.Valid(data) {
+		text = latin1(data)
+	}
+
+	sheet := &Sheet{}
+	var file string
+	var track *Track  // The current track, or nil before the first.
+	skipping := false // In a track that isn't audio.
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		command, rest, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		rest = strings.TrimSpace(rest)
+		switch strings.ToUpper(command) {
+		case "TITLE":
+			if track == nil {
+				sheet.Title = unquote(rest)
+			} else if !skipping {
+				track.Title = unquote(rest)
+			}
+		case "PERFORMER":
+			if track == nil {
+				sheet.Performer = unquote(rest)
+			} else if !skipping {
+				track.Performer = unquote(rest)
+			}
+		case "FILE":
+			if file = fileName(rest); file == "" {
+				return nil, fmt.Errorf("cue: line %d: FILE without a name", line)
+			}
+		case "TRACK":
+			number, kind, _ := strings.Cut(rest, " ")
+			n, err := strconv.Atoi(number)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("cue: line %d: bad track number %q", line, number)
+			} else if file == "" {
+				return nil, fmt.Errorf("cue: line %d: TRACK before FILE", line)
+			}
+			skipping = !strings.EqualFold(strings.TrimSpace(kind), "AUDIO")
+			if skipping {
+				continue
+			}
+			sheet.Tracks = append(sheet.Tracks, Track{Number: n, File: file, Start: -1})
+			track = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "INDEX":
+			number, stamp, _ := strings.Cut(rest, " ")
+			if skipping {
+				continue
+			} else if track == nil {
+				return nil, fmt.Errorf("cue: line %d: INDEX before TRACK", line)
+			} else if number != "01" && number != "1" {
+				// INDEX 00 is the pregap, which belongs to the previous track.
+				continue
+			}
+			start, err := ParseTime(strings.TrimSpace(stamp))
+			if err != nil {
+				return nil, fmt.Errorf("cue: line %d: %w", line, err)
+			}
+			track.Start = start
+			// The track is in the file the INDEX is in, which may differ from
+			// the TRACK when the file changes during its pregap.
+			track.File = file
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range sheet.Tracks {
+		t := &sheet.Tracks[i]
+		if t.Start < 0 {
+			return nil, fmt.Errorf("cue: track %d has no INDEX 01", t.Number)
+		}
+		if t.Performer == "" {
+			t.Performer = sheet.Performer
+		}
+		t.Album = sheet.Title
+		if i+1 < len(sheet.Tracks) && sheet.Tracks[i+1].File == t.File {
+			t.End = sheet.Tracks[i+1].Start
+			if t.End <= t.Start {
+				return nil, fmt.Errorf("cue: track %d doesn't start after track %d", sheet.Tracks[i+1].Number, t.Number)
+			}
+		}
+	}
+	return sheet, nil
+}
+
+// Parses an INDEX time, mm:ss:ff, where ff is in frames. Minutes may go past
+// 59, e.g., 74:59:74 for the end of a full CD.
+func ParseTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad time %q: expected mm:ss:ff", value)
+	}
+	var n [3]int
+	for i, part := range parts {
+		var err error
+		if n[i], err = strconv.Atoi(part); err != nil || n[i] < 0 {
+			return 0, fmt.Errorf("bad time %q: expected mm:ss:ff", value)
+		}
+	}
+	if n[1] >= 60 || n[2] >= FramesPerSecond {
+		return 0, fmt.Errorf("bad time %q: seconds or frames out of range", value)
+	}
+	frames := (n[0]*60+n[1])*FramesPerSecond + n[2]
+	return time.Duration(frames) * time.Second / FramesPerSecond, nil
+}
+
+// Returns value without the quotes around it, if any.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Returns the name from the arguments of FILE, e.g., `"Album.flac" WAVE`. An
+// unquoted name may have spaces, so it's everything before the type.
+func fileName(rest string) string {
+	if quoted, ok := strings.CutPrefix(rest, `"`); ok {
+		name, _, _ := strings.Cut(quoted, `"`)
+		return name
+	}
+	if i := strings.LastIndexByte(rest, ' '); i > 0 {
+		return strings.TrimSpace(rest[:i])
+	}
+	return rest
+}
+
+// Decodes Latin-1, which older rippers write rather than UTF-8.
+func latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package cue
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Based on a sheet from EAC, then mangled the way sheets get mangled: a byte
+// order mark, CRLF line endings, stray indentation, REM lines, a lower case
+// command, an unquoted performer, pregaps, and a title with quotes in it.
+const messySheet = "\ufeffREM GENRE \"Electronic\"\r\n" +
+	"REM DATE 1999\r\n" +
+	"REM DISCID 8A0B4C0D\r\n" +
+	"REM COMMENT \"ExactAudioCopy v0.99pb5\"\r\n" +
+	"PERFORMER \"Various Artists\"\r\n" +
+	"TITLE \"Night Drive: The Mix\"\r\n" +
+	"FILE \"Night Drive - The Mix.flac\" WAVE\r\n" +
+	"  TRACK 01 AUDIO\r\n" +
+	"    TITLE \"Intro\"\r\n" +
+	"    PERFORMER \"DJ Someone\"\r\n" +
+	"    INDEX 01 00:00:00\r\n" +
+	"  TRACK 02 AUDIO\r\n" +
+	"    TITLE \"Say \"Hello\" Again\"\r\n" +
+	"    performer Band With No Quotes\r\n" +
+	"    INDEX 00 03:58:40\r\n" +
+	"    INDEX 01 04:00:37\r\n" +
+	"  TRACK 03 AUDIO\r\n" +
+	"    TITLE \"Outro\"\r\n" +
+	"    FLAGS DCP\r\n" +
+	"    INDEX 01 74:59:74\r\n"
+
+func TestParse(t *testing.T) {
+	sheet, err := Parse(strings.NewReader(messySheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Title != "Night Drive: The Mix" || sheet.Performer != "Various Artists" {
+		t.Errorf("Bad album: %q by %q", sheet.Title, sheet.Performer)
+	}
+	file := "Night Drive - The Mix.flac"
+	want := []Track{
+		{Number: 1, Title: "Intro", Performer: "DJ Someone", Album: sheet.Title, File: file, Start: 0, End: 4*time.Minute + 37*time.Second/75},
+		{Number: 2, Title: `Say "Hello" Again`, Performer: "Band With No Quotes", Album: sheet.Title, File: file, Start: 4*time.Minute + 37*time.Second/75, End: 74*time.Minute + 59*time.Second + 74*time.Second/75},
+		{Number: 3, Title: "Outro", Performer: "Various Artists", Album: sheet.Title, File: file, Start: 74*time.Minute + 59*time.Second + 74*time.Second/75},
+	}
+	if !slices.Equal(sheet.Tracks, want) {
+		t.Errorf("Bad tracks:\n got: %+v\nwant: %+v", sheet.Tracks, want)
+	}
+	if files := sheet.Files(); !slices.Equal(files, []string{file}) {
+		t.Errorf("Bad files: %q", files)
+	}
+}
+
+func TestParseFiles(t *testing.T) {
+	// One file per track, with a data track at the end, in Latin-1.
+	sheet, err := Parse(strings.NewReader("TITLE \"Caf\xe9\"\n" +
+		"FILE 01 Side A.wav WAVE\nTRACK 1 AUDIO\nINDEX 01 00:00:00\n" +
+		"FILE \"02 Side B.wav\" WAVE\nTRACK 2 AUDIO\nINDEX 01 00:00:00\n" +
+		"FILE \"data.bin\" BINARY\nTRACK 3 MODE1/2352\nTITLE \"Data\"\nINDEX 01 00:00:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sheet.Title != "Café" {
+		t.Errorf("Latin-1 should be decoded: %q", sheet.Title)
+	}
+	if files := sheet.Files(); !slices.Equal(files, []string{"01 Side A.wav", "02 Side B.wav"}) {
+		t.Errorf("Bad files: %q", files)
+	}
+	if tracks := sheet.TracksOf("02 Side B.wav"); len(tracks) != 1 || tracks[0].Number != 2 || tracks[0].End != 0 {
+		t.Errorf("Bad tracks of the second file: %+v", tracks)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		"TRACK 01 AUDIO\nINDEX 01 00:00:00\n",
+		"FILE \"a.flac\" WAVE\nINDEX 01 00:00:00\n",
+		"FILE \"a.flac\" WAVE\nTRACK xx AUDIO\n",
+		"FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\n",
+		"FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:60:00\n",
+		"FILE \"a.flac\" WAVE\nTRACK 01 AUDIO\nINDEX 01 00:10:00\nTRACK 02 AUDIO\nINDEX 01 00:05:00\n",
+		"FILE\n",
+	} {
+		if sheet, err := Parse(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error for %q: %+v", bad, sheet)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"00:00:00": 0,
+		"00:01:00": time.Second,
+		"00:00:75": -1,
+		"01:02:15": time.Minute + 2*time.Second + 200*time.Millisecond,
+		"99:00:00": 99 * time.Minute,
+		"1:2":      -1,
+		"aa:00:00": -1,
+	} {
+		got, err := ParseTime(value)
+		if want < 0 && err == nil {
+			t.Errorf("ParseTime(%q) = %v, expected an error", value, got)
+		} else if want >= 0 && (err != nil || got != want) {
+			t.Errorf("ParseTime(%q) = %v, %v, expected %v", value, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned when the output exists and isn't to be replaced.
+var ErrOutputExists = errors.New("output already exists")
+
+// How long confirmOverwrite waits for an answer before taking it as no, e.g.,
+// when run from cron with a terminal nobody is watching.
+var promptTimeout = time.Minute
+
+// The answers typed on stdin, read by readAnswers once the first prompt needs
+// them, and shared by every prompt after.
+var stdinAnswers = sync.OnceValue(func() <-chan string {
+	return readAnswers(os.Stdin)
+})
+
+// Held while confirmOverwrite asks, so that jobs converting at once, e.g.,
+// to_flac -j 4 *.wav out/, ask one at a time.
+var promptMutex sync.Mutex
+
+// Runs convert with the output going to a temporary file, which is renamed to
+// opts.OutputFile on success and removed on failure. That way, a failed or
+// interrupted conversion never leaves a broken output behind.
+//
+// Since ffmpeg only sees the temporary file, it can't honor -n or ask before
+// overwriting, so that's done here. confirm is called to ask when neither -n
+// nor -y was given. With -n, an output that shows up while converting is kept,
+// and ErrOutputExists returned, rather than replaced.
+func writeAtomically(opts *options.ConverterOptions, confirm func(name string) bool, convert func(*options.ConverterOptions) error) error {
+	if err := checkOutput(opts.OutputFile, &opts.GlobalOptions, confirm); err != nil {
+		return err
+	}
+	dir, name := filepath.Split(opts.OutputFile)
+	if dir == "" {
+		dir = "."
+	}
+	af := filesystem.NewAtomicFile(filesystem.NewFileSystem(dir), name)
+	topts := *opts
+	topts.OutputFile = filepath.Join(dir, af.Temp())
+	topts.NoClobber = false
+	topts.Overwrite = true
+	if err := convert(&topts); err != nil {
+		if aerr := af.Abort(); aerr != nil {
+			logging.Printf("Failed removing partial output %q: %v", topts.OutputFile, aerr)
+		}
+		return err
+	}
+	commit := af.Commit
+	if opts.NoClobber {
+		commit = af.CommitNoClobber
+	}
+	if err := commit(); errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("not overwriting %q: %w", opts.OutputFile, ErrOutputExists)
+	} else if err != nil {
+		return fmt.Errorf("renaming %q into place failed: %w", topts.OutputFile, err)
+	}
+	return nil
+}
+
+// Returns ErrOutputExists if name exists and isn't to be replaced, going by
+// -n and -y, or by asking with confirm when neither was given. Checked here
+// rather than left to ffmpeg, which would wait on a prompt forever without a
+// terminal.
+func checkOutput(name string, opts *options.GlobalOptions, confirm func(name string) bool) error {
+	if name == "-" {
+		return nil
+	} else if _, err := os.Stat(name); err != nil {
+		return nil
+	}
+	if opts.NoClobber || (!opts.Overwrite && !confirm(name)) {
+		return fmt.Errorf("not overwriting %q: %w", name, ErrOutputExists)
+	}
+	return nil
+}
+
+// Reads the lines of in, sending each to the channel, which is closed at the
+// end of in. A single goroutine owns in, so that a line read ahead, e.g., from
+// yes(1), isn't lost, and an answer that comes after its prompt gave up goes to
+// the next prompt.
+func readAnswers(in io.Reader) <-chan string {
+	answers := make(chan string)
+	go func() {
+		defer close(answers)
+		br := bufio.NewReader(in)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				answers <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return answers
+}
+
+// Asks whether to overwrite name the way ffmpeg does, taking the next of
+// answers and defaulting to no. No answer within promptTimeout, or none left,
+// is taken as no.
+func confirmOverwrite(name string, answers <-chan string, out io.Writer) bool {
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+	fmt.Fprintf(out, "File '%s' already exists. Overwrite? [y/N] ", name)
+	var answer string
+	select {
+	case answer = <-answers:
+	case <-time.After(promptTimeout):
+		fmt.Fprintln(out)
+		logging.Printf("No answer after %v, not overwriting %q", promptTimeout, name)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"audio_converter/internal/workpool"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Converts each of opts.InputFiles into the directory opts.OutputFile, on up to
+// opts.MaxJobs jobs. A file that fails doesn't stop the rest: the failures are
+// listed in the error returned once they're all done. Outputs that exist and
+// aren't to be replaced are skipped, as Convert does for a single file.
+func ConvertFiles(ctx context.Context, opts *options.ConverterOptions) error {
+	pool := workpool.NewWorkPool(ctx, opts.MaxJobs, 0)
+	pool.Start()
+	defer pool.Stop()
+	// By input, so the failures are listed in the order given.
+	failed := make([]error, len(opts.InputFiles))
+	var skipped atomic.Int64
+	for i, input := range opts.InputFiles {
+		fopts := *opts
+		fopts.InputFile, fopts.OutputFile, fopts.InputFiles = input, opts.OutputFor(input), nil
+		err := pool.AddNamedContext(ctx, input, func() error {
+			err := prepare(ctx, &fopts)
+			if err == nil {
+				err = Convert(ctx, &fopts)
+			}
+			if errors.Is(err, ErrOutputExists) {
+				logging.Println("Skipping:", err)
+				skipped.Add(1)
+				return nil
+			} else if err != nil {
+				logging.Printf("!!! FAILED: %q: %v !!!", input, err)
+				failed[i] = err
+			}
+			return err
+		})
+		if err != nil {
+			break
+		}
+	}
+	pool.Wait()
+	var list []string
+	for i, err := range failed {
+		if err != nil {
+			list = append(list, fmt.Sprintf("%s: %v", opts.InputFiles[i], err))
+		}
+	}
+	if err := context.Cause(ctx); err != nil {
+		return fmt.Errorf("stopped converting: %w", err)
+	} else if len(list) > 0 {
+		return fmt.Errorf("failed converting %d of %d files:\n\t%s", len(list), len(opts.InputFiles), strings.Join(list, "\n\t"))
+	}
+	logging.Printf("Converted %d files into %q, and skipped %d that already existed",
+		len(opts.InputFiles)-int(skipped.Load()), opts.OutputFile, skipped.Load())
+	return nil
+}
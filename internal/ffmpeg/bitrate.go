@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"time"
+)
+
+// The lowest and highest audio bit rates worth using with a codec, in bits
+// per second.
+type BitRateRange struct {
+	Min int64
+	Max int64
+}
+
+// Bit rates for the codecs that take one. Lossless codecs like flac don't.
+var BitRateRanges = map[string]BitRateRange{
+	"aac":        {32_000, 320_000},
+	"aac_at":     {32_000, 320_000},
+	"libmp3lame": {32_000, 320_000},
+}
+
+// Fraction of -target-size set aside for the container, metadata, and cover
+// art, rather than the audio.
+const TargetSizeOverhead = 0.03
+
+// Returns the bit rate that fits duration worth of audio into size bytes,
+// after setting aside overhead as a fraction of size. The result is clamped to
+// limits, so a very short or very long input may not fit exactly.
+func TargetBitRate(size int64, duration time.Duration, overhead float64, limits BitRateRange) (int64, error) {
+	if duration <= 0 {
+		return 0, fmt.Errorf("cannot fit a duration of %v", duration)
+	} else if size <= 0 || overhead < 0 || overhead >= 1 {
+		return 0, fmt.Errorf("cannot fit %d bytes with %.0f%% overhead", size, overhead*100)
+	}
+	bits := float64(size) * (1 - overhead) * 8
+	rate := int64(bits / duration.Seconds())
+	return min(max(rate, limits.Min), limits.Max), nil
+}
+
+// Sets opts.BitRate so the output fits in opts.TargetSize, by probing the
+// duration of the input, less what -start and -duration trim off.
+func applyTargetSize(ctx context.Context, opts *options.ConverterOptions) error {
+	limits, ok := BitRateRanges[opts.Codec]
+	if !ok {
+		return fmt.Errorf("-target-size is not supported with codec %q", opts.Codec)
+	}
+	duration, err := ProbeDuration(ctx, opts.FFprobe(), opts.InputFile)
+	if err != nil {
+		return err
+	}
+	duration -= opts.Start
+	if opts.Duration > 0 {
+		duration = min(duration, opts.Duration)
+	}
+	rate, err := TargetBitRate(opts.TargetSize, duration, TargetSizeOverhead, limits)
+	if err != nil {
+		return err
+	}
+	opts.BitRate = fmt.Sprintf("%dk", rate/1000)
+	logging.Verbosef("Using bitrate %s to fit %v of %q in %d bytes", opts.BitRate, duration, opts.InputFile, opts.TargetSize)
+	return nil
+}
@@ -5,37 +5,25 @@ package ffmpeg
 import (
 	"audio_converter/internal/logging"
 	"audio_converter/internal/options"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// List of supported input format extensions.
-var InputExtensions = []string{
-	".flac",
-	".m4a", ".m4r",
-	".mp3",
-	".wav",
-	".aiff",
-}
-
-var DefaultOptions = []*options.ConverterOptions{
-	FlacOptions,
-	AacOptions,
-	Mp3Options,
-}
-
+// Returns the DefaultOptions of the registered format for ext.
 func GetDefaultOptions(ext string) *options.ConverterOptions {
-	for _, opts := range DefaultOptions {
-		if slices.Contains(opts.OutputExtensions, ext) {
-			return opts
-		}
+	if f, ok := Lookup(ext); ok && f.DefaultOptions != nil {
+		return f.DefaultOptions
 	}
 	opts := &options.ConverterOptions{}
 	opts.Err = fmt.Errorf("no defaults for extension %q", ext)
@@ -47,44 +35,209 @@ func IsMediaFile(name string) bool {
 	return slices.Contains(InputExtensions, filepath.Ext(name))
 }
 
+// The extensions treated as media files by a tool that lets the user change
+// what InputExtensions covers, e.g., export_audio_tree -media-ext.
+type MediaExtensions map[string]bool
+
+// Returns InputExtensions plus add, less remove. Extensions include the dot.
+func NewMediaExtensions(add, remove []string) MediaExtensions {
+	m := make(MediaExtensions)
+	for _, ext := range slices.Concat(InputExtensions, add) {
+		m[ext] = true
+	}
+	for _, ext := range remove {
+		delete(m, ext)
+	}
+	return m
+}
+
+// Returns true if name has one of the extensions in m. A nil m is the same as
+// IsMediaFile.
+func (m MediaExtensions) IsMediaFile(name string) bool {
+	if m == nil {
+		return IsMediaFile(name)
+	}
+	return m[filepath.Ext(name)]
+}
+
+// Returns true if name has one of LossyExtensions.
+func IsLossy(name string) bool {
+	return slices.Contains(LossyExtensions, filepath.Ext(name))
+}
+
 // Implements the main() for various to_<format>. Just provide the default
 // options for the format. Suitable defaults are exposed as package level
 // variables. E.g., FlacOptions.
 func ConvertMain(defaults *options.ConverterOptions) {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 	defer cancel()
-	opts := options.NewConverterOptions(os.Args, defaults)
-	if opts == nil {
-		// Arg parsing error. Usage, etc is handled by the constructor.
-		os.Exit(1)
+	opts, outcome := options.NewConverterOptions(os.Args, defaults)
+	if outcome != options.OutcomeRun {
+		// Usage, etc. is handled by the constructor.
+		os.Exit(outcome.ExitCode())
+	}
+	if opts.OutputFile == "-" {
+		logging.ReserveStdout()
+	}
+	logging.SetFileOptions(opts.LogAppend, opts.LogMaxSize, opts.LogKeep)
+	if err := logging.Initialize(ctx, opts.LogFile, opts.LogLevel, logging.Format(opts.LogFormat), opts.Verbose); err != nil {
+		logging.Fatalln(err)
+	}
+	if err := CheckFFmpeg(ctx, &opts.GlobalOptions); err != nil {
+		logging.Fatalln(err)
+	}
+	WarnGapless(opts)
+	if len(opts.InputFiles) > 0 {
+		if err := ConvertFiles(ctx, opts); err != nil {
+			logging.Fatalln(err)
+		}
+		return
+	}
+	if opts.OutputDir() != "" {
+		logging.Printf("Writing %q, since {output} is a directory", opts.OutputFile)
+	} else if opts.AddedExt() != "" {
+		logging.Printf("Writing %q, since {output} had no extension", opts.OutputFile)
 	}
-	if err := logging.Initialize(ctx, opts.LogFile, opts.Verbose); err != nil {
+	if err := prepare(ctx, opts); err != nil {
 		logging.Fatalln(err)
 	}
-	Convert(ctx, opts)
+	if err := Convert(ctx, opts); errors.Is(err, ErrOutputExists) {
+		logging.Println("Skipping:", err)
+	}
 }
 
-func makeCmd(ctx context.Context, opts *options.ConverterOptions) *exec.Cmd {
-	args := []string{
-		// Set the input file.
-		"-i", opts.InputFile,
-		// Wrangle the metadata.
-		"-map_metadata", "0",
-		// Copy the cover art if it exists.
-		"-c:v", opts.CoverArtFormat,
+// Checks -start against the input, and works out the bit rate for
+// -target-size, before converting with opts.
+func prepare(ctx context.Context, opts *options.ConverterOptions) error {
+	if opts.Start > 0 && opts.InputFile != "-" {
+		if err := checkStart(ctx, opts); err != nil {
+			return err
+		}
 	}
+	if opts.TargetSize > 0 {
+		return applyTargetSize(ctx, opts)
+	}
+	return nil
+}
 
-	// Scale the cover art. Note, FFmpeg ignores scale when copying rather than
-	// converting video streams, cover art included.
-	if opts.Scale != "" {
-		args = append(args, "-s", opts.Scale)
+// Checks that opts.Start isn't past the end of the input, which ffmpeg would
+// happily turn into an empty file. If the duration can't be probed, e.g.,
+// without ffprobe, it's left to ffmpeg.
+func checkStart(ctx context.Context, opts *options.ConverterOptions) error {
+	duration, err := ProbeDuration(ctx, opts.FFprobe(), opts.InputFile)
+	if err != nil {
+		logging.Verbosef("Not checking -start: %v", err)
+		return nil
+	} else if opts.Start >= duration {
+		return fmt.Errorf("-start %v is past the end of %q, which is %v long", opts.Start, opts.InputFile, duration)
 	}
+	return nil
+}
 
+// Returns the command line that converts with opts, ffmpeg followed by its
+// arguments.
+func makeCmd(opts *options.ConverterOptions) []string {
+	// ffmpeg never reads its keyboard commands, or a prompt, from stdin, so it
+	// can't hang waiting on a terminal nobody is watching.
+	args := []string{"-nostdin"}
+	if opts.HWAccel != "" {
+		// Like -ss, it applies to the input that follows.
+		args = append(args, "-hwaccel", opts.HWAccel)
+	}
+	if opts.Threads > 0 {
+		// Before -i, it limits the decoder, and after, the encoder.
+		args = append(args, "-threads", strconv.Itoa(opts.Threads))
+	}
+	if opts.Start > 0 {
+		// Before -i, so ffmpeg seeks the input rather than decoding up to it.
+		args = append(args, "-ss", seconds(opts.Start))
+	}
+	// Set the input file.
+	args = append(args, "-i", pipeName(opts.InputFile, 0))
+	art := opts.ArtFile != "" && opts.CoverArtFormat != "none"
+	if art {
+		// Every input comes before the output options, or ffmpeg takes them
+		// as options of the input that follows.
+		args = append(args, "-i", opts.ArtFile)
+	}
+	// Wrangle the metadata.
+	args = append(args, "-map_metadata", "0")
+	if art {
+		// Take the audio from the input and the cover art from the image.
+		args = append(args, "-map", "0:a", "-map", "1:v", "-disposition:v", "attached_pic")
+	}
+	if opts.Duration > 0 {
+		args = append(args, "-t", seconds(opts.Duration))
+	}
+	for _, tag := range opts.Metadata {
+		args = append(args, "-metadata", tag)
+	}
 	if opts.NoClobber {
 		args = append(args, "-n")
 	} else if opts.Overwrite {
 		args = append(args, "-y")
 	}
+	args = append(args, encodingArgs(opts)...)
+	if opts.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(opts.Threads))
+	}
+
+	// Set the output file.
+	if opts.OutputFile == "-" {
+		args = append(args, pipeOptions(opts)...)
+	}
+	args = append(args, pipeName(opts.OutputFile, 1))
+	return append([]string{program(&opts.GlobalOptions)}, args...)
+}
+
+// Formats d as ffmpeg takes it for -ss and -t, e.g., "90.5".
+func seconds(d time.Duration) string {
+	return formatSeconds(d.Seconds())
+}
+
+// Formats seconds without an exponent or trailing zeros, e.g., "90.5".
+func formatSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
+// Returns what ffmpeg calls file descriptor fd when name is "-", or name.
+func pipeName(name string, fd int) string {
+	if name == "-" {
+		return "pipe:" + strconv.Itoa(fd)
+	}
+	return name
+}
+
+// Returns the PipeOptions of the format being written, given by
+// opts.PipeFormat or else the first of opts.OutputExtensions.
+func pipeOptions(opts *options.ConverterOptions) []string {
+	name := opts.PipeFormat
+	if name == "" && len(opts.OutputExtensions) > 0 {
+		name = opts.OutputExtensions[0]
+	}
+	if f, ok := Lookup(name); ok {
+		return f.PipeOptions
+	}
+	return nil
+}
+
+// Returns the arguments that control what ffmpeg writes to the output.
+func encodingArgs(opts *options.ConverterOptions) []string {
+	var args []string
+	if opts.CoverArtFormat == "none" {
+		// Drop the cover art.
+		args = append(args, "-vn")
+	} else {
+		// Copy the cover art if it exists.
+		args = append(args, "-c:v", opts.CoverArtFormat)
+	}
+
+	// Scale the cover art. Note, FFmpeg ignores scale when copying rather than
+	// converting video streams, cover art included.
+	if opts.Scale != "" {
+		args = append(args, "-s", opts.Scale)
+	}
+
 	if opts.Codec != "" {
 		// Set the audio codec
 		args = append(args, "-c:a", opts.Codec)
@@ -99,25 +252,131 @@ func makeCmd(ctx context.Context, opts *options.ConverterOptions) *exec.Cmd {
 	if opts.Channels > 0 {
 		args = append(args, "-ac", strconv.Itoa(opts.Channels))
 	}
+	if filters := audioFilters(opts); len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	args = append(args, gaplessArgs(opts)...)
+	return args
+}
 
-	// Set the output file.
-	args = append(args, opts.OutputFile)
-	return exec.CommandContext(ctx, "ffmpeg", args...)
+// Returns the audio filters to apply, in order, for a single -af.
+func audioFilters(opts *options.ConverterOptions) []string {
+	var filters []string
+	if opts.FadeIn > 0 {
+		filters = append(filters, "afade=t=in:d="+formatSeconds(opts.FadeIn))
+	}
+	if opts.FadeOut > 0 && opts.Duration > 0 {
+		// The output starts at 0, even with -ss, so it ends at -duration.
+		start := opts.Duration.Seconds() - opts.FadeOut
+		filters = append(filters, "afade=t=out:st="+formatSeconds(start)+":d="+formatSeconds(opts.FadeOut))
+	}
+	return filters
+}
+
+// Summarizes the settings that affect the output of a conversion, such as the
+// codec and bit rate. Converting the same input with the same fingerprint
+// produces the same output.
+func Fingerprint(opts *options.ConverterOptions) string {
+	return strings.Join(encodingArgs(opts), " ")
+}
+
+// Returns the command line run to convert with opts, e.g., for logging.
+func CommandLine(opts *options.ConverterOptions) []string {
+	return makeCmd(opts)
 }
 
-// Runs ffmpeg using the current process's standard I/O for output.
+// Runs ffmpeg using the current process's standard I/O for output, and for
+// input when opts.InputFile is "-". If opts.ArtFallback is set, the conversion
+// is retried without cover art when the art appears to be the problem, unless
+// reading stdin, which can't be read twice. If opts.Atomic is set, the output is written
+// by way of a temporary file, so it's either complete or left alone.
 func Convert(ctx context.Context, opts *options.ConverterOptions) error {
-	cmd := makeCmd(ctx, opts)
-	logging.Println("Running:", strings.Join(cmd.Args, " "))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	confirm := func(name string) bool {
+		if opts.InputFile == "-" {
+			// The audio is on stdin, so there's nowhere to read an answer.
+			return false
+		}
+		return confirmOverwrite(name, stdinAnswers(), os.Stderr)
+	}
+	if !opts.Atomic {
+		if err := checkOutput(opts.OutputFile, &opts.GlobalOptions, confirm); err != nil {
+			return err
+		}
+		// Already asked, so ffmpeg mustn't. With -n, it's still passed on, so
+		// that an output that shows up before ffmpeg opens it is kept.
+		direct := *opts
+		if !direct.NoClobber {
+			direct.Overwrite = true
+		}
+		return convert(ctx, &direct)
+	}
+	return writeAtomically(opts, confirm, func(opts *options.ConverterOptions) error {
+		return convert(ctx, opts)
+	})
+}
+
+// Does the work of Convert, writing directly to the output.
+func convert(ctx context.Context, opts *options.ConverterOptions) error {
+	stdin := opts.InputFile == "-"
+	// Probing stdin would eat the input, so that's left to ffmpeg's output.
+	probed := !stdin && LogProbedInputInfo(ctx, opts.FFprobe(), opts.InputFile)
+	run := func(opts *options.ConverterOptions) ([]byte, error) {
+		var stderr bytes.Buffer
+		cmdline := makeCmd(opts)
+		logging.Println("Running:", strings.Join(cmdline, " "))
+		// With an output of "-", stdout is the output, so ffmpeg's logging
+		// only goes to stderr.
+		stdio := Stdio{Stdout: os.Stdout}
+		if stdin {
+			stdio.Stdin = os.Stdin
+		}
+		// Keep a copy to summarize the input and check for cover art errors,
+		// and log it, since it's the encoder's own account of what happened.
+		// With -quiet, it's only shown if ffmpeg fails.
+		logw := logging.NewLineWriter(slog.LevelInfo, opts.InputFile)
+		if opts.Quiet {
+			stdio.Stderr = io.MultiWriter(&stderr, logw)
+		} else {
+			stdio.Stderr = io.MultiWriter(os.Stderr, &stderr, logw)
+		}
+		err := DefaultRunner.Run(ctx, cmdline[0], cmdline[1:], stdio)
+		logw.Flush()
+		if err != nil && opts.Quiet {
+			os.Stderr.Write(stderr.Bytes())
+		}
+		if !probed {
+			LogInputInfo(opts.InputFile, stderr.Bytes())
+		}
+		return stderr.Bytes(), err
+	}
+	if !opts.ArtFallback || stdin {
+		_, err := run(opts)
+		return err
+	}
+	_, dropped, err := ConvertWithArtFallback(opts, run)
+	if dropped {
+		logging.Verbosef("Converted %q without cover art", opts.InputFile)
+	}
+	return err
 }
 
 // Runs ffmpeg in a background process, returning its combined standard output
-// and error.
+// and error. If opts.MemoryLimit is set and MemoryLimitSupported, the process
+// is limited to that much memory. Its priority is lowered to opts.Nice and
+// opts.IONice, where supported.
 func ConvertInBackground(ctx context.Context, opts *options.ConverterOptions) ([]byte, error) {
-	cmd := makeCmd(ctx, opts)
-	logging.Println("Running in background:", strings.Join(cmd.Args, " "))
-	return cmd.CombinedOutput()
+	r := DefaultRunner
+	if er, ok := r.(ExecRunner); ok {
+		er.MemoryLimit, er.Nice, er.IONice = opts.MemoryLimit, opts.Nice, opts.IONice
+		r = er
+	}
+	return ConvertInBackgroundWith(ctx, r, opts)
+}
+
+// Like ConvertInBackground, but runs ffmpeg through r, which has the say over
+// any limits on the process.
+func ConvertInBackgroundWith(ctx context.Context, r Runner, opts *options.ConverterOptions) ([]byte, error) {
+	cmdline := makeCmd(opts)
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	return runCombined(ctx, r, cmdline)
 }
@@ -5,20 +5,59 @@ package ffmpeg
 import (
 	"audio_converter/internal/logging"
 	"audio_converter/internal/options"
+	"bytes"
 	"context"
+	"errors"
 	"os"
-	"os/exec"
 	"strings"
 )
 
 // Extract cover art from input to output. If provided, scale is used as the
-// value for the -s flag. If clobbering is less than, equal, or greater than
-// zero then the ffmpeg will be told to overwrite, prompt, or no clobber
-// existing files.
-//
-// The clobbering flag is kinda hacky, but there's only one tool that relies on this function.
+// value for the -s flag. An existing output is skipped with -n, replaced with
+// -y, and otherwise only replaced if confirmed at the prompt; either way,
+// ffmpeg is never left to ask.
 func ExtractCoverArt(ctx context.Context, opts *options.ExtracterOptions) error {
+	confirm := func(name string) bool {
+		return confirmOverwrite(name, stdinAnswers(), os.Stderr)
+	}
+	if err := checkOutput(opts.OutputFile, &opts.GlobalOptions, confirm); errors.Is(err, ErrOutputExists) {
+		logging.Println("Skipping:", err)
+		return nil
+	}
+	// Already asked, so ffmpeg mustn't.
+	direct := *opts
+	direct.NoClobber = false
+	direct.Overwrite = true
+	cmdline := coverArtCmd(&direct)
+	// With -quiet, ffmpeg's output is only shown if it fails.
+	var stderr bytes.Buffer
+	stdio := Stdio{Stdout: os.Stdout, Stderr: os.Stderr}
+	if opts.Quiet {
+		stdio.Stderr = &stderr
+	}
+
+	logging.Println("Running:", strings.Join(cmdline, " "))
+	err := DefaultRunner.Run(ctx, cmdline[0], cmdline[1:], stdio)
+	if err != nil && opts.Quiet {
+		os.Stderr.Write(stderr.Bytes())
+	}
+	return err
+}
+
+// Like ExtractCoverArt, but returns ffmpeg's output rather than showing it,
+// for extracting from many files at once. An error usually means the input has
+// no art.
+func ExtractCoverArtInBackground(ctx context.Context, opts *options.ExtracterOptions) ([]byte, error) {
+	cmdline := coverArtCmd(opts)
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	return runCombined(ctx, DefaultRunner, cmdline)
+}
+
+// Returns the ffmpeg command line that extracts the cover art for opts.
+func coverArtCmd(opts *options.ExtracterOptions) []string {
 	args := []string{
+		// Never wait on a prompt.
+		"-nostdin",
 		// Set the input file.
 		"-i", opts.InputFile,
 		// Set the necessary options.
@@ -37,13 +76,12 @@ func ExtractCoverArt(ctx context.Context, opts *options.ExtracterOptions) error
 	} else if opts.Overwrite {
 		args = append(args, "-y")
 	}
+	if opts.OutputFile == "-" {
+		// Options made sure there's a codec, as there's no extension.
+		args = append(args, "-f", "image2pipe")
+	}
 	// Set the output file.
-	args = append(args, opts.OutputFile)
-
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	args = append(args, pipeName(opts.OutputFile, 1))
 
-	logging.Println("Running:", strings.Join(cmd.Args, " "))
-	return cmd.Run()
+	return append([]string{program(&opts.GlobalOptions)}, args...)
 }
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Embeds image as the cover art of audio, replacing any art it had, and
+// returns ffmpeg's output. The result is written to a temporary file that's
+// renamed over audio on success, so an interrupted run leaves it untouched.
+func EmbedCoverArt(ctx context.Context, opts *options.EmbedderOptions, image, audio string) ([]byte, error) {
+	dir, name := filepath.Split(audio)
+	if dir == "" {
+		dir = "."
+	}
+	af := filesystem.NewAtomicFile(filesystem.NewFileSystem(dir), name)
+	temp := filepath.Join(dir, af.Temp())
+	cmdline := embedCmd(opts, image, audio, temp)
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runCombined(ctx, DefaultRunner, cmdline)
+	if err == nil {
+		// Otherwise, the file would get the default permissions.
+		var st os.FileInfo
+		if st, err = os.Stat(audio); err == nil {
+			err = os.Chmod(temp, st.Mode().Perm())
+		}
+	}
+	if err != nil {
+		if aerr := af.Abort(); aerr != nil {
+			logging.Printf("Failed removing partial output %q: %v", temp, aerr)
+		}
+		return output, fmt.Errorf("embedding %q into %q failed: %w", image, audio, err)
+	}
+	if err := af.Commit(); err != nil {
+		return output, fmt.Errorf("renaming %q into place failed: %w", temp, err)
+	}
+	return output, nil
+}
+
+// Returns the ffmpeg command line that writes audio to output with image as
+// its cover art.
+func embedCmd(opts *options.EmbedderOptions, image, audio, output string) []string {
+	args := []string{
+		"-v", "error", "-nostdin",
+		"-i", audio,
+		"-i", image,
+		// Everything in the audio but its old art, then the new art.
+		"-map", "0", "-map", "-0:v", "-map", "1",
+		"-c", "copy",
+	}
+	if codec := embedCodec(opts, image); codec != "" {
+		args = append(args, "-c:v", codec)
+	}
+	if opts.Scale != "" {
+		args = append(args, "-s", opts.Scale)
+	}
+	args = append(args,
+		"-disposition:v:0", "attached_pic",
+		// ID3 takes the picture's description and type from these.
+		"-metadata:s:v", "title=Album cover",
+		"-metadata:s:v", "comment=Cover (front)",
+		// The output is always a new, temporary file.
+		"-y", output)
+	return append([]string{program(&opts.GlobalOptions)}, args...)
+}
+
+// Returns the codec to convert image with, or "" to copy it as is. Scaling
+// means converting, so it defaults to the image's own format.
+func embedCodec(opts *options.EmbedderOptions, image string) string {
+	if opts.Codec != "" || opts.Scale == "" {
+		return opts.Codec
+	} else if strings.EqualFold(filepath.Ext(image), ".png") {
+		return "png"
+	}
+	return "mjpeg"
+}
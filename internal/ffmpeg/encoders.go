@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/options"
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait on ffmpeg for the encoder list when validating flags.
+const encodersTimeout = 10 * time.Second
+
+// Audio encoders by ffmpeg binary, so asking repeatedly doesn't run it again.
+var encoders = struct {
+	sync.Mutex
+	byProgram map[string]map[string]bool
+}{byProgram: make(map[string]map[string]bool)}
+
+func init() {
+	options.CheckEncoder = checkEncoder
+}
+
+// Returns the set of audio encoders the given ffmpeg supports, e.g., "aac" and
+// "libmp3lame", as listed by `ffmpeg -encoders`. The result is cached.
+func SupportedEncoders(ctx context.Context, ffmpeg string) (map[string]bool, error) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	if set, ok := encoders.byProgram[ffmpeg]; ok {
+		return set, nil
+	}
+	output, err := runOutput(ctx, DefaultRunner, []string{ffmpeg, "-hide_banner", "-encoders"})
+	if err != nil {
+		return nil, fmt.Errorf("listing encoders failed: %w", err)
+	}
+	set := parseEncoders(output)
+	if len(set) == 0 {
+		return nil, fmt.Errorf("ffmpeg listed no audio encoders")
+	}
+	encoders.byProgram[ffmpeg] = set
+	return set, nil
+}
+
+// Parses the audio encoders out of `ffmpeg -encoders`. The list follows a
+// legend ending in a line of dashes, each line being the capability flags,
+// the name, and a description, e.g.:
+//
+//	A....D aac                  AAC (Advanced Audio Coding)
+func parseEncoders(output []byte) map[string]bool {
+	set := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if !listing {
+			listing = strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 && strings.HasPrefix(fields[0], "A") {
+			set[fields[1]] = true
+		}
+	}
+	return set
+}
+
+// Implements options.CheckEncoder. If ffmpeg can't be asked, the codec is let
+// through, leaving the error for CheckFFmpeg or the conversion to report.
+func checkEncoder(opts *options.GlobalOptions, codec string) error {
+	if codec == "copy" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), encodersTimeout)
+	defer cancel()
+	ffmpeg := program(opts)
+	set, err := SupportedEncoders(ctx, ffmpeg)
+	if err != nil || set[codec] {
+		return nil
+	}
+	err = fmt.Errorf("ffmpeg %q has no audio encoder %q", ffmpeg, codec)
+	if matches := closeMatches(codec, set); len(matches) > 0 {
+		err = fmt.Errorf("%w, did you mean %s?", err, strings.Join(matches, " or "))
+	}
+	return err
+}
+
+// Returns up to three names from set that look like a misspelling of name, or
+// that one contains the other, closest first.
+func closeMatches(name string, set map[string]bool) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	for candidate := range set {
+		d := editDistance(name, candidate)
+		if d <= max(2, len(name)/3) || strings.Contains(candidate, name) || strings.Contains(name, candidate) {
+			matches = append(matches, match{candidate, d})
+		}
+	}
+	slices.SortFunc(matches, func(a, b match) int {
+		return cmp.Or(cmp.Compare(a.distance, b.distance), strings.Compare(a.name, b.name))
+	})
+	var names []string
+	for _, m := range matches[:min(len(matches), 3)] {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// Returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
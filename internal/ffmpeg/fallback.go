@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"bytes"
+	"errors"
+	"os"
+)
+
+// Substrings of ffmpeg's output that indicate it choked on the cover art rather
+// than the audio. Cover art is usually the second stream of the input, hence
+// the 0:1 business. Add to this as new ones are encountered in the wild.
+var CoverArtErrors = []string{
+	"[mjpeg @",
+	"[png @",
+	"[bmp @",
+	"Could not find codec parameters for stream 1 (Video",
+	"Error while decoding stream #0:1",
+	"Error initializing output stream 0:1",
+	"Error while opening encoder for output stream #0:1",
+}
+
+// Returns true if output contains one of CoverArtErrors.
+func IsCoverArtError(output []byte) bool {
+	for _, s := range CoverArtErrors {
+		if bytes.Contains(output, []byte(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function that runs a conversion and returns its captured output.
+type ConvertFunc func(*options.ConverterOptions) ([]byte, error)
+
+// Runs convert with opts. If that fails and the output looks like a cover art
+// problem, the conversion is retried once without the cover art. Returns the
+// output of the last attempt and whether the cover art was dropped.
+func ConvertWithArtFallback(opts *options.ConverterOptions, convert ConvertFunc) ([]byte, bool, error) {
+	_, err := os.Stat(opts.OutputFile)
+	existed := !errors.Is(err, os.ErrNotExist)
+
+	output, err := convert(opts)
+	if err == nil || !IsCoverArtError(output) {
+		return output, false, err
+	}
+
+	logging.Verbosef("Retrying %q without cover art after error: %v", opts.InputFile, err)
+	retry := *opts
+	retry.CoverArtFormat = "none"
+	if !existed {
+		// Whatever is there now is the remains of the first attempt.
+		retry.Overwrite = true
+		retry.NoClobber = false
+	}
+	output, err = convert(&retry)
+	return output, err == nil, err
+}
@@ -3,10 +3,29 @@
 package ffmpeg
 
 import (
+	"audio_converter/internal/filesystem"
+	"audio_converter/internal/logging"
 	"audio_converter/internal/options"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
 	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestIsMediaFile(t *testing.T) {
@@ -20,18 +39,52 @@ func TestIsMediaFile(t *testing.T) {
 	}
 }
 
+func TestMediaExtensions(t *testing.T) {
+	m := NewMediaExtensions([]string{".ape", ".wv"}, []string{".wav"})
+	for _, name := range []string{"a.flac", "b/c.ape", "d.wv", "e.mp3"} {
+		if !m.IsMediaFile(name) {
+			t.Errorf("%q should be a media file", name)
+		}
+	}
+	for _, name := range []string{"stems/vocals.wav", "cover.jpg", "ape"} {
+		if m.IsMediaFile(name) {
+			t.Errorf("%q should not be a media file", name)
+		}
+	}
+	if !IsMediaFile("a.wav") || IsMediaFile("a.ape") {
+		t.Errorf("InputExtensions should be left alone")
+	}
+	var defaults MediaExtensions
+	if !defaults.IsMediaFile("a.wav") || defaults.IsMediaFile("a.ape") {
+		t.Errorf("A nil MediaExtensions should be the same as IsMediaFile")
+	}
+}
+
+func TestIsLossy(t *testing.T) {
+	for _, name := range []string{"a.mp3", "b/c.m4a", "ring.m4r"} {
+		if !IsLossy(name) {
+			t.Errorf("%q should be lossy", name)
+		}
+	}
+	for _, name := range []string{"a.flac", "b/c.wav", "d.aiff", "cover.jpg"} {
+		if IsLossy(name) {
+			t.Errorf("%q should not be lossy", name)
+		}
+	}
+}
+
 func TestMakeCmd(t *testing.T) {
 	assert := func(t *testing.T, flag, arg string, opts *options.ConverterOptions) {
-		if cmd := makeCmd(t.Context(), opts); cmd == nil {
+		if cmd := makeCmd(opts); cmd == nil {
 			t.Errorf("makeCmd failed on ConverterOptions: %+v", opts)
-		} else if i := slices.Index(cmd.Args, flag); i == -1 && flag != "" {
+		} else if i := slices.Index(cmd, flag); i == -1 && flag != "" {
 			t.Errorf("makeCmd didn't add flag %q", flag)
 		} else if arg != "" && flag != "" {
-			t.Logf("args: %+v expected: %s %s", cmd.Args, flag, arg)
-			if len(cmd.Args) < i+1 {
+			t.Logf("args: %+v expected: %s %s", cmd, flag, arg)
+			if len(cmd) < i+1 {
 				t.Errorf("makeCmd didn't add a value for %q", flag)
-			} else if cmd.Args[i+1] != arg {
-				t.Errorf("makeCmd used %s %s instead of %s %s", flag, cmd.Args[i+1], flag, arg)
+			} else if cmd[i+1] != arg {
+				t.Errorf("makeCmd used %s %s instead of %s %s", flag, cmd[i+1], flag, arg)
 			}
 		}
 	}
@@ -49,8 +102,243 @@ func TestMakeCmd(t *testing.T) {
 		assert(t, "-ac", strconv.Itoa(i), &options.ConverterOptions{Channels: i})
 		assert(t, "-ar", strconv.Itoa(i), &options.ConverterOptions{SampleRate: i})
 	}
+	assert(t, "-vn", "", &options.ConverterOptions{CoverArtFormat: "none"})
+	if cmd := makeCmd(&options.ConverterOptions{CoverArtFormat: "none"}); slices.Contains(cmd, "-c:v") {
+		t.Errorf("makeCmd added -c:v when dropping cover art: %+v", cmd)
+	}
+	sidecar := &options.ConverterOptions{InputFile: "song.flac", ArtFile: "cover.jpg", CoverArtFormat: "copy"}
+	if cmd := makeCmd(sidecar); !slices.Equal(cmd[1:14], []string{
+		"-nostdin", "-i", "song.flac", "-i", "cover.jpg",
+		"-map_metadata", "0", "-map", "0:a", "-map", "1:v", "-disposition:v", "attached_pic",
+	}) {
+		t.Errorf("makeCmd didn't give both inputs before the output options: %+v", cmd)
+	}
+	if cmd := makeCmd(&options.ConverterOptions{InputFile: "song.flac", ArtFile: "cover.jpg", CoverArtFormat: "none"}); slices.Contains(cmd, "cover.jpg") {
+		t.Errorf("makeCmd used the art file when dropping cover art: %+v", cmd)
+	}
 	assert(t, "-y", "", &options.ConverterOptions{GlobalOptions: options.GlobalOptions{NoClobber: false, Overwrite: true}})
 	assert(t, "-n", "", &options.ConverterOptions{GlobalOptions: options.GlobalOptions{NoClobber: true, Overwrite: false}})
+	trim := &options.ConverterOptions{InputFile: "song.flac", OutputFile: "ringtone.m4r", Start: 90500 * time.Millisecond, Duration: 30 * time.Second}
+	if cmd := makeCmd(trim); !slices.Equal(cmd[2:6], []string{"-ss", "90.5", "-i", "song.flac"}) {
+		t.Errorf("makeCmd didn't put -ss before the input: %+v", cmd)
+	} else if i := slices.Index(cmd, "-t"); i < 6 || cmd[i+1] != "30" {
+		t.Errorf("makeCmd didn't put -t after the input: %+v", cmd)
+	}
+	accel := &options.ConverterOptions{InputFile: "song.flac", OutputFile: "song.m4a", Start: time.Second, HWAccel: "vaapi", Threads: 2}
+	if cmd := makeCmd(accel); !slices.Equal(cmd[1:10], []string{"-nostdin", "-hwaccel", "vaapi", "-threads", "2", "-ss", "1", "-i", "song.flac"}) {
+		t.Errorf("makeCmd didn't put -hwaccel and -threads before the input: %+v", cmd)
+	} else if n := len(cmd); !slices.Equal(cmd[n-3:], []string{"-threads", "2", "song.m4a"}) {
+		t.Errorf("makeCmd didn't put -threads before the output: %+v", cmd)
+	}
+	if cmd := makeCmd(&options.ConverterOptions{}); slices.Contains(cmd, "-hwaccel") || slices.Contains(cmd, "-threads") {
+		t.Errorf("makeCmd added -hwaccel or -threads without them: %+v", cmd)
+	}
+	assert(t, "-use_editlist", "1", &options.ConverterOptions{Codec: "aac", Gapless: true})
+	assert(t, "-movflags", "+faststart", &options.ConverterOptions{Codec: "aac_at", Gapless: true})
+	assert(t, "-write_xing", "1", &options.ConverterOptions{Codec: "libmp3lame", Gapless: true})
+	for _, opts := range []*options.ConverterOptions{
+		{Codec: "aac"},
+		{Codec: "flac", Gapless: true},
+	} {
+		if cmd := makeCmd(opts); slices.Contains(cmd, "-use_editlist") || slices.Contains(cmd, "-write_xing") {
+			t.Errorf("makeCmd added -gapless arguments for %+v: %+v", opts, cmd)
+		}
+	}
+	if cmd := makeCmd(&options.ConverterOptions{Codec: "aac", Gapless: true, OutputFile: "-", OutputExtensions: AacOptions.OutputExtensions}); slices.Contains(cmd, "+faststart") {
+		t.Errorf("makeCmd used faststart for a pipe: %+v", cmd)
+	}
+	fades := &options.ConverterOptions{Duration: 30 * time.Second, FadeIn: 0.5, FadeOut: 2}
+	assert(t, "-af", "afade=t=in:d=0.5,afade=t=out:st=28:d=2", fades)
+	if cmd := makeCmd(&options.ConverterOptions{}); slices.Contains(cmd, "-af") {
+		t.Errorf("makeCmd added -af without any filters: %+v", cmd)
+	}
+	if cmd := makeCmd(&options.ConverterOptions{InputFile: "-", OutputFile: "song.flac"}); cmd[3] != "pipe:0" || cmd[len(cmd)-1] != "song.flac" || slices.Contains(cmd, "-f") {
+		t.Errorf("makeCmd didn't read stdin as pipe:0: %+v", cmd)
+	}
+	for _, opts := range []*options.ConverterOptions{
+		{InputFile: "song.wav", OutputFile: "-", OutputExtensions: FlacOptions.OutputExtensions},
+		{InputFile: "song.wav", OutputFile: "-", OutputExtensions: AacOptions.OutputExtensions, PipeFormat: "flac"},
+	} {
+		cmd := makeCmd(opts)
+		if n := len(cmd); cmd[n-1] != "pipe:1" || !slices.Equal(cmd[n-3:n-1], []string{"-f", "flac"}) {
+			t.Errorf("makeCmd didn't write stdout as pipe:1 with -f flac: %+v", cmd)
+		}
+	}
+	for ffmpeg, want := range map[string]string{"": "ffmpeg", "/usr/local/ffmpeg/bin/ffmpeg": "/usr/local/ffmpeg/bin/ffmpeg"} {
+		if cmd := makeCmd(&options.ConverterOptions{GlobalOptions: options.GlobalOptions{FFmpeg: ffmpeg}}); cmd[0] != want {
+			t.Errorf("makeCmd ran %q for -ffmpeg %q, expected %q", cmd[0], ffmpeg, want)
+		}
+	}
+}
+
+func TestCheckFFmpeg(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := CheckFFmpeg(t.Context(), &options.GlobalOptions{FFmpeg: missing}); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected an error naming the missing ffmpeg: %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffmpeg")
+	}
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\necho 'ffmpeg version 7.1 Copyright (c) 2000-2024 the FFmpeg developers'\necho 'built with gcc'\n"
+	if err := os.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := Version(t.Context(), fake); err != nil {
+		t.Error(err)
+	} else if version != "ffmpeg version 7.1 Copyright (c) 2000-2024 the FFmpeg developers" {
+		t.Errorf("Bad version %q", version)
+	}
+	if err := CheckFFmpeg(t.Context(), &options.GlobalOptions{FFmpeg: fake}); err != nil {
+		t.Error(err)
+	}
+	if err := os.Chmod(fake, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFFmpeg(t.Context(), &options.GlobalOptions{FFmpeg: fake}); err == nil {
+		t.Error("Expected an error for an ffmpeg that isn't executable")
+	}
+}
+
+func TestCheckStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffprobe")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte("#!/bin/sh\necho 120.000000\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options.ConverterOptions{GlobalOptions: options.GlobalOptions{FFmpeg: filepath.Join(dir, "ffmpeg")}, InputFile: "song.flac"}
+	opts.Start = 90 * time.Second
+	if err := checkStart(t.Context(), opts); err != nil {
+		t.Error(err)
+	}
+	opts.Start = 2 * time.Minute
+	if err := checkStart(t.Context(), opts); err == nil {
+		t.Error("Expected an error for a -start past the end")
+	}
+	// Without ffprobe, it's left to ffmpeg.
+	opts.FFmpeg = filepath.Join(t.TempDir(), "ffmpeg")
+	if err := checkStart(t.Context(), opts); err != nil {
+		t.Errorf("Expected no error without ffprobe: %v", err)
+	}
+}
+
+// Trimmed down output of `ffmpeg -hide_banner -encoders`.
+const encodersOutput = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D mjpeg                MJPEG (Motion JPEG)
+ V....D png                  PNG (Portable Network Graphics) image
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D alac                 ALAC (Apple Lossless Audio Codec)
+ A....D flac                 FLAC (Free Lossless Audio Codec)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+ A....D libopus              libopus Opus (codec opus)
+ S..... srt                  SubRip subtitle
+`
+
+func TestParseEncoders(t *testing.T) {
+	set := parseEncoders([]byte(encodersOutput))
+	want := []string{"aac", "alac", "flac", "libmp3lame", "libopus"}
+	if got := slices.Sorted(maps.Keys(set)); !slices.Equal(got, want) {
+		t.Errorf("Bad encoders:\n got: %q\nwant: %q", got, want)
+	}
+}
+
+func TestCloseMatches(t *testing.T) {
+	set := parseEncoders([]byte(encodersOutput))
+	for name, want := range map[string][]string{
+		"libfdk_aac": {"aac"},
+		"libmp3lam":  {"libmp3lame"},
+		"flca":       {"flac"},
+		"vorbis":     nil,
+	} {
+		if got := closeMatches(name, set); !slices.Equal(got, want) {
+			t.Errorf("Bad matches for %q: %q, expected %q", name, got, want)
+		}
+	}
+}
+
+func TestCheckEncoder(t *testing.T) {
+	// Without an ffmpeg to ask, anything goes.
+	missing := &options.GlobalOptions{FFmpeg: filepath.Join(t.TempDir(), "ffmpeg")}
+	if err := checkEncoder(missing, "libfdk_aac"); err != nil {
+		t.Errorf("Should skip the check without ffmpeg: %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffmpeg")
+	}
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\ncat <<'EOF'\n" + encodersOutput + "EOF\n"
+	if err := os.WriteFile(fake, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options.GlobalOptions{FFmpeg: fake}
+	for _, codec := range []string{"aac", "libmp3lame", "copy"} {
+		if err := checkEncoder(opts, codec); err != nil {
+			t.Errorf("Rejected %q: %v", codec, err)
+		}
+	}
+	if err := checkEncoder(opts, "libfdk_aac"); err == nil || !strings.Contains(err.Error(), "did you mean aac?") {
+		t.Errorf("Expected a suggestion for libfdk_aac: %v", err)
+	}
+	if err := checkEncoder(opts, "png"); err == nil {
+		t.Error("Video encoders should be rejected")
+	}
+	// Cached, so it doesn't have to run again.
+	if err := os.Remove(fake); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEncoder(opts, "libfdk_aac"); err == nil {
+		t.Error("The encoders should be cached")
+	}
+}
+
+func TestFormats(t *testing.T) {
+	if names := Names(); !slices.Equal(names, []string{"flac", "m4a", "m4r", "mp3"}) {
+		t.Errorf("Bad names: %q", names)
+	}
+	for name, want := range map[string]string{"m4a": "m4a", ".m4r": "m4a", "flac": "flac", "wav": "wav", ".aiff": "aiff"} {
+		if f, ok := Lookup(name); !ok || f.Name != want {
+			t.Errorf("Lookup(%q) = %+v, expected %s", name, f, want)
+		}
+	}
+	if f, ok := Lookup("ogg"); ok {
+		t.Errorf("Lookup found an unknown format: %+v", f)
+	}
+	if !slices.Equal(AacOptions.OutputExtensions, []string{".m4a", ".m4r"}) || !slices.Equal(Mp3Options.InputExtensions, InputExtensions) {
+		t.Errorf("Register didn't fill in the default options: %+v", AacOptions)
+	}
+	for _, name := range []string{"song.wav", "song.flac"} {
+		if !IsMediaFile(name) || IsLossy(name) {
+			t.Errorf("%s should be a lossless media file", name)
+		}
+	}
+	if !IsLossy("song.m4r") {
+		t.Error("song.m4r should be lossy")
+	}
+	for _, f := range []Format{
+		{Name: "flac", Extensions: []string{".fla"}},
+		{Name: "alac", Extensions: []string{".m4a"}},
+		{Name: "none"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register should panic for %+v", f)
+				}
+			}()
+			Register(f)
+		}()
+	}
 }
 
 func TestGetDefaultOptions(t *testing.T) {
@@ -69,3 +357,852 @@ func TestGetDefaultOptions(t *testing.T) {
 	assert(FlacOptions)
 	assert(Mp3Options)
 }
+
+func TestOutputExtensions(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "song.wav")
+	for _, tc := range []struct {
+		name     string
+		defaults *options.ConverterOptions
+		good     []string
+		bad      []string
+	}{
+		{"to_aac", AacOptions, []string{"song.m4a", "ringtone.m4r", "SONG.M4A"}, []string{"song.mp3", "song.flac", "song.aac"}},
+		{"to_flac", FlacOptions, []string{"song.flac", "Song.FLAC"}, []string{"song.m4a", "song.wav"}},
+		{"to_mp3", Mp3Options, []string{"song.mp3"}, []string{"song.m4a", "song.mp2"}},
+	} {
+		for _, output := range tc.good {
+			if opts, _ := options.NewConverterOptions([]string{tc.name, input, output}, tc.defaults); opts == nil || opts.OutputFile != output {
+				t.Errorf("%s should write %q", tc.name, output)
+			}
+		}
+		for _, output := range tc.bad {
+			if opts, _ := options.NewConverterOptions([]string{tc.name, input, output}, tc.defaults); opts != nil {
+				t.Errorf("%s should refuse to write %q", tc.name, output)
+			} else if opts, _ := options.NewConverterOptions([]string{tc.name, "-force-ext", input, output}, tc.defaults); opts == nil {
+				t.Errorf("%s should write %q with -force-ext", tc.name, output)
+			}
+		}
+		// Without an extension, the format's first is added.
+		want := "song" + tc.defaults.OutputExtensions[0]
+		if opts, _ := options.NewConverterOptions([]string{tc.name, input, "song"}, tc.defaults); opts == nil || opts.OutputFile != want || opts.AddedExt() == "" {
+			t.Errorf("%s should write %q for song", tc.name, want)
+		}
+	}
+}
+
+func TestConvertLogsOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffmpeg")
+	}
+	dir := t.TempDir()
+	// Reports on stderr the way ffmpeg does, then writes the output, its last
+	// argument.
+	script := "#!/bin/sh\necho 'Stream #0:0: Audio: flac' >&2\nprintf 'size=1kB\\rsize=2kB\\n' >&2\nfor last; do :; done\necho converted > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "test.log")
+	if err := logging.Initialize(t.Context(), log, slog.LevelInfo, logging.FormatText, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logging.Initialize(t.Context(), "", slog.LevelInfo, logging.FormatText, false) })
+	opts := &options.ConverterOptions{
+		GlobalOptions: options.GlobalOptions{FFmpeg: filepath.Join(dir, "ffmpeg"), Quiet: true},
+		InputFile:     filepath.Join(dir, "song.flac"),
+		OutputFile:    filepath.Join(dir, "song.m4a"),
+	}
+	if err := Convert(t.Context(), opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{opts.InputFile + ": Stream #0:0: Audio: flac\n", opts.InputFile + ": size=2kB\n"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("Expected %q in the log: %q", line, data)
+		}
+	}
+	if strings.Contains(string(data), "size=1kB") {
+		t.Errorf("Progress that was drawn over shouldn't be logged: %q", data)
+	}
+}
+
+// Makes rec the DefaultRunner until the test is done.
+func useRunner(t *testing.T, rec *Recorder) *Recorder {
+	old := DefaultRunner
+	DefaultRunner = rec
+	t.Cleanup(func() { DefaultRunner = old })
+	return rec
+}
+
+func TestConvertRunner(t *testing.T) {
+	dir := t.TempDir()
+	opts := &options.ConverterOptions{
+		GlobalOptions: options.GlobalOptions{FFmpeg: "ffmpeg", Quiet: true},
+		InputFile:     filepath.Join(dir, "song.flac"),
+		OutputFile:    filepath.Join(dir, "song.m4a"),
+	}
+	failure := errors.New("exit status 1")
+	rec := useRunner(t, &Recorder{Fake: func(ctx context.Context, name string, args []string, stdio Stdio) error {
+		fmt.Fprintln(stdio.Stderr, "Invalid data found when processing input")
+		return failure
+	}})
+	if err := Convert(t.Context(), opts); !errors.Is(err, failure) {
+		t.Errorf("Expected ffmpeg's error: %v", err)
+	}
+	calls := rec.Calls()
+	if len(calls) != 1 || calls[0][0] != "ffmpeg" || !slices.Contains(calls[0], opts.InputFile) {
+		t.Fatalf("Expected ffmpeg to be run on the input: %q", calls)
+	}
+	// Whether to replace the output was settled before running ffmpeg, so it
+	// mustn't ask.
+	if !slices.Contains(calls[0], "-y") {
+		t.Errorf("Expected -y: %q", calls[0])
+	}
+
+	// An existing output is kept with -n, without running ffmpeg at all.
+	if err := os.WriteFile(opts.OutputFile, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.NoClobber = true
+	if err := Convert(t.Context(), opts); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("Expected ErrOutputExists: %v", err)
+	}
+	if calls := rec.Calls(); len(calls) != 1 {
+		t.Errorf("ffmpeg shouldn't have been run for an existing output: %q", calls[1:])
+	}
+
+	// Otherwise, ffmpeg still gets -n, in case the output shows up before it
+	// opens it.
+	if err := os.Remove(opts.OutputFile); err != nil {
+		t.Fatal(err)
+	}
+	Convert(t.Context(), opts)
+	if calls := rec.Calls(); len(calls) != 2 || !slices.Contains(calls[1], "-n") || slices.Contains(calls[1], "-y") {
+		t.Errorf("Expected -n: %q", calls[1:])
+	}
+}
+
+func TestConvertFiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := &options.ConverterOptions{
+		GlobalOptions:    options.GlobalOptions{FFmpeg: "ffmpeg", Quiet: true, NoClobber: true},
+		OutputFile:       dir,
+		OutputExtensions: []string{".flac"},
+		MaxJobs:          2,
+	}
+	for _, name := range []string{"a.wav", "bad.wav", "c.wav", "old.wav"} {
+		opts.InputFiles = append(opts.InputFiles, filepath.Join(dir, name))
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old.flac"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("exit status 1")
+	rec := useRunner(t, &Recorder{Fake: func(ctx context.Context, name string, args []string, stdio Stdio) error {
+		if name == "ffmpeg" && slices.Contains(args, filepath.Join(dir, "bad.wav")) {
+			return failure
+		}
+		return nil
+	}})
+	err := ConvertFiles(t.Context(), opts)
+	if err == nil || !strings.Contains(err.Error(), "bad.wav") || strings.Contains(err.Error(), "c.wav") {
+		t.Errorf("Expected only bad.wav to be listed as failed: %v", err)
+	}
+	var outputs []string
+	for _, call := range rec.Calls() {
+		if call[0] == "ffmpeg" {
+			outputs = append(outputs, filepath.Base(call[len(call)-1]))
+		}
+	}
+	slices.Sort(outputs)
+	// The existing output is skipped with -n, and the failure doesn't stop
+	// the rest.
+	if !slices.Equal(outputs, []string{"a.flac", "bad.flac", "c.flac"}) {
+		t.Errorf("Expected a, bad, and c to be converted: %q", outputs)
+	}
+
+	// Without failures, the skipped file isn't an error.
+	opts.InputFiles = slices.DeleteFunc(opts.InputFiles, func(name string) bool { return strings.HasSuffix(name, "bad.wav") })
+	if err := ConvertFiles(t.Context(), opts); err != nil {
+		t.Errorf("Expected success: %v", err)
+	}
+}
+
+func TestExtractCoverArtRunner(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "cover.jpg")
+	if err := os.WriteFile(output, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := useRunner(t, &Recorder{})
+	opts := &options.ExtracterOptions{InputFile: filepath.Join(dir, "song.flac"), OutputFile: output}
+	opts.NoClobber = true
+	if err := ExtractCoverArt(t.Context(), opts); err != nil {
+		t.Errorf("An existing output should be skipped with -n: %v", err)
+	} else if calls := rec.Calls(); len(calls) != 0 {
+		t.Errorf("ffmpeg shouldn't have been run with -n: %q", calls)
+	}
+	opts.NoClobber, opts.Overwrite = false, true
+	if err := ExtractCoverArt(t.Context(), opts); err != nil {
+		t.Fatal(err)
+	}
+	if calls := rec.Calls(); len(calls) != 1 || !slices.Contains(calls[0], "-y") || calls[0][len(calls[0])-1] != output {
+		t.Errorf("Expected ffmpeg to replace the output with -y: %q", calls)
+	}
+}
+
+func TestConvertInBackgroundWith(t *testing.T) {
+	rec := &Recorder{Fake: func(ctx context.Context, name string, args []string, stdio Stdio) error {
+		fmt.Fprint(stdio.Stdout, "out ")
+		fmt.Fprint(stdio.Stderr, "err")
+		return nil
+	}}
+	opts := &options.ConverterOptions{InputFile: "song.flac", OutputFile: "song.m4a"}
+	if output, err := ConvertInBackgroundWith(t.Context(), rec, opts); err != nil || string(output) != "out err" {
+		t.Errorf("Expected both outputs: %q err: %v", output, err)
+	}
+	if calls := rec.Calls(); len(calls) != 1 || !slices.Equal(calls[0], CommandLine(opts)) {
+		t.Errorf("Expected the command line of the conversion: %q", calls)
+	}
+	// Nothing is run once the context is done.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := ConvertInBackgroundWith(ctx, rec, opts); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the context's error: %v", err)
+	}
+}
+
+func TestConvertInBackgroundKilled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffmpeg")
+	}
+	dir := t.TempDir()
+	// Reports some progress, then hangs in a child that holds on to the output
+	// after the script is killed.
+	script := "#!/bin/sh\necho 'size=1kB' >&2\nsleep 60\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options.ConverterOptions{
+		GlobalOptions: options.GlobalOptions{FFmpeg: filepath.Join(dir, "ffmpeg")},
+		InputFile:     filepath.Join(dir, "song.flac"),
+		OutputFile:    filepath.Join(dir, "song.m4a"),
+	}
+	defer func(d time.Duration) { waitDelay = d }(waitDelay)
+	waitDelay = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	output, err := ConvertInBackground(ctx, opts)
+	if err == nil {
+		t.Error("Expected the hung ffmpeg to be killed")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Waited %v for the killed ffmpeg", elapsed)
+	}
+	if !strings.Contains(string(output), "size=1kB") {
+		t.Errorf("The output from before it was killed should be kept: %q", output)
+	}
+}
+
+func TestConvertWithArtFallback(t *testing.T) {
+	artErr := []byte("[mjpeg @ 0x0] unable to decode APP fields\nError while decoding stream #0:1: Invalid data found when processing input\n")
+	failure := errors.New("exit status 1")
+
+	// Fake that fails with an art error until the art is dropped, then returns
+	// whatever result was requested for the retry.
+	fake := func(calls *[]string, retryErr error) ConvertFunc {
+		return func(opts *options.ConverterOptions) ([]byte, error) {
+			*calls = append(*calls, opts.CoverArtFormat)
+			if opts.CoverArtFormat != "none" {
+				return artErr, failure
+			}
+			return nil, retryErr
+		}
+	}
+	opts := &options.ConverterOptions{
+		InputFile:      "input.flac",
+		OutputFile:     filepath.Join(t.TempDir(), "output.m4a"),
+		CoverArtFormat: "copy",
+	}
+
+	t.Run("retry succeeds", func(t *testing.T) {
+		var calls []string
+		_, dropped, err := ConvertWithArtFallback(opts, fake(&calls, nil))
+		if err != nil || !dropped {
+			t.Errorf("Expected success without art: dropped: %v err: %v", dropped, err)
+		}
+		if !slices.Equal(calls, []string{"copy", "none"}) {
+			t.Errorf("Bad attempts: %+v", calls)
+		}
+	})
+	t.Run("retry fails", func(t *testing.T) {
+		var calls []string
+		_, dropped, err := ConvertWithArtFallback(opts, fake(&calls, failure))
+		if err == nil || dropped {
+			t.Errorf("Expected failure: dropped: %v err: %v", dropped, err)
+		}
+		if len(calls) != 2 {
+			t.Errorf("Expected exactly one retry: %+v", calls)
+		}
+	})
+	t.Run("not an art error", func(t *testing.T) {
+		var calls []string
+		_, dropped, err := ConvertWithArtFallback(opts, func(opts *options.ConverterOptions) ([]byte, error) {
+			calls = append(calls, opts.CoverArtFormat)
+			return []byte("input.flac: No such file or directory"), failure
+		})
+		if err == nil || dropped || len(calls) != 1 {
+			t.Errorf("Should not have retried: calls: %+v dropped: %v err: %v", calls, dropped, err)
+		}
+	})
+	if opts.CoverArtFormat != "copy" {
+		t.Errorf("ConvertWithArtFallback modified the caller's options")
+	}
+}
+
+func TestFindSidecarArt(t *testing.T) {
+	encode := func(width, height int, asPNG bool) *fstest.MapFile {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		var b bytes.Buffer
+		var err error
+		if asPNG {
+			err = png.Encode(&b, img)
+		} else {
+			err = jpeg.Encode(&b, img, nil)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &fstest.MapFile{Data: b.Bytes()}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		files    fstest.MapFS
+		expected string
+	}{
+		{
+			name: "largest wins",
+			files: fstest.MapFS{
+				"album/cover.jpg":  encode(10, 10, false),
+				"album/folder.jpg": encode(30, 30, false),
+				"album/Front.PNG":  encode(20, 20, true),
+			},
+			expected: "album/folder.jpg",
+		},
+		{
+			name: "ties go to preferred name",
+			files: fstest.MapFS{
+				"album/front.png":  encode(20, 20, true),
+				"album/folder.jpg": encode(20, 20, false),
+				"album/cover.jpg":  encode(20, 20, false),
+			},
+			expected: "album/cover.jpg",
+		},
+		{
+			name: "broken and unrelated images are ignored",
+			files: fstest.MapFS{
+				"album/cover.jpg":   {Data: []byte("not a jpeg")},
+				"album/back.jpg":    encode(50, 50, false),
+				"album/folder.png":  encode(5, 5, true),
+				"album/song.flac":   {Data: []byte("fLaC")},
+				"album/scans/a.jpg": encode(50, 50, false),
+			},
+			expected: "album/folder.png",
+		},
+		{
+			name: "none",
+			files: fstest.MapFS{
+				"album/song.flac": {Data: []byte("fLaC")},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			art, err := FindSidecarArt(tc.files, "album")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if art == nil {
+				if tc.expected != "" {
+					t.Errorf("Expected %q, found nothing", tc.expected)
+				}
+				return
+			}
+			if art.Name != tc.expected {
+				t.Errorf("Chose %q (%dx%d) expected %q", art.Name, art.Width, art.Height, tc.expected)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := &options.ConverterOptions{Codec: "aac", BitRate: "256k", InputFile: "a.flac", OutputFile: "a.m4a"}
+	b := &options.ConverterOptions{Codec: "aac", BitRate: "256k", InputFile: "b.flac", OutputFile: "b.m4a"}
+	a.Overwrite = true
+	b.NoClobber = true
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Errorf("Files and clobbering should not affect the fingerprint: %q != %q", Fingerprint(a), Fingerprint(b))
+	}
+	b.BitRate = "128k"
+	if Fingerprint(a) == Fingerprint(b) {
+		t.Errorf("Bit rate should affect the fingerprint: %q", Fingerprint(a))
+	}
+}
+
+func TestTargetBitRate(t *testing.T) {
+	limits := BitRateRange{32_000, 320_000}
+	for _, tc := range []struct {
+		size     int64
+		duration time.Duration
+		overhead float64
+		expected int64
+	}{
+		// 10 MB of 5 minutes is 266,666 bps.
+		{10_000_000, 5 * time.Minute, 0, 266_666},
+		{10_000_000, 5 * time.Minute, 0.1, 240_000},
+		// A 10 hour audiobook in 700 MiB.
+		{700 << 20, 10 * time.Hour, TargetSizeOverhead, 158_218},
+		// Too roomy and too tight are clamped.
+		{100_000_000, time.Minute, 0, 320_000},
+		{1_000_000, time.Hour, 0, 32_000},
+	} {
+		actual, err := TargetBitRate(tc.size, tc.duration, tc.overhead, limits)
+		if err != nil {
+			t.Errorf("%d bytes in %v: %v", tc.size, tc.duration, err)
+		} else if actual != tc.expected {
+			t.Errorf("%d bytes in %v with %.2f overhead: actual: %d expected: %d", tc.size, tc.duration, tc.overhead, actual, tc.expected)
+		}
+	}
+	for _, bad := range []struct {
+		size     int64
+		duration time.Duration
+		overhead float64
+	}{
+		{1000, 0, 0},
+		{0, time.Minute, 0},
+		{1000, time.Minute, 1},
+		{1000, time.Minute, -0.1},
+	} {
+		if _, err := TargetBitRate(bad.size, bad.duration, bad.overhead, limits); err == nil {
+			t.Errorf("Failed to reject %+v", bad)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	if d, err := parseDuration([]byte("215.500000\n")); err != nil || d != 215500*time.Millisecond {
+		t.Errorf("Bad duration: %v err: %v", d, err)
+	}
+	for _, bad := range []string{"", "N/A", "-1", "0"} {
+		if _, err := parseDuration([]byte(bad)); err == nil {
+			t.Errorf("Failed to reject %q", bad)
+		}
+	}
+}
+
+func TestWriteAtomically(t *testing.T) {
+	// Writes the output, then returns err.
+	fake := func(err error) func(*options.ConverterOptions) error {
+		return func(opts *options.ConverterOptions) error {
+			if !filesystem.IsTempName(opts.OutputFile) || filepath.Ext(opts.OutputFile) != ".m4a" || !opts.Overwrite || opts.NoClobber {
+				t.Errorf("Should write to a temporary file with -y: %+v", opts)
+			}
+			if werr := os.WriteFile(opts.OutputFile, []byte("converted"), 0644); werr != nil {
+				t.Fatal(werr)
+			}
+			return err
+		}
+	}
+	never := func(*options.ConverterOptions) error {
+		t.Error("Should not have converted")
+		return nil
+	}
+	answer := func(yes bool) func(string) bool {
+		return func(string) bool { return yes }
+	}
+	setup := func(t *testing.T, existing bool) *options.ConverterOptions {
+		opts := &options.ConverterOptions{InputFile: "song.flac", OutputFile: filepath.Join(t.TempDir(), "song.m4a")}
+		if existing {
+			if err := os.WriteFile(opts.OutputFile, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return opts
+	}
+	assertOutput := func(t *testing.T, opts *options.ConverterOptions, expected string) {
+		data, err := os.ReadFile(opts.OutputFile)
+		if expected == "" && !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Output should not exist: %q err: %v", data, err)
+		} else if expected != "" && string(data) != expected {
+			t.Errorf("Bad output: %q err: %v expected: %q", data, err, expected)
+		}
+		entries, err := os.ReadDir(filepath.Dir(opts.OutputFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if filesystem.IsTempName(entry.Name()) {
+				t.Errorf("Temporary file was left behind: %s", entry.Name())
+			}
+		}
+	}
+
+	t.Run("success", func(t *testing.T) {
+		opts := setup(t, false)
+		if err := writeAtomically(opts, answer(false), fake(nil)); err != nil {
+			t.Fatal(err)
+		}
+		assertOutput(t, opts, "converted")
+	})
+	t.Run("failure", func(t *testing.T) {
+		opts := setup(t, false)
+		if err := writeAtomically(opts, answer(false), fake(errors.New("exit status 1"))); err == nil {
+			t.Errorf("Expected an error")
+		}
+		assertOutput(t, opts, "")
+	})
+	t.Run("failure keeps existing", func(t *testing.T) {
+		opts := setup(t, true)
+		opts.Overwrite = true
+		if err := writeAtomically(opts, answer(false), fake(errors.New("exit status 1"))); err == nil {
+			t.Errorf("Expected an error")
+		}
+		assertOutput(t, opts, "existing")
+	})
+	t.Run("no clobber", func(t *testing.T) {
+		opts := setup(t, true)
+		opts.NoClobber = true
+		if err := writeAtomically(opts, answer(true), never); !errors.Is(err, ErrOutputExists) {
+			t.Errorf("Expected ErrOutputExists: %v", err)
+		}
+		assertOutput(t, opts, "existing")
+	})
+	t.Run("no clobber race", func(t *testing.T) {
+		opts := setup(t, false)
+		opts.NoClobber = true
+		// The output shows up while converting, e.g., from another export.
+		convert := func(topts *options.ConverterOptions) error {
+			if err := os.WriteFile(opts.OutputFile, []byte("existing"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return fake(nil)(topts)
+		}
+		if err := writeAtomically(opts, answer(true), convert); !errors.Is(err, ErrOutputExists) {
+			t.Errorf("Expected ErrOutputExists: %v", err)
+		}
+		assertOutput(t, opts, "existing")
+	})
+	t.Run("declined", func(t *testing.T) {
+		opts := setup(t, true)
+		if err := writeAtomically(opts, answer(false), never); !errors.Is(err, ErrOutputExists) {
+			t.Errorf("Expected ErrOutputExists: %v", err)
+		}
+		assertOutput(t, opts, "existing")
+	})
+	t.Run("confirmed", func(t *testing.T) {
+		opts := setup(t, true)
+		if err := writeAtomically(opts, answer(true), fake(nil)); err != nil {
+			t.Fatal(err)
+		}
+		assertOutput(t, opts, "converted")
+	})
+}
+
+func TestConfirmOverwrite(t *testing.T) {
+	for input, expected := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		if actual := confirmOverwrite("song.m4a", readAnswers(strings.NewReader(input)), &out); actual != expected {
+			t.Errorf("Answering %q: actual: %v expected: %v", input, actual, expected)
+		}
+		if !strings.Contains(out.String(), "song.m4a") {
+			t.Errorf("Prompt doesn't name the file: %q", out.String())
+		}
+	}
+
+	defer func(saved time.Duration) { promptTimeout = saved }(promptTimeout)
+	promptTimeout = 10 * time.Millisecond
+	in, w := io.Pipe()
+	defer w.Close()
+	answers := readAnswers(in)
+	if confirmOverwrite("song.m4a", answers, io.Discard) {
+		t.Error("No answer should be taken as no")
+	}
+	// The late answer goes to the next prompt, rather than being lost.
+	go io.WriteString(w, "y\n")
+	promptTimeout = time.Minute
+	if !confirmOverwrite("song.m4a", answers, io.Discard) {
+		t.Error("The next prompt should have taken the answer")
+	}
+
+	// Lines read ahead, e.g., from yes(1), answer the prompts that follow.
+	answers = readAnswers(strings.NewReader("y\nn\nyes\n"))
+	var actual []bool
+	for range 4 {
+		actual = append(actual, confirmOverwrite("song.m4a", answers, io.Discard))
+	}
+	if expected := []bool{true, false, true, false}; !slices.Equal(actual, expected) {
+		t.Errorf("Answering several prompts: actual: %v expected: %v", actual, expected)
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "song.m4a")
+	if err := os.WriteFile(existing, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	answer := func(yes bool) func(string) bool {
+		return func(string) bool { return yes }
+	}
+	for _, tc := range []struct {
+		name      string
+		global    options.GlobalOptions
+		confirmed bool
+		exists    bool
+	}{
+		{name: existing, exists: true},
+		{name: existing, confirmed: true},
+		{name: existing, global: options.GlobalOptions{Overwrite: true}},
+		{name: existing, global: options.GlobalOptions{NoClobber: true}, confirmed: true, exists: true},
+		{name: existing + ".new"},
+		{name: "-"},
+	} {
+		err := checkOutput(tc.name, &tc.global, answer(tc.confirmed))
+		if errors.Is(err, ErrOutputExists) != tc.exists {
+			t.Errorf("checkOutput(%q, %+v) with confirmed %v: %v", tc.name, tc.global, tc.confirmed, err)
+		}
+	}
+}
+
+func TestParseInputInfo(t *testing.T) {
+	type testCase struct {
+		name     string
+		output   string
+		expected InputInfo
+	}
+	for _, tc := range []testCase{
+		{
+			name: "flac with cover art",
+			output: `ffmpeg version 7.1 Copyright (c) 2000-2024 the FFmpeg developers
+Input #0, flac, from 'song.flac':
+  Metadata:
+    ARTIST          : Someone
+  Duration: 00:03:35.33, start: 0.000000, bitrate: 1021 kb/s
+  Stream #0:0: Audio: flac, 44100 Hz, stereo, s16
+  Stream #0:1: Video: mjpeg (Baseline), yuvj420p(pc, bt470bg/unknown/unknown), 500x500 [SAR 1:1 DAR 1:1], 90k tbr, 90k tbn (attached pic)
+Stream mapping:
+  Stream #0:0 -> #0:0 (flac (native) -> aac (native))
+Output #0, ipod, to 'song.m4a':
+  Stream #0:0: Audio: aac (LC), 48000 Hz, mono, fltp, 128 kb/s
+`,
+			expected: InputInfo{Codec: "flac", SampleRate: 44100, Channels: "stereo", Duration: 215330 * time.Millisecond, BitRate: "1021 kb/s"},
+		},
+		{
+			name: "m4a",
+			output: `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'song.m4a':
+  Duration: 01:02:03.50, start: 0.000000, bitrate: 260 kb/s
+  Stream #0:0[0x1](und): Audio: aac (LC) (mp4a / 0x6134706D), 48000 Hz, 5.1, fltp, 256 kb/s (default)
+`,
+			expected: InputInfo{Codec: "aac", SampleRate: 48000, Channels: "5.1", Duration: time.Hour + 2*time.Minute + 3500*time.Millisecond, BitRate: "260 kb/s"},
+		},
+		{
+			name: "unknown duration",
+			output: `Input #0, wav, from 'pipe:':
+  Duration: N/A, bitrate: N/A
+  Stream #0:0: Audio: pcm_s16le ([1][0][0][0] / 0x0001), 22050 Hz, mono, s16, 352 kb/s
+`,
+			expected: InputInfo{Codec: "pcm_s16le", SampleRate: 22050, Channels: "mono"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := ParseInputInfo([]byte(tc.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *info != tc.expected {
+				t.Errorf("Bad info:\nactual  : %+v\nexpected: %+v", *info, tc.expected)
+			}
+		})
+	}
+	if _, err := ParseInputInfo([]byte("song.flac: No such file or directory\n")); !errors.Is(err, ErrNoInputInfo) {
+		t.Errorf("Expected ErrNoInputInfo, got: %v", err)
+	}
+	info := InputInfo{Codec: "flac", SampleRate: 44100, Channels: "stereo", Duration: 215330 * time.Millisecond, BitRate: "1021 kb/s"}
+	probed, err := parseProbeInfo([]byte(`{
+    "programs": [],
+    "streams": [{"codec_name": "flac", "sample_rate": "44100", "channels": 2, "channel_layout": "stereo"}],
+    "format": {"duration": "215.330000", "bit_rate": "1021456"}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if *probed != info {
+		t.Errorf("Bad probe:\nactual  : %+v\nexpected: %+v", *probed, info)
+	}
+	if _, err := parseProbeInfo([]byte(`{"streams": [], "format": {}}`)); !errors.Is(err, ErrNoInputInfo) {
+		t.Errorf("Expected ErrNoInputInfo without an audio stream, got: %v", err)
+	}
+	if s := info.String(); s != "flac, 44100 Hz, stereo, 3m35.33s, 1021 kb/s" {
+		t.Errorf("Bad summary: %q", s)
+	}
+}
+
+func TestParseMediaInfo(t *testing.T) {
+	info, err := parseMediaInfo([]byte(`{
+    "streams": [
+        {"codec_type": "audio", "codec_name": "vorbis", "sample_rate": "48000", "channels": 2, "channel_layout": "stereo", "tags": {"TITLE": "Song", "ARTIST": "Stream"}},
+        {"codec_type": "video", "codec_name": "mjpeg"}
+    ],
+    "format": {"duration": "61.500000", "bit_rate": "160000", "tags": {"ARTIST": "Someone"}}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := InputInfo{Codec: "vorbis", SampleRate: 48000, Channels: "stereo", Duration: 61500 * time.Millisecond, BitRate: "160 kb/s"}
+	if info.InputInfo != expected {
+		t.Errorf("Bad info:\nactual  : %+v\nexpected: %+v", info.InputInfo, expected)
+	}
+	if tags := map[string]string{"title": "Song", "artist": "Someone"}; !maps.Equal(info.Tags, tags) {
+		t.Errorf("Bad tags %q, expected %q", info.Tags, tags)
+	}
+	if !info.CoverArt {
+		t.Error("A video stream should be cover art")
+	}
+	if _, err := parseMediaInfo([]byte(`{"streams": [{"codec_type": "video"}], "format": {}}`)); !errors.Is(err, ErrNoInputInfo) {
+		t.Errorf("Expected ErrNoInputInfo without an audio stream, got: %v", err)
+	}
+}
+
+func TestParseLoudness(t *testing.T) {
+	output := "[Parsed_ebur128_0 @ 0x5581] Summary:\n" +
+		"\n" +
+		"  Integrated loudness:\n" +
+		"    I:         -12.0 LUFS\n" +
+		"    Threshold: -22.3 LUFS\n" +
+		"\n" +
+		"  Loudness range:\n" +
+		"    LRA:         5.8 LU\n" +
+		"    Threshold: -32.4 LUFS\n" +
+		"    LRA low:   -16.1 LUFS\n" +
+		"    LRA high:  -10.3 LUFS\n" +
+		"\n" +
+		"  Sample peak:\n" +
+		"    Peak:       -6.0 dBFS\n" +
+		"size=N/A time=00:01:10.00 bitrate=N/A speed=412x\r" +
+		"size=N/A time=00:03:20.50 bitrate=N/A speed=415x\n"
+	l, err := parseLoudness([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Integrated != -12 || math.Abs(l.Peak-0.501187) > 1e-6 || l.Duration != 200500*time.Millisecond {
+		t.Errorf("Bad loudness: %+v", l)
+	}
+	if l.Gain() != -6 {
+		t.Errorf("Bad gain: %v", l.Gain())
+	}
+	silence := strings.Replace(strings.Replace(output, "-12.0", "-70.0", 1), "-6.0 dBFS", "-inf dBFS", 1)
+	if l, err := parseLoudness([]byte(silence)); err != nil || l.Integrated != -70 || l.Peak != 0 {
+		t.Errorf("Bad loudness for silence: %+v err: %v", l, err)
+	}
+	for _, bad := range []string{"", "No such file or directory", output[:strings.Index(output, "Sample peak")]} {
+		if _, err := parseLoudness([]byte(bad)); err == nil {
+			t.Errorf("Failed to reject %q", bad)
+		}
+	}
+}
+
+func TestAlbumLoudness(t *testing.T) {
+	// Equally long tracks 10 LU apart, so the louder one has ten times the
+	// energy of the other.
+	album := AlbumLoudness([]Loudness{
+		{Integrated: -10, Peak: 0.9, Duration: time.Minute},
+		{Integrated: -20, Peak: 0.5, Duration: time.Minute},
+	})
+	if math.Abs(album.Integrated-(-10-10*math.Log10(2/1.1))) > 1e-9 || album.Peak != 0.9 || album.Duration != 2*time.Minute {
+		t.Errorf("Bad album: %+v", album)
+	}
+	track := Loudness{Integrated: -20.5, Peak: 0.25}
+	tags := []string{"REPLAYGAIN_TRACK_GAIN=2.50 dB", "REPLAYGAIN_TRACK_PEAK=0.250000"}
+	if got := ReplayGainTags(track, nil); !slices.Equal(got, tags) {
+		t.Errorf("Bad track tags: %q", got)
+	}
+	tags = append(tags, "REPLAYGAIN_ALBUM_GAIN=-8.00 dB", "REPLAYGAIN_ALBUM_PEAK=1.000000")
+	if got := ReplayGainTags(track, &Loudness{Integrated: -10, Peak: 1}); !slices.Equal(got, tags) {
+		t.Errorf("Bad album tags: %q", got)
+	}
+}
+
+func TestEmbedCmd(t *testing.T) {
+	opts := &options.EmbedderOptions{}
+	cmd := embedCmd(opts, "cover.jpg", "song.m4a", ".song.part.m4a")
+	want := []string{
+		"-v", "error", "-nostdin", "-i", "song.m4a", "-i", "cover.jpg",
+		"-map", "0", "-map", "-0:v", "-map", "1", "-c", "copy",
+		"-disposition:v:0", "attached_pic",
+		"-metadata:s:v", "title=Album cover", "-metadata:s:v", "comment=Cover (front)",
+		"-y", ".song.part.m4a",
+	}
+	if !slices.Equal(cmd[1:], want) {
+		t.Errorf("Bad command:\n got: %q\nwant: %q", cmd[1:], want)
+	}
+	for _, tc := range []struct {
+		codec, scale, image, expected string
+	}{
+		{"", "", "cover.png", ""},
+		{"png", "", "cover.jpg", "png"},
+		{"", "500x500", "cover.jpg", "mjpeg"},
+		{"", "500x500", "cover.PNG", "png"},
+	} {
+		opts.Codec, opts.Scale = tc.codec, tc.scale
+		if codec := embedCodec(opts, tc.image); codec != tc.expected {
+			t.Errorf("embedCodec(%+v) = %q", tc, codec)
+		}
+	}
+}
+
+func TestEmbedCoverArt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script standing in for ffmpeg")
+	}
+	dir := t.TempDir()
+	// Writes "embedded" to the output, its last argument, or fails if the
+	// image is named "bad.jpg".
+	script := "#!/bin/sh\ncase \"$*\" in *bad.jpg*) echo bad image; exit 1;; esac\nfor last; do :; done\necho embedded > \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options.EmbedderOptions{GlobalOptions: options.GlobalOptions{FFmpeg: filepath.Join(dir, "ffmpeg")}}
+	audio := filepath.Join(dir, "song.m4a")
+	if err := os.WriteFile(audio, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if output, err := EmbedCoverArt(t.Context(), opts, "bad.jpg", audio); err == nil || !strings.Contains(string(output), "bad image") {
+		t.Errorf("Expected the failure with its output: %q %v", output, err)
+	} else if data, _ := os.ReadFile(audio); string(data) != "original" {
+		t.Errorf("A failure should leave the original alone: %q", data)
+	}
+	if _, err := EmbedCoverArt(t.Context(), opts, "cover.jpg", audio); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(audio); string(data) != "embedded\n" {
+		t.Errorf("Bad output: %q", data)
+	} else if st, _ := os.Stat(audio); st.Mode().Perm() != 0600 {
+		t.Errorf("The permissions should be kept: %v", st.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Temporary files should be cleaned up: %v", entries)
+	}
+}
+
+func TestCoverArtCmd(t *testing.T) {
+	opts := &options.ExtracterOptions{InputFile: "song.m4a", OutputFile: "cover.jpg"}
+	if cmd := coverArtCmd(opts); cmd[len(cmd)-1] != "cover.jpg" || slices.Contains(cmd, "image2pipe") {
+		t.Errorf("coverArtCmd didn't write cover.jpg: %+v", cmd)
+	}
+	opts.OutputFile = "-"
+	opts.Codec = "png"
+	if cmd := coverArtCmd(opts); !slices.Equal(cmd[len(cmd)-3:], []string{"-f", "image2pipe", "pipe:1"}) {
+		t.Errorf("coverArtCmd didn't write stdout as pipe:1 with -f image2pipe: %+v", cmd)
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/options"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// An audio format the tools know about. Every format can be converted from,
+// and those with DefaultOptions can be converted to.
+type Format struct {
+	Name string // As given to export_audio_tree -f, e.g., "m4a".
+	// With the dot, e.g., ".m4a". The first is what's written. The rest are
+	// aliases that -f also takes, e.g., ".m4r".
+	Extensions     []string
+	DefaultOptions *options.ConverterOptions // Nil for formats that are only read.
+	// Converting from a lossy format loses quality. Going by extension is a
+	// guess, since an .m4a may hold lossless ALAC.
+	Lossless bool
+	// The ffmpeg output options to write to a pipe, which has no extension
+	// for ffmpeg to pick the format from, e.g., -f flac.
+	PipeOptions []string
+}
+
+// Registered formats, in order.
+var formats []*Format
+
+// Extensions of every registered format, in order.
+var InputExtensions []string
+
+// The InputExtensions that are lossy. The rest are lossless.
+var LossyExtensions []string
+
+func init() {
+	Register(Format{Name: "flac", Extensions: []string{".flac"}, DefaultOptions: FlacOptions, Lossless: true, PipeOptions: []string{"-f", "flac"}})
+	// The moov atom normally goes at the end, which means seeking back to
+	// write it, so a pipe needs a fragmented file instead.
+	Register(Format{Name: "m4a", Extensions: []string{".m4a", ".m4r"}, DefaultOptions: AacOptions, PipeOptions: []string{"-f", "ipod", "-movflags", "+frag_keyframe+empty_moov"}})
+	Register(Format{Name: "mp3", Extensions: []string{".mp3"}, DefaultOptions: Mp3Options, PipeOptions: []string{"-f", "mp3"}})
+	Register(Format{Name: "wav", Extensions: []string{".wav"}, Lossless: true})
+	Register(Format{Name: "aiff", Extensions: []string{".aiff"}, Lossless: true})
+	options.FormatNames = Names
+}
+
+// Adds f to the known formats. Its DefaultOptions get its Extensions as their
+// OutputExtensions, and every format's DefaultOptions get the updated
+// InputExtensions. Meant to be called from init, since nothing guards against
+// concurrent use. Panics if the name or an extension is already taken.
+func Register(f Format) {
+	if len(f.Extensions) == 0 {
+		panic(fmt.Sprintf("ffmpeg.Register: format %q has no extensions", f.Name))
+	}
+	for _, name := range append([]string{f.Name}, f.Extensions...) {
+		if _, ok := Lookup(name); ok {
+			panic(fmt.Sprintf("ffmpeg.Register: format %q conflicts on %q", f.Name, name))
+		}
+	}
+	formats = append(formats, &f)
+	InputExtensions = append(InputExtensions, f.Extensions...)
+	if !f.Lossless {
+		LossyExtensions = append(LossyExtensions, f.Extensions...)
+	}
+	if f.DefaultOptions != nil {
+		f.DefaultOptions.OutputExtensions = f.Extensions
+	}
+	for _, other := range formats {
+		if other.DefaultOptions != nil {
+			other.DefaultOptions.InputExtensions = InputExtensions
+		}
+	}
+}
+
+// Returns the format with the given name or extension, with or without the
+// dot, e.g., "m4a", ".m4a", or "m4r".
+func Lookup(name string) (*Format, bool) {
+	ext := "." + strings.TrimPrefix(name, ".")
+	for _, f := range formats {
+		if f.Name == name || slices.Contains(f.Extensions, ext) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Returns what export_audio_tree -f takes: the names and aliases of the
+// formats that can be written, sorted.
+func Names() []string {
+	var names []string
+	for _, f := range formats {
+		if f.DefaultOptions == nil {
+			continue
+		}
+		names = append(names, f.Name)
+		for _, ext := range f.Extensions {
+			if alias := strings.TrimPrefix(ext, "."); alias != f.Name {
+				names = append(names, alias)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
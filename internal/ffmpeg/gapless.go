@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"slices"
+)
+
+// The AAC encoders, which add priming samples at the start of the output that
+// a player has to know to skip for -gapless.
+var aacCodecs = []string{"aac", "aac_at", "libfdk_aac"}
+
+// Returns the arguments for -gapless, which depend on the codec. Lossless
+// codecs like flac have no encoder delay, so they need none.
+//
+// For AAC, the mp4 muxer records the delay as an edit list, and faststart moves
+// the index to the front so that the next track can start right away, unless
+// writing to a pipe, which can't be seeked back over to do that. ffmpeg
+// has no way to write the iTunSMPB tag that iTunes and older iPods go by, see
+// WarnGapless. For MP3, the LAME header records the delay and padding.
+func gaplessArgs(opts *options.ConverterOptions) []string {
+	switch {
+	case !opts.Gapless:
+		return nil
+	case slices.Contains(aacCodecs, opts.Codec) && opts.OutputFile == "-":
+		return []string{"-use_editlist", "1"}
+	case slices.Contains(aacCodecs, opts.Codec):
+		return []string{"-use_editlist", "1", "-movflags", "+faststart"}
+	case opts.Codec == "libmp3lame":
+		return []string{"-write_xing", "1"}
+	}
+	return nil
+}
+
+// Warns when -gapless can only be done in part, which is the case for AAC,
+// since only the edit list is written.
+func WarnGapless(opts *options.ConverterOptions) {
+	if opts.Gapless && slices.Contains(aacCodecs, opts.Codec) {
+		logging.Warnf("Warning: -gapless with %s writes the encoder delay as an edit list, but not iTunSMPB, so some players may still leave gaps\n", opts.Codec)
+	}
+}
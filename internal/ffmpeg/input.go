@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned by ParseInputInfo when the output has no "Input #0" block, and by
+// ProbeInputInfo when there's no audio stream.
+var ErrNoInputInfo = errors.New("no input information")
+
+// What ffmpeg detected about the audio of its first input. Fields that ffmpeg
+// didn't report are left zero.
+type InputInfo struct {
+	Codec      string        // E.g., "flac".
+	SampleRate int           // In Hz.
+	Channels   string        // The channel layout, e.g., "stereo" or "5.1".
+	Duration   time.Duration // Of the whole input.
+	BitRate    string        // Of the whole input, e.g., "1021 kb/s".
+}
+
+// Returns a one line summary, e.g., "flac, 44100 Hz, stereo, 3m35.33s, 1021 kb/s".
+func (info *InputInfo) String() string {
+	var fields []string
+	if info.Codec != "" {
+		fields = append(fields, info.Codec)
+	}
+	if info.SampleRate > 0 {
+		fields = append(fields, fmt.Sprintf("%d Hz", info.SampleRate))
+	}
+	if info.Channels != "" {
+		fields = append(fields, info.Channels)
+	}
+	if info.Duration > 0 {
+		fields = append(fields, info.Duration.String())
+	}
+	if info.BitRate != "" {
+		fields = append(fields, info.BitRate)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// Parses the "Input #0" block that ffmpeg writes to stderr before converting.
+// E.g.,
+//
+//	Input #0, flac, from 'song.flac':
+//	  Duration: 00:03:35.33, start: 0.000000, bitrate: 1021 kb/s
+//	  Stream #0:0: Audio: flac, 44100 Hz, stereo, s16
+//
+// Only the first audio stream is considered.
+func ParseInputInfo(output []byte) (*InputInfo, error) {
+	var info InputInfo
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "Input #0") {
+			found = true
+			continue
+		} else if !found {
+			continue
+		} else if !strings.HasPrefix(line, " ") {
+			// The block is indented, so anything else is the end of it.
+			break
+		}
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "Duration: "); ok {
+			parseDurationLine(&info, rest)
+		} else if _, rest, ok := strings.Cut(line, ": Audio: "); ok && info.Codec == "" && strings.HasPrefix(line, "Stream #0:") {
+			parseAudioStream(&info, rest)
+		}
+	}
+	if !found {
+		return nil, ErrNoInputInfo
+	}
+	return &info, nil
+}
+
+// Parses the rest of a line like "Duration: 00:03:35.33, start: 0.000000,
+// bitrate: 1021 kb/s".
+func parseDurationLine(info *InputInfo, rest string) {
+	for i, field := range strings.Split(rest, ", ") {
+		if i == 0 {
+			info.Duration = parseTimestamp(field)
+		} else if rate, ok := strings.CutPrefix(field, "bitrate: "); ok && rate != "N/A" {
+			info.BitRate = rate
+		}
+	}
+}
+
+// Parses a timestamp like "00:03:35.33", returning 0 for "N/A" or the like.
+func parseTimestamp(s string) time.Duration {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil {
+			return 0
+		}
+		d += time.Duration(n * float64(unit))
+	}
+	return d
+}
+
+// Parses the rest of a line like "Stream #0:0: Audio: aac (LC) (mp4a /
+// 0x6134706D), 44100 Hz, stereo, fltp, 256 kb/s (default)". The codec comes
+// first, followed by the sample rate and channel layout.
+func parseAudioStream(info *InputInfo, rest string) {
+	fields := strings.Split(rest, ", ")
+	if codec := strings.Fields(fields[0]); len(codec) > 0 {
+		info.Codec = codec[0]
+	}
+	for i, field := range fields {
+		if hz, ok := strings.CutSuffix(field, " Hz"); ok {
+			info.SampleRate, _ = strconv.Atoi(hz)
+			if i+1 < len(fields) {
+				info.Channels = fields[i+1]
+			}
+			break
+		}
+	}
+}
+
+// Returns what ffprobe says about the audio of name, like ParseInputInfo but
+// before converting it.
+func ProbeInputInfo(ctx context.Context, ffprobe, name string) (*InputInfo, error) {
+	return probeInputInfo(ctx, DefaultRunner, ffprobe, name)
+}
+
+// Does the work of ProbeInputInfo, running ffprobe through r.
+func probeInputInfo(ctx context.Context, r Runner, ffprobe, name string) (*InputInfo, error) {
+	cmdline := []string{ffprobe, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,channel_layout,channels:format=duration,bit_rate",
+		"-of", "json", name}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runOutput(ctx, r, cmdline)
+	if err != nil {
+		return nil, fmt.Errorf("probing %q failed: %w", name, err)
+	}
+	info, err := parseProbeInfo(output)
+	if err != nil {
+		return nil, fmt.Errorf("probing %q: %w", name, err)
+	}
+	return info, nil
+}
+
+// The JSON written by ffprobe with -of json, as far as it's used here.
+type probeOutput struct {
+	Streams []probeStream `json:"streams"`
+	Format  struct {
+		Duration string            `json:"duration"`
+		BitRate  string            `json:"bit_rate"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+type probeStream struct {
+	CodecType     string            `json:"codec_type"`
+	CodecName     string            `json:"codec_name"`
+	SampleRate    string            `json:"sample_rate"`
+	ChannelLayout string            `json:"channel_layout"`
+	Channels      int               `json:"channels"`
+	Tags          map[string]string `json:"tags"`
+}
+
+// Parses the JSON written by ffprobe for ProbeInputInfo.
+func parseProbeInfo(output []byte) (*InputInfo, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+	if len(probe.Streams) == 0 {
+		return nil, ErrNoInputInfo
+	}
+	return probe.inputInfo(&probe.Streams[0]), nil
+}
+
+// Returns the InputInfo of stream, an audio stream of probe.
+func (probe *probeOutput) inputInfo(stream *probeStream) *InputInfo {
+	info := &InputInfo{Codec: stream.CodecName, Channels: stream.ChannelLayout}
+	info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+	if info.Channels == "" && stream.Channels > 0 {
+		info.Channels = fmt.Sprintf("%d channels", stream.Channels)
+	}
+	// Left zero when ffprobe says "N/A".
+	info.Duration, _ = parseDuration([]byte(probe.Format.Duration))
+	if rate, err := strconv.Atoi(probe.Format.BitRate); err == nil {
+		// Matches how ffmpeg reports it.
+		info.BitRate = fmt.Sprintf("%d kb/s", rate/1000)
+	}
+	return info
+}
+
+// Logs a summary of the input name before converting it when running verbose,
+// using ProbeInputInfo. Returns false if nothing was logged, e.g., because
+// ffprobe isn't installed, in which case LogInputInfo can be used instead.
+func LogProbedInputInfo(ctx context.Context, ffprobe, name string) bool {
+	return LogProbedInputInfoWith(ctx, DefaultRunner, ffprobe, name)
+}
+
+// Like LogProbedInputInfo, but runs ffprobe through r.
+func LogProbedInputInfoWith(ctx context.Context, r Runner, ffprobe, name string) bool {
+	if !logging.IsVerbose() {
+		return false
+	}
+	info, err := probeInputInfo(ctx, r, ffprobe, name)
+	if err != nil {
+		logging.Println(err)
+		return false
+	}
+	logging.Verbosef("Input %q: %s", name, info)
+	return true
+}
+
+// Logs a summary of the input ffmpeg detected for name when running verbose.
+// Nothing is logged if output doesn't say.
+func LogInputInfo(name string, output []byte) {
+	if info, err := ParseInputInfo(output); err == nil {
+		logging.Verbosef("Input %q: %s", name, info)
+	}
+}
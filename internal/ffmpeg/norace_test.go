@@ -0,0 +1,9 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !race
+
+package ffmpeg
+
+// Whether the tests were built with the race detector.
+const raceEnabled = false
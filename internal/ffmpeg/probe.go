@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns the duration of the media in name, as reported by ffprobe.
+func ProbeDuration(ctx context.Context, ffprobe, name string) (time.Duration, error) {
+	cmdline := []string{ffprobe, "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", name}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runOutput(ctx, DefaultRunner, cmdline)
+	if err != nil {
+		return 0, fmt.Errorf("probing %q failed: %w", name, err)
+	}
+	d, err := parseDuration(output)
+	if err != nil {
+		return 0, fmt.Errorf("probing %q: %w", name, err)
+	}
+	return d, nil
+}
+
+// Parses the duration printed by ffprobe, in seconds, e.g., "215.333000".
+func parseDuration(output []byte) (time.Duration, error) {
+	value := string(bytes.TrimSpace(output))
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("bad duration %q", value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// What ffprobe says about a media file as a whole, for audio_probe.
+type MediaInfo struct {
+	InputInfo                   // Of the first audio stream.
+	Tags      map[string]string // Of the container and the audio stream, with lowercase keys, e.g., "title".
+	CoverArt  bool              // Whether there's art embedded, meaning a video stream.
+}
+
+// Returns what ffprobe says about name: its audio, tags, and whether it has
+// cover art. Returns ErrNoInputInfo if name has no audio.
+func Probe(ctx context.Context, ffprobe, name string) (*MediaInfo, error) {
+	return ProbeWith(ctx, DefaultRunner, ffprobe, name)
+}
+
+// Like Probe, but runs ffprobe through r.
+func ProbeWith(ctx context.Context, r Runner, ffprobe, name string) (*MediaInfo, error) {
+	cmdline := []string{ffprobe, "-v", "error",
+		"-show_entries", "stream=codec_type,codec_name,sample_rate,channel_layout,channels:stream_tags:format=duration,bit_rate:format_tags",
+		"-of", "json", name}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runOutput(ctx, r, cmdline)
+	if err != nil {
+		return nil, fmt.Errorf("probing %q failed: %w", name, err)
+	}
+	info, err := parseMediaInfo(output)
+	if err != nil {
+		return nil, fmt.Errorf("probing %q: %w", name, err)
+	}
+	return info, nil
+}
+
+// Parses the JSON written by ffprobe for Probe. Tags on the container win
+// over the audio stream's, which is where Ogg keeps them.
+func parseMediaInfo(output []byte) (*MediaInfo, error) {
+	var probe probeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return nil, err
+	}
+	var audio *probeStream
+	art := false
+	for i := range probe.Streams {
+		switch probe.Streams[i].CodecType {
+		case "audio":
+			if audio == nil {
+				audio = &probe.Streams[i]
+			}
+		case "video":
+			art = true
+		}
+	}
+	if audio == nil {
+		return nil, ErrNoInputInfo
+	}
+	info := &MediaInfo{InputInfo: *probe.inputInfo(audio), Tags: make(map[string]string), CoverArt: art}
+	maps.Insert(info.Tags, lowerKeys(audio.Tags))
+	maps.Insert(info.Tags, lowerKeys(probe.Format.Tags))
+	return info, nil
+}
+
+// Returns the tags of m with their keys in lowercase, since formats differ,
+// e.g., "TITLE" in FLAC and "title" in MP4.
+func lowerKeys(m map[string]string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for k, v := range m {
+			if !yield(strings.ToLower(k), v) {
+				return
+			}
+		}
+	}
+}
+
+// Returns true if name has cover art, meaning a video stream, according to
+// ffprobe.
+func HasCoverArt(ctx context.Context, ffprobe, name string) (bool, error) {
+	cmdline := []string{ffprobe, "-v", "error", "-select_streams", "v", "-show_entries", "stream=index", "-of", "csv=p=0", name}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runOutput(ctx, DefaultRunner, cmdline)
+	if err != nil {
+		return false, fmt.Errorf("probing %q failed: %w", name, err)
+	}
+	return len(bytes.TrimSpace(output)) > 0, nil
+}
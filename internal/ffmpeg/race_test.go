@@ -0,0 +1,10 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build race
+
+package ffmpeg
+
+// Whether the tests were built with the race detector, which reserves far more
+// address space than a memory limit the tests can afford to use.
+const raceEnabled = true
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The loudness that ReplayGain 2.0 levels tracks to, in LUFS.
+const ReplayGainReference = -18.0
+
+// The loudness of a track or album, as measured by the ebur128 filter.
+type Loudness struct {
+	Integrated float64       // In LUFS.
+	Peak       float64       // The sample peak, where 1.0 is full scale.
+	Duration   time.Duration // How much audio was measured.
+}
+
+// Returns the ReplayGain of l, in dB.
+func (l Loudness) Gain() float64 {
+	return ReplayGainReference - l.Integrated
+}
+
+// Combines the loudness of the tracks of an album. The album's loudness is
+// the mean of the tracks' energy, weighted by their duration, which is close
+// to measuring them as one without decoding them again.
+func AlbumLoudness(tracks []Loudness) Loudness {
+	var album Loudness
+	var energy, weight float64
+	for _, track := range tracks {
+		w := track.Duration.Seconds()
+		if w <= 0 {
+			// Without a duration, every track counts the same.
+			w = 1
+		}
+		energy += w * math.Pow(10, track.Integrated/10)
+		weight += w
+		album.Peak = max(album.Peak, track.Peak)
+		album.Duration += track.Duration
+	}
+	if weight > 0 {
+		album.Integrated = 10 * math.Log10(energy/weight)
+	}
+	return album
+}
+
+// Returns the ReplayGain tags for track, and for album too unless it's nil, as
+// key=value for -metadata.
+func ReplayGainTags(track Loudness, album *Loudness) []string {
+	tags := []string{
+		fmt.Sprintf("REPLAYGAIN_TRACK_GAIN=%.2f dB", track.Gain()),
+		fmt.Sprintf("REPLAYGAIN_TRACK_PEAK=%.6f", track.Peak),
+	}
+	if album != nil {
+		tags = append(tags,
+			fmt.Sprintf("REPLAYGAIN_ALBUM_GAIN=%.2f dB", album.Gain()),
+			fmt.Sprintf("REPLAYGAIN_ALBUM_PEAK=%.6f", album.Peak))
+	}
+	return tags
+}
+
+// Decodes the audio in name through the ebur128 filter, returning its
+// loudness along with ffmpeg's output.
+func MeasureLoudness(ctx context.Context, ffmpeg, name string) (Loudness, []byte, error) {
+	return MeasureLoudnessWith(ctx, DefaultRunner, ffmpeg, name)
+}
+
+// Like MeasureLoudness, but runs ffmpeg through r.
+func MeasureLoudnessWith(ctx context.Context, r Runner, ffmpeg, name string) (Loudness, []byte, error) {
+	// The per-frame log is left at verbose, so only the summary is printed.
+	cmdline := []string{ffmpeg, "-hide_banner", "-nostdin", "-i", name,
+		"-map", "0:a:0", "-af", "ebur128=peak=sample:framelog=verbose", "-f", "null", "-"}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runCombined(ctx, r, cmdline)
+	if err != nil {
+		return Loudness{}, output, fmt.Errorf("measuring the loudness of %q failed: %w", name, err)
+	}
+	l, err := parseLoudness(output)
+	if err != nil {
+		return l, output, fmt.Errorf("measuring the loudness of %q: %w", name, err)
+	}
+	return l, output, nil
+}
+
+// Parses the summary printed by the ebur128 filter:
+//
+//	Integrated loudness:
+//	  I:         -19.4 LUFS
+//	...
+//	Sample peak:
+//	  Peak:       -0.1 dBFS
+//
+// The duration comes from the last progress line, e.g., "time=00:03:35.33".
+func parseLoudness(output []byte) (Loudness, error) {
+	var l Loudness
+	if i := bytes.LastIndex(output, []byte("time=")); i >= 0 {
+		if fields := strings.Fields(string(output[i+len("time="):])); len(fields) > 0 {
+			l.Duration = parseTimestamp(fields[0])
+		}
+	}
+	_, summary, ok := bytes.Cut(output, []byte("Summary:"))
+	if !ok {
+		return l, fmt.Errorf("no ebur128 summary in the output")
+	}
+	var haveI, havePeak bool
+	for line := range strings.Lines(string(summary)) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		fields := strings.Fields(value)
+		if !ok || len(fields) == 0 {
+			continue
+		}
+		// Silence is -70 LUFS, but its peak is "-inf", which ParseFloat
+		// handles too.
+		n, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		switch {
+		case key == "I" && !haveI:
+			l.Integrated, haveI = n, true
+		case key == "Peak" && !havePeak:
+			l.Peak, havePeak = math.Pow(10, n/20), true
+		}
+	}
+	if !haveI || !havePeak {
+		return l, fmt.Errorf("incomplete ebur128 summary")
+	}
+	return l, nil
+}
+
+// Copies the streams of in to out, a new file, adding tags, as key=value like
+// -metadata. Returns ffmpeg's output.
+func WriteTags(ctx context.Context, ffmpeg, in, out string, tags []string) ([]byte, error) {
+	return WriteTagsWith(ctx, DefaultRunner, ffmpeg, in, out, tags)
+}
+
+// Like WriteTags, but runs ffmpeg through r.
+func WriteTagsWith(ctx context.Context, r Runner, ffmpeg, in, out string, tags []string) ([]byte, error) {
+	args := []string{ffmpeg, "-v", "error", "-nostdin", "-y", "-i", in, "-map", "0", "-c", "copy", "-map_metadata", "0"}
+	if ext := filepath.Ext(out); ext == ".m4a" || ext == ".mp4" {
+		// Otherwise, the MP4 muxer drops tags iTunes doesn't know.
+		args = append(args, "-movflags", "use_metadata_tags")
+	}
+	for _, tag := range tags {
+		args = append(args, "-metadata", tag)
+	}
+	cmdline := append(args, out)
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runCombined(ctx, r, cmdline)
+	if err != nil {
+		return output, fmt.Errorf("tagging %q failed: %w", in, err)
+	}
+	return output, nil
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// Whether startLimited is implemented on this platform.
+const MemoryLimitSupported = true
+
+// Starts cmd with its address space and data segment limited to limit bytes.
+// Allocations beyond that fail, which ffmpeg treats as a fatal error.
+//
+// The process is started traced, so that it stops as soon as it has exec'd.
+// The limit is set while it's stopped, before any of its code has run, and
+// then it's let go. Where tracing isn't allowed, e.g., by Yama's ptrace_scope
+// or a container's seccomp profile, the process can't be started this way and
+// the error is errMemoryLimitDenied, leaving cmd unusable.
+func startLimited(cmd *exec.Cmd, limit int64) error {
+	// The tracer is the thread that started the process, so the rest has to
+	// happen on the same one.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+	if err := startTraced(cmd); errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSYS) {
+		return fmt.Errorf("%w: %w", errMemoryLimitDenied, err)
+	} else if err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	err := waitStopped(pid)
+	if err == nil {
+		rlim := unix.Rlimit{Cur: uint64(limit), Max: uint64(limit)}
+		for _, resource := range []int{unix.RLIMIT_AS, unix.RLIMIT_DATA} {
+			if err = unix.Prlimit(pid, resource, &rlim, nil); err != nil {
+				break
+			}
+		}
+		if detachErr := unix.PtraceDetach(pid); err == nil {
+			err = detachErr
+		}
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("failed limiting memory of %s: %w", cmd.Path, err)
+	}
+	return nil
+}
+
+// Starts cmd, which is traced. Replaced by tests to deny the tracing.
+var startTraced = (*exec.Cmd).Start
+
+// Waits for the traced process pid to stop after exec.
+func waitStopped(pid int) error {
+	var status unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &status, unix.WALL, nil)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		} else if !status.Stopped() {
+			return fmt.Errorf("process %d did not stop after exec: %#x", pid, uint32(status))
+		}
+		return nil
+	}
+}
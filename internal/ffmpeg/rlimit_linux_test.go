@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const memoryHogEnv = "AUDIO_CONVERTER_MEMORY_HOG"
+
+// Not a real test. When run as a child of TestExecRunnerMemoryLimit, this reports the
+// limit it started with and then allocates as many bytes as memoryHogEnv says.
+func TestMemoryHog(t *testing.T) {
+	size, err := strconv.Atoi(os.Getenv(memoryHogEnv))
+	if err != nil {
+		t.Skip("only run as a child of TestExecRunnerMemoryLimit")
+	}
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_AS, &rlim); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("limit: %d\n", rlim.Cur)
+	hog := make([]byte, size)
+	for i := range hog {
+		hog[i] = byte(i)
+	}
+	os.Exit(0)
+}
+
+// Runs TestMemoryHog in a child limited to limit bytes, allocating size.
+func runMemoryHog(t *testing.T, size int, limit int64) ([]byte, error) {
+	t.Setenv(memoryHogEnv, strconv.Itoa(size))
+	return runCombined(t.Context(), ExecRunner{MemoryLimit: limit}, []string{os.Args[0], "-test.run=^TestMemoryHog$"})
+}
+
+func TestExecRunnerMemoryLimit(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector reserves more address space than the limit")
+	}
+	run := func(size int, limit int64) ([]byte, error) {
+		return runMemoryHog(t, size, limit)
+	}
+
+	// The limit is in place before the child runs at all, not just by the
+	// time it gets around to allocating.
+	output, err := run(2<<30, 1<<30)
+	if err == nil {
+		t.Fatalf("Child should have failed with a 1 GiB limit:\n%s", output)
+	}
+	if !strings.Contains(string(output), fmt.Sprintf("limit: %d\n", 1<<30)) {
+		t.Errorf("Child should have started with the limit: %v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "out of memory") {
+		t.Errorf("Child should have run out of memory: %v\n%s", err, output)
+	}
+
+	if output, err := run(256<<20, 4<<30); err != nil {
+		t.Errorf("Child should have succeeded with a 4 GiB limit: %v\n%s", err, output)
+	}
+}
+
+func TestExecRunnerMemoryLimitDenied(t *testing.T) {
+	t.Cleanup(func() {
+		startTraced = (*exec.Cmd).Start
+		memoryLimitDenied.Store(false)
+	})
+	traced := 0
+	startTraced = func(cmd *exec.Cmd) error {
+		traced++
+		return &os.PathError{Op: "fork/exec", Path: cmd.Path, Err: syscall.EPERM}
+	}
+
+	// Where tracing isn't allowed, the child runs anyway, without the limit.
+	for range 2 {
+		output, err := runMemoryHog(t, 1<<20, 1<<30)
+		if err != nil {
+			t.Fatalf("Child should have run without the limit: %v\n%s", err, output)
+		} else if strings.Contains(string(output), fmt.Sprintf("limit: %d\n", 1<<30)) {
+			t.Errorf("Child shouldn't have been limited:\n%s", output)
+		}
+	}
+	if traced != 1 {
+		t.Errorf("Tracing should have been tried once, not %d times", traced)
+	}
+}
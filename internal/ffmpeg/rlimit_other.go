@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !linux
+
+package ffmpeg
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Whether startLimited is implemented on this platform.
+const MemoryLimitSupported = false
+
+func startLimited(cmd *exec.Cmd, limit int64) error {
+	return errors.ErrUnsupported
+}
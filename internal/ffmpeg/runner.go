@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/proc"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Where a command run by a Runner reads and writes. A nil Stdin reads nothing,
+// and what's written to a nil Stdout or Stderr is discarded.
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runs ffmpeg and ffprobe for this package. Everything here goes through one,
+// so that tests can stand in a Recorder for the real thing.
+type Runner interface {
+	// Runs the program name with args until it exits or ctx is done,
+	// returning an error if it couldn't be run or failed.
+	Run(ctx context.Context, name string, args []string, stdio Stdio) error
+}
+
+// The Runner used by the functions that aren't given one.
+var DefaultRunner Runner = ExecRunner{}
+
+// A Runner that starts each program as a process, which is killed if its
+// context is done. The process is limited to MemoryLimit bytes, if it's set
+// and MemoryLimitSupported, and its priority lowered to Nice and IONice, where
+// supported. If the system doesn't allow the limit to be set, it's warned
+// about once, and the processes run without it.
+type ExecRunner struct {
+	MemoryLimit int64
+	Nice        int
+	IONice      string
+}
+
+func (r ExecRunner) Run(ctx context.Context, name string, args []string, stdio Stdio) error {
+	cmd := command(ctx, name, args, stdio)
+	limited := r.MemoryLimit > 0 && MemoryLimitSupported && !memoryLimitDenied.Load()
+	var err error
+	if limited {
+		if err = startLimited(cmd, r.MemoryLimit); errors.Is(err, errMemoryLimitDenied) {
+			if !memoryLimitDenied.Swap(true) {
+				logging.Warnf("Warning: %v, running without a memory limit\n", err)
+			}
+			// The failed start leaves cmd unusable.
+			cmd, limited = command(ctx, name, args, stdio), false
+		}
+	}
+	if !limited {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return err
+	}
+	lowerPriority(cmd.Process.Pid, r.Nice, r.IONice)
+	return cmd.Wait()
+}
+
+// Returns the command that runs name with args and stdio until ctx is done.
+func command(ctx context.Context, name string, args []string, stdio Stdio) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdio.Stdin, stdio.Stdout, stdio.Stderr
+	// Once it's killed, e.g., for a timeout, anything it started that's still
+	// holding on to its output can't keep Wait from returning.
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// Returned by startLimited when the system doesn't allow a memory limit to be
+// set on a process.
+var errMemoryLimitDenied = errors.New("the system does not allow limiting the memory of ffmpeg")
+
+// Set once a memory limit has been denied, so the rest of the processes don't
+// try again, or warn again.
+var memoryLimitDenied atomic.Bool
+
+// Sets the niceness and I/O class of the process pid, unless they're 0 and "".
+// A failure is only logged, since the program can still run at the usual
+// priority.
+func lowerPriority(pid, nice int, ioClass string) {
+	if nice > 0 && proc.PrioritySupported {
+		if err := proc.SetPriority(pid, nice); err != nil {
+			logging.Printf("Failed setting the niceness of process %d to %d: %v", pid, nice, err)
+		}
+	}
+	if ioClass != "" && proc.IOPrioritySupported {
+		if err := proc.SetIOClass(pid, ioClass); err != nil {
+			logging.Printf("Failed setting the I/O class of process %d to %s: %v", pid, ioClass, err)
+		}
+	}
+}
+
+// How long Wait waits for the output of a killed process to be closed.
+var waitDelay = 5 * time.Second
+
+// A Runner that records the command lines it's asked to run instead of running
+// them, for tests. Safe for concurrent use.
+type Recorder struct {
+	// Called for each command to write its output and return its result, or
+	// nil for every command to succeed without output. The Stdio has no nil
+	// members.
+	Fake func(ctx context.Context, name string, args []string, stdio Stdio) error
+
+	mutex sync.Mutex
+	calls [][]string
+}
+
+func (r *Recorder) Run(ctx context.Context, name string, args []string, stdio Stdio) error {
+	r.mutex.Lock()
+	r.calls = append(r.calls, append([]string{name}, args...))
+	r.mutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Fake == nil {
+		return nil
+	}
+	if stdio.Stdin == nil {
+		stdio.Stdin = strings.NewReader("")
+	}
+	if stdio.Stdout == nil {
+		stdio.Stdout = io.Discard
+	}
+	if stdio.Stderr == nil {
+		stdio.Stderr = io.Discard
+	}
+	return r.Fake(ctx, name, args, stdio)
+}
+
+// Returns the command lines run so far, each the program followed by its
+// arguments, in the order they were run.
+func (r *Recorder) Calls() [][]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return slices.Clone(r.calls)
+}
+
+// Runs cmdline, the program followed by its arguments, through r, returning
+// its standard output and error combined.
+func runCombined(ctx context.Context, r Runner, cmdline []string) ([]byte, error) {
+	var output bytes.Buffer
+	err := r.Run(ctx, cmdline[0], cmdline[1:], Stdio{Stdout: &output, Stderr: &output})
+	return output.Bytes(), err
+}
+
+// Runs cmdline, the program followed by its arguments, through r, returning
+// its standard output. Its standard error is discarded.
+func runOutput(ctx context.Context, r Runner, cmdline []string) ([]byte, error) {
+	var output bytes.Buffer
+	err := r.Run(ctx, cmdline[0], cmdline[1:], Stdio{Stdout: &output})
+	return output.Bytes(), err
+}
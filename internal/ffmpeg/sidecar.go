@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"cmp"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Base names of images in an album's directory that are likely to be its
+// cover, most preferred first. Matched without regard to case.
+var SidecarArtNames = []string{"cover", "folder", "front", "album", "albumart"}
+
+// Extensions of sidecar images that can be measured.
+var SidecarArtExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// An image found next to the audio files in a directory.
+type SidecarArt struct {
+	Name   string // Path relative to the FS.
+	Width  int
+	Height int
+	rank   int // Index into SidecarArtNames.
+}
+
+// Finds the best cover art among the images in dir of fsys. That's the one with
+// the highest resolution, with ties going to the name earliest in
+// SidecarArtNames. Images that can't be decoded are ignored. Returns nil if no
+// suitable image is found.
+func FindSidecarArt(fsys fs.FS, dir string) (*SidecarArt, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []SidecarArt
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		rank := sidecarRank(entry.Name())
+		if rank < 0 {
+			continue
+		}
+		name := path.Join(dir, entry.Name())
+		config, err := decodeImageConfig(fsys, name)
+		if err != nil {
+			logging.Verbosef("Ignoring cover art candidate %q: %v", name, err)
+			continue
+		}
+		candidates = append(candidates, SidecarArt{Name: name, Width: config.Width, Height: config.Height, rank: rank})
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	best := slices.MinFunc(candidates, func(a, b SidecarArt) int {
+		return cmp.Or(
+			cmp.Compare(b.Width*b.Height, a.Width*a.Height),
+			cmp.Compare(a.rank, b.rank),
+			cmp.Compare(a.Name, b.Name))
+	})
+	logging.Verbosef("Using %q (%dx%d) as the cover art for %q", best.Name, best.Width, best.Height, dir)
+	return &best, nil
+}
+
+// Returns the index of name's base name in SidecarArtNames, or -1 if it isn't a
+// sidecar image.
+func sidecarRank(name string) int {
+	name = strings.ToLower(name)
+	ext := path.Ext(name)
+	if !slices.Contains(SidecarArtExtensions, ext) {
+		return -1
+	}
+	return slices.Index(SidecarArtNames, strings.TrimSuffix(name, ext))
+}
+
+// Reads just enough of the image to learn its dimensions.
+func decodeImageConfig(fsys fs.FS, name string) (image.Config, error) {
+	fp, err := fsys.Open(name)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer fp.Close()
+	config, _, err := image.DecodeConfig(fp)
+	return config, err
+}
@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Decodes the audio in name without writing anything, returning ffmpeg's
+// output. An error means the file is damaged, e.g., truncated by a crash.
+//
+// ffmpeg is only asked to report errors, so any output at all counts as a
+// failure, even if it manages to exit successfully.
+func VerifyDecode(ctx context.Context, ffmpeg, name string) ([]byte, error) {
+	return VerifyDecodeWith(ctx, DefaultRunner, ffmpeg, name)
+}
+
+// Like VerifyDecode, but runs ffmpeg through r.
+func VerifyDecodeWith(ctx context.Context, r Runner, ffmpeg, name string) ([]byte, error) {
+	cmdline := []string{ffmpeg, "-v", "error", "-xerror", "-i", name, "-map", "0:a", "-f", "null", "-"}
+	logging.Println("Running in background:", strings.Join(cmdline, " "))
+	output, err := runCombined(ctx, r, cmdline)
+	if err != nil {
+		return output, fmt.Errorf("decoding %q failed: %w", name, err)
+	} else if msg := bytes.TrimSpace(output); len(msg) > 0 {
+		return output, fmt.Errorf("decoding %q reported errors: %s", name, firstLine(msg))
+	}
+	return output, nil
+}
+
+func firstLine(b []byte) []byte {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	return line
+}
@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package ffmpeg
+
+import (
+	"audio_converter/internal/logging"
+	"audio_converter/internal/options"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Returns the ffmpeg to run for opts. Options built in code rather than parsed
+// from flags may leave -ffmpeg empty, which means the one in $PATH.
+func program(opts *options.GlobalOptions) string {
+	if opts.FFmpeg == "" {
+		return "ffmpeg"
+	}
+	return opts.FFmpeg
+}
+
+// Returns the first line of `ffmpeg -version`, e.g., "ffmpeg version 7.1
+// Copyright (c) 2000-2024 the FFmpeg developers".
+func Version(ctx context.Context, ffmpeg string) (string, error) {
+	output, err := runOutput(ctx, DefaultRunner, []string{ffmpeg, "-version"})
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(firstLine(output)))
+	if line == "" {
+		return "", fmt.Errorf("no version reported")
+	}
+	return line, nil
+}
+
+// Checks that the ffmpeg in opts can be run, logging its version, so that a
+// missing or broken binary stops the tool before it starts rather than failing
+// every file.
+func CheckFFmpeg(ctx context.Context, opts *options.GlobalOptions) error {
+	ffmpeg := program(opts)
+	version, err := Version(ctx, ffmpeg)
+	if err != nil {
+		return fmt.Errorf("cannot run ffmpeg %q: %w\nInstall ffmpeg, or give its path with -ffmpeg or $%s", ffmpeg, err, options.FFmpegEnv)
+	}
+	logging.Printf("Using %s: %s", ffmpeg, version)
+	return nil
+}
@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"audio_converter/internal/logging"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Inserted before the extension of temporary files.
+const TempSuffix = ".part"
+
+// Returns a temporary name to use while writing name. The temporary file lives
+// in the same directory as name, so that renaming it into place doesn't cross
+// file systems. The extension is preserved so that tools like ffmpeg can still
+// infer the format. The name is hidden and has a random part, so that it's
+// never the name of a real file. E.g., "album/song.m4a" becomes something like
+// "album/.song.3f9a0c1e.part.m4a".
+func TempName(name string) string {
+	dir, base := filepath.Split(name)
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s.%s.%08x%s%s", dir, base[:len(base)-len(ext)], rand.Uint32(), TempSuffix, ext)
+}
+
+// Returns true if name looks like it was returned by TempName, e.g., a file
+// left behind by a crash.
+func IsTempName(name string) bool {
+	base := filepath.Base(name)
+	if !strings.HasPrefix(base, ".") {
+		return false
+	}
+	return strings.HasSuffix(base, TempSuffix) || strings.HasSuffix(strings.TrimSuffix(base, filepath.Ext(base)), TempSuffix)
+}
+
+// Writes a file atomically by way of a temporary file in the same directory.
+// Either write to the file returned by Create or to the path named by Temp,
+// then call Commit to move it into place or Abort to throw it away.
+type AtomicFile struct {
+	fsys FS
+	name string
+	temp string
+	file File
+}
+
+// Prepares to atomically write name in fsys. Nothing is created until Create is
+// called or something writes to Temp.
+func NewAtomicFile(fsys FS, name string) *AtomicFile {
+	return &AtomicFile{fsys: fsys, name: name, temp: TempName(name)}
+}
+
+// Returns the final name of the file.
+func (f *AtomicFile) Name() string {
+	return f.name
+}
+
+// Returns the name of the temporary file, relative to the FS.
+func (f *AtomicFile) Temp() string {
+	return f.temp
+}
+
+// Creates the temporary file. It is closed by Commit or Abort.
+func (f *AtomicFile) Create() (File, error) {
+	file, err := f.fsys.Create(f.temp)
+	if err != nil {
+		return nil, err
+	}
+	f.file = file
+	return file, nil
+}
+
+// Closes the temporary file if it was opened by Create.
+func (f *AtomicFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// Moves the temporary file into place, replacing any existing file.
+func (f *AtomicFile) Commit() error {
+	if err := f.close(); err != nil {
+		return errors.Join(err, f.Abort())
+	}
+	return f.fsys.Rename(f.temp, f.name)
+}
+
+// Like Commit, but leaves a file that already exists alone, removing the
+// temporary file and returning an error matching fs.ErrExist. For -n, when the
+// file may have been created since it was last checked for. Where the FS has
+// real paths, the file is hard linked into place, which fails rather than
+// replace anything, so nothing that appears at the last moment is lost. On
+// file systems without hard links, like FAT, the name is checked just before
+// renaming instead.
+func (f *AtomicFile) CommitNoClobber() error {
+	if err := f.close(); err != nil {
+		return errors.Join(err, f.Abort())
+	}
+	exists := &fs.PathError{Op: "commit", Path: f.name, Err: fs.ErrExist}
+	if r, ok := f.fsys.(resolver); ok {
+		temp, err := r.resolve(f.temp)
+		if err != nil {
+			return errors.Join(err, f.Abort())
+		}
+		name, err := r.resolve(f.name)
+		if err != nil {
+			return errors.Join(err, f.Abort())
+		}
+		if err = os.Link(temp, name); err == nil {
+			// The file is in place, so only the extra name is left over.
+			if err := f.Abort(); err != nil {
+				logging.Printf("Failed removing %q after linking it to %q: %v", f.temp, f.name, err)
+			}
+			return nil
+		} else if errors.Is(err, fs.ErrExist) {
+			return errors.Join(exists, f.Abort())
+		}
+	}
+	if _, err := f.fsys.Lstat(f.name); err == nil {
+		return errors.Join(exists, f.Abort())
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return errors.Join(err, f.Abort())
+	}
+	return f.fsys.Rename(f.temp, f.name)
+}
+
+// Removes the temporary file. It is not an error if it was never created.
+func (f *AtomicFile) Abort() error {
+	err := f.close()
+	if rerr := f.fsys.Remove(f.temp); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		err = errors.Join(err, rerr)
+	}
+	return err
+}
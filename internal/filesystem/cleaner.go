@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // Reserved characters are defined in terms of common platforms. The resulting
@@ -42,13 +44,48 @@ func init() {
 	}
 }
 
+// Device names that Windows reserves in every directory, with or without an
+// extension, e.g., CON and nul.txt.
+var ReservedNames []string
+
+func init() {
+	ReservedNames = []string{"CON", "PRN", "AUX", "NUL"}
+	for i := range 10 {
+		ReservedNames = append(ReservedNames, fmt.Sprintf("COM%d", i), fmt.Sprintf("LPT%d", i))
+	}
+}
+
+// The Unicode normalization forms for Cleaner.Normalize.
+const (
+	// Leave names as they are.
+	NormalizeNone = "none"
+	// Composed, as most systems but macOS create names, e.g., "é" is U+00E9.
+	NormalizeNFC = "nfc"
+	// Decomposed, as HFS+ stores names, e.g., "é" is "e" and U+0301.
+	NormalizeNFD = "nfd"
+)
+
 // A string replacer for cleaning paths.
 type Cleaner struct {
 	*strings.Replacer
+	replacement string
+
+	// Prefix names in ReservedNames with the replacement text. Only
+	// Windows cares, so UNIX only users may turn this off.
+	DeviceNames bool
+	// Replace trailing dots and spaces, which Windows and exFAT strip, with
+	// the replacement text, or trim them if it ends with one too. Like
+	// DeviceNames, only Windows cares.
+	TrailingDots bool
+	// The Unicode normalization form names are converted to before anything
+	// else, so that names that look the same are the same. One of the
+	// Normalize constants, or "" for none.
+	Normalize string
 }
 
 // Creates a new cleaner that will replace all occurances of strings in
-// `reserved` with `replacement` text when encountered during a clean.
+// `reserved` with `replacement` text when encountered during a clean. Reserved
+// device names and trailing dots and spaces are cleaned too, unless turned off.
 func NewCleaner(replacement string, reserved []string) *Cleaner {
 	var r []string
 	if replacement != "" {
@@ -56,13 +93,43 @@ func NewCleaner(replacement string, reserved []string) *Cleaner {
 			r = append(r, s, replacement)
 		}
 	}
-	fmt.Println("replacement: ", replacement)
-	return &Cleaner{Replacer: strings.NewReplacer(r...)}
+	return &Cleaner{
+		Replacer:     strings.NewReplacer(r...),
+		replacement:  replacement,
+		DeviceNames:  true,
+		TrailingDots: true,
+	}
 }
 
-// Replaces reserved characters in `name` with the replacement character.
+// Replaces reserved characters in `name` with the replacement character. E.g.,
+// "AUX.flac" becomes "_AUX.flac" and "Vol. 1." becomes "Vol. 1_".
 func (c *Cleaner) CleanName(name string) string {
-	return c.Replace(name)
+	switch c.Normalize {
+	case NormalizeNFC:
+		name = norm.NFC.String(name)
+	case NormalizeNFD:
+		name = norm.NFD.String(name)
+	}
+	name = c.Replace(name)
+	if name == "." || name == ".." || c.replacement == "" {
+		return name
+	}
+	if c.TrailingDots {
+		// They're just trimmed if the replacement would be stripped too.
+		trimmed := strings.TrimRight(name, ". ")
+		replacement := strings.TrimRight(c.replacement, ". ")
+		name = trimmed + strings.Repeat(replacement, len(name)-len(trimmed))
+	}
+	if c.DeviceNames {
+		stem, _, _ := strings.Cut(name, ".")
+		stem = strings.TrimRight(stem, " ")
+		for _, reserved := range ReservedNames {
+			if strings.EqualFold(stem, reserved) {
+				return c.replacement + name
+			}
+		}
+	}
+	return name
 }
 
 // Returns `path` with each element cleaned. E.g., "/foo>bar/file" will become
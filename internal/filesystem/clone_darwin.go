@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+// Creates dst as a clone of src with clonefile, which APFS supports. dst must
+// not exist.
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
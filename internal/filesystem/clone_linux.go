@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Creates dst as a clone of src with the FICLONE ioctl, which btrfs and XFS
+// support. dst must not exist, and is removed if cloning fails.
+func cloneFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	err = errors.Join(err, out.Close())
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
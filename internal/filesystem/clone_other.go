@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !linux && !darwin
+
+package filesystem
+
+import "errors"
+
+func cloneFile(src, dst string) error {
+	return errors.ErrUnsupported
+}
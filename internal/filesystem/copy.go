@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// The size of the buffer CopyFile uses when the kernel can't do the copy,
+// e.g., when writing to an FS that isn't backed by the OS. Larger than
+// io.Copy's default, since the files are often large.
+var CopyBufferSize = 1 << 20
+
+// Implemented by FSes whose files have real paths, like FileSystem.
+type resolver interface {
+	resolve(name string) (string, error)
+}
+
+// Tries to clone source in srcFS into temp in dstFS, which must not exist yet,
+// sharing the data rather than copying it. Returns false, leaving nothing
+// behind, unless both have real paths on the same device of a file system that
+// supports cloning.
+func tryClone(srcFS FS, source string, dstFS FS, temp string) bool {
+	sr, ok := srcFS.(resolver)
+	if !ok {
+		return false
+	}
+	dr, ok := dstFS.(resolver)
+	if !ok {
+		return false
+	}
+	srcPath, err := sr.resolve(source)
+	if err != nil {
+		return false
+	}
+	dstPath, err := dr.resolve(temp)
+	if err != nil || !sameDevice(srcPath, filepath.Dir(dstPath)) {
+		return false
+	}
+	return cloneFile(srcPath, dstPath) == nil
+}
+
+// Writes to a file, seeking over writes of nothing but zeros rather than
+// writing them, so that they become holes. The file must be truncated to its
+// full size afterward, in case it ends with a hole. The file isn't embedded, so
+// io.Copy can't go around Write with its ReadFrom.
+type sparseWriter struct {
+	f *os.File
+}
+
+// Returns a sparseWriter for dst if src is an OS file with holes, and dst is
+// an OS file too.
+func newSparseWriter(src fs.File, dst File, size int64) (sparseWriter, bool) {
+	sf, ok := src.(*os.File)
+	if !ok || !hasHoles(sf, size) {
+		return sparseWriter{}, false
+	}
+	df, ok := dst.(*os.File)
+	if !ok {
+		return sparseWriter{}, false
+	}
+	return sparseWriter{df}, true
+}
+
+func (w sparseWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != 0 {
+			return w.f.Write(p)
+		}
+	}
+	if _, err := w.f.Seek(int64(len(p)), io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sets the size of the file, so it ends where the copy did.
+func (w sparseWriter) Truncate(size int64) error {
+	return w.f.Truncate(size)
+}
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !linux && !darwin && !freebsd
+
+package filesystem
+
+import "os"
+
+// Always false, so nothing is cloned.
+func sameDevice(a, b string) bool {
+	return false
+}
+
+// Always false, so files are copied as is.
+func hasHoles(f *os.File, size int64) bool {
+	return false
+}
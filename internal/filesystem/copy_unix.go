@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build linux || darwin || freebsd
+
+package filesystem
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns true if the paths a and b are on the same device.
+func sameDevice(a, b string) bool {
+	var sa, sb unix.Stat_t
+	if unix.Stat(a, &sa) != nil || unix.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
+
+// Returns true if f, which is size bytes, has holes. Leaves f at its start.
+func hasHoles(f *os.File, size int64) bool {
+	hole, err := f.Seek(0, unix.SEEK_HOLE)
+	if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+		return false
+	}
+	return err == nil && hole < size
+}
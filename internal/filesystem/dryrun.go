@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// A change that DryRunFS didn't make.
+type Operation struct {
+	// The method, e.g., "Create", "MkDirAll", or "Rename".
+	Op   string
+	Name string
+	// For Rename, the new name, and for Symlink, what the link points to.
+	Target string
+}
+
+// Wraps an FS so that nothing is changed, to see what would be. Reads go to
+// the wrapped FS, while changes are recorded as Operations and reported as
+// successful. So reading something that would have been written fails, and
+// files that would have been created are always empty.
+type DryRunFS struct {
+	FS
+	mutex sync.Mutex
+	ops   []Operation
+}
+
+func NewDryRunFS(fsys FS) *DryRunFS {
+	return &DryRunFS{FS: fsys}
+}
+
+// Returns the changes that would have been made, in order.
+func (d *DryRunFS) Operations() []Operation {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return slices.Clone(d.ops)
+}
+
+func (d *DryRunFS) Create(name string) (File, error) {
+	if err := d.record("Create", name, ""); err != nil {
+		return nil, err
+	}
+	return discardFile{name: path.Base(name)}, nil
+}
+
+func (d *DryRunFS) MkDir(name string, mode fs.FileMode) error {
+	return d.record("MkDir", name, "")
+}
+
+func (d *DryRunFS) MkDirAll(name string, mode fs.FileMode) error {
+	return d.record("MkDirAll", name, "")
+}
+
+func (d *DryRunFS) Remove(name string) error {
+	if err := checkBelow("remove", name); err != nil {
+		return err
+	}
+	return d.record("Remove", name, "")
+}
+
+func (d *DryRunFS) RemoveAll(name string) error {
+	if err := checkBelow("removeall", name); err != nil {
+		return err
+	}
+	return d.record("RemoveAll", name, "")
+}
+
+func (d *DryRunFS) Rename(oldname, newname string) error {
+	if err := checkBelow("rename", oldname); err != nil {
+		return err
+	} else if err := checkBelow("rename", newname); err != nil {
+		return err
+	}
+	return d.record("Rename", oldname, newname)
+}
+
+func (d *DryRunFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return d.record("Chtimes", name, "")
+}
+
+func (d *DryRunFS) Chmod(name string, mode fs.FileMode) error {
+	return d.record("Chmod", name, "")
+}
+
+func (d *DryRunFS) Symlink(oldname, newname string) error {
+	return d.record("Symlink", newname, oldname)
+}
+
+// Records the operation, if name is valid.
+func (d *DryRunFS) record(op, name, target string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ops = append(d.ops, Operation{Op: op, Name: name, Target: target})
+	return nil
+}
+
+// A file created by DryRunFS. Writes are discarded.
+type discardFile struct {
+	name string
+}
+
+func (f discardFile) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (f discardFile) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (f discardFile) Stat() (fs.FileInfo, error) {
+	return &memInfo{name: f.name, file: fstest.MapFile{Mode: 0666, ModTime: time.Now()}}, nil
+}
+
+func (f discardFile) Close() error {
+	return nil
+}
@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package filesystem
+
+import "errors"
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
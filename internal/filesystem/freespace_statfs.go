@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build linux || darwin || freebsd
+
+package filesystem
+
+import "golang.org/x/sys/unix"
+
+// Returns the bytes available to unprivileged users on the file system
+// containing path.
+func freeSpace(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
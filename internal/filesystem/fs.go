@@ -3,11 +3,13 @@
 package filesystem
 
 import (
-	"fmt"
+	"audio_converter/internal/logging"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type FS interface {
@@ -15,27 +17,64 @@ type FS interface {
 	fs.ReadDirFS
 	fs.ReadFileFS
 	fs.StatFS
-	// fs.GlobFS
+	// Globs with MatchGlob's syntax, so "**" matches any number of directories.
+	fs.GlobFS
 	// Resolves to the legit path. This is perhaps evil based on the _concept_ of Go's fs.FS, but implements
 
 	// Create a file in the FS. Returns the handle as per os.Create().
-	Create(name string) (fs.File, error)
+	Create(name string) (File, error)
 
 	// Create a directory in the FS.
 	MkDir(name string, mode fs.FileMode) error
 	// Create a directory in the FS, recursively.
 	MkDirAll(name string, mode fs.FileMode) error
+
+	// Remove a file or empty directory from the FS. The root can't be removed.
+	Remove(name string) error
+	// Remove a file or directory from the FS along with everything in it.
+	// Returns nil if name doesn't exist. The root can't be removed.
+	RemoveAll(name string) error
+	// Rename a file within the FS, replacing newname if it exists. Neither
+	// may be the root.
+	Rename(oldname, newname string) error
+
+	// Change the access and modification times of a file.
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	// Change the mode bits of a file.
+	Chmod(name string, mode fs.FileMode) error
+
+	// Like Stat, but describes a symbolic link itself rather than its target.
+	Lstat(name string) (fs.FileInfo, error)
+	// Returns the target of a symbolic link, as written in the link.
+	Readlink(name string) (string, error)
+	// Create newname as a symbolic link to oldname. oldname is written into the
+	// link as-is, so a relative oldname is relative to the link's directory.
+	Symlink(oldname, newname string) error
+}
+
+// A file opened for writing by FS.Create.
+type File interface {
+	fs.File
+	io.Writer
 }
 
 // Implements our extended FS for the target OS.
 type FileSystem struct {
-	root string
+	root   string
+	strict bool
 }
 
 func NewFileSystem(root string) *FileSystem {
 	return &FileSystem{root: root}
 }
 
+// Like NewFileSystem, but symlinks within root can't lead outside of it. Names
+// that would are rejected with fs.ErrInvalid, at the cost of following every
+// symlink in them on each call.
+func NewStrictFileSystem(root string) *FileSystem {
+	return &FileSystem{root: root, strict: true}
+}
+
 // Open opens the named file.
 // [File.Close] must be called to release any associated resources.
 //
@@ -89,19 +128,55 @@ func (fsys *FileSystem) Stat(name string) (fs.FileInfo, error) {
 	}
 }
 
+// Glob returns the names of all files matching pattern, or nil if there are
+// none. The syntax is that of MatchGlob, so unlike fs.Glob, "**" matches any
+// number of directories and a trailing "/" only matches directories. Errors
+// reading directories are ignored, like fs.Glob. A pattern that would reach
+// outside of the root, e.g., "../*", is rejected with fs.ErrInvalid.
+func (fsys *FileSystem) Glob(pattern string) ([]string, error) {
+	return globFS(fsys, pattern)
+}
+
+// Returns the path on disk for name, or fs.ErrInvalid if it would be outside
+// the root. E.g., "C:\x" and "a\..\..\b" are valid names, but escape on
+// Windows. In strict mode, so do symlinks to outside the root.
 func (fsys *FileSystem) resolve(name string) (string, error) {
-	if !fs.ValidPath(name) {
+	return fsys.resolvePath(name, true)
+}
+
+// Like resolve, but doesn't follow name if it's a symlink, for operations
+// on the link itself.
+func (fsys *FileSystem) resolveLink(name string) (string, error) {
+	return fsys.resolvePath(name, false)
+}
+
+func (fsys *FileSystem) resolvePath(name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
 		return "", fs.ErrInvalid
 	}
-	return filepath.Join(fsys.root, name), nil
+	path := filepath.Join(fsys.root, filepath.FromSlash(name))
+	if !within(filepath.Clean(fsys.root), path) {
+		return "", fs.ErrInvalid
+	}
+	if fsys.strict {
+		if err := checkLinks(fsys.root, path, follow); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
 }
 
-func (fsys *FileSystem) Create(name string) (fs.File, error) {
-	if path, err := fsys.resolve(name); err != nil {
+func (fsys *FileSystem) Create(name string) (File, error) {
+	path, err := fsys.resolve(name)
+	if err != nil {
 		return nil, err
-	} else {
-		return os.Create(path)
 	}
+	// Not returned directly, since a nil *os.File isn't a nil File.
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return fp, nil
 }
 
 func (fsys *FileSystem) MkDir(name string, mode fs.FileMode) error {
@@ -120,23 +195,212 @@ func (fsys *FileSystem) MkDirAll(name string, mode fs.FileMode) error {
 	}
 }
 
+func (fsys *FileSystem) Remove(name string) error {
+	if path, err := fsys.resolveBelow("remove", name); err != nil {
+		return err
+	} else {
+		return os.Remove(path)
+	}
+}
+
+func (fsys *FileSystem) RemoveAll(name string) error {
+	if path, err := fsys.resolveBelow("removeall", name); err != nil {
+		return err
+	} else {
+		return os.RemoveAll(path)
+	}
+}
+
+func (fsys *FileSystem) Rename(oldname, newname string) error {
+	oldpath, err := fsys.resolveBelow("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newpath, err := fsys.resolveBelow("rename", newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+// Like resolve, but rejects the root itself, so that op can't remove it or
+// move it away.
+func (fsys *FileSystem) resolveBelow(op, name string) (string, error) {
+	if err := checkBelow(op, name); err != nil {
+		return "", err
+	}
+	return fsys.resolveLink(name)
+}
+
+func (fsys *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if path, err := fsys.resolve(name); err != nil {
+		return err
+	} else {
+		return os.Chtimes(path, atime, mtime)
+	}
+}
+
+func (fsys *FileSystem) Chmod(name string, mode fs.FileMode) error {
+	if path, err := fsys.resolve(name); err != nil {
+		return err
+	} else {
+		return os.Chmod(path, mode)
+	}
+}
+
+func (fsys *FileSystem) Lstat(name string) (fs.FileInfo, error) {
+	if path, err := fsys.resolveLink(name); err != nil {
+		return nil, err
+	} else {
+		return os.Lstat(path)
+	}
+}
+
+func (fsys *FileSystem) Readlink(name string) (string, error) {
+	if path, err := fsys.resolveLink(name); err != nil {
+		return "", err
+	} else {
+		return os.Readlink(path)
+	}
+}
+
+func (fsys *FileSystem) Symlink(oldname, newname string) error {
+	if path, err := fsys.resolveLink(newname); err != nil {
+		return err
+	} else {
+		return os.Symlink(oldname, path)
+	}
+}
+
+// Returns the number of bytes that can be written to the file system holding
+// the root. Returns errors.ErrUnsupported on platforms where this isn't known.
+func (fsys *FileSystem) FreeSpace() (uint64, error) {
+	return freeSpace(fsys.root)
+}
+
 // Helper function that performs a copy between to filesystem.FS instances.
+//
+// The destination is written atomically, so it either contains the complete
+// source or is left untouched. It gets the permissions of the source.
+//
+// Between real paths on the same device, the source is cloned if the file
+// system supports it, e.g., btrfs, XFS, or APFS, which is instant. Otherwise,
+// it's copied, skipping long runs of zeros if the source has holes so the
+// copy keeps them. Which was done is logged with -v. Copies, but not clones,
+// are limited to the rate of CopyRate.
 func CopyFile(srcFS FS, source string, dstFS FS, destination string) (int64, error) {
+	return copyFile(srcFS, source, dstFS, destination, nil)
+}
+
+// Time spent on each side of CopyFileTimed.
+type CopyTiming struct {
+	Read  time.Duration // Reading the source.
+	Write time.Duration // Writing the destination and renaming it into place.
+}
+
+// Like CopyFile, but also measures how long reading and writing took. This is
+// slower, since the copy can't be handed off to the kernel.
+func CopyFileTimed(srcFS FS, source string, dstFS FS, destination string) (int64, CopyTiming, error) {
+	var timing CopyTiming
+	nb, err := copyFile(srcFS, source, dstFS, destination, &timing)
+	return nb, timing, err
+}
+
+// Does the work of CopyFile, timing it if timing isn't nil.
+func copyFile(srcFS FS, source string, dstFS FS, destination string, timing *CopyTiming) (int64, error) {
 	src, err := srcFS.Open(source)
 	if err != nil {
 		return 0, err
 	}
 	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
 
-	dst, err := dstFS.Create(destination)
+	af := NewAtomicFile(dstFS, destination)
+	start := time.Now()
+	if tryClone(srcFS, source, dstFS, af.Temp()) {
+		if timing != nil {
+			timing.Write += time.Since(start)
+		}
+		logging.Verbosef("Cloned %q to %q", source, destination)
+		return st.Size(), commitCopy(dstFS, af, st, timing)
+	}
+	dst, err := af.Create()
 	if err != nil {
 		return 0, err
 	}
-	defer dst.Close()
+	var w io.Writer = dst
+	var r io.Reader = src
+	strategy := "Copied"
+	sparse, isSparse := newSparseWriter(src, dst, st.Size())
+	if isSparse {
+		w = sparse
+		// Hide the WriteTo of the source too, which would skip the sparseWriter.
+		r = struct{ io.Reader }{src}
+		strategy = "Sparse copied"
+	}
+	if timing != nil {
+		w = timedWriter{w, &timing.Write}
+		r = timedReader{r, &timing.Read}
+	}
+	if CopyRate != nil {
+		// Outside the timing, so that waiting on the limit isn't counted as
+		// reading.
+		r = rateReader{r, CopyRate}
+	}
+	// An *os.File on both ends lets io.CopyBuffer hand this off to the kernel,
+	// unless it's wrapped for timing, sparseness, or a rate limit.
+	nb, err := io.CopyBuffer(w, r, make([]byte, CopyBufferSize))
+	if err == nil && isSparse {
+		// In case it ends with a hole.
+		err = sparse.Truncate(nb)
+	}
+	if err != nil {
+		return nb, errors.Join(err, af.Abort())
+	}
+	logging.Verbosef("%s %q to %q", strategy, source, destination)
+	return nb, commitCopy(dstFS, af, st, timing)
+}
 
-	fp := dst.(*os.File)
-	if fp == nil {
-		return 0, fmt.Errorf("dstFS.Create did not return a pointer to an os.File")
+// Gives the temporary file of af the permissions of the source, described by
+// st, and moves it into place.
+func commitCopy(dstFS FS, af *AtomicFile, st fs.FileInfo, timing *CopyTiming) error {
+	start := time.Now()
+	err := dstFS.Chmod(af.Temp(), st.Mode().Perm())
+	if err != nil {
+		return errors.Join(err, af.Abort())
 	}
-	return io.Copy(fp, src)
+	err = af.Commit()
+	if timing != nil {
+		timing.Write += time.Since(start)
+	}
+	return err
+}
+
+// Adds the time spent in Read to d.
+type timedReader struct {
+	r io.Reader
+	d *time.Duration
+}
+
+func (t timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	*t.d += time.Since(start)
+	return n, err
+}
+
+// Adds the time spent in Write to d.
+type timedWriter struct {
+	w io.Writer
+	d *time.Duration
+}
+
+func (t timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.w.Write(p)
+	*t.d += time.Since(start)
+	return n, err
 }
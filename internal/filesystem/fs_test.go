@@ -3,9 +3,23 @@
 package filesystem
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"testing/fstest"
+	"time"
+	"unicode/utf8"
 )
 
 func TestFileSystem(t *testing.T) {
@@ -15,6 +29,121 @@ func TestFileSystem(t *testing.T) {
 	}
 }
 
+func TestFileSystemRemoveRename(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	for _, name := range []string{"root/Album/01 Song.flac", "root/Album/scans/front.jpg", "root/old.m3u", "outside.txt"} {
+		name = filepath.Join(parent, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys := NewFileSystem(root)
+
+	if err := fsys.Rename("old.m3u", "Album/new.m3u"); err != nil {
+		t.Fatal(err)
+	} else if err := fsys.Remove("Album/01 Song.flac"); err != nil {
+		t.Fatal(err)
+	} else if err := fsys.RemoveAll("Album/scans"); err != nil {
+		t.Fatal(err)
+	} else if err := fsys.RemoveAll("missing"); err != nil {
+		t.Errorf("Removing all of nothing failed: %v", err)
+	}
+	if err := fstest.TestFS(fsys, "Album/new.m3u"); err != nil {
+		t.Fatal(err)
+	}
+	if names, err := fs.Glob(fsys, "**"); err != nil || !slices.Equal(names, []string{"Album", "Album/new.m3u"}) {
+		t.Errorf("Bad tree after changes: %q err: %v", names, err)
+	}
+
+	for _, name := range []string{".", "..", "../outside.txt", "Album/../../outside.txt", "/outside.txt", ""} {
+		if err := fsys.Remove(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Remove(%q) should be fs.ErrInvalid: %v", name, err)
+		}
+		if err := fsys.RemoveAll(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("RemoveAll(%q) should be fs.ErrInvalid: %v", name, err)
+		}
+		if err := fsys.Rename("Album/new.m3u", name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Rename to %q should be fs.ErrInvalid: %v", name, err)
+		}
+		if err := fsys.Rename(name, "moved"); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Rename from %q should be fs.ErrInvalid: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "outside.txt")); err != nil {
+		t.Errorf("Changed a file outside the root: %v", err)
+	} else if _, err := os.Stat(filepath.Join(root, "Album/new.m3u")); err != nil {
+		t.Errorf("Renamed out of the root: %v", err)
+	}
+}
+
+func TestFileSystemEscapes(t *testing.T) {
+	parent := t.TempDir()
+	outside := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.MkdirAll(filepath.Join(root, "Album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"tmp":      outside,
+		"up":       "..",
+		"dangling": filepath.Join(outside, "missing.m4a"),
+		"inside":   "Album",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("Can't create symlinks: %v", err)
+		}
+	}
+
+	names := []string{"/etc/passwd", "a/../../b", "../root/Album", "Album/../..", "./Album", ""}
+	if runtime.GOOS == "windows" {
+		names = append(names, `C:\x`, `\\server\share\x`, `Album\..\..\x`, `\x`)
+	}
+	for _, fsys := range []*FileSystem{NewFileSystem(root), NewStrictFileSystem(root)} {
+		for _, name := range names {
+			if _, err := fsys.Create(name); !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("Create(%q) should be fs.ErrInvalid: %v", name, err)
+			}
+			if err := fsys.MkDirAll(name, 0755); !errors.Is(err, fs.ErrInvalid) {
+				t.Errorf("MkDirAll(%q) should be fs.ErrInvalid: %v", name, err)
+			}
+		}
+	}
+
+	strict := NewStrictFileSystem(root)
+	for _, name := range []string{"tmp/x.m4a", "tmp", "up/x.m4a", "dangling", "inside/../tmp/x.m4a"} {
+		if _, err := strict.Create(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Create(%q) through a symlink out of the root should be fs.ErrInvalid: %v", name, err)
+		}
+	}
+	if err := strict.MkDirAll("tmp/Album", 0755); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("MkDirAll through a symlink out of the root should be fs.ErrInvalid: %v", err)
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("Wrote outside the root: %v err: %v", entries, err)
+	}
+	// The links themselves are within the root, and so is what's inside.
+	if st, err := strict.Lstat("tmp"); err != nil || st.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("Lstat of a link out of the root failed: %v", err)
+	} else if err := strict.Remove("dangling"); err != nil {
+		t.Errorf("Removing a link out of the root failed: %v", err)
+	} else if f, err := strict.Create("inside/song.m4a"); err != nil {
+		t.Errorf("Create through a link within the root failed: %v", err)
+	} else {
+		f.Close()
+	}
+	if _, err := strict.Stat("."); err != nil {
+		t.Errorf("Stat of the root failed: %v", err)
+	}
+
+	// Without strict mode, symlinks are trusted.
+	if err := NewFileSystem(root).MkDir("tmp/Album", 0755); err != nil {
+		t.Errorf("MkDir through a symlink failed: %v", err)
+	}
+}
+
 func TestIsTrashfile(t *testing.T) {
 	if !IsTrashFile(".DS_Store") || !IsTrashFile("/foo/bar/.DS_Store") {
 		t.Errorf("Failed to catch finder info file.")
@@ -27,6 +156,36 @@ func TestIsTrashfile(t *testing.T) {
 	}
 }
 
+func TestTrashPatterns(t *testing.T) {
+	t.Cleanup(func() { SetTrashPatterns(DefaultTrashPatterns()) })
+	for _, name := range []string{"Thumbs.db", "Album/thumbs.db", "Album/desktop.ini", "@eaDir/song.flac@SynoEAStream", "Album/.AppleDouble/song.flac"} {
+		if !IsTrashFile(name) {
+			t.Errorf("Failed to catch trash %q", name)
+		}
+	}
+	if !IsTrashDir("Album/@eaDir") || IsTrashDir("Album") || IsTrashFile("Album/song.flac") {
+		t.Errorf("Wrong trash directories")
+	}
+
+	SetTrashPatterns(TrashPatterns{})
+	if IsTrashFile(".DS_Store") || IsTrashDir("@eaDir") {
+		t.Errorf("Caught trash without any patterns")
+	}
+	for _, pattern := range []string{"Folder.jpg", "~*", ".@__thumb/"} {
+		if err := AddTrashPattern(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !IsTrashFile("Album/folder.jpg") || !IsTrashFile("~lock.flac") || !IsTrashDir(".@__thumb") || IsTrashDir("Folder.jpg") {
+		t.Errorf("Failed to use added patterns: %+v", CurrentTrashPatterns())
+	}
+	for _, pattern := range []string{"", "/", "*", "a/b", "a*b", `a\b`, "..", "./"} {
+		if err := AddTrashPattern(pattern); err == nil {
+			t.Errorf("Failed to reject %q", pattern)
+		}
+	}
+}
+
 func TestCleaner(t *testing.T) {
 	replacement := "_"
 	cleaner := NewCleaner(replacement, ReservedCharacters)
@@ -56,6 +215,70 @@ func TestCleaner(t *testing.T) {
 			}
 		}
 	})
+	t.Run("Device names", func(t *testing.T) {
+		for input, expected := range map[string]string{
+			"/music/CON/AUX.flac":       "/music/_CON/_AUX.flac",
+			"/music/nul.tar.m4a":        "/music/_nul.tar.m4a",
+			"/music/Com1/lpt9 .txt":     "/music/_Com1/_lpt9 .txt",
+			"/music/CONSOLE/NULL.flac":  "/music/CONSOLE/NULL.flac",
+			"/music/Prince/Controversy": "/music/Prince/Controversy",
+		} {
+			assert(input, expected)
+		}
+	})
+	t.Run("Trailing dots and spaces", func(t *testing.T) {
+		for input, expected := range map[string]string{
+			"/music/Vol. 1./01 Song.flac": "/music/Vol. 1_/01 Song.flac",
+			"/music/Artist /Album. .":     "/music/Artist_/Album___",
+			"/music/.hidden/...":          "/music/.hidden/___",
+			"../music/./song.flac":        "../music/song.flac",
+			"/music/CON./song.flac":       "/music/CON_/song.flac",
+		} {
+			assert(input, expected)
+		}
+		// The replacement would be stripped too.
+		if actual := NewCleaner(" ", ReservedCharacters).CleanName("Album..."); actual != "Album" {
+			t.Errorf("Trailing dots were not trimmed: %q", actual)
+		}
+	})
+	t.Run("Normalize", func(t *testing.T) {
+		composed, decomposed := "/music/Beyonc\u00e9/Caf\u00e9.flac", "/music/Beyonce\u0301/Cafe\u0301.flac"
+		c := NewCleaner("_", ReservedCharacters)
+		for _, tc := range []struct {
+			form     string
+			expected string
+		}{
+			{NormalizeNFC, composed},
+			{NormalizeNFD, decomposed},
+		} {
+			c.Normalize = tc.form
+			for _, input := range []string{composed, decomposed} {
+				if actual := c.CleanPath(input); actual != tc.expected {
+					t.Errorf("%s of %q: %q expected: %q", tc.form, input, actual, tc.expected)
+				}
+			}
+		}
+		c.Normalize = NormalizeNone
+		if actual := c.CleanPath(decomposed); actual != decomposed {
+			t.Errorf("Normalized without a form: %q", actual)
+		}
+		// Normalizing doesn't need a replacement.
+		c = NewCleaner("", ReservedCharacters)
+		c.Normalize = NormalizeNFC
+		if actual := c.CleanName("Cafe\u0301?"); actual != "Caf\u00e9?" {
+			t.Errorf("Failed to normalize without a replacement: %q", actual)
+		}
+	})
+	t.Run("UNIX only", func(t *testing.T) {
+		c := NewCleaner("_", ReservedCharacters)
+		c.DeviceNames = false
+		c.TrailingDots = false
+		for _, input := range []string{"/music/CON/AUX.flac", "/music/Vol. 1./Song ."} {
+			if actual := c.CleanPath(input); actual != input {
+				t.Errorf("CleanPath(%q) = %q with Windows names off", input, actual)
+			}
+		}
+	})
 	t.Run("Empty cleaner", func(t *testing.T) {
 		c := NewCleaner("_", []string{})
 		input := "/foo<>bar/ham \\ spam/quux.ext"
@@ -70,3 +293,562 @@ func TestCleaner(t *testing.T) {
 		}
 	})
 }
+
+func TestPathLimiter(t *testing.T) {
+	l := NewPathLimiter(24, 0)
+	for input, expected := range map[string]string{
+		"short.flac":                   "short.flac",
+		"exactly twenty-four.flac":     "exactly twenty-four.flac",
+		"twenty-five bytes!!!.flac":    "twenty-fiv~6a450a24.flac",
+		"Mvt. 1 - Allegro con brio":    "Mvt. 1 - Allegr~dfc8fa66",
+		"Dvořák - Symphony No. 9.flac": "Dvořák -~62a8304e.flac",
+		"..":                           "..",
+	} {
+		if actual := l.LimitName(input); actual != expected {
+			t.Errorf("LimitName(%q) = %q expected %q", input, actual, expected)
+		} else if len(actual) > 24 {
+			t.Errorf("LimitName(%q) = %q is too long", input, actual)
+		}
+	}
+	// Each rune of ř is two bytes, so it can't be split.
+	if actual := NewPathLimiter(18, 0).LimitName("Dvořák - Symphony No. 9.flac"); !utf8.ValidString(actual) || len(actual) > 18 {
+		t.Errorf("Split a rune: %q", actual)
+	}
+	if a, b := l.LimitName("Symphony No. 5 - I. Allegro.flac"), l.LimitName("Symphony No. 5 - II. Andante.flac"); a == b {
+		t.Errorf("Siblings weren't kept unique: %q", a)
+	}
+
+	l = NewPathLimiter(24, 40)
+	dir := l.LimitPath("A Very Long Album Name (Remastered)")
+	for _, name := range []string{"01 Short.flac", "02 A Much Longer Track Title.flac"} {
+		actual := l.LimitPath("A Very Long Album Name (Remastered)/" + name)
+		if path.Dir(actual) != dir {
+			t.Errorf("Directories disagree: %q and %q", dir, actual)
+		} else if len(actual) > 40 || path.Ext(actual) != ".flac" {
+			t.Errorf("Bad limited path: %q", actual)
+		}
+	}
+	if actual := NewPathLimiter(0, 0).LimitPath(strings.Repeat("x", 300)); len(actual) != 300 {
+		t.Errorf("Limited without any limits")
+	}
+}
+
+func TestAtomicFile(t *testing.T) {
+	for name, pattern := range map[string]string{
+		"album/song.m4a":   `^album/\.song\.[0-9a-f]{8}\.part\.m4a$`,
+		"album/notes":      `^album/\.notes\.[0-9a-f]{8}\.part$`,
+		"album/x.part.m4a": `^album/\.x\.part\.[0-9a-f]{8}\.part\.m4a$`,
+	} {
+		s := TempName(name)
+		if !regexp.MustCompile(pattern).MatchString(s) || !IsTempName(s) {
+			t.Errorf("Bad temp name for %q: %q", name, s)
+		}
+		if IsTempName(name) {
+			t.Errorf("%q is not a temp name", name)
+		}
+	}
+	if TempName("album/song.m4a") == TempName("album/song.m4a") {
+		t.Errorf("Temp names should be unique")
+	}
+
+	root := t.TempDir()
+	fsys := NewFileSystem(root)
+	write := func(af *AtomicFile, data string) {
+		fp, err := af.Create()
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if _, err := fp.Write([]byte(data)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	assertContent := func(name, expected string) {
+		if data, err := fsys.ReadFile(name); err != nil {
+			t.Errorf("ReadFile(%q) failed: %v", name, err)
+		} else if string(data) != expected {
+			t.Errorf("%s: actual: %q expected: %q", name, data, expected)
+		}
+	}
+	assertGone := func(name string) {
+		if _, err := fsys.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s should not exist: err: %v", name, err)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		af := NewAtomicFile(fsys, "commit.txt")
+		write(af, "first")
+		assertGone("commit.txt")
+		if err := af.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		assertContent("commit.txt", "first")
+		assertGone(af.Temp())
+
+		// Replaces the existing file.
+		af = NewAtomicFile(fsys, "commit.txt")
+		write(af, "second")
+		if err := af.Commit(); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		assertContent("commit.txt", "second")
+	})
+	t.Run("abort", func(t *testing.T) {
+		af := NewAtomicFile(fsys, "commit.txt")
+		write(af, "aborted")
+		if err := af.Abort(); err != nil {
+			t.Fatalf("Abort failed: %v", err)
+		}
+		assertContent("commit.txt", "second")
+		assertGone(af.Temp())
+
+		// Nothing to remove isn't an error.
+		if err := NewAtomicFile(fsys, "never.txt").Abort(); err != nil {
+			t.Errorf("Abort without Create failed: %v", err)
+		}
+	})
+	t.Run("commit no clobber", func(t *testing.T) {
+		for name, fsys := range map[string]FS{"FileSystem": fsys, "MemFS": NewMemFS(nil)} {
+			af := NewAtomicFile(fsys, "kept.txt")
+			write(af, "first")
+			if err := af.CommitNoClobber(); err != nil {
+				t.Fatalf("%s: CommitNoClobber failed: %v", name, err)
+			}
+			// Something else wrote the file while this one was being written.
+			af = NewAtomicFile(fsys, "kept.txt")
+			write(af, "second")
+			if err := af.CommitNoClobber(); !errors.Is(err, fs.ErrExist) {
+				t.Errorf("%s: expected fs.ErrExist, have %v", name, err)
+			}
+			if data, err := fs.ReadFile(fsys, "kept.txt"); err != nil || string(data) != "first" {
+				t.Errorf("%s: expected the first file to be kept, have %q: %v", name, data, err)
+			}
+			if _, err := fsys.Stat(af.Temp()); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: %s should not exist: err: %v", name, af.Temp(), err)
+			}
+		}
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		isDir   bool
+		match   bool
+	}{
+		{"*.pdf", "booklet.pdf", false, true},
+		{"*.pdf", "Album/booklet.pdf", false, false},
+		{"**/*.pdf", "booklet.pdf", false, true},
+		{"**/*.pdf", "Artist/Album/booklet.pdf", false, true},
+		{"Artist/**", "Artist/Album/song.flac", false, true},
+		{"Artist/**/song.flac", "Artist/song.flac", false, true},
+		{"Artist/**/song.flac", "Artist/Album/Disc 1/song.flac", false, true},
+		{"Artist/**/song.flac", "Other/Album/song.flac", false, false},
+		{"__backup/", "__backup", true, true},
+		{"__backup/", "__backup", false, false},
+		{"**/scans/", "Artist/Album/scans", true, true},
+		{"[Ss]cans", "scans", true, true},
+		{"?can", "scans", true, false},
+	} {
+		if actual := MatchGlob(tc.pattern, tc.name, tc.isDir); actual != tc.match {
+			t.Errorf("MatchGlob(%q, %q, %v): %v expected: %v", tc.pattern, tc.name, tc.isDir, actual, tc.match)
+		}
+	}
+}
+
+func TestGlob(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"cover.jpg", "notes.txt",
+		"Artist/Album/01.flac", "Artist/Album/cover.jpg", "Artist/Album/scans/back.jpg",
+		"Other/Album/cover.png",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Something outside the root for patterns to try to reach.
+	fsys := NewFileSystem(filepath.Join(root, "Artist"))
+	for pattern, expected := range map[string][]string{
+		"*":             {"Album"},
+		"Album/*.jpg":   {"Album/cover.jpg"},
+		"Album/*/":      {"Album/scans"},
+		"**/*.jpg":      {"Album/cover.jpg", "Album/scans/back.jpg"},
+		"Album/**":      {"Album", "Album/01.flac", "Album/cover.jpg", "Album/scans", "Album/scans/back.jpg"},
+		"*/[0-9]*.flac": {"Album/01.flac"},
+		"Missing/*":     nil,
+	} {
+		actual, err := fsys.Glob(pattern)
+		if err != nil {
+			t.Errorf("Glob(%q): %v", pattern, err)
+		} else if !slices.Equal(actual, expected) {
+			t.Errorf("Glob(%q): %q expected: %q", pattern, actual, expected)
+		}
+	}
+	for _, pattern := range []string{"../*", "../**/cover.jpg", "Album/../../*", "/*", "[abc"} {
+		if matches, err := fsys.Glob(pattern); err == nil {
+			t.Errorf("Glob(%q) should be rejected, matched %q", pattern, matches)
+		}
+	}
+	if _, err := fsys.Glob("../*"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Escaping the root should be fs.ErrInvalid: %v", err)
+	}
+}
+
+func TestValidateGlob(t *testing.T) {
+	for _, pattern := range []string{"*.pdf", "**/scans/", "a/[bc]/d"} {
+		if err := ValidateGlob(pattern); err != nil {
+			t.Errorf("ValidateGlob(%q): %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"", "/", "[abc", "a/[b-]/c", "a\\"} {
+		if err := ValidateGlob(pattern); err == nil {
+			t.Errorf("ValidateGlob(%q) should have failed", pattern)
+		}
+	}
+}
+
+func TestChtimesChmod(t *testing.T) {
+	fsys := NewFileSystem(t.TempDir())
+	fp, err := fsys.Create("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := fsys.Chtimes("file", past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Chmod("file", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := fsys.Stat("file"); err != nil {
+		t.Fatal(err)
+	} else if !st.ModTime().Equal(past) || st.Mode().Perm() != 0600 {
+		t.Errorf("mtime: %v mode: %v expected: %v %v", st.ModTime(), st.Mode().Perm(), past, fs.FileMode(0600))
+	}
+	if err := fsys.Chtimes("../file", past, past); err == nil {
+		t.Errorf("Chtimes should reject paths outside the FS")
+	}
+}
+
+func TestFreeSpace(t *testing.T) {
+	fsys := NewFileSystem(t.TempDir())
+	free, err := fsys.FreeSpace()
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Errorf("No free space in a temporary directory")
+	}
+}
+
+func TestCopyFileTimed(t *testing.T) {
+	src := NewFileSystem(t.TempDir())
+	dst := NewFileSystem(t.TempDir())
+	data := make([]byte, 1<<20)
+	if err := os.WriteFile(filepath.Join(src.root, "song.flac"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	nb, timing, err := CopyFileTimed(src, "song.flac", dst, "song.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nb != int64(len(data)) {
+		t.Errorf("Copied %d bytes, expected %d", nb, len(data))
+	}
+	if timing.Read <= 0 || timing.Write <= 0 {
+		t.Errorf("Copy wasn't timed: %+v", timing)
+	}
+	if actual, err := dst.ReadFile("song.flac"); err != nil || len(actual) != len(data) {
+		t.Errorf("Bad copy: %d bytes err: %v", len(actual), err)
+	}
+}
+
+func TestCopyFileSparse(t *testing.T) {
+	src := NewFileSystem(t.TempDir())
+	dst := NewFileSystem(t.TempDir())
+	f, err := os.Create(filepath.Join(src.root, "sparse.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(8 << 20); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.WriteAt([]byte("data"), 4<<20); err != nil {
+		t.Fatal(err)
+	}
+	if nb, err := CopyFile(src, "sparse.wav", dst, "sparse.wav"); err != nil {
+		t.Fatal(err)
+	} else if nb != 8<<20 {
+		t.Errorf("Copied %d bytes, expected %d", nb, 8<<20)
+	}
+	expected, err := src.ReadFile("sparse.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := dst.ReadFile("sparse.wav"); err != nil || !bytes.Equal(actual, expected) {
+		t.Fatalf("Bad copy: %d bytes err: %v", len(actual), err)
+	}
+	if !hasHoles(f, 8<<20) {
+		t.Skip("The temporary directory doesn't support holes")
+	}
+	out, err := os.Open(filepath.Join(dst.root, "sparse.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if !hasHoles(out, 8<<20) {
+		t.Errorf("The copy should keep the holes of the source")
+	}
+}
+
+func TestSparseWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := sparseWriter{f}
+	for _, chunk := range [][]byte{make([]byte, 3), []byte("ab"), make([]byte, 2)} {
+		if n, err := w.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	if err := w.Truncate(7); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(f.Name()); err != nil || string(data) != "\x00\x00\x00ab\x00\x00" {
+		t.Errorf("Bad write: %q err: %v", data, err)
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	m := NewMemFS(fstest.MapFS{
+		"a.flac": {Data: []byte("abc"), Mode: 0644},
+		"b.flac": {Data: []byte("abc"), Mode: 0644},
+	})
+	sum, err := HashFile(m, "a.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; sum != want {
+		t.Errorf("HashFile() = %q, want %q", sum, want)
+	}
+	if other, err := HashFile(m, "b.flac"); err != nil || other != sum {
+		t.Errorf("Identical files should hash the same: %q %v", other, err)
+	}
+	if _, err := HashFile(m, "missing.flac"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS(fstest.MapFS{
+		"Album/01 Song.flac": {Data: []byte("flac"), Mode: 0644},
+		"Album/cover.jpg":    {Data: []byte("jpeg"), Mode: 0644},
+	})
+	if err := m.MkDirAll("Out/Album", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.Create("Out/Album/01 Song.m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := m.Stat("Out/Album/01 Song.m4a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "m4a data"); err != nil {
+		t.Fatal(err)
+	}
+	// Visible before closing, like on disk, but not to what was read before.
+	if data, err := m.ReadFile("Out/Album/01 Song.m4a"); err != nil || string(data) != "m4a data" {
+		t.Errorf("Bad data while open: %q err: %v", data, err)
+	} else if before.Size() != 0 {
+		t.Errorf("A write changed an earlier Stat: %d", before.Size())
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := f.Write([]byte("more")); !errors.Is(err, fs.ErrClosed) {
+		t.Errorf("Write after Close should be fs.ErrClosed: %v", err)
+	}
+	if err := m.Symlink("01 Song.m4a", "Out/Album/link.m4a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(m, "Album/01 Song.flac", "Album/cover.jpg", "Out/Album/01 Song.m4a", "Out/Album/link.m4a"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("Out/Album/link.m4a"); err != nil || string(data) != "m4a data" {
+		t.Errorf("Bad data through a symlink: %q err: %v", data, err)
+	} else if st, err := m.Lstat("Out/Album/link.m4a"); err != nil || st.Mode().Type() != fs.ModeSymlink {
+		t.Errorf("Lstat should describe the link: %v err: %v", st, err)
+	} else if target, err := m.Readlink("Out/Album/link.m4a"); err != nil || target != "01 Song.m4a" {
+		t.Errorf("Bad link: %q err: %v", target, err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		fn   func() error
+	}{
+		{"create without parent", fs.ErrNotExist, func() error { _, err := m.Create("Missing/song.m4a"); return err }},
+		{"create over directory", errIsDir, func() error { _, err := m.Create("Album"); return err }},
+		{"mkdir existing", fs.ErrExist, func() error { return m.MkDir("Album", 0755) }},
+		{"mkdir without parent", fs.ErrNotExist, func() error { return m.MkDir("Missing/Album", 0755) }},
+		{"mkdirall through file", errNotDir, func() error { return m.MkDirAll("Album/cover.jpg/x", 0755) }},
+		{"remove non-empty", errNotEmpty, func() error { return m.Remove("Album") }},
+		{"remove missing", fs.ErrNotExist, func() error { return m.Remove("Missing") }},
+		{"remove root", fs.ErrInvalid, func() error { return m.RemoveAll(".") }},
+		{"remove escape", fs.ErrInvalid, func() error { return m.RemoveAll("../Album") }},
+		{"rename into itself", fs.ErrInvalid, func() error { return m.Rename("Album", "Album/Sub") }},
+		{"rename over non-empty", errNotEmpty, func() error { return m.Rename("Out/Album", "Album") }},
+		{"rename escape", fs.ErrInvalid, func() error { return m.Rename("Album", "../Album") }},
+		{"open escape", fs.ErrInvalid, func() error { _, err := m.Open("../Album"); return err }},
+	} {
+		if err := tc.fn(); !errors.Is(err, tc.err) {
+			t.Errorf("%s: %v expected: %v", tc.name, err, tc.err)
+		}
+	}
+
+	if err := m.Rename("Out/Album", "Out/Renamed"); err != nil {
+		t.Fatal(err)
+	} else if err := m.Remove("Album/cover.jpg"); err != nil {
+		t.Fatal(err)
+	} else if err := m.RemoveAll("Album"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Out", "Out/Renamed", "Out/Renamed/01 Song.m4a", "Out/Renamed/link.m4a"}
+	if names, err := m.Glob("**"); err != nil || !slices.Equal(names, expected) {
+		t.Errorf("Bad tree: %q err: %v expected: %q", names, err, expected)
+	}
+}
+
+func TestDryRunFS(t *testing.T) {
+	m := NewMemFS(fstest.MapFS{"song.flac": {Data: []byte("flac"), Mode: 0644}})
+	d := NewDryRunFS(m)
+	if _, err := CopyFile(d, "song.flac", d, "Album/song.flac"); err != nil {
+		t.Fatal(err)
+	} else if err := d.RemoveAll("Old"); err != nil {
+		t.Fatal(err)
+	} else if err := d.Remove("../song.flac"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Removing outside the root should be fs.ErrInvalid: %v", err)
+	}
+	ops := d.Operations()
+	if len(ops) != 4 || ops[0].Op != "Create" || ops[1].Op != "Chmod" || ops[2].Op != "Rename" || ops[2].Target != "Album/song.flac" || ops[3] != (Operation{Op: "RemoveAll", Name: "Old"}) {
+		t.Errorf("Bad operations: %+v", ops)
+	}
+	if names, err := fs.Glob(m, "**"); err != nil || !slices.Equal(names, []string{"song.flac"}) {
+		t.Errorf("Changed the wrapped FS: %q err: %v", names, err)
+	}
+}
+
+func TestCopyFileMemFS(t *testing.T) {
+	src := NewFileSystem(t.TempDir())
+	if err := os.WriteFile(filepath.Join(src.root, "song.flac"), []byte("flac"), 0640); err != nil {
+		t.Fatal(err)
+	} else if err := os.Chmod(filepath.Join(src.root, "song.flac"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemFS(nil)
+	if nb, err := CopyFile(src, "song.flac", dst, "song.flac"); err != nil {
+		t.Fatal(err)
+	} else if nb != 4 {
+		t.Errorf("Copied %d bytes, expected 4", nb)
+	}
+	if data, err := dst.ReadFile("song.flac"); err != nil || string(data) != "flac" {
+		t.Errorf("Bad copy: %q err: %v", data, err)
+	}
+	if st, err := dst.Stat("song.flac"); err != nil || st.Mode().Perm() != 0640 {
+		t.Errorf("The copy should have the source's mode: %v err: %v", st, err)
+	}
+	if names, err := fs.Glob(dst, "*"); err != nil || len(names) != 1 {
+		t.Errorf("The temporary file should be gone: %q err: %v", names, err)
+	}
+
+	// And back again, with a copy of the copy.
+	back := NewFileSystem(t.TempDir())
+	if _, _, err := CopyFileTimed(dst, "song.flac", back, "copy.flac"); err != nil {
+		t.Fatal(err)
+	} else if data, err := back.ReadFile("copy.flac"); err != nil || string(data) != "flac" {
+		t.Errorf("Bad copy from memory: %q err: %v", data, err)
+	}
+}
+
+func TestCopyRate(t *testing.T) {
+	// To memory, so that it can't be cloned instead.
+	src := NewFileSystem(t.TempDir())
+	data := bytes.Repeat([]byte("flac"), 48<<10)
+	if err := os.WriteFile(filepath.Join(src.root, "song.flac"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	CopyRate = NewRateLimiter(128 << 10)
+	defer func() { CopyRate = nil }()
+	// The first second's worth goes right away, and the other 64 KiB takes
+	// half a second more.
+	start := time.Now()
+	dst := NewMemFS(nil)
+	if _, err := CopyFile(src, "song.flac", dst, "song.flac"); err != nil {
+		t.Fatal(err)
+	} else if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Copying 192 KiB at 128 KiB/s took %v", elapsed)
+	}
+	if copied, err := dst.ReadFile("song.flac"); err != nil || !bytes.Equal(copied, data) {
+		t.Errorf("Bad copy of %d bytes: err: %v", len(copied), err)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1 << 20)
+	start := time.Now()
+	l.Wait(1 << 20)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("A second's worth should go at once, but took %v", elapsed)
+	}
+	// Both owe a quarter second, so the second waits for the first.
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait(256 << 10)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Another 512 KiB at 1 MiB/s should take half a second, but took %v", elapsed)
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	fsys := NewFileSystem(t.TempDir())
+	if err := fsys.MkDir("dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := fsys.Create("dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	if err := fsys.Symlink("dir/file", "link"); err != nil {
+		t.Skipf("Can't create symlinks here: %v", err)
+	}
+	if target, err := fsys.Readlink("link"); err != nil || target != "dir/file" {
+		t.Errorf("Readlink: %q err: %v", target, err)
+	}
+	if st, err := fsys.Lstat("link"); err != nil || st.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat should describe the link: %v err: %v", st, err)
+	}
+	if st, err := fsys.Stat("link"); err != nil || !st.Mode().IsRegular() {
+		t.Errorf("Stat should follow the link: %v err: %v", st, err)
+	}
+	if err := fsys.Symlink("file", "../link"); err == nil {
+		t.Errorf("Symlink should reject paths outside the FS")
+	}
+}
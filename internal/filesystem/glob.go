@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Returns an error if pattern is not a valid glob for MatchGlob.
+func ValidateGlob(pattern string) error {
+	if strings.TrimSuffix(pattern, "/") == "" {
+		return fmt.Errorf("empty pattern %q", pattern)
+	}
+	for _, elem := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		if _, err := path.Match(elem, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Reports whether name, a slash separated path relative to some root, matches
+// pattern. Each element of the pattern uses the syntax of path.Match, except
+// that "**" matches any number of elements, including none. A pattern ending in
+// "/" only matches directories, which isDir says whether name is.
+//
+// E.g., "**/*.pdf" matches "booklet.pdf" and "Artist/Album/booklet.pdf", and
+// "__backup/" matches the directory "__backup" but not a file by that name.
+func MatchGlob(pattern, name string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// Implements Glob for fsys by walking the directories that could have a match,
+// starting from the part of pattern without any wildcards. Doesn't call
+// fs.Glob, which would call back into fsys.Glob.
+func globFS(fsys fs.FS, pattern string) ([]string, error) {
+	if err := ValidateGlob(pattern); err != nil {
+		return nil, &fs.PathError{Op: "glob", Path: pattern, Err: path.ErrBadPattern}
+	} else if !fs.ValidPath(strings.TrimSuffix(pattern, "/")) {
+		return nil, &fs.PathError{Op: "glob", Path: pattern, Err: fs.ErrInvalid}
+	}
+	patterns := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	root := "."
+	for i, elem := range patterns[:len(patterns)-1] {
+		if hasMeta(elem) {
+			break
+		}
+		root = path.Join(patterns[:i+1]...)
+	}
+	var matches []string
+	fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return nil
+		}
+		if MatchGlob(pattern, name, d.IsDir()) {
+			matches = append(matches, name)
+		}
+		if d.IsDir() && !matchPrefix(patterns, strings.Split(name, "/")) {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return matches, nil
+}
+
+// Reports whether the directory names could contain a match for patterns,
+// the elements of a pattern.
+func matchPrefix(patterns, names []string) bool {
+	for ; len(names) > 0; patterns, names = patterns[1:], names[1:] {
+		if len(patterns) == 0 {
+			return false
+		} else if patterns[0] == "**" {
+			return true
+		} else if ok, _ := path.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+	}
+	return len(patterns) > 0
+}
+
+// Reports whether elem has any of the special characters of path.Match.
+func hasMeta(elem string) bool {
+	return strings.ContainsAny(elem, `*?[\`)
+}
+
+func matchElems(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			rest := patterns[1:]
+			for i := range len(names) + 1 {
+				if matchElems(rest, names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
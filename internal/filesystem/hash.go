@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+)
+
+// Returns the SHA-256 of the contents of name in fsys, in hex. The file is
+// read a buffer at a time, so it's fine for files too big to fit in memory.
+func HashFile(fsys fs.FS, name string) (string, error) {
+	fp, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+	h := sha256.New()
+	if _, err := io.CopyBuffer(h, fp, make([]byte, CopyBufferSize)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
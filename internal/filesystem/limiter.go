@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// The usual limit on the bytes in a file name, NAME_MAX on most UNIX file
+// systems, and 255 UTF-16 units on NTFS and exFAT, which fit at least as many.
+const DefaultMaxNameBytes = 255
+
+// Extensions longer than this are assumed to be part of the name, like the
+// ". 1 - Allegro" of "Symphony No. 5, Mvt. 1 - Allegro".
+const maxExtBytes = 8
+
+// Long enough for "~" and the hash of what was removed.
+const hashBytes = 9
+
+// Shortens the elements of slash separated paths that are too long. Elements
+// are truncated without splitting UTF-8 runes, keeping their extension, and
+// get a hash of what was removed, so siblings that only differ past the limit
+// stay unique. E.g., "Very Long Name.flac" limited to 16 bytes becomes
+// "Ve~1a2b3c4d.flac".
+type PathLimiter struct {
+	// The most bytes in any element, or 0 for no limit.
+	MaxName int
+	// The most bytes in the whole path, or 0 for no limit. Only the last
+	// element is shortened to fit, since the rest are limited as paths of
+	// their own. So a directory is always limited the same way, whichever
+	// path it's found in.
+	MaxPath int
+}
+
+// Creates a new limiter. Either limit may be 0 to not enforce it.
+func NewPathLimiter(maxName, maxPath int) *PathLimiter {
+	return &PathLimiter{MaxName: maxName, MaxPath: maxPath}
+}
+
+// Returns path with each element limited to MaxName bytes, and the whole to
+// MaxPath bytes if the elements leading to it allow.
+func (l *PathLimiter) LimitPath(p string) string {
+	dir, name := path.Split(p)
+	if dir == "" || dir == "/" {
+		return dir + l.limit(name, len(dir))
+	}
+	dir = l.LimitPath(strings.TrimSuffix(dir, "/")) + "/"
+	return dir + l.limit(name, len(dir))
+}
+
+// Returns name limited to MaxName bytes.
+func (l *PathLimiter) LimitName(name string) string {
+	return l.limit(name, 0)
+}
+
+// Returns name limited to MaxName bytes, and to MaxPath bytes after a prefix of
+// used bytes.
+func (l *PathLimiter) limit(name string, used int) string {
+	n := l.MaxName
+	if l.MaxPath > 0 && (n <= 0 || l.MaxPath-used < n) {
+		n = l.MaxPath - used
+	}
+	if n <= 0 || len(name) <= n || name == "." || name == ".." {
+		return name
+	}
+	stem, ext := name, path.Ext(name)
+	if len(ext) > maxExtBytes || len(ext) == len(name) || strings.ContainsRune(ext, ' ') {
+		ext = ""
+	}
+	stem = stem[:len(stem)-len(ext)]
+	// Keeps at least a byte of the stem, even if that's too long. The
+	// file system will have to complain.
+	keep := max(n-len(ext)-hashBytes, 1)
+	if keep >= len(stem) {
+		return name
+	}
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	h := fnv.New32a()
+	h.Write([]byte(stem[keep:]))
+	return fmt.Sprintf("%s~%08x%s", stem[:keep], h.Sum32(), ext)
+}
@@ -0,0 +1,440 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+	errLoop     = errors.New("too many levels of symbolic links")
+)
+
+// An FS kept in memory, for tests and trying things out without touching the
+// disk. Reads are served by fstest.MapFS, so directories are implied by the
+// files in them and ReadDir is sorted by name. Otherwise, it acts like the
+// disk: files are visible as soon as they're created, parents must exist, and
+// only empty directories can be removed. Symlinks are only followed as the
+// last element of a name. Safe for concurrent use.
+type MemFS struct {
+	mutex sync.Mutex
+	files fstest.MapFS
+}
+
+// Creates a MemFS holding files, which it takes ownership of. files may be
+// nil for an empty FS.
+func NewMemFS(files fstest.MapFS) *MemFS {
+	if files == nil {
+		files = fstest.MapFS{}
+	}
+	return &MemFS{files: files}
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, err := m.follow("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.snapshot(name).Open(name)
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, err := m.follow("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.snapshot(name).ReadDir(name)
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, err := m.follow("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.snapshot(name).ReadFile(name)
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, err := m.follow("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return m.snapshot(name).Stat(name)
+}
+
+// Globs like FileSystem.Glob.
+func (m *MemFS) Glob(pattern string) ([]string, error) {
+	return globFS(m, pattern)
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, err := m.follow("open", name)
+	if err != nil {
+		return nil, err
+	} else if err := m.checkParent("open", name); err != nil {
+		return nil, err
+	}
+	mf, ok := m.files[name]
+	if mode, exists := m.lstat(name); exists && mode.IsDir() {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	} else if ok {
+		mf.Data = nil
+		mf.ModTime = time.Now()
+	} else {
+		mf = &fstest.MapFile{Mode: 0666, ModTime: time.Now()}
+		m.files[name] = mf
+	}
+	return &memFile{fsys: m, file: mf, name: name}, nil
+}
+
+func (m *MemFS) MkDir(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.lstat(name); exists {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if err := m.checkParent("mkdir", name); err != nil {
+		return err
+	}
+	m.files[name] = &fstest.MapFile{Mode: fs.ModeDir | mode.Perm(), ModTime: time.Now()}
+	return nil
+}
+
+func (m *MemFS) MkDirAll(name string, mode fs.FileMode) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	dir := ""
+	for elem := range strings.SplitSeq(name, "/") {
+		dir = path.Join(dir, elem)
+		if existing, exists := m.lstat(dir); exists && !existing.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: errNotDir}
+		} else if !exists {
+			m.files[dir] = &fstest.MapFile{Mode: fs.ModeDir | mode.Perm(), ModTime: time.Now()}
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	if err := checkBelow("remove", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.lstat(name); !exists {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	} else if m.hasChildren(name) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	if err := checkBelow("removeall", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for file := range m.files {
+		if file == name || strings.HasPrefix(file, name+"/") {
+			delete(m.files, file)
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	if err := checkBelow("rename", oldname); err != nil {
+		return err
+	} else if err := checkBelow("rename", newname); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldMode, exists := m.lstat(oldname)
+	if !exists {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: fs.ErrNotExist}
+	} else if err := m.checkParent("rename", newname); err != nil {
+		return err
+	} else if oldname == newname {
+		return nil
+	} else if strings.HasPrefix(newname, oldname+"/") {
+		return &fs.PathError{Op: "rename", Path: newname, Err: fs.ErrInvalid}
+	}
+	if newMode, exists := m.lstat(newname); exists {
+		if newMode.IsDir() != oldMode.IsDir() {
+			err := errIsDir
+			if oldMode.IsDir() {
+				err = errNotDir
+			}
+			return &fs.PathError{Op: "rename", Path: newname, Err: err}
+		} else if m.hasChildren(newname) {
+			return &fs.PathError{Op: "rename", Path: newname, Err: errNotEmpty}
+		}
+		delete(m.files, newname)
+	}
+	for file, mf := range m.files {
+		if file == oldname || strings.HasPrefix(file, oldname+"/") {
+			delete(m.files, file)
+			m.files[newname+file[len(oldname):]] = mf
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	mf, err := m.entry("chtimes", name)
+	if err == nil {
+		mf.ModTime = mtime
+	}
+	return err
+}
+
+func (m *MemFS) Chmod(name string, mode fs.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	mf, err := m.entry("chmod", name)
+	if err == nil {
+		mf.Mode = mf.Mode.Type() | mode.Perm()
+	}
+	return err
+}
+
+func (m *MemFS) Lstat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if mf, ok := m.files[name]; ok && mf.Mode.Type() == fs.ModeSymlink {
+		return &memInfo{name: path.Base(name), file: *mf}, nil
+	}
+	return m.snapshot(name).Stat(name)
+}
+
+func (m *MemFS) Readlink(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if mf, ok := m.files[name]; ok && mf.Mode.Type() == fs.ModeSymlink {
+		return string(mf.Data), nil
+	} else if _, exists := m.lstat(name); exists {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) Symlink(oldname, newname string) error {
+	if !fs.ValidPath(newname) {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrInvalid}
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.lstat(newname); exists {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	} else if err := m.checkParent("symlink", newname); err != nil {
+		return err
+	}
+	m.files[newname] = &fstest.MapFile{Data: []byte(oldname), Mode: fs.ModeSymlink | 0777, ModTime: time.Now()}
+	return nil
+}
+
+// Returns the mode of name without following symlinks, and whether it exists
+// at all. Directories implied by the files in them exist too.
+func (m *MemFS) lstat(name string) (fs.FileMode, bool) {
+	if name == "." {
+		return fs.ModeDir, true
+	} else if mf, ok := m.files[name]; ok {
+		return mf.Mode, true
+	} else if m.hasChildren(name) {
+		return fs.ModeDir, true
+	}
+	return 0, false
+}
+
+// Returns true if anything is within the directory name.
+func (m *MemFS) hasChildren(name string) bool {
+	for file := range m.files {
+		if name == "." || strings.HasPrefix(file, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an error unless the parent of name is a directory.
+func (m *MemFS) checkParent(op, name string) error {
+	if mode, exists := m.lstat(path.Dir(name)); !exists {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	} else if !mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+// Returns the name that name refers to if it's a symlink, or else name.
+func (m *MemFS) follow(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	for range 40 {
+		mf, ok := m.files[name]
+		if !ok || mf.Mode.Type() != fs.ModeSymlink {
+			return name, nil
+		}
+		target := path.Join(path.Dir(name), string(mf.Data))
+		if path.IsAbs(string(mf.Data)) || !fs.ValidPath(target) {
+			// Outside the FS, so there's nothing to find.
+			return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		name = target
+	}
+	return "", &fs.PathError{Op: op, Path: name, Err: errLoop}
+}
+
+// Returns the entry for name, following symlinks. Implied directories are
+// given an entry, so that they can be changed.
+func (m *MemFS) entry(op, name string) (*fstest.MapFile, error) {
+	name, err := m.follow(op, name)
+	if err != nil {
+		return nil, err
+	} else if mf, ok := m.files[name]; ok {
+		return mf, nil
+	} else if name != "." && m.hasChildren(name) {
+		mf = &fstest.MapFile{Mode: fs.ModeDir | 0755, ModTime: time.Now()}
+		m.files[name] = mf
+		return mf, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Returns a copy of the entries at or within name, so that what's read from it
+// doesn't change with later writes. Data is shared, since memFile never changes
+// the bytes a copy can see.
+func (m *MemFS) snapshot(name string) fstest.MapFS {
+	files := make(fstest.MapFS)
+	for file, mf := range m.files {
+		if name == "." || file == name || strings.HasPrefix(file, name+"/") {
+			c := *mf
+			files[file] = &c
+		}
+	}
+	return files
+}
+
+// A file opened by MemFS.Create. Like on disk, it keeps working if it's
+// renamed or removed while open.
+type memFile struct {
+	fsys   *MemFS
+	file   *fstest.MapFile
+	name   string
+	offset int
+	closed bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fsys.mutex.Lock()
+	defer f.fsys.mutex.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	} else if f.offset >= len(f.file.Data) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.file.Data[f.offset:])
+	f.offset += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fsys.mutex.Lock()
+	defer f.fsys.mutex.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.offset == len(f.file.Data) {
+		// Bytes past the end of a copy's Data aren't visible to it.
+		f.file.Data = append(f.file.Data, p...)
+	} else {
+		data := slices.Clone(f.file.Data)
+		if end := f.offset + len(p); end > len(data) {
+			data = slices.Grow(data, end-len(data))[:end]
+		}
+		copy(data[f.offset:], p)
+		f.file.Data = data
+	}
+	f.offset += len(p)
+	f.file.ModTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mutex.Lock()
+	defer f.fsys.mutex.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return &memInfo{name: path.Base(f.name), file: *f.file}, nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.mutex.Lock()
+	defer f.fsys.mutex.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// Describes a copy of an entry of a MemFS.
+type memInfo struct {
+	name string
+	file fstest.MapFile
+}
+
+func (i *memInfo) Name() string       { return i.name }
+func (i *memInfo) Size() int64        { return int64(len(i.file.Data)) }
+func (i *memInfo) Mode() fs.FileMode  { return i.file.Mode }
+func (i *memInfo) ModTime() time.Time { return i.file.ModTime }
+func (i *memInfo) IsDir() bool        { return i.file.Mode.IsDir() }
+func (i *memInfo) Sys() any           { return i.file.Sys }
+
+// Returns an error if name is invalid or the root, which can't be removed or
+// renamed.
+func checkBelow(op, name string) error {
+	if name == "." || !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Limits the bytes per second of every copy made by CopyFile, or nil for no
+// limit. Shared by all of them, so the limit holds however many run at once.
+var CopyRate *RateLimiter
+
+// The most a limited copy reads at a time, so that a slow rate is spread evenly
+// rather than going in bursts of a whole buffer.
+const rateChunkSize = 64 << 10
+
+// A token bucket limiting the bytes per second passed through it. Up to a
+// second's worth can go at once after a pause. Safe for concurrent use.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64 // Bytes per second.
+	tokens float64 // Bytes that may go now, negative when they're owed.
+	last   time.Time
+}
+
+// Returns a limiter for rate bytes per second, which must be above 0.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// Blocks until n more bytes may go. Each caller takes its bytes right away and
+// sleeps until they've been paid for, so concurrent callers share the rate in
+// the order they arrived.
+func (l *RateLimiter) Wait(n int) {
+	l.mutex.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+	time.Sleep(delay)
+}
+
+// Reads from r no faster than l allows. It has no WriteTo, so io.Copy can't go
+// around it by handing the copy to the kernel.
+type rateReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+func (r rateReader) Read(p []byte) (int, error) {
+	if len(p) > rateChunkSize {
+		p = p[:rateChunkSize]
+	}
+	n, err := r.r.Read(p)
+	r.l.Wait(n)
+	return n, err
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package filesystem
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Returns true if path is root or within it. Both must be clean.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// Returns fs.ErrInvalid if following the symlinks in path leads outside of
+// root. Unless follow is set, path itself isn't followed if it's a symlink.
+func checkLinks(root, path string, follow bool) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	base := ""
+	if !follow && path != filepath.Clean(root) {
+		path, base = filepath.Split(path)
+	}
+	real, err := realPath(path)
+	if err != nil {
+		return err
+	} else if !within(realRoot, filepath.Join(real, base)) {
+		return fs.ErrInvalid
+	}
+	return nil
+}
+
+// Returns path with its symlinks followed, as far as it exists. Unlike
+// filepath.EvalSymlinks, dangling symlinks are followed to where they point,
+// since creating the missing file would put it there.
+func realPath(path string) (string, error) {
+	for range 255 {
+		real, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return real, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		// Look for the deepest part that exists.
+		dir, rest := path, ""
+		for {
+			st, err := os.Lstat(dir)
+			if err == nil && st.Mode().Type() == fs.ModeSymlink {
+				// Dangling, so try again from its target.
+				target, err := os.Readlink(dir)
+				if err != nil {
+					return "", err
+				} else if !filepath.IsAbs(target) {
+					target = filepath.Join(filepath.Dir(dir), target)
+				}
+				path = filepath.Join(target, rest)
+				break
+			} else if err == nil {
+				real, err := filepath.EvalSymlinks(dir)
+				if err != nil {
+					return "", err
+				}
+				return filepath.Join(real, rest), nil
+			} else if parent := filepath.Dir(dir); parent != dir {
+				rest = filepath.Join(filepath.Base(dir), rest)
+				dir = parent
+			} else {
+				return path, nil
+			}
+		}
+	}
+	return "", &fs.PathError{Op: "resolve", Path: path, Err: errLoop}
+}
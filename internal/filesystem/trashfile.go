@@ -3,24 +3,122 @@
 package filesystem
 
 import (
+	"fmt"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
+// The names of files and directories that are trash for the purposes of this
+// application, like metadata left by a file manager or NAS. Names are matched
+// without regard to case, since they mostly come from Windows and macOS.
+type TrashPatterns struct {
+	// Exact file names, like Thumbs.db.
+	Names []string
+	// Prefixes of file names, like ._ for Apple Double files.
+	Prefixes []string
+	// Directory names, like @eaDir, which are skipped with all their contents.
+	Dirs []string
+}
+
+// Returns the patterns used unless changed by SetTrashPatterns.
+func DefaultTrashPatterns() TrashPatterns {
+	return TrashPatterns{
+		// Finder and Windows Explorer metadata.
+		Names: []string{".DS_Store", "Thumbs.db", "ehthumbs.db", "desktop.ini"},
+		// Apple Double files, like '._somefile.ext'
+		Prefixes: []string{"._"},
+		// macOS volume metadata, Netatalk, Synology, and the Windows recycle bin.
+		Dirs: []string{".AppleDouble", ".Spotlight-V100", ".Trashes", ".fseventsd", "@eaDir", "$RECYCLE.BIN"},
+	}
+}
+
+var trash = DefaultTrashPatterns()
+
+// Replaces the patterns used by IsTrashFile and IsTrashDir. Not safe to call
+// while they're in use, so do it before starting any work.
+func SetTrashPatterns(patterns TrashPatterns) {
+	trash = patterns
+}
+
+// Returns the patterns used by IsTrashFile and IsTrashDir.
+func CurrentTrashPatterns() TrashPatterns {
+	return trash
+}
+
+// Adds pattern to the patterns used by IsTrashFile and IsTrashDir. It's a
+// directory name if it ends with a /, a prefix if it ends with a *, and
+// otherwise an exact file name. Like SetTrashPatterns, this should be done
+// before starting any work.
+func AddTrashPattern(pattern string) error {
+	kind, name, err := parseTrashPattern(pattern)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case '/':
+		trash.Dirs = append(slices.Clip(trash.Dirs), name)
+	case '*':
+		trash.Prefixes = append(slices.Clip(trash.Prefixes), name)
+	default:
+		trash.Names = append(slices.Clip(trash.Names), name)
+	}
+	return nil
+}
+
+// Returns an error if pattern isn't one AddTrashPattern accepts.
+func ValidateTrashPattern(pattern string) error {
+	_, _, err := parseTrashPattern(pattern)
+	return err
+}
+
+// Splits pattern into its kind, '/', '*', or 0 for names, and the name.
+func parseTrashPattern(pattern string) (byte, string, error) {
+	var kind byte
+	name := pattern
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, "*") {
+		kind = name[len(name)-1]
+		name = name[:len(name)-1]
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\*`) {
+		return 0, "", fmt.Errorf("bad trash pattern %q: expected a name, a prefix ending with *, or a directory ending with /", pattern)
+	}
+	return kind, name, nil
+}
+
 // Returns true if we think the file is trash for the purposes of this
 // application. This mainly exists to exclude dot files that shouldn't be copied
-// nor mistaken for content.
+// nor mistaken for content. Files within trash directories are trash too.
 func IsTrashFile(name string) bool {
-	base := filepath.Base(name)
-
-	// Skip Finder metadata files.
-	if base == ".DS_Store" {
-		return true
+	name = filepath.ToSlash(name)
+	base := path.Base(name)
+	for _, trashName := range trash.Names {
+		if strings.EqualFold(base, trashName) {
+			return true
+		}
+	}
+	for _, prefix := range trash.Prefixes {
+		if len(base) >= len(prefix) && strings.EqualFold(base[:len(prefix)], prefix) {
+			return true
+		}
 	}
-	// Skip Apple Double files, like '._somefile.ext'
-	if strings.HasPrefix(base, "._") {
-		return true
+	for dir := range strings.SplitSeq(path.Dir(name), "/") {
+		if IsTrashDir(dir) {
+			return true
+		}
 	}
+	return false
+}
 
+// Returns true if the directory is trash for the purposes of this application,
+// and should be skipped with everything in it.
+func IsTrashDir(name string) bool {
+	base := path.Base(filepath.ToSlash(name))
+	for _, dir := range trash.Dirs {
+		if strings.EqualFold(base, dir) {
+			return true
+		}
+	}
 	return false
 }
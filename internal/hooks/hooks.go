@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+// Package hooks runs user supplied commands, e.g., to notify when an export is
+// done. Every hook must be run through a Runner, so that -no-exec-hooks covers
+// all of them. ffmpeg is part of the job rather than a hook, so it's run
+// directly.
+package hooks
+
+import (
+	"audio_converter/internal/logging"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Returned by Runner.Run when the command is empty.
+var ErrNoCommand = errors.New("no command given")
+
+// Runs hooks, unless they're disabled.
+type Runner struct {
+	// Log what would have run instead of running it, for -no-exec-hooks.
+	Disabled bool
+}
+
+// Returns a runner, disabled per -no-exec-hooks.
+func NewRunner(disabled bool) *Runner {
+	return &Runner{Disabled: disabled}
+}
+
+// Runs argv as the hook called name, e.g., "post-cmd", returning its combined
+// output. When disabled, the command is only logged, and the hook is treated
+// as having succeeded with no output.
+func (r *Runner) Run(ctx context.Context, name string, argv ...string) ([]byte, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("%s hook: %w", name, ErrNoCommand)
+	}
+	if r.Disabled {
+		logging.Printf("Hooks disabled, not running %s hook: %s", name, strings.Join(argv, " "))
+		return nil, nil
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	logging.Println("Running", name, "hook:", strings.Join(cmd.Args, " "))
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("%s hook %q failed: %w", name, argv[0], err)
+	}
+	return output, nil
+}
@@ -0,0 +1,49 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package hooks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// Returns a command that creates name, so tests can tell if it ran.
+func touch(t *testing.T, name string) []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/c", "type nul > " + name}
+	}
+	return []string{"touch", name}
+}
+
+func TestRunner(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "ran")
+		if _, err := NewRunner(false).Run(t.Context(), "test", touch(t, name)...); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("Hook did not run: %v", err)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "ran")
+		output, err := NewRunner(true).Run(t.Context(), "test", touch(t, name)...)
+		if err != nil || output != nil {
+			t.Errorf("Disabled hook should succeed without output: %q %v", output, err)
+		}
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Disabled hook ran: %v", err)
+		}
+	})
+	t.Run("failure", func(t *testing.T) {
+		if _, err := NewRunner(false).Run(t.Context(), "test", filepath.Join(t.TempDir(), "missing")); err == nil {
+			t.Errorf("Missing command should fail")
+		}
+		if _, err := NewRunner(true).Run(t.Context(), "test"); !errors.Is(err, ErrNoCommand) {
+			t.Errorf("Expected ErrNoCommand: %v", err)
+		}
+	})
+}
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+// Package layout maps the files of a music library to where they go in an
+// export that doesn't keep the structure of the library, for
+// export_audio_tree -layout. E.g., Artist/Year - Album/Disc 1/01 Intro.flac
+// can become Artist/Album/01 Intro.flac for a player that only reads two
+// directories deep.
+package layout
+
+import (
+	"fmt"
+	pathpkg "path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The layouts Parse knows by name, besides templates.
+const (
+	// Moves the files of disc directories, e.g., "Disc 2" or "CD2", up into
+	// the album. Files with the same name on different discs are told apart
+	// the same way as any other collision.
+	FlattenDiscs = "flatten-discs"
+	// Files go in a directory per album, in a directory per artist, by tags:
+	// the same as the template {artist}/{album}/{name}.
+	ArtistAlbum = "artist-album"
+)
+
+// Maps the path of a file, relative to the root of the library and with
+// slashes, to its path in the export. The extension of the path is kept.
+type Layout interface {
+	Map(path string, tags map[string]string) string
+	// Whether Map uses tags, which for a media file have to be probed. The
+	// tags have lowercase keys, e.g., "artist", and Map is given nil for a
+	// file without any.
+	NeedsTags() bool
+}
+
+// Returns the built-in layout name, or else the template it parses as.
+func Parse(name string) (Layout, error) {
+	switch name {
+	case FlattenDiscs:
+		return flattenDiscs{}, nil
+	case ArtistAlbum:
+		name = "{artist}/{album}/{name}"
+	}
+	return ParseTemplate(name)
+}
+
+// The names of disc directories for FlattenDiscs, e.g., "Disc 2", "disk02",
+// "CD 1 - Live".
+var discDir = regexp.MustCompile(`(?i)^(disc|disk|cd)[ _-]*\d+([ _-].*)?$`)
+
+type flattenDiscs struct{}
+
+func (flattenDiscs) Map(path string, _ map[string]string) string {
+	dir, name := pathpkg.Split(path)
+	if dir = strings.TrimSuffix(dir, "/"); discDir.MatchString(pathpkg.Base(dir)) {
+		return pathpkg.Join(pathpkg.Dir(dir), name)
+	}
+	return path
+}
+
+func (flattenDiscs) NeedsTags() bool {
+	return false
+}
+
+// A layout given as a template of the path, e.g., "{artist}/{album}/{track:02d}
+// {title}". Text in braces is replaced by a field of the file:
+//
+//   - {name} is its name without the extension.
+//   - {dir} is the directory it's in, which may be several deep.
+//   - {parent} is the name of the directory it's in.
+//   - {dir1}, {dir2}, etc. are the directories it's in from the top, e.g.,
+//     {dir1} is the artist of Artist/Album/Song.flac.
+//   - Anything else is a tag, e.g., {artist} or {date}. Missing tags are
+//     "Unknown" and the name, e.g., "Unknown Artist".
+//
+// A field may end with :d for a number, e.g., {track:02d} for 01 when the tag
+// is "1/12". Slashes in the fields are replaced by dashes, so that they don't
+// make directories, e.g., for AC/DC.
+type Template struct {
+	text   string
+	fields []field
+}
+
+// A piece of a template: text to keep, or a field to replace.
+type field struct {
+	text   string
+	name   string // Of the field, or "" for text.
+	number bool   // Formatted as a number of width digits.
+	width  int
+	zero   bool // Padded with zeros rather than spaces.
+}
+
+// The names of the fields that come from the path rather than the tags.
+var pathFields = regexp.MustCompile(`^(name|dir|parent|dir[1-9][0-9]*)$`)
+
+// The names of fields, and the formats they may have.
+var (
+	fieldName   = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	fieldFormat = regexp.MustCompile(`^(0?)([0-9]*)d$`)
+)
+
+// Parses text as a template.
+func ParseTemplate(text string) (*Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("empty layout")
+	} else if strings.HasPrefix(text, "/") {
+		return nil, fmt.Errorf("layout %q must be relative", text)
+	}
+	t := &Template{text: text}
+	rest := text
+	for rest != "" {
+		open := strings.IndexAny(rest, "{}")
+		if open < 0 {
+			t.fields = append(t.fields, field{text: rest})
+			break
+		} else if rest[open] == '}' {
+			return nil, fmt.Errorf("layout %q has a } without a {", text)
+		} else if open > 0 {
+			t.fields = append(t.fields, field{text: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("layout %q has a { without a }", text)
+		}
+		f, err := parseField(rest[open+1 : open+end])
+		if err != nil {
+			return nil, fmt.Errorf("layout %q: %w", text, err)
+		}
+		t.fields = append(t.fields, f)
+		rest = rest[open+end+1:]
+	}
+	for _, part := range strings.Split(text, "/") {
+		if part == ".." {
+			return nil, fmt.Errorf("layout %q must stay within the output directory", text)
+		}
+	}
+	return t, nil
+}
+
+// Parses the inside of the braces of a field, e.g., "track:02d".
+func parseField(text string) (field, error) {
+	name, format, hasFormat := strings.Cut(text, ":")
+	f := field{name: strings.ToLower(name)}
+	if !fieldName.MatchString(f.name) {
+		return f, fmt.Errorf("bad field {%s}", text)
+	} else if !hasFormat {
+		return f, nil
+	}
+	m := fieldFormat.FindStringSubmatch(format)
+	if m == nil {
+		return f, fmt.Errorf("bad format for {%s}: must be like 02d", text)
+	}
+	f.number, f.zero = true, m[1] != ""
+	if m[2] != "" {
+		f.width, _ = strconv.Atoi(m[2])
+	}
+	return f, nil
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+func (t *Template) NeedsTags() bool {
+	for _, f := range t.fields {
+		if f.name != "" && !pathFields.MatchString(f.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Template) Map(path string, tags map[string]string) string {
+	ext := pathpkg.Ext(path)
+	var b strings.Builder
+	for _, f := range t.fields {
+		if f.name == "" {
+			b.WriteString(f.text)
+			continue
+		}
+		value := t.value(f.name, path, tags)
+		if f.number {
+			value = formatNumber(value, f.width, f.zero)
+		}
+		if f.name != "dir" {
+			value = strings.ReplaceAll(value, "/", "-")
+		}
+		b.WriteString(value)
+	}
+	// Empty fields, e.g., {dir} at the top, leave no empty directories.
+	var parts []string
+	for _, part := range strings.Split(b.String(), "/") {
+		switch part = strings.TrimSpace(part); part {
+		case "":
+		case ".", "..":
+			parts = append(parts, "_")
+		default:
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, strings.TrimSuffix(pathpkg.Base(path), ext))
+	}
+	return strings.Join(parts, "/") + ext
+}
+
+// Returns the field name for the file at path with tags.
+func (t *Template) value(name, path string, tags map[string]string) string {
+	dir := pathpkg.Dir(path)
+	if dir == "." {
+		dir = ""
+	}
+	switch {
+	case name == "name":
+		return strings.TrimSuffix(pathpkg.Base(path), pathpkg.Ext(path))
+	case name == "dir":
+		return dir
+	case name == "parent":
+		if dir == "" {
+			return ""
+		}
+		return pathpkg.Base(dir)
+	case pathFields.MatchString(name):
+		n, _ := strconv.Atoi(name[len("dir"):])
+		if parts := strings.Split(dir, "/"); dir != "" && n <= len(parts) {
+			return parts[n-1]
+		}
+		return ""
+	}
+	if value := strings.TrimSpace(tags[name]); value != "" {
+		return value
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return "Unknown " + string(r)
+}
+
+// Formats the number value starts with, e.g., 3 for "3/12", as width digits.
+// A value without a number, e.g., "Unknown Track", is 0.
+func formatNumber(value string, width int, zero bool) string {
+	end := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(value)
+	}
+	n, _ := strconv.Atoi(value[:end])
+	if zero {
+		return fmt.Sprintf("%0*d", width, n)
+	}
+	return fmt.Sprintf("%*d", width, n)
+}
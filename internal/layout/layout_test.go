@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package layout
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tags := map[string]string{"artist": "AC/DC", "album": "Back in Black", "track": "3/10", "title": "Shoot to Thrill"}
+	for _, test := range []struct {
+		layout, path string
+		tags         map[string]string
+		expected     string
+		needsTags    bool
+	}{
+		{FlattenDiscs, "Artist/2001 - Album/Disc 2/01 Intro.flac", nil, "Artist/2001 - Album/01 Intro.flac", false},
+		{FlattenDiscs, "Artist/Album/cd02/cover.jpg", nil, "Artist/Album/cover.jpg", false},
+		{FlattenDiscs, "Artist/Discography/01 Intro.flac", nil, "Artist/Discography/01 Intro.flac", false},
+		{FlattenDiscs, "Disc 1/01.flac", nil, "01.flac", false},
+		{ArtistAlbum, "x/y/z/Song.flac", tags, "AC-DC/Back in Black/Song.flac", true},
+		{ArtistAlbum, "Song.flac", nil, "Unknown Artist/Unknown Album/Song.flac", true},
+		{"{artist}/{album}/{track:02d} {title}", "a.flac", tags, "AC-DC/Back in Black/03 Shoot to Thrill.flac", true},
+		{"{track:02d} {title}", "a.flac", nil, "00 Unknown Title.flac", true},
+		{"{dir1}/{parent}/{name}", "Artist/Year/Album/Song.mp3", nil, "Artist/Album/Song.mp3", false},
+		{"{dir}/{name}", "Song.mp3", nil, "Song.mp3", false},
+		{"{dir2}", "Artist/Song.mp3", nil, "Song.mp3", false},
+		{"{artist}/{name}", "Song.mp3", map[string]string{"artist": ".."}, "_/Song.mp3", true},
+	} {
+		l, err := Parse(test.layout)
+		if err != nil {
+			t.Errorf("Failed parsing %q: %v", test.layout, err)
+			continue
+		}
+		if actual := l.Map(test.path, test.tags); actual != test.expected {
+			t.Errorf("%q mapped %q to %q, expected %q", test.layout, test.path, actual, test.expected)
+		}
+		if l.NeedsTags() != test.needsTags {
+			t.Errorf("%q should need tags: %v", test.layout, test.needsTags)
+		}
+	}
+	for _, bad := range []string{"", " ", "/{artist}", "{artist", "artist}", "{}", "{a b}", "{track:x}", "{track:02s}", "../{name}"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Failed to reject %q", bad)
+		}
+	}
+}
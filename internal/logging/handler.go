@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Somewhere the log is written, and the least severe level it takes.
+type sink struct {
+	w     io.Writer
+	level slog.Level
+	// Only the message, as on the console, rather than the time, level, and
+	// source too, as in the log file.
+	plain bool
+	// Shows the messages that Reportf and Warnf also print on their own, so
+	// they're left out.
+	console bool
+	// Each record as a JSON object, for -log-format json. Only these sinks
+	// take events.
+	json bool
+}
+
+// A slog.Handler that writes each record to every sink that takes its level.
+// Safe for concurrent use.
+type handler struct {
+	mutex  *sync.Mutex
+	level  slog.Level // Given to Initialize, for what's printed besides the sinks.
+	sinks  []sink
+	attrs  []slog.Attr // From WithAttrs, flattened by flatten.
+	prefix string      // The groups from WithGroup, each followed by a dot.
+}
+
+func newHandler(level slog.Level, sinks ...sink) *handler {
+	return &handler{mutex: new(sync.Mutex), level: level, sinks: sinks}
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	for _, s := range h.sinks {
+		if level >= s.level {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	return h.write(r, true, false)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = slices.Clip(c.attrs)
+	for _, a := range attrs {
+		c.attrs = flatten(c.attrs, c.prefix, a)
+	}
+	return &c
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix += name + "."
+	return &c
+}
+
+// Writes r to the sinks that take its level, leaving out the console unless
+// console is set. An event only goes to the JSON sinks.
+func (h *handler) write(r slog.Record, console, event bool) error {
+	attrs := slices.Clone(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flatten(attrs, h.prefix, a)
+		return true
+	})
+	msg := strings.TrimSuffix(r.Message, "\n")
+	text := msg
+	for _, a := range attrs {
+		text += formatAttr(a)
+	}
+	var source string
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		source = fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+	}
+	var line string
+	if source != "" {
+		line = fmt.Sprintf("%s %s %s: %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Level, source, text)
+	} else {
+		line = fmt.Sprintf("%s %s %s\n", r.Time.Format("2006/01/02 15:04:05"), r.Level, text)
+	}
+	var object []byte
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var err error
+	for _, s := range h.sinks {
+		if r.Level < s.level || (s.console && !console) || (event && !s.json) {
+			continue
+		}
+		switch {
+		case s.json:
+			if object == nil {
+				object = formatJSON(r, source, msg, attrs)
+			}
+			_, err = s.w.Write(object)
+		case s.plain:
+			_, err = io.WriteString(s.w, text+"\n")
+		default:
+			_, err = io.WriteString(s.w, line)
+		}
+	}
+	return err
+}
+
+// Appends a to attrs, with its key prefixed by the groups in prefix. The
+// members of a group are appended in its place, so that every key is unique
+// without nesting, e.g., "stats.converted".
+func flatten(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	} else if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			attrs = flatten(attrs, prefix, g)
+		}
+		return attrs
+	}
+	a.Key = prefix + a.Key
+	return append(attrs, a)
+}
+
+// Returns a as " key=value".
+func formatAttr(a slog.Attr) string {
+	value := a.Value.String()
+	if a.Value.Kind() == slog.KindString {
+		value = strconv.Quote(value)
+	}
+	return fmt.Sprintf(" %s=%s", a.Key, value)
+}
+
+// Formats r as a JSON object on a line of its own: the time as ts, the level,
+// the source and message, if any, then the attrs.
+func formatJSON(r slog.Record, source, msg string, attrs []slog.Attr) []byte {
+	var b bytes.Buffer
+	field := func(key string, value any) {
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(value)
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(value))
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	field("ts", r.Time.Format(time.RFC3339Nano))
+	field("level", r.Level.String())
+	if source != "" {
+		field("source", source)
+	}
+	if msg != "" {
+		field("msg", msg)
+	}
+	for _, a := range attrs {
+		field(a.Key, jsonValue(a.Value))
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// Returns v as something json.Marshal handles the way a reader would expect:
+// times as RFC 3339, durations as strings like "1.5s", and errors as their
+// messages.
+func jsonValue(v slog.Value) any {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+	}
+	return v.Any()
+}
+
+// Logs msg at level to the sinks, as if from the caller of the function that
+// called this. The console is left out unless console is set.
+func output(level slog.Level, msg string, console bool) {
+	h := current()
+	if !h.Enabled(context.Background(), level) {
+		return
+	}
+	var pcs [1]uintptr
+	// Skips runtime.Callers, output, and the function calling output.
+	runtime.Callers(3, pcs[:])
+	h.write(slog.NewRecord(time.Now(), level, msg, pcs[0]), console, false)
+}
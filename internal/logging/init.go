@@ -6,34 +6,101 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
+	"sync/atomic"
 )
 
-var logger *log.Logger = log.New(io.Discard, "", 0)
-var verbose *log.Logger = log.New(io.Discard, "", 0)
+// Where the log goes. Nowhere until Initialize is called.
+var logger atomic.Pointer[handler]
 
-// Initializes loggers based on the provided settings.
+func init() {
+	logger.Store(newHandler(slog.LevelInfo))
+}
+
+// Returns the handler the log is written with.
+func current() *handler {
+	return logger.Load()
+}
+
+// Where output meant for stdout goes. See ReserveStdout.
+var stdout io.Writer = os.Stdout
+
+// Where warnings and errors are printed. Replaced in tests.
+var stderr io.Writer = os.Stderr
+
+// Sends what would go to stdout to stderr instead, for when stdout is the
+// output of the program, e.g., to_flac in.wav - | ... Call before Initialize.
+func ReserveStdout() {
+	stdout = os.Stderr
+}
+
+// How the log is written to the log file, or to stdout with "-".
+type Format string
+
+const (
+	FormatText Format = "text" // A line per message, with the time, level, and source.
+	FormatJSON Format = "json" // A JSON object per message or Event, for other programs.
+)
+
+// Initializes the log based on the provided settings. Only messages at level
+// or above are logged.
 //
-// The top level logger will point to file specified by name, to stdout
-// if name is "-", or to the bit bucket if name is "".
+// The log goes to the file specified by name, to stdout if name is "-", or to
+// the bit bucket if name is "". It's written in the given format.
 //
-// The verbose logger will either go to stdout or the bitbucket depending on
-// verboseMode.
-func Initialize(ctx context.Context, name string, verboseMode bool) error {
-	if name != "" {
-		flags := log.Ldate | log.Ltime | log.Lshortfile
-		if name == "-" {
-			logger = log.New(os.Stdout, "", flags)
-		} else if fp, err := os.Create(name); err != nil {
-			return fmt.Errorf("failed creating log file %s: %w", name, err)
-		} else {
-			logger = log.New(fp, "", flags)
-			context.AfterFunc(ctx, func() { fp.Close() })
+// With verboseMode, the messages are also shown on stdout, without the time
+// and source, unless the log is already going there. After ReserveStdout,
+// stderr is used instead of stdout. That's always text, for the user to read.
+func Initialize(ctx context.Context, name string, level slog.Level, format Format, verboseMode bool) error {
+	var sinks []sink
+	if name == "-" {
+		sinks = append(sinks, sink{w: stdout, level: level, json: format == FormatJSON})
+	} else if name != "" {
+		fp, err := openLogFile(name, fileOptions.appendMode, fileOptions.maxSize, fileOptions.keep)
+		if err != nil {
+			return fmt.Errorf("-log-file: failed opening %s: %w", name, err)
 		}
+		context.AfterFunc(ctx, func() { fp.Close() })
+		sinks = append(sinks, sink{w: fp, level: level, json: format == FormatJSON})
 	}
-	if verboseMode {
-		verbose = log.New(os.Stdout, verbose.Prefix(), verbose.Flags())
+	if verboseMode && name != "-" {
+		sinks = append(sinks, sink{w: stdout, level: level, plain: true, console: true})
 	}
+	logger.Store(newHandler(level, sinks...))
 	return nil
 }
+
+// Returns a structured logger that writes to the same places as Printf and
+// friends.
+func Logger() *slog.Logger {
+	return slog.New(current())
+}
+
+// Returns true if messages at level are logged anywhere, e.g., to skip work
+// that only feeds Verbosef.
+func Enabled(level slog.Level) bool {
+	return current().Enabled(context.Background(), level)
+}
+
+// Returns true if the log goes to stdout or stderr, so that messages shown to
+// the user regardless don't need to be printed twice.
+func onConsole() bool {
+	for _, s := range current().sinks {
+		if !s.console && (s.w == os.Stdout || s.w == os.Stderr || s.w == stdout) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the log is shown on stdout or stderr at all, e.g., with -v,
+// so that anything else drawn on the terminal would get mixed up with it.
+func ToConsole() bool {
+	for _, s := range current().sinks {
+		if s.w == os.Stdout || s.w == os.Stderr || s.w == stdout {
+			return true
+		}
+	}
+	return false
+}
@@ -3,34 +3,92 @@
 package logging
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
+	"time"
 )
 
-// Printf formats according to a format specifier and writes to standard logger.
+// Called by Fatalf and Fatalln. Replaced in tests.
+var exit = os.Exit
+
+// Printf formats according to a format specifier and logs it at the info level.
 func Printf(format string, args ...any) {
-	logger.Printf(format, args...)
+	output(slog.LevelInfo, fmt.Sprintf(format, args...), true)
 }
 
-// Println formats using the default formats for its operands and writes to
-// standard logger. Spaces are always added between operands and a newline is
-// appended.
+// Println formats using the default formats for its operands and logs it at
+// the info level. Spaces are always added between operands.
 func Println(args ...any) {
-	logger.Println(args...)
+	output(slog.LevelInfo, fmt.Sprintln(args...), true)
+}
+
+// Wrapper that ensures the message goes to stdout as well as the log file.
+// Useful for reports the user should see regardless of logging.
+func Reportf(format string, args ...any) {
+	if !onConsole() {
+		fmt.Fprintf(stdout, format, args...)
+	}
+	output(slog.LevelInfo, fmt.Sprintf(format, args...), false)
+}
+
+// Wrapper that ensures the message goes to stderr as well as the log file,
+// unless warnings aren't logged. Useful for warnings the user should see
+// regardless of the log file.
+func Warnf(format string, args ...any) {
+	if current().level > slog.LevelWarn {
+		return
+	}
+	if !onConsole() {
+		fmt.Fprintf(stderr, format, args...)
+	}
+	output(slog.LevelWarn, fmt.Sprintf(format, args...), false)
+}
+
+// Like Fatalf, but carries on afterward, e.g., for failures that don't have to
+// stop the program.
+func Errorf(format string, args ...any) {
+	if !onConsole() {
+		fmt.Fprintf(stderr, format, args...)
+	}
+	output(slog.LevelError, fmt.Sprintf(format, args...), false)
 }
 
-// Wrapper that ensures the message goes to stderr as well as the log file.
+// Wrapper that ensures the message goes to stderr as well as the log file,
+// then exits with status 1.
 func Fatalf(format string, args ...any) {
-	if w := logger.Writer(); w != os.Stdout && w != os.Stderr {
-		fmt.Fprintf(os.Stderr, format, args...)
+	if !onConsole() {
+		fmt.Fprintf(stderr, format, args...)
 	}
-	logger.Fatalf(format, args...)
+	output(slog.LevelError, fmt.Sprintf(format, args...), false)
+	exit(1)
 }
 
-// Wrapper that ensures the message goes to stderr as well as the log file.
+// Wrapper that ensures the message goes to stderr as well as the log file,
+// then exits with status 1.
 func Fatalln(args ...any) {
-	if w := logger.Writer(); w != os.Stdout && w != os.Stderr {
-		fmt.Fprintln(os.Stderr, args...)
+	if !onConsole() {
+		fmt.Fprintln(stderr, args...)
+	}
+	output(slog.LevelError, fmt.Sprintln(args...), false)
+	exit(1)
+}
+
+// Logs an event for -log-format json, e.g., a file having been converted, with
+// args as key-value pairs or slog.Attrs, as for slog.Logger.Log. The text log
+// leaves events out, since Printf and friends already say the same in words.
+func Event(level slog.Level, event string, msg string, args ...any) {
+	h := current()
+	if !h.Enabled(context.Background(), level) {
+		return
 	}
-	logger.Fatalln(args...)
+	var pcs [1]uintptr
+	// Skips runtime.Callers and Event.
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.AddAttrs(slog.String("event", event))
+	r.Add(args...)
+	h.write(r, false, true)
 }
@@ -0,0 +1,292 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Initializes logging to a temporary file, with stdout and stderr captured.
+// Returns the name of the file and the captured output. Everything is put back
+// when the test is done.
+func capture(t *testing.T, level slog.Level, verbose bool) (string, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	var out, errs bytes.Buffer
+	saved, savedOut, savedErr := logger.Load(), stdout, stderr
+	t.Cleanup(func() {
+		logger.Store(saved)
+		stdout, stderr = savedOut, savedErr
+	})
+	stdout, stderr = &out, &errs
+	name := filepath.Join(t.TempDir(), "test.log")
+	if err := Initialize(t.Context(), name, level, FormatText, verbose); err != nil {
+		t.Fatal(err)
+	}
+	return name, &out, &errs
+}
+
+// Returns the contents of the log file name.
+func readLog(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLevels(t *testing.T) {
+	name, out, errs := capture(t, slog.LevelWarn, false)
+	Verbosef("debug %d", 1)
+	Printf("info %d", 2)
+	Warnf("warn %d\n", 3)
+	Errorf("error %d\n", 4)
+
+	log := readLog(t, name)
+	for _, msg := range []string{"debug 1", "info 2"} {
+		if strings.Contains(log, msg) {
+			t.Errorf("%q should have been filtered out: %q", msg, log)
+		}
+	}
+	for _, line := range []string{"WARN logging_test.go:", "warn 3\n", "ERROR logging_test.go:", "error 4\n"} {
+		if !strings.Contains(log, line) {
+			t.Errorf("Expected %q in the log: %q", line, log)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("Nothing should be shown on stdout without -v: %q", out)
+	}
+	if errs.String() != "warn 3\nerror 4\n" {
+		t.Errorf("Warnings and errors should be shown on stderr: %q", errs)
+	}
+	if IsVerbose() {
+		t.Errorf("Debug messages should not be enabled at the warn level")
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	name, out, _ := capture(t, slog.LevelDebug, true)
+	Verbosef("debug %d", 1)
+	Println("info", 2)
+	Reportf("report %d\n", 3)
+
+	log := readLog(t, name)
+	for _, line := range []string{"DEBUG logging_test.go:", "debug 1\n", "INFO logging_test.go:", "info 2\n", "report 3\n"} {
+		if !strings.Contains(log, line) {
+			t.Errorf("Expected %q in the log: %q", line, log)
+		}
+	}
+	// The report is printed once, not once more by the console.
+	if out.String() != "debug 1\ninfo 2\nreport 3\n" {
+		t.Errorf("Bad stdout: %q", out)
+	}
+	if !IsVerbose() {
+		t.Errorf("-v should enable debug messages")
+	}
+}
+
+func TestVerboseLevel(t *testing.T) {
+	// -v along with -log-level only shows what the level allows.
+	name, out, _ := capture(t, slog.LevelInfo, true)
+	Verbosef("debug")
+	Printf("info")
+	if log := readLog(t, name); strings.Contains(log, "debug") || !strings.Contains(log, "info") {
+		t.Errorf("Bad log: %q", log)
+	}
+	if out.String() != "info\n" {
+		t.Errorf("Bad stdout: %q", out)
+	}
+}
+
+func TestStdoutLog(t *testing.T) {
+	_, out, errs := capture(t, slog.LevelInfo, false)
+	if err := Initialize(t.Context(), "-", slog.LevelInfo, FormatText, true); err != nil {
+		t.Fatal(err)
+	}
+	Printf("info")
+	Warnf("warn\n")
+	Reportf("report\n")
+	// Already on stdout, so nothing is printed twice.
+	if s := out.String(); strings.Count(s, "info") != 1 || strings.Count(s, "warn") != 1 || strings.Count(s, "report") != 1 {
+		t.Errorf("Bad stdout: %q", s)
+	}
+	if errs.Len() != 0 {
+		t.Errorf("Warnings are on stdout with the rest of the log: %q", errs)
+	}
+}
+
+func TestFatalf(t *testing.T) {
+	name, _, errs := capture(t, slog.LevelInfo, false)
+	status := -1
+	defer func(f func(int)) { exit = f }(exit)
+	exit = func(code int) { status = code }
+	Fatalf("failed %d\n", 1)
+	if status != 1 {
+		t.Errorf("Fatalf should exit 1, not %d", status)
+	}
+	if errs.String() != "failed 1\n" || !strings.Contains(readLog(t, name), "ERROR logging_test.go:") {
+		t.Errorf("Fatalf should log an error and print it: %q", errs)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	name, _, _ := capture(t, slog.LevelInfo, false)
+	Logger().With("file", "song.flac").WithGroup("ffmpeg").Info("converted", "status", 0)
+	Logger().Debug("hidden")
+	log := readLog(t, name)
+	if !strings.Contains(log, `INFO logging_test.go:`) || !strings.Contains(log, ` converted file="song.flac" ffmpeg.status=0`+"\n") {
+		t.Errorf("Bad log: %q", log)
+	}
+	if strings.Contains(log, "hidden") {
+		t.Errorf("Debug messages should be filtered out: %q", log)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	name, out, _ := capture(t, slog.LevelInfo, true)
+	if err := Initialize(t.Context(), name, slog.LevelInfo, FormatJSON, true); err != nil {
+		t.Fatal(err)
+	}
+	Printf("info %d", 1)
+	Event(slog.LevelError, "finish", "Failed", "path", "a.flac", "bytes", 42, "error", errors.New("oops"))
+	Verbosef("hidden")
+
+	lines := strings.Split(strings.TrimSuffix(readLog(t, name), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a line per record: %q", lines)
+	}
+	var records []map[string]any
+	for _, line := range lines {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Bad JSON %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	if r := records[0]; r["level"] != "INFO" || r["msg"] != "info 1" || !strings.HasPrefix(r["source"].(string), "logging_test.go:") || r["ts"] == nil {
+		t.Errorf("Bad record: %v", r)
+	}
+	if r := records[1]; r["level"] != "ERROR" || r["event"] != "finish" || r["path"] != "a.flac" || r["bytes"] != 42.0 || r["error"] != "oops" {
+		t.Errorf("Bad event: %v", r)
+	}
+	// The console is for the user, so it stays text, without the events.
+	if out.String() != "info 1\n" {
+		t.Errorf("Bad stdout: %q", out)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	name, _, _ := capture(t, slog.LevelInfo, false)
+	Printf("first")
+	defer SetFileOptions(false, 0, 0)
+	SetFileOptions(true, 0, 0)
+	if err := Initialize(t.Context(), name, slog.LevelInfo, FormatText, false); err != nil {
+		t.Fatal(err)
+	}
+	Printf("second")
+	if log := readLog(t, name); !strings.Contains(log, "first") || !strings.Contains(log, "second") {
+		t.Errorf("The log should have been appended to: %q", log)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+	f, err := openLogFile(name, false, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range []string{"1111111\n", "2222222\n", "3333333\n", "4444444\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only two old files are kept, so the first line is gone.
+	for suffix, expected := range map[string]string{"": "4444444\n", ".1": "3333333\n", ".2": "2222222\n"} {
+		if actual := readLog(t, name+suffix); actual != expected {
+			t.Errorf("%s%s actual: %q expected: %q", name, suffix, actual, expected)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); err == nil {
+		t.Errorf("Kept too many files")
+	}
+
+	// Workers log concurrently, so rotation mustn't lose or split lines.
+	name = filepath.Join(t.TempDir(), "test.log")
+	if f, err = openLogFile(name, false, 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 100 {
+				fmt.Fprintf(f, "worker %d line %03d\n", i, j)
+			}
+		}()
+	}
+	wg.Wait()
+	matches, _ := filepath.Glob(name + "*")
+	lines := 0
+	for _, match := range matches {
+		for _, line := range strings.SplitAfter(readLog(t, match), "\n") {
+			if line == "" {
+				continue
+			} else if !strings.HasPrefix(line, "worker ") || !strings.HasSuffix(line, "\n") || len(line) != len("worker 0 line 000\n") {
+				t.Fatalf("Bad line in %s: %q", match, line)
+			}
+			lines++
+		}
+	}
+	if lines != 800 {
+		t.Errorf("Expected 800 lines across the files, not %d", lines)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	name, out, _ := capture(t, slog.LevelInfo, true)
+	w := NewLineWriter(slog.LevelInfo, "in.flac")
+	for _, s := range []string{
+		"Input #0, ",
+		"flac\n",
+		"size=1kB time=00:01\rsize=2kB time=00:02\r",
+		"size=3kB time=00:03   \r\n",
+		"Windows line\r\n\n",
+		"unfinished",
+	} {
+		if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	w.Flush()
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSuffix(readLog(t, name), "\n"), "\n") {
+		_, msg, _ := strings.Cut(line, " INFO ")
+		lines = append(lines, msg)
+	}
+	expected := []string{
+		"in.flac: Input #0, flac",
+		"in.flac: size=3kB time=00:03",
+		"in.flac: Windows line",
+		"in.flac: unfinished",
+	}
+	if !slices.Equal(lines, expected) {
+		t.Errorf("Bad log:\nactual  : %q\nexpected: %q", lines, expected)
+	}
+	// The output is on the terminal already.
+	if out.Len() != 0 {
+		t.Errorf("Shouldn't be on stdout with -v: %q", out)
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// How the log file is opened, set by SetFileOptions.
+var fileOptions struct {
+	appendMode bool
+	maxSize    int64
+	keep       int
+}
+
+// Sets how Initialize opens the log file. With appendMode, the log is added to
+// the end of the file rather than replacing it. With a maxSize above 0, the
+// file is rotated once it would grow past maxSize bytes: name becomes name.1,
+// name.1 becomes name.2, and so on, keeping up to keep old files. Call before
+// Initialize.
+func SetFileOptions(appendMode bool, maxSize int64, keep int) {
+	fileOptions.appendMode = appendMode
+	fileOptions.maxSize = maxSize
+	fileOptions.keep = keep
+}
+
+// A log file that rotates itself once it reaches its maximum size. Safe for
+// concurrent use.
+type logFile struct {
+	mutex   sync.Mutex
+	name    string
+	fp      *os.File
+	size    int64
+	maxSize int64 // 0 for no limit.
+	keep    int
+}
+
+// Opens the named log file, truncating it unless appendMode is set.
+func openLogFile(name string, appendMode bool, maxSize int64, keep int) (*logFile, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	fp, err := os.OpenFile(name, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f := &logFile{name: name, fp: fp, maxSize: maxSize, keep: keep}
+	if st, err := fp.Stat(); err == nil {
+		f.size = st.Size()
+	}
+	return f, nil
+}
+
+// Writes p to the file, rotating it first if p would take it past the
+// maximum size. A write larger than the maximum still goes in one piece, so
+// that a record is never split across files.
+func (f *logFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.fp == nil {
+		return 0, fs.ErrClosed
+	}
+	var rerr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if rerr = f.rotate(); f.fp == nil {
+			return 0, rerr
+		}
+	}
+	n, err := f.fp.Write(p)
+	f.size += int64(n)
+	return n, errors.Join(rerr, err)
+}
+
+// Shifts each old file up by one, dropping the oldest, moves the current file
+// to name.1, and starts a new one. The mutex must be held.
+func (f *logFile) rotate() error {
+	err := f.fp.Close()
+	f.fp = nil
+	if err != nil {
+		return fmt.Errorf("closing %s for rotation: %w", f.name, err)
+	}
+	if f.keep > 0 {
+		for i := f.keep - 1; i >= 1 && err == nil; i-- {
+			err = os.Rename(fmt.Sprintf("%s.%d", f.name, i), fmt.Sprintf("%s.%d", f.name, i+1))
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+		}
+		if err == nil {
+			err = os.Rename(f.name, f.name+".1")
+		}
+	}
+	// Even if the old file couldn't be kept, the log carries on in a new one.
+	fp, oerr := os.OpenFile(f.name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if oerr != nil {
+		return fmt.Errorf("reopening %s after rotation: %w", f.name, oerr)
+	}
+	f.fp, f.size = fp, 0
+	if err != nil {
+		return fmt.Errorf("rotating %s: %w", f.name, err)
+	}
+	return nil
+}
+
+// Closes the file. Later writes fail.
+func (f *logFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.fp == nil {
+		return nil
+	}
+	err := f.fp.Close()
+	f.fp = nil
+	return err
+}
@@ -2,14 +2,24 @@
 // Copyright 2025, Terry M. Poulin.
 package logging
 
-// Wrapper that calls Printf on both the standard and verbose loggers.
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Logs at the debug level, which is shown on stdout and written to the log
+// file with -v.
 func Verbosef(format string, args ...any) {
-	logger.Printf(format, args...)
-	verbose.Printf(format, args...)
+	output(slog.LevelDebug, fmt.Sprintf(format, args...), true)
 }
 
 // Like Verbosef, but uses Println rather than Printf.
 func Verbose(args ...any) {
-	logger.Println(args...)
-	verbose.Println(args...)
+	output(slog.LevelDebug, fmt.Sprintln(args...), true)
+}
+
+// Returns true if verbose output is logged, e.g., to skip work that only feeds
+// Verbosef.
+func IsVerbose() bool {
+	return Enabled(slog.LevelDebug)
 }
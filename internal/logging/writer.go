@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// An io.Writer that logs what's written to it a line at a time, e.g., the
+// output of ffmpeg. Lines may arrive in pieces. A carriage return starts the
+// line over, as it would on a terminal, so of the progress updates ffmpeg
+// draws that way, only the last makes it into the log. Safe for concurrent
+// use.
+type LineWriter struct {
+	mutex  sync.Mutex
+	level  slog.Level
+	prefix string
+	line   []byte // Written since the last line ended.
+	last   []byte // The line before the last carriage return.
+}
+
+// Returns a writer that logs each line at level, after prefix and a colon.
+// Call Flush once the output is done.
+func NewLineWriter(level slog.Level, prefix string) *LineWriter {
+	return &LineWriter{level: level, prefix: prefix}
+}
+
+// Logs each line in p that's complete, keeping the rest for later. Always
+// consumes all of p.
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, c := range p {
+		switch c {
+		case '\n':
+			w.flush()
+		case '\r':
+			if len(w.line) > 0 {
+				w.last = append(w.last[:0], w.line...)
+				w.line = w.line[:0]
+			}
+		default:
+			w.line = append(w.line, c)
+		}
+	}
+	return len(p), nil
+}
+
+// Logs whatever is left of a line that didn't end.
+func (w *LineWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.flush()
+}
+
+// Logs the current line, or else the one a carriage return ended, if any. The
+// mutex must be held.
+func (w *LineWriter) flush() {
+	line := w.line
+	if len(line) == 0 {
+		line = w.last
+	}
+	if line = bytes.TrimRight(line, " "); len(line) > 0 {
+		h := current()
+		if h.Enabled(context.Background(), w.level) {
+			// There's no caller worth naming, and the console has the output
+			// already.
+			h.write(slog.NewRecord(time.Now(), w.level, fmt.Sprintf("%s: %s", w.prefix, line), 0), false, false)
+		}
+	}
+	w.line, w.last = w.line[:0], w.last[:0]
+}
@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"audio_converter/internal/proc"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Returned when parsing args that asked for a completion script with
+// -completion, which has been written to stdout instead.
+var ErrCompletion = errors.New("completion script written")
+
+// Shells -completion can write a script for.
+var CompletionShells = []string{"bash", "fish", "zsh"}
+
+// What to complete for a flag's value.
+type completion struct {
+	choices []string // A fixed set of values.
+	files   bool
+	dirs    bool
+}
+
+// Returns what to complete for the value of f, if anything. Flags that take
+// one of a fixed set of values are listed here. Otherwise, a `FILE`, `DIR`,
+// or `PATH` in the usage means a path.
+func flagCompletion(f *flag.Flag) completion {
+	switch f.Name {
+	case "f", "format":
+		if name, _ := flag.UnquoteUsage(f); name == "IMAGE_FORMAT" {
+			// extract_coverart's, which is an image rather than audio.
+			return completion{choices: imageFormats()}
+		} else if FormatNames != nil {
+			return completion{choices: FormatNames()}
+		}
+	case "channels":
+		return completion{choices: []string{ChannelsKeep, ChannelsMono, ChannelsStereo}}
+	case "cover":
+		return completion{choices: []string{"copy", "mjpeg", "png", "none"}}
+	case "lossy-policy":
+		return completion{choices: []string{LossyConvert, LossyCopy, LossySkip}}
+	case "preserve-times":
+		return completion{choices: []string{PreserveNone, PreserveCopies, PreserveAll}}
+	case "ffmpeg-log":
+		return completion{choices: []string{FFmpegLogNone, FFmpegLogErrors, FFmpegLogFull}}
+	case "log-format":
+		return completion{choices: []string{LogFormatText, LogFormatJSON}}
+	case "ionice":
+		return completion{choices: []string{proc.IOClassIdle, proc.IOClassBestEffort}}
+	}
+	switch name, _ := flag.UnquoteUsage(f); name {
+	case "FILE", "PATH":
+		return completion{files: true}
+	case "DIR":
+		return completion{dirs: true}
+	}
+	return completion{}
+}
+
+// Writes a script to complete the flags of opts.fs for shell, one of
+// CompletionShells, e.g., to be loaded with
+// `source <(export_audio_tree -completion bash)`.
+func (opts *GlobalOptions) writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash":
+		return opts.writeBashCompletion(w)
+	case "fish":
+		return opts.writeFishCompletion(w)
+	case "zsh":
+		return opts.writeZshCompletion(w)
+	}
+	return fmt.Errorf("unsupported -completion %q: must be one of %s", shell, strings.Join(CompletionShells, ", "))
+}
+
+// Writes the completion script for -completion, if it's in args.
+func (opts *GlobalOptions) completion(args []string) (bool, error) {
+	shell, ok := opts.findArg(args, "completion")
+	if !ok {
+		return false, nil
+	}
+	return true, opts.writeCompletion(os.Stdout, shell)
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Returns the name of the shell function completing the tool.
+func (opts *GlobalOptions) completionFunc() string {
+	return "_" + nonIdentifier.ReplaceAllString(opts.fs.Name(), "_")
+}
+
+// Returns the first line of the usage of f, for describing it.
+func flagSummary(f *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(f)
+	line, _, _ := strings.Cut(usage, "\n")
+	return line
+}
+
+// Quotes s for a shell as a single argument.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func (opts *GlobalOptions) writeBashCompletion(w io.Writer) error {
+	var flags, others []string
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s() {\n", opts.completionFunc())
+	b.WriteString("\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("\tcase \"$prev\" in\n")
+	opts.fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, "-"+f.Name)
+		pattern := fmt.Sprintf("-%s|--%s", f.Name, f.Name)
+		var reply string
+		switch c := flagCompletion(f); {
+		case isBoolFlag(f):
+			return
+		case len(c.choices) > 0:
+			reply = fmt.Sprintf("compgen -W %s -- \"$cur\"", shellQuote(strings.Join(c.choices, " ")))
+		case c.files:
+			reply = "compgen -f -- \"$cur\""
+		case c.dirs:
+			reply = "compgen -d -- \"$cur\""
+		default:
+			// Nothing to suggest, but the value isn't an argument either.
+			others = append(others, pattern)
+			return
+		}
+		fmt.Fprintf(&b, "\t%s)\n\t\tCOMPREPLY=($(%s))\n\t\treturn ;;\n", pattern, reply)
+	})
+	if len(others) > 0 {
+		fmt.Fprintf(&b, "\t%s)\n\t\tCOMPREPLY=()\n\t\treturn ;;\n", strings.Join(others, "|"))
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("\tif [[ \"$cur\" == -* ]]; then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(flags, " ")))
+	b.WriteString("\telse\n")
+	if opts.dirArgs {
+		b.WriteString("\t\tCOMPREPLY=($(compgen -d -- \"$cur\"))\n")
+	} else {
+		b.WriteString("\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	}
+	b.WriteString("\tfi\n}\n")
+	fmt.Fprintf(&b, "complete -o filenames -F %s %s\n", opts.completionFunc(), shellQuote(opts.fs.Name()))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (opts *GlobalOptions) writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	name := shellQuote(opts.fs.Name())
+	opts.fs.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&b, "complete -c %s -o %s -d %s", name, f.Name, shellQuote(flagSummary(f)))
+		if !isBoolFlag(f) {
+			switch c := flagCompletion(f); {
+			case len(c.choices) > 0:
+				fmt.Fprintf(&b, " -x -a %s", shellQuote(strings.Join(c.choices, " ")))
+			case c.files:
+				b.WriteString(" -r -F")
+			case c.dirs:
+				b.WriteString(" -x -a '(__fish_complete_directories)'")
+			default:
+				b.WriteString(" -x")
+			}
+		}
+		b.WriteString("\n")
+	})
+	if opts.dirArgs {
+		fmt.Fprintf(&b, "complete -c %s -x -a '(__fish_complete_directories)'\n", name)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Escapes s for the description in a zsh _arguments spec.
+var zshEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`)
+
+func (opts *GlobalOptions) writeZshCompletion(w io.Writer) error {
+	var specs []string
+	opts.fs.VisitAll(func(f *flag.Flag) {
+		spec := fmt.Sprintf("-%s[%s]", f.Name, zshEscaper.Replace(flagSummary(f)))
+		if !isBoolFlag(f) {
+			value, _ := flag.UnquoteUsage(f)
+			switch c := flagCompletion(f); {
+			case len(c.choices) > 0:
+				spec += fmt.Sprintf(":%s:(%s)", value, strings.Join(c.choices, " "))
+			case c.files:
+				spec += ":" + value + ":_files"
+			case c.dirs:
+				spec += ":" + value + ":_files -/"
+			default:
+				spec += ":" + value + ": "
+			}
+		}
+		specs = append(specs, shellQuote(spec))
+	})
+	if opts.dirArgs {
+		specs = append(specs, shellQuote("*:directory:_files -/"))
+	} else {
+		specs = append(specs, shellQuote("*:file:_files"))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n", opts.fs.Name())
+	fmt.Fprintf(&b, "%s() {\n\t_arguments \\\n\t\t%s\n}\n", opts.completionFunc(), strings.Join(specs, " \\\n\t\t"))
+	fmt.Fprintf(&b, "compdef %s %s\n", opts.completionFunc(), shellQuote(opts.fs.Name()))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
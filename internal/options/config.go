@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Name of the per-tree config file export_audio_tree reads from the input
+// root.
+const TreeConfigFile = ".audio_converter"
+
+// Flags a per-tree config file may not set, since the tree may have come from
+// someone else, and these decide what gets run, or name files to write outside
+// the output directory.
+var treeConfigDenied = []string{
+	"config", "ffmpeg", "no-exec-hooks",
+	"dest", "error-logs", "log-file", "manifest", "report", "state", "stats", "watch-spill",
+}
+
+// A setting read from a config file.
+type configEntry struct {
+	line  int
+	key   string
+	value string
+}
+
+// Returns the per-user config file: audio_converter/config under
+// $XDG_CONFIG_HOME, or the platform's equivalent.
+func UserConfigFile() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audio_converter", "config"), nil
+}
+
+// Reads the settings for tool from the config file name. Each line is a flag
+// name without the dash and its value, e.g., "b = 256k", or just the name for a
+// boolean flag. Values may be quoted like a Go string. Blank lines and lines
+// starting with # are ignored. Settings after a "[tool]" line only apply to
+// that tool, e.g., "[export_audio_tree]", until the next such line.
+func readConfig(name, tool string) ([]configEntry, error) {
+	fp, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var entries []configEntry
+	section := ""
+	scanner := bufio.NewScanner(fp)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "["); ok {
+			if section, ok = strings.CutSuffix(rest, "]"); !ok {
+				return nil, fmt.Errorf("%s:%d: unterminated section %q", name, n, line)
+			}
+			section = strings.TrimSpace(section)
+			continue
+		}
+		if section != "" && section != tool {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !found {
+			value = "true"
+		} else if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad quoting for %s: %w", name, n, key, err)
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: missing flag name", name, n)
+		}
+		entries = append(entries, configEntry{line: n, key: strings.TrimLeft(key, "-"), value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading %s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// Loads the config file before args are parsed: the one given by -config, or
+// else the per-user one, if there is one. Its settings become the defaults of
+// the flags, so they show up in -h and anything on the command line wins.
+func (opts *GlobalOptions) loadConfig(args []string) error {
+	name, forced := opts.findArg(args, "config")
+	if forced {
+		var err error
+		if name, err = expandHome(name); err != nil {
+			return fmt.Errorf("-config: %w", err)
+		}
+	} else if user, err := UserConfigFile(); err != nil {
+		return nil
+	} else {
+		name = user
+	}
+	err := opts.applyConfig(name, nil)
+	if !forced && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Loads the per-tree config file in root if there is one and -config wasn't
+// given. Unlike loadConfig, this is done after parsing, since the root is an
+// argument, so flags set on the command line are left alone.
+func (opts *GlobalOptions) loadTreeConfig(root string) error {
+	if opts.Config != "" || root == "" {
+		return nil
+	}
+	set := make(map[string]bool)
+	opts.fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	for _, key := range treeConfigDenied {
+		set[key] = true
+	}
+	err := opts.applyConfig(filepath.Join(root, TreeConfigFile), set)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Applies the settings in the config file name as the defaults of opts.fs,
+// skipping those in skip. Unknown flags are warned about rather than failing,
+// so one file can be shared by tools that don't all have the same flags.
+func (opts *GlobalOptions) applyConfig(name string, skip map[string]bool) error {
+	entries, err := readConfig(name, opts.fs.Name())
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		f := opts.fs.Lookup(e.key)
+		switch {
+		case f == nil:
+			opts.printf("Warning: %s:%d: unknown flag %q, ignoring it\n", name, e.line, e.key)
+			continue
+		case e.key == "config":
+			return fmt.Errorf("%s:%d: -config cannot be set from a config file", name, e.line)
+		case skip[e.key]:
+			if slices.Contains(treeConfigDenied, e.key) {
+				opts.printf("Warning: %s:%d: -%s cannot be set from %s, ignoring it\n", name, e.line, e.key, TreeConfigFile)
+			}
+			continue
+		}
+		// Setting the value directly rather than through opts.fs.Set keeps
+		// isSet about the command line.
+		if err := f.Value.Set(e.value); err != nil {
+			return fmt.Errorf("%s:%d: bad value %q for -%s: %w", name, e.line, e.value, e.key, err)
+		}
+		f.DefValue = f.Value.String()
+	}
+	return nil
+}
@@ -3,49 +3,123 @@
 package options
 
 import (
-	"reflect"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+// The range of -b that makes sense for audio, in kbit/s.
+const (
+	MinBitRate = 8
+	MaxBitRate = 1600
+)
+
+// The longest -fade-in or -fade-out that makes sense, in seconds.
+const MaxFade = 60
+
+// Values for -channels, besides a number.
+const (
+	ChannelsKeep   = "keep"
+	ChannelsMono   = "mono"
+	ChannelsStereo = "stereo"
+)
+
+// The sample rates -r accepts without -force-rate, in Hz.
+var StandardSampleRates = []int{
+	8000, 11025, 16000, 22050, 24000, 32000,
+	44100, 48000, 88200, 96000, 176400, 192000,
+}
+
+// Checks that the ffmpeg in opts can encode with codec, for an explicit -c.
+// Set by the ffmpeg package, since it imports this one. When nil, or when
+// ffmpeg can't be asked, any codec is accepted and left for ffmpeg to reject.
+var CheckEncoder func(opts *GlobalOptions, codec string) error
+
+// Returns the formats export_audio_tree -f takes. Set by the ffmpeg package,
+// which keeps the registry of formats. When nil, any format is accepted.
+var FormatNames func() []string
+
 type ConverterOptions struct {
 	GlobalOptions
 	InputFile        string
+	InputFiles       []string // Every {input}, when there's more than one, and OutputFile is the directory for them.
 	OutputFile       string
+	ArtFile          string // Image to use as the cover art instead of any in InputFile.
+	PipeFormat       string // The format to write when OutputFile is "-", if not OutputExtensions[0].
 	BitRate          string
 	Codec            string
 	CoverArtFormat   string
 	Scale            string
 	InputExtensions  []string
 	OutputExtensions []string
+	Metadata         []string // key=value tags to set on the output, e.g., title=Song.
 	Channels         int
 	SampleRate       int
-	stereo           bool
-	mono             bool
+	FadeIn           float64 // Seconds.
+	FadeOut          float64 // Seconds, ending at Duration.
+	Start            time.Duration
+	Duration         time.Duration
+	MemoryLimit      int64  // Bytes of memory ffmpeg may use, or 0 for no limit.
+	TargetSize       int64  // Bytes the output should fit in, or 0 to use BitRate.
+	Threads          int    // For ffmpeg's -threads, or 0 to leave it to ffmpeg.
+	HWAccel          string // For ffmpeg's -hwaccel, e.g., vaapi, or "" for none.
+	Nice             int    // The niceness to run ffmpeg at, or 0 to leave it.
+	IONice           string // The I/O class to run ffmpeg in, or "" to leave it.
+	ArtFallback      bool
+	Gapless          bool
+	Atomic           bool  // Write the output by way of a temporary file.
+	MaxJobs          int   // How many of InputFiles to convert at once.
+	Explicit         Field // Fields set on purpose, even if to the zero value.
+	channels         string
+	targetSize       string
+	noAtomic         bool
+	forceRate        bool
+	forceExt         bool
+	maxJobs          jobsFlag
+	addedExt         string // Added to OutputFile by Validate, since it had none.
+	outputDir        string // The {output} given, if it was a directory.
+	start            string
+	duration         string
 }
 
 // Creates a new instance based on defaults.
-func NewConverterOptions(args []string, defaults *ConverterOptions) *ConverterOptions {
+func NewConverterOptions(args []string, defaults *ConverterOptions) (*ConverterOptions, Outcome) {
 	opts := &ConverterOptions{}
 	opts.AddOptions(args, defaults)
-	defer opts.onError()
-	if opts.Err = opts.Parse(args[1:]); opts.Err != nil {
-		return nil
+	// Not in AddOptions, since that's shared with the exporter, where one size
+	// for every file makes no sense.
+	opts.fs.StringVar(&opts.targetSize, "target-size", "", "Choose the bitrate so the output fits in `SIZE` bytes. E.g., 700M.\nSizes may use a K, M, or G suffix. Cannot be combined with -b.")
+	opts.fs.BoolVar(&opts.noAtomic, "no-atomic", false, "Write the output directly, rather than to a temporary file that is renamed into place on success.\nUse when renaming is a problem, e.g., on some network file systems.")
+	opts.fs.BoolVar(&opts.forceExt, "force-ext", false, "Write {output} even if its extension isn't one of the format's, e.g., a .mp3 from to_aac.")
+	opts.fs.Var(&opts.maxJobs, "j", "With more than one {input}, sets the maximum number of concurrent `JOBS`, or a percentage of the CPUs, e.g., 50%.\nThe default is 1, converting them in order.")
+	opts.fs.StringVar(&opts.PipeFormat, "format", "", "Write `FMT` when {output} is -, since there's no extension to go by. E.g., flac.\nRequired when {input} is - too.")
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
 	}
-	if opts.Err = opts.Validate(); opts.Err != nil {
-		return nil
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
 	}
-	return opts
+	// A temporary file can't be renamed onto stdout.
+	opts.Atomic = !opts.noAtomic && opts.OutputFile != "-"
+	return opts, OutcomeRun
 }
 
 func (opts *ConverterOptions) AddOptions(args []string, defs *ConverterOptions) {
 	fs := AddGlobalOptions(args, &opts.GlobalOptions)
 	fs.Usage = opts.Usage
 
-	opts.InputExtensions = defs.InputExtensions
-	opts.OutputExtensions = defs.OutputExtensions
+	opts.InputExtensions = slices.Clone(defs.InputExtensions)
+	opts.OutputExtensions = slices.Clone(defs.OutputExtensions)
 	opts.Channels = defs.Channels
 
-	fs.StringVar(&opts.BitRate, "b", defs.BitRate, "Sets the output bitrate.")
+	fs.StringVar(&opts.BitRate, "b", defs.BitRate, "Sets the output bitrate in kbit/s. E.g., 256 or 256k.\nValues may use a k or M suffix.")
 	fs.StringVar(&opts.Codec, "c", defs.Codec, "Sets the ffmpeg codec.")
 
 	if defs.SampleRate > 0 {
@@ -53,84 +127,455 @@ func (opts *ConverterOptions) AddOptions(args []string, defs *ConverterOptions)
 	} else if opts.SampleRate == 0 {
 		opts.SampleRate = 44100
 	}
-	fs.IntVar(&opts.SampleRate, "r", opts.SampleRate, "Sets sample rate.")
-	fs.BoolVar(&opts.stereo, "s", defs.stereo, "Sets 2.0/stereo mode.")
-	fs.BoolVar(&opts.mono, "m", defs.mono, "Sets 1.0/mono mode.")
+	fs.IntVar(&opts.SampleRate, "r", opts.SampleRate, "Sets sample rate in Hz. E.g., 44100.")
+	fs.BoolVar(&opts.forceRate, "force-rate", false, "Allow an -r other than the standard sample rates, e.g., for exotic hardware.")
+	fs.Var(channelsAlias{&opts.channels, ChannelsStereo}, "s", "Sets 2.0/stereo mode. Short for -channels stereo.")
+	fs.Var(channelsAlias{&opts.channels, ChannelsMono}, "m", "Sets 1.0/mono mode. Short for -channels mono.")
+	fs.StringVar(&opts.channels, "channels", defs.channels, "Sets the output channels to keep, mono, stereo, or `N`. E.g., 6 for 5.1.\nkeep leaves the input's layout alone, e.g., to keep a 5.1 rip 5.1. Cannot be combined with -s or -m.")
 
 	if defs.CoverArtFormat == "" && opts.CoverArtFormat == "" {
 		opts.CoverArtFormat = "copy"
 	}
-	fs.StringVar(&opts.CoverArtFormat, "cover", opts.CoverArtFormat, "Sets whether cover art is copied or converted to `FMT`.\nValues may be mjpeg, png, or copy.")
+	fs.StringVar(&opts.CoverArtFormat, "cover", opts.CoverArtFormat, "Sets whether cover art is copied or converted to `FMT`.\nValues may be mjpeg, png, copy, or none to drop it.")
+	fs.BoolVar(&opts.ArtFallback, "art-fallback", defs.ArtFallback, "If the cover art can't be converted, retry without it.")
+	fs.BoolVar(&opts.Gapless, "gapless", defs.Gapless, "Record the encoder delay so that players can skip it, e.g., for continuous mixes.\nFor AAC, this is an edit list, since ffmpeg can't write iTunSMPB.")
+	fs.StringVar(&opts.start, "start", "", "Start converting at `TIME` into the input, e.g., for a ringtone.\nTIME is seconds, e.g., 90.5, or hh:mm:ss.ms, e.g., 1:30.5. Not supported by export_audio_tree.")
+	fs.StringVar(&opts.duration, "duration", "", "Convert only `TIME` of the input, in the same form as -start. Not supported by export_audio_tree.")
+	fs.Float64Var(&opts.FadeIn, "fade-in", defs.FadeIn, "Fade in over the first `SECONDS` of the output, e.g., 0.5.")
+	fs.Float64Var(&opts.FadeOut, "fade-out", defs.FadeOut, "Fade out over the last `SECONDS` of the output, e.g., 2.\nRequires -duration, since the end of the output has to be known up front.")
+	fs.IntVar(&opts.Threads, "ffmpeg-threads", defs.Threads, "Limit each ffmpeg to `N` threads. The default leaves it to ffmpeg.\nexport_audio_tree defaults to 1 with more than one job, since it already runs an ffmpeg per job.")
+	fs.StringVar(&opts.HWAccel, "hwaccel", defs.HWAccel, "Decode the input with ffmpeg's `NAME` hardware acceleration, e.g., auto, cuda, vaapi, or videotoolbox.")
 	fs.StringVar(&opts.Scale, "scale", defs.Scale, "When converting cover art, scale it to `SCALE`. Format is HEIGHTxWIDTH. E.g., \"500x500\"\nNote: only takes affect when -cover is not set to copy")
 }
 
 func (opts *ConverterOptions) Parse(args []string) error {
 	if opts.Err = opts.parse(args); opts.Err != nil {
-		return nil
+		return opts.Err
 	}
+	opts.markExplicit()
 	opts.InputFile = opts.fs.Arg(0)
 	opts.OutputFile = opts.fs.Arg(1)
+	if n := opts.fs.NArg(); n > 2 {
+		opts.InputFiles = slices.Clone(opts.fs.Args()[:n-1])
+		opts.OutputFile = opts.fs.Arg(n - 1)
+	}
 	return nil
 }
 
 func (opts *ConverterOptions) Validate() error {
-	if opts.stereo {
-		opts.Channels = 2
-	} else if opts.mono {
-		opts.Channels = 1
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
+	if err := opts.validateChannels(); err != nil {
+		return err
+	}
+	if err := opts.validateCodec(); err != nil {
+		return err
+	}
+	if err := opts.validateBitRate(); err != nil {
+		return err
+	}
+	if err := opts.validateSampleRate(); err != nil {
+		return err
 	}
 	if err := ValidateHeightWidth(opts.Scale); err != nil {
 		return err
 	}
-	if err := ValidateFileArgs(opts.InputFile, opts.OutputFile); err != nil {
+	if len(opts.InputFiles) > 0 {
+		if err := opts.validateInputs(); err != nil {
+			return err
+		}
+	} else {
+		if opts.isSet("j") {
+			return fmt.Errorf("-j requires more than one {input}")
+		}
+		if err := ValidateFileArgs(opts.InputFile, opts.OutputFile); err != nil {
+			return err
+		}
+		if err := opts.validateOutputDir(); err != nil {
+			return err
+		}
+		if err := opts.validateOutputExt(); err != nil {
+			return err
+		}
+	}
+	if err := opts.validatePipes(); err != nil {
+		return err
+	}
+	if err := opts.validateTrim(); err != nil {
+		return err
+	}
+	if err := opts.validateFades(); err != nil {
+		return err
+	}
+	if err := opts.validateThreads(); err != nil {
 		return err
 	}
+	if opts.targetSize != "" {
+		var err error
+		if opts.TargetSize, err = parseByteSize(opts.targetSize); err != nil {
+			return fmt.Errorf("bad -target-size: %w", err)
+		} else if opts.isSet("b") {
+			return fmt.Errorf("-target-size cannot be combined with -b")
+		}
+	}
 	return nil
 }
 
+// Turns an OutputFile that's a directory, or ends with a separator, into the
+// file in it named after InputFile with the first of OutputExtensions, if any,
+// e.g., to_mp3 in.flac outdir/ writes outdir/in.mp3. -n and -y then apply to
+// that.
+func (opts *ConverterOptions) validateOutputDir() error {
+	output := opts.OutputFile
+	if output == "-" {
+		return nil
+	}
+	st, err := os.Stat(output)
+	if os.IsPathSeparator(output[len(output)-1]) {
+		if err != nil {
+			return fmt.Errorf("{output} directory %q doesn't exist: %w", output, err)
+		} else if !st.IsDir() {
+			return fmt.Errorf("{output} %q isn't a directory", output)
+		}
+	} else if err != nil || !st.IsDir() {
+		return nil
+	}
+	if opts.InputFile == "-" {
+		return fmt.Errorf("{output} %q is a directory, but there's no {input} name to go by", output)
+	}
+	opts.outputDir = output
+	opts.OutputFile = opts.outputIn(output, opts.InputFile)
+	// E.g., to_flac song.flac . would write over the input.
+	return ValidateFileArgs(filepath.Clean(opts.InputFile), opts.OutputFile)
+}
+
+// Returns the file in dir that input is written to: its name, with the first
+// of OutputExtensions, if any.
+func (opts *ConverterOptions) outputIn(dir, input string) string {
+	base := filepath.Base(input)
+	if len(opts.OutputExtensions) > 0 {
+		base = strings.TrimSuffix(base, filepath.Ext(base)) + opts.OutputExtensions[0]
+	}
+	return filepath.Join(dir, base)
+}
+
+// Returns the file that input, one of InputFiles, is written to.
+func (opts *ConverterOptions) OutputFor(input string) string {
+	return opts.outputIn(opts.OutputFile, input)
+}
+
+// Checks the arguments when there's more than one {input}: each must be a
+// media file, and OutputFile a directory to write them into, each under a name
+// of its own. Also sets MaxJobs.
+func (opts *ConverterOptions) validateInputs() error {
+	if st, err := os.Stat(opts.OutputFile); err != nil {
+		return fmt.Errorf("{output} directory: %w", err)
+	} else if !st.IsDir() {
+		return fmt.Errorf("with more than one {input}, {output} %q must be a directory", opts.OutputFile)
+	}
+	outputs := make(map[string]string, len(opts.InputFiles))
+	for _, input := range opts.InputFiles {
+		if input == "-" {
+			return fmt.Errorf("cannot read stdin with more than one {input}")
+		} else if st, err := os.Stat(input); err != nil {
+			return fmt.Errorf("{input}: %w", err)
+		} else if !st.Mode().IsRegular() {
+			return fmt.Errorf("{input} %q is not a file", input)
+		} else if len(opts.InputExtensions) > 0 && !slices.Contains(opts.InputExtensions, filepath.Ext(input)) {
+			return fmt.Errorf("{input} %q is not a media file: must end with %s", input, strings.Join(opts.InputExtensions, " "))
+		}
+		output := opts.OutputFor(input)
+		if err := ValidateFileArgs(filepath.Clean(input), output); err != nil {
+			return err
+		} else if other, ok := outputs[output]; ok {
+			return fmt.Errorf("{input} %q and %q would both be written to %q", other, input, output)
+		}
+		outputs[output] = input
+	}
+	var err error
+	if opts.MaxJobs, err = opts.maxJobs.jobs("-j", runtime.NumCPU()); err != nil {
+		return err
+	} else if opts.MaxJobs == 0 {
+		opts.MaxJobs = 1
+	}
+	if opts.MaxJobs > 1 && !opts.isSet("ffmpeg-threads") {
+		// An ffmpeg per job, each with a thread per core, would oversubscribe
+		// the CPUs many times over.
+		opts.Threads = 1
+	}
+	return nil
+}
+
+// Returns the directory {output} named, if Validate put OutputFile in it, or ""
+// if {output} was a file.
+func (opts *ConverterOptions) OutputDir() string {
+	return opts.outputDir
+}
+
+// Checks the extension of OutputFile against OutputExtensions, so that, e.g.,
+// to_aac doesn't write AAC into song.mp3, unless -force-ext says to. An
+// OutputFile without an extension gets the first of OutputExtensions.
+func (opts *ConverterOptions) validateOutputExt() error {
+	if opts.OutputFile == "-" || len(opts.OutputExtensions) == 0 {
+		return nil
+	}
+	ext := filepath.Ext(opts.OutputFile)
+	if ext == "" {
+		opts.addedExt = opts.OutputExtensions[0]
+		opts.OutputFile += opts.addedExt
+		return nil
+	}
+	matches := func(e string) bool { return strings.EqualFold(e, ext) }
+	if opts.forceExt || slices.ContainsFunc(opts.OutputExtensions, matches) {
+		return nil
+	}
+	return fmt.Errorf("{output} %q should end with %s, use -force-ext to write it anyway", opts.OutputFile, strings.Join(opts.OutputExtensions, " or "))
+}
+
+// Returns the extension Validate added to OutputFile because it had none, or
+// "" if it had one already.
+func (opts *ConverterOptions) AddedExt() string {
+	return opts.addedExt
+}
+
+// Checks the use of "-" for {input} and {output}, which ffmpeg reads from stdin
+// and writes to stdout. Reading stdin leaves nothing to probe, so -target-size
+// can't work out a bit rate.
+func (opts *ConverterOptions) validatePipes() error {
+	opts.PipeFormat = strings.ToLower(opts.PipeFormat)
+	if opts.PipeFormat != "" && FormatNames != nil && !slices.Contains(FormatNames(), opts.PipeFormat) {
+		return fmt.Errorf("unsupported -format: %q", opts.PipeFormat)
+	}
+	if opts.InputFile != "-" {
+		return nil
+	} else if opts.OutputFile == "-" && opts.PipeFormat == "" {
+		return fmt.Errorf("-format is required to read stdin and write stdout")
+	} else if opts.targetSize != "" {
+		return fmt.Errorf("-target-size cannot be used when reading stdin")
+	}
+	return nil
+}
+
+// Parses -start and -duration into Start and Duration. Whether Start is past
+// the end of the input can only be known by probing it, so that's left to the
+// ffmpeg package.
+func (opts *ConverterOptions) validateTrim() error {
+	if opts.start != "" {
+		start, err := ParseTime(opts.start)
+		if err != nil {
+			return fmt.Errorf("bad -start: %w", err)
+		}
+		opts.Start = start
+	}
+	if opts.duration != "" {
+		duration, err := ParseTime(opts.duration)
+		if err != nil {
+			return fmt.Errorf("bad -duration: %w", err)
+		} else if duration == 0 {
+			return fmt.Errorf("bad -duration: %q: must be positive", opts.duration)
+		}
+		opts.Duration = duration
+	}
+	return nil
+}
+
+// Checks -fade-in and -fade-out against MaxFade and -duration. -fade-out needs
+// -duration to know where the output ends, rather than probing the input,
+// which can't be done with stdin.
+func (opts *ConverterOptions) validateFades() error {
+	for _, fade := range []struct {
+		name    string
+		seconds float64
+	}{{"-fade-in", opts.FadeIn}, {"-fade-out", opts.FadeOut}} {
+		if fade.seconds < 0 || fade.seconds > MaxFade || math.IsNaN(fade.seconds) {
+			return fmt.Errorf("bad %s: %v: must be between 0 and %d seconds", fade.name, fade.seconds, MaxFade)
+		} else if opts.Duration > 0 && fade.seconds > opts.Duration.Seconds() {
+			return fmt.Errorf("bad %s: %v seconds is longer than -duration %v", fade.name, fade.seconds, opts.Duration)
+		}
+	}
+	if opts.FadeOut > 0 && opts.Duration == 0 {
+		return fmt.Errorf("-fade-out requires -duration")
+	}
+	return nil
+}
+
+// Checks -ffmpeg-threads and -hwaccel. ffmpeg knows which accelerations it
+// has, so that's left to it, but NAME mustn't pass for another flag.
+func (opts *ConverterOptions) validateThreads() error {
+	if opts.Threads < 0 {
+		return fmt.Errorf("-ffmpeg-threads cannot be negative")
+	} else if strings.HasPrefix(opts.HWAccel, "-") || strings.ContainsFunc(opts.HWAccel, unicode.IsSpace) {
+		return fmt.Errorf("bad -hwaccel: %q", opts.HWAccel)
+	}
+	return nil
+}
+
+// Resolves the channel flags into Channels. An explicit -channels, -s, or -m
+// takes precedence over the format's default; the flags themselves are
+// mutually exclusive on the command line. -channels keep sets Channels to 0,
+// so that ffmpeg keeps the input's layout.
+func (opts *ConverterOptions) validateChannels() error {
+	n := 0
+	for _, name := range []string{"channels", "s", "m"} {
+		if opts.isSet(name) {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("only one of -channels, -s, or -m may be specified")
+	}
+	switch opts.channels {
+	case "":
+	case ChannelsKeep:
+		opts.Channels = 0
+	case ChannelsMono:
+		opts.Channels = 1
+	case ChannelsStereo:
+		opts.Channels = 2
+	default:
+		n, err := strconv.Atoi(opts.channels)
+		if err != nil || n < 1 || n > 8 {
+			return fmt.Errorf("bad -channels: %q: must be keep, mono, stereo, or between 1 and 8", opts.channels)
+		}
+		opts.Channels = n
+	}
+	return nil
+}
+
+// Rejects a -c that ffmpeg has no encoder for up front, rather than failing
+// every file. Only an explicit -c is checked, since the defaults are for a
+// typical ffmpeg and it's ffmpeg's place to complain about those.
+func (opts *ConverterOptions) validateCodec() error {
+	if CheckEncoder == nil || opts.Codec == "" || !opts.isSet("c") {
+		return nil
+	}
+	if err := CheckEncoder(&opts.GlobalOptions, opts.Codec); err != nil {
+		return fmt.Errorf("-c: %w", err)
+	}
+	return nil
+}
+
+// Checks SampleRate against StandardSampleRates, so a typo like 4410 isn't
+// resampled to something nothing can play. -force-rate allows others, but
+// never zero or negative.
+func (opts *ConverterOptions) validateSampleRate() error {
+	if opts.SampleRate <= 0 {
+		return fmt.Errorf("bad -r: %d: must be positive", opts.SampleRate)
+	} else if !opts.forceRate && !slices.Contains(StandardSampleRates, opts.SampleRate) {
+		return fmt.Errorf("bad -r: %d is not a standard sample rate, use -force-rate if you mean it", opts.SampleRate)
+	}
+	return nil
+}
+
+// Normalizes BitRate to kbit/s, e.g., "320k", so that a plain "320" doesn't
+// reach ffmpeg, which would take it as 320 bits per second.
+func (opts *ConverterOptions) validateBitRate() error {
+	if opts.BitRate == "" {
+		return nil
+	}
+	rate, err := parseBitRate(opts.BitRate)
+	if err != nil {
+		return fmt.Errorf("bad -b: %w", err)
+	}
+	opts.BitRate = rate
+	return nil
+}
+
+// Parses a bit rate in kbit/s, with an optional k or M suffix, returning it
+// as ffmpeg expects, e.g., "1.5M" becomes "1500k".
+func parseBitRate(value string) (string, error) {
+	scale := 1.0
+	digits := value
+	switch value[len(value)-1:] {
+	case "k", "K":
+		digits = value[:len(value)-1]
+	case "M":
+		scale = 1000
+		digits = value[:len(value)-1]
+	}
+	// ParseFloat alone would take things like "1e3" and "Inf".
+	n, err := strconv.ParseFloat(digits, 64)
+	if err != nil || strings.Trim(digits, "0123456789.") != "" {
+		return "", fmt.Errorf("invalid bitrate %q: expected kbit/s, e.g., 256 or 256k", value)
+	}
+	kbps := n * scale
+	if kbps != math.Trunc(kbps) {
+		return "", fmt.Errorf("invalid bitrate %q: must be a whole number of kbit/s", value)
+	} else if kbps < MinBitRate || kbps > MaxBitRate {
+		return "", fmt.Errorf("bitrate %q is out of range: must be between %dk and %dk", value, MinBitRate, MaxBitRate)
+	}
+	return fmt.Sprintf("%dk", int64(kbps)), nil
+}
+
+// Parses a time as ffmpeg takes it for -ss and -t: seconds, e.g., "90.5", or
+// [[hh:]mm:]ss[.ms], e.g., "1:30.5". Negative times are rejected.
+func ParseTime(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q: expected seconds or hh:mm:ss.ms", value)
+	}
+	var d time.Duration
+	for i, part := range parts {
+		last := i == len(parts)-1
+		// ParseFloat alone would take things like "1e3", "Inf", and "-1".
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || part == "" || strings.Trim(part, "0123456789.") != "" {
+			return 0, fmt.Errorf("invalid time %q: expected seconds or hh:mm:ss.ms", value)
+		} else if !last && n != math.Trunc(n) {
+			return 0, fmt.Errorf("invalid time %q: only the seconds may have a fraction", value)
+		} else if i > 0 && n >= 60 {
+			return 0, fmt.Errorf("invalid time %q: minutes and seconds must be less than 60", value)
+		}
+		d = d*60 + time.Duration(n*float64(time.Second))
+	}
+	return d, nil
+}
+
 func (opts *ConverterOptions) Usage() {
 	opts.printf("%s [options] {input} {output}\n", opts.fs.Name())
-	opts.printf("\nConverts the {input} file into {output} using ffmpeg\n\n")
+	opts.printf("%s [options] {input...} {directory}\n", opts.fs.Name())
+	opts.printf("\nConverts the {input} file into {output} using ffmpeg\n")
+	opts.printf("Either may be - for stdin or stdout\n")
+	opts.printf("Given more than one {input}, each is converted into {directory}, and the failures listed at the end\n\n")
 	if len(opts.InputExtensions) > 0 {
 		opts.printf("Supported input extensions: %s\n\n", strings.Join(opts.InputExtensions, " "))
 	}
 	if len(opts.OutputExtensions) > 0 {
-		opts.printf("Be sure to include an %s extension in {output}\n\n", opts.OutputExtensions[0])
+		opts.printf("The {output} extension must be %s, unless -force-ext is given\n", strings.Join(opts.OutputExtensions, " or "))
+		opts.printf("Without an extension, %s is added\n", opts.OutputExtensions[0])
+		opts.printf("If {output} is a directory, {input} is written into it with that extension\n\n")
 	}
 	opts.fs.PrintDefaults()
 }
 
-// Merges options from `source` into the current options structure. Any fields
-// that are zero initialized are ignored.
-//
-// Note, this performs a shallow copy. That's suitable for our purposes, but not great.
-func (opts *ConverterOptions) Merge(source *ConverterOptions) {
-	// Since they're pointers, ValueOf(...).Elem() should never crash.
-	self := reflect.ValueOf(opts).Elem()
-	other := reflect.ValueOf(source).Elem()
-	if !other.IsValid() {
-		return
-	}
-	if self.NumField() != other.NumField() {
-		// I don't think this is technically possible when they're the same
-		// type. But if it ever is, I want to see it logged (^_^)
-		panic("ConverterOptions.Merge: different number of fields for the same type")
-	}
-	for i := range other.NumField() {
-		s := self.Field(i)
-		o := other.Field(i)
-
-		// These should never fail, but let's be pendantic.
-		if !o.IsValid() || o.IsZero() {
-			continue
-		}
-		// Skip already initialized.
-		if !s.IsZero() {
-			continue
-		}
-		if !s.CanSet() {
-			panic("ConverterOptions.Merge: can't set destination field")
-		}
-		s.Set(o)
+// A flag.Value for -s and -m, which are short for -channels stereo and
+// -channels mono.
+type channelsAlias struct {
+	channels *string
+	value    string
+}
+
+func (a channelsAlias) String() string {
+	return "false"
+}
+
+func (a channelsAlias) IsBoolFlag() bool {
+	return true
+}
+
+func (a channelsAlias) Set(value string) error {
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
 	}
+	if set {
+		*a.channels = a.value
+	} else if *a.channels == a.value {
+		*a.channels = ""
+	}
+	return nil
 }
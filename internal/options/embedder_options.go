@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+type EmbedderOptions struct {
+	GlobalOptions
+	ImageOptions
+	ImageFile  string
+	AudioFiles []string
+	Root       string // The directory given with -R.
+	CoverName  string
+	MaxJobs    int
+	Recursive  bool
+	maxJobs    jobsFlag
+}
+
+func NewEmbedderOptions(args []string) (*EmbedderOptions, Outcome) {
+	opts := &EmbedderOptions{}
+	opts.AddOptions(args)
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
+	}
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
+	}
+	return opts, OutcomeRun
+}
+
+func (opts *EmbedderOptions) Usage() {
+	opts.printf("%s [options] {image} {audio...}\n", opts.fs.Name())
+	opts.printf("%s [options] -R {directory}\n", opts.fs.Name())
+	opts.printf("\nEmbeds {image} as the cover art of each {audio} file using ffmpeg, replacing any art it had.\n")
+	opts.printf("With -R, the -cover-name image in each directory under {directory} is embedded into the tracks next to it.\n")
+	opts.printf("Files that already have cover art are only changed with -y, or when confirmed without -R.\n\n")
+	opts.fs.PrintDefaults()
+}
+
+func (opts *EmbedderOptions) AddOptions(args []string) {
+	fs := AddGlobalOptions(args, &opts.GlobalOptions)
+	opts.addImageOptions(fs, "Convert the image with the ffmpeg `CODEC` rather than embedding it as is, e.g., mjpeg.")
+	fs.BoolVar(&opts.Recursive, "R", false, "Embed the cover of every album in {directory} and its subdirectories into its tracks.")
+	fs.StringVar(&opts.CoverName, "cover-name", "cover.jpg", "With -R, the `NAME` of each album's cover.")
+	fs.Var(&opts.maxJobs, "j", "With -R, sets the maximum number of concurrent `JOBS`, or a percentage of the CPUs, e.g., 50%.")
+	fs.Usage = opts.Usage
+}
+
+func (opts *EmbedderOptions) Parse(args []string) error {
+	if opts.Err = opts.parse(args); opts.Err != nil {
+		return opts.Err
+	}
+	if opts.Recursive {
+		opts.Root = opts.fs.Arg(0)
+		return nil
+	}
+	opts.ImageFile = opts.fs.Arg(0)
+	if opts.fs.NArg() > 1 {
+		opts.AudioFiles = opts.fs.Args()[1:]
+	}
+	return nil
+}
+
+func (opts *EmbedderOptions) Validate() error {
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
+	if err := ValidateHeightWidth(opts.Scale); err != nil {
+		return err
+	}
+	if opts.Recursive {
+		return opts.validateRoot()
+	}
+	for _, name := range []string{"cover-name", "j"} {
+		if opts.isSet(name) {
+			return fmt.Errorf("-%s requires -R", name)
+		}
+	}
+	if opts.ImageFile == "" {
+		return fmt.Errorf("must specify image file")
+	} else if len(opts.AudioFiles) == 0 {
+		return fmt.Errorf("must specify audio files")
+	}
+	for _, audio := range opts.AudioFiles {
+		if err := ValidateFileArgs(opts.ImageFile, audio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks the arguments for -R: a directory and nothing else.
+func (opts *EmbedderOptions) validateRoot() error {
+	if opts.fs.NArg() != 1 {
+		return fmt.Errorf("-R takes one directory, have %d arguments", opts.fs.NArg())
+	} else if opts.CoverName != filepath.Base(opts.CoverName) {
+		return fmt.Errorf("bad -cover-name %q: must be a file name", opts.CoverName)
+	}
+	var err error
+	if opts.MaxJobs, err = opts.maxJobs.jobs("-j", runtime.NumCPU()); err != nil {
+		return err
+	}
+	if st, err := os.Stat(opts.Root); err != nil {
+		return fmt.Errorf("input directory: %w", err)
+	} else if !st.IsDir() {
+		return fmt.Errorf("input %q must be a directory with -R", opts.Root)
+	}
+	return nil
+}
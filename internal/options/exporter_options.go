@@ -4,56 +4,200 @@ package options
 
 import (
 	"audio_converter/internal/filesystem"
+	"audio_converter/internal/layout"
+	"audio_converter/internal/proc"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+)
+
+// Values for -preserve-times.
+const (
+	PreserveNone   = "none"
+	PreserveCopies = "copies"
+	PreserveAll    = "all"
+)
+
+// Values for -ffmpeg-log.
+const (
+	FFmpegLogNone   = "none"
+	FFmpegLogErrors = "errors"
+	FFmpegLogFull   = "full"
+)
+
+// Values for -lossy-policy.
+const (
+	LossyConvert = "convert"
+	LossyCopy    = "copy"
+	LossySkip    = "skip"
 )
 
 type ExporterOptions struct {
 	ConverterOptions
-	InRoot        string
-	OutRoot       string
-	Format        string
-	CleanPaths    string
-	MaxQueue      int
-	MaxJobs       int
-	CopyUnknown   bool
-	noCopyUnknown bool
+	InRoot       string
+	OutRoot      string
+	Format       string
+	CleanPaths   string
+	LossyPolicy  string
+	PreserveTime string
+	FFmpegLog    string
+	StatsFile    string
+	Excludes     []string
+	Includes     []string
+	MediaExts    []string
+	IgnoreExts   []string
+	Only         []string
+	MaxQueue     int
+	MaxJobs      int
+	IOJobs       int
+	SpotCheck    int
+	SizeRatio    float64
+	CopyUnknown  bool
+	IgnoreSpace  bool
+	Verify       bool
+	Update       bool
+	DedupeSuffix bool
+	Diff         bool
+	JSON         bool
+
+	CaseInsensitiveTarget bool
+	CollectPlaylists      string
+	PreserveSymlinks      bool
+	FollowSymlinks        bool
+	SidecarArt            bool
+	StatusInterval        time.Duration
+	Timeout               time.Duration // For each ffmpeg run, or 0 for none.
+	StateFile             string
+	Watch                 bool
+	WatchSettle           time.Duration
+	WatchPoll             time.Duration
+	WatchSpill            string
+	ErrorLogs             string
+	IgnoreSettingsChange  bool
+	SpotCheckSeed         int64
+	ByAlbum               bool
+	SplitCue              bool
+	ReplayGain            bool
+	CleanPathsStrict      bool
+	NormalizeNames        string
+	MaxNameBytes          int
+	MaxPathBytes          int
+	SkipTrash             []string
+	NoSkipTrash           bool
+	Checksum              bool
+	ReportDuplicates      bool
+	RenameFromTags        bool
+	Manifest              string
+	BandwidthLimit        int64         // Bytes per second for all copies, or 0 for no limit.
+	Layout                layout.Layout // Where files go in the output directory, or nil to keep the input's structure.
+	Dests                 []Destination // From -dest, or nil to export to OutRoot as Format.
+	noCopyUnknown         bool
+	memoryLimit           string
+	bandwidthLimit        string
+	layout                string
+	skipTrash             string
+	maxJobs               jobsFlag
+	dests                 []string
+}
+
+// An output directory given by -dest, and the format to export to it.
+type Destination struct {
+	Format  string
+	OutRoot string
 }
 
-func NewExporterOptions(args []string, defs *ConverterOptions) *ExporterOptions {
+// Returns d as it's given to -dest, e.g., "mp3:/mnt/sd".
+func (d Destination) String() string {
+	return d.Format + ":" + d.OutRoot
+}
+
+func NewExporterOptions(args []string, defs *ConverterOptions) (*ExporterOptions, Outcome) {
 	opts := &ExporterOptions{}
 	if defs != nil {
 		opts.Merge(defs)
 	}
 	opts.AddOptions(args)
-	defer opts.onError() // handle printing if opts.Err != nil
-
-	if opts.Err = opts.Parse(args[1:]); opts.Err != nil {
-		return nil
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
 	}
-	if opts.Err = opts.Validate(); opts.Err != nil {
-		return nil
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
 	}
-	return opts
+	return opts, OutcomeRun
 }
 
 func (opts *ExporterOptions) AddOptions(args []string) {
+	// Losing the art beats losing the song when exporting a whole library.
+	opts.ArtFallback = true
 	opts.ConverterOptions.AddOptions(args, &opts.ConverterOptions)
+	opts.dirArgs = true
 	// So, this would work ^, but takes us back to the injecting defaults issue.
 	fs := opts.fs
 
 	fs.BoolVar(&opts.CopyUnknown, "C", true, "Copy unknown files, like album art and booklets. (default)")
 	fs.BoolVar(&opts.noCopyUnknown, "N", false, "Do not copy unknown files.")
 	fs.IntVar(&opts.MaxQueue, "q", 0, "Sets the maximum queue depth.")
-	fs.IntVar(&opts.MaxJobs, "j", 0, "Sets the maximum number of concurrent jobs.")
+	jobsHelp := strings.Join([]string{
+		"Sets the maximum number of concurrent `JOBS`, or a percentage of the CPUs, e.g., 50%.",
+		"Each conversion or copy takes a job, but copies are also limited by -io-jobs.",
+	}, "\n")
+	fs.Var(&opts.maxJobs, "j", jobsHelp)
+	fs.DurationVar(&opts.StatusInterval, "status-interval", 30*time.Second, "Log the status of the work pool every `INTERVAL`, e.g., 10s. 0 disables it.")
+	timeoutHelp := strings.Join([]string{
+		"Kill ffmpeg if it runs longer than `DURATION`, e.g., 30m, and count the file as failed, so a hung conversion doesn't tie up a job for the rest of the export.",
+		"0 disables it.",
+	}, "\n")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, timeoutHelp)
+	ioJobsHelp := strings.Join([]string{
+		"Sets the maximum number of files copied at once.",
+		"Copies are run by the -j jobs, so at most the smaller of the two run at once.",
+		"Keep this low when reading from or writing to a spinning disk or memory card.",
+	}, "\n")
+	fs.IntVar(&opts.IOJobs, "io-jobs", 2, ioJobsHelp)
+	byAlbumHelp := strings.Join([]string{
+		"Convert the tracks of each directory one after the other, in order, on a single job.",
+		"Up to -j albums convert at once, rather than every job taking the next track of any album,",
+		"so each album is finished sooner and its source is read in order. Copies and files found by -watch are queued as usual.",
+	}, "\n")
+	fs.BoolVar(&opts.ByAlbum, "by-album", false, byAlbumHelp)
+	splitCueHelp := strings.Join([]string{
+		"Split media files described by a .cue sheet in the same directory into a file per track, named like \"01 - Title\".",
+		"The tracks are tagged with their number, title, and artist from the sheet, which isn't copied.",
+	}, "\n")
+	fs.BoolVar(&opts.SplitCue, "split-cue", false, splitCueHelp)
+	replayGainHelp := strings.Join([]string{
+		"Measure the loudness of each converted file and tag it with its ReplayGain track gain and peak.",
+		"With -by-album, the album gain and peak are tagged too, once every track of the directory is measured.",
+	}, "\n")
+	fs.BoolVar(&opts.ReplayGain, "replaygain", false, replayGainHelp)
 	fs.Usage = opts.Usage
 
 	// Since we can't just look up the flag and set its DefValue, we can't use
 	// Func to bind a parse function to the flag and have working unit tests,
 	// since those expect the DefValue and Value to actually work. So instead,
 	// we need to make this a normal flag and validate after parse.
-	fs.StringVar(&opts.Format, "f", "m4a", "Set the output extension/format.")
+	formatHelp := "Set the output extension/format."
+	if FormatNames != nil {
+		formatHelp = "Set the output extension/format: " + strings.Join(FormatNames(), ", ") + "."
+	}
+	fs.StringVar(&opts.Format, "f", "m4a", formatHelp)
+	destHelp := strings.Join([]string{
+		"Export to the directory `FORMAT:DIR`, e.g., mp3:/mnt/sd, in place of {outdir} and -f. May be repeated to export to several",
+		"directories in one pass: the input is walked and probed once, and each file is converted for each one with the defaults of its format.",
+		"Each gets its own summary and -diff report, and -manifest and -stats say which one each record is for. Cannot be combined with -watch or -state.",
+	}, "\n")
+	fs.Var((*stringList)(&opts.dests), "dest", destHelp)
+	lossyHelp := strings.Join([]string{
+		"How to export lossy files like mp3 and m4a: convert, copy, or skip.",
+		"Converting lossy files loses quality, so copying them as-is may be preferable.",
+	}, "\n")
+	fs.StringVar(&opts.LossyPolicy, "lossy-policy", LossyConvert, lossyHelp)
 
 	cleanPathsHelp := strings.Join([]string{
 		"Replace reserved characters with `TEXT` when creating output file names.",
@@ -61,28 +205,371 @@ func (opts *ExporterOptions) AddOptions(args []string) {
 		"The underscore ('_') makes a good replacement text.",
 	}, "\n")
 	fs.StringVar(&opts.CleanPaths, "cleanpaths", "", cleanPathsHelp)
+	strictHelp := strings.Join([]string{
+		"With -cleanpaths, also prefix Windows device names like CON and nul.txt with the replacement text,",
+		"and replace trailing dots and spaces. Use -cleanpaths-strict=false if Windows doesn't matter. (default true)",
+	}, "\n")
+	fs.BoolVar(&opts.CleanPathsStrict, "cleanpaths-strict", true, strictHelp)
+	normalizeHelp := strings.Join([]string{
+		"Convert output names to the Unicode normalization form `FORM`: nfc, nfd, or none.",
+		"Names from macOS are often nfd, and look the same as but differ from nfc names made elsewhere.",
+		"Sources that only differ by form are treated as colliding.",
+	}, "\n")
+	fs.StringVar(&opts.NormalizeNames, "normalize-names", filesystem.NormalizeNone, normalizeHelp)
+	fs.IntVar(&opts.MaxNameBytes, "max-name-bytes", filesystem.DefaultMaxNameBytes, "Shorten output file and directory names longer than `BYTES`, keeping the extension. 0 disables it.")
+	maxPathHelp := strings.Join([]string{
+		"Shorten output file names so paths within the output directory are at most `BYTES`. 0 disables it.",
+		"E.g., 240 leaves room for the 260 character MAX_PATH of Windows when the output is a short directory like E:\\Music.",
+	}, "\n")
+	fs.IntVar(&opts.MaxPathBytes, "max-path-bytes", 0, maxPathHelp)
+	dedupeHelp := strings.Join([]string{
+		"When two files map to the same output name, append \" (2)\", \" (3)\", etc.",
+		"The default is to fail before exporting anything.",
+	}, "\n")
+	fs.BoolVar(&opts.DedupeSuffix, "dedupe-suffix", false, dedupeHelp)
+	fs.BoolVar(&opts.CaseInsensitiveTarget, "case-insensitive-target", false, "Treat output names that differ only by case as colliding. Useful for FAT and exFAT.")
+	excludeHelp := strings.Join([]string{
+		"Do not export paths matching `GLOB`, relative to the input directory. May be repeated.",
+		"Use ** to match any number of directories, e.g., **/*.pdf, and end with / to only match directories.",
+	}, "\n")
+	fs.Var((*stringList)(&opts.Excludes), "exclude", excludeHelp)
+	fs.Var((*stringList)(&opts.Includes), "include", "Export paths matching `GLOB` even if excluded. May be repeated.")
+	mediaExtHelp := strings.Join([]string{
+		"Also convert files ending in `EXT`, e.g., .ape or .wv, as long as ffmpeg can decode them. May be repeated.",
+		"The built in extensions are listed by -help.",
+	}, "\n")
+	fs.Var((*stringList)(&opts.MediaExts), "media-ext", mediaExtHelp)
+	fs.Var((*stringList)(&opts.IgnoreExts), "ignore-ext", "Do not export files ending in `EXT`, e.g., .wav, at all. May be repeated.")
+	onlyHelp := strings.Join([]string{
+		"Only export the directory `PATH`, relative to the input directory. May be repeated.",
+		"Output is still placed under the same path in the output directory.",
+	}, "\n")
+	fs.Var((*stringList)(&opts.Only), "only", onlyHelp)
+	layoutHelp := strings.Join([]string{
+		"Arrange the output by `LAYOUT` rather than as the input is arranged, e.g., for a player that only reads two directories deep.",
+		"flatten-discs moves the files of disc directories like \"Disc 2\" up into the album, and artist-album arranges them by tags.",
+		"Anything else is a template, e.g., \"{artist}/{album}/{track:02d} {title}\". Fields are tags, or {name}, {dir}, {parent}, or {dir1}, {dir2}, etc.",
+		"for the directories of the source from the top. Other files, like cover art, go with the first media file next to them.",
+		"Collisions are handled as for -cleanpaths. Cannot be combined with -only or -preserve-symlinks.",
+	}, "\n")
+	fs.StringVar(&opts.layout, "layout", "", layoutHelp)
+	renameHelp := strings.Join([]string{
+		"Name media files \"NN - Title\" by their track, disc, and title tags, e.g., for rips named 01.flac, cleaned like any other name.",
+		"Files missing a track or title tag, or named like another file before them, keep their own names.",
+	}, "\n")
+	fs.BoolVar(&opts.RenameFromTags, "rename-from-tags", false, renameHelp)
+	preserveHelp := strings.Join([]string{
+		"Which outputs get the modification time of their source: none, copies, or all.",
+		"Copies also keep the permissions of their source.",
+	}, "\n")
+	fs.StringVar(&opts.PreserveTime, "preserve-times", PreserveCopies, preserveHelp)
+	updateHelp := strings.Join([]string{
+		"Skip files whose output is newer than the source and was converted with the same settings.",
+		"The settings are recorded in a .export_audio_tree.json file in the output directory.",
+	}, "\n")
+	fs.BoolVar(&opts.Update, "update", false, updateHelp)
+	fs.BoolVar(&opts.IgnoreSettingsChange, "ignore-settings-change", false, "With -update, keep outputs converted with different settings, such as a different bit rate.")
+	checksumHelp := strings.Join([]string{
+		"With -update, also skip files whose source is newer than the output but has the same contents as when it was exported,",
+		"e.g., after being copied or restored from a backup. The contents are compared by SHA-256,",
+		"recorded in a .export_audio_tree.sha256.json file in the output directory.",
+	}, "\n")
+	fs.BoolVar(&opts.Checksum, "checksum", false, checksumHelp)
+	fs.BoolVar(&opts.ReportDuplicates, "report-duplicates", false, "After exporting, log the sources that have the same contents as another source.")
+	fs.BoolVar(&opts.IgnoreSpace, "ignore-space", false, "Export even if the output directory looks like it doesn't have enough free space.")
+	fs.Float64Var(&opts.SizeRatio, "size-ratio", 0, "Estimate converted files to be `RATIO` times the size of their source when checking free space.\nThe default depends on the output format, e.g., 0.35 for m4a.")
+	fs.BoolVar(&opts.PreserveSymlinks, "preserve-symlinks", false, "Recreate symlinks to files and directories within the input directory as relative symlinks to their\nexported targets, rather than exporting the same files twice. Other symlinks are exported as usual.")
+	followHelp := strings.Join([]string{
+		"Export the contents of symlinked directories as if they were copies. Links that would loop forever are skipped.",
+		"Without this, symlinked directories are skipped, and logged with -v. Links kept by -preserve-symlinks aren't followed.",
+	}, "\n")
+	fs.BoolVar(&opts.FollowSymlinks, "follow-symlinks", false, followHelp)
+	collectHelp := strings.Join([]string{
+		"Also write every .m3u and .m3u8 playlist into `DIR`, relative to the output directory, e.g., Playlists.",
+		"Entries are rewritten to point at the exported files, and playlists with the same name are prefixed with their directory.",
+	}, "\n")
+	fs.StringVar(&opts.CollectPlaylists, "collect-playlists", "", collectHelp)
+	sidecarHelp := strings.Join([]string{
+		"Use the best image in each directory, e.g., cover.jpg or folder.jpg, as the cover art of the files converted from it.",
+		"The highest resolution image wins, and directories without one keep the art of each file.",
+	}, "\n")
+	fs.BoolVar(&opts.SidecarArt, "sidecar-art", false, sidecarHelp)
+	fs.BoolVar(&opts.Verify, "verify", false, "Decode each converted file to check it before moving it into place. Bad outputs are removed and counted as failed.")
+	spotCheckHelp := strings.Join([]string{
+		"After exporting, decode `N` randomly chosen conversions to make sure they're intact.",
+		"Larger files are more likely to be chosen. Exits with status 3 if any are bad.",
+	}, "\n")
+	fs.IntVar(&opts.SpotCheck, "spot-check", 0, spotCheckHelp)
+	fs.Int64Var(&opts.SpotCheckSeed, "spot-check-seed", 0, "Seed for choosing -spot-check files, as logged by a previous run. The default is random.")
+	memoryLimitHelp := strings.Join([]string{
+		"Limit the memory each ffmpeg process may use to `BYTES`, so a runaway job fails alone.",
+		"Accepts K, M, and G suffixes, e.g., 2G. Only supported on Linux.",
+	}, "\n")
+	fs.StringVar(&opts.memoryLimit, "rlimit-mem", "", memoryLimitHelp)
+	niceHelp := strings.Join([]string{
+		"Run each conversion at a niceness of `N`, from 1 to 19, so that the rest of the system stays responsive.",
+		"Higher is a lower priority. Not supported on Windows.",
+	}, "\n")
+	fs.IntVar(&opts.Nice, "nice", 0, niceHelp)
+	ioniceHelp := strings.Join([]string{
+		"Run each conversion in the I/O scheduling `CLASS`: idle to only use the disk when nothing else is,",
+		"or best-effort for the usual class. Only supported on Linux.",
+	}, "\n")
+	fs.StringVar(&opts.IONice, "ionice", "", ioniceHelp)
+	bandwidthHelp := strings.Join([]string{
+		"Limit copies to `BYTES` per second in all, e.g., 20M, so that they don't swamp a shared disk or network.",
+		"Accepts K, M, and G suffixes. Files that are cloned rather than copied aren't limited.",
+	}, "\n")
+	fs.StringVar(&opts.bandwidthLimit, "bwlimit", "", bandwidthHelp)
+	stateHelp := strings.Join([]string{
+		"Record each file exported in `FILE`, and skip the files it says were exported when run again with the same FILE.",
+		"Unlike -update, the outputs aren't looked at, which is faster on slow destinations like MTP devices.",
+	}, "\n")
+	fs.StringVar(&opts.StateFile, "state", "", stateHelp)
+	watchHelp := strings.Join([]string{
+		"After exporting, keep running and export files as they're added or changed, until interrupted with Ctrl-C.",
+		"Files are only exported once they've stopped changing for -watch-settle, so that files still being written are left alone.",
+	}, "\n")
+	fs.BoolVar(&opts.Watch, "watch", false, watchHelp)
+	fs.DurationVar(&opts.WatchSettle, "watch-settle", 30*time.Second, "With -watch, how long a file must go unchanged, e.g., 1m, before it's exported.")
+	watchPollHelp := strings.Join([]string{
+		"With -watch, rescan the input directory every `INTERVAL`, e.g., 1m, rather than relying on change notifications.",
+		"Needed for network shares and other file systems that don't report changes.",
+	}, "\n")
+	fs.DurationVar(&opts.WatchPoll, "watch-poll", 0, watchPollHelp)
+	watchSpillHelp := strings.Join([]string{
+		"With -watch, when the queue is full, write the files waiting for room to a temporary file in `DIR` rather than holding them in memory.",
+		"Useful when thousands of files show up at once, e.g., from a bulk copy. The file is removed on exit.",
+	}, "\n")
+	fs.StringVar(&opts.WatchSpill, "watch-spill", "", watchSpillHelp)
+	ffmpegLogHelp := strings.Join([]string{
+		"How much of ffmpeg's output to log for each conversion: none, errors, or full.",
+		"With errors, only failed conversions have their output logged. The default is full, or errors with -quiet.",
+	}, "\n")
+	fs.StringVar(&opts.FFmpegLog, "ffmpeg-log", FFmpegLogFull, ffmpegLogHelp)
+	errorLogsHelp := strings.Join([]string{
+		"Write the output of each failed conversion to its own file under `DIR`, named after the output file plus .ffmpeg.log.",
+		"The file includes the ffmpeg command line, and is removed once the file converts successfully.",
+	}, "\n")
+	fs.StringVar(&opts.ErrorLogs, "error-logs", "", errorLogsHelp)
+	fs.StringVar(&opts.StatsFile, "stats", "", "Write statistics about the export to `FILE` as JSON.")
+	manifestHelp := strings.Join([]string{
+		"Write a record of each file converted, copied, skipped, or failed to `FILE`: its source and output paths, sizes, and how long it took.",
+		"A FILE ending in .csv is written as CSV, anything else as a JSON object per line. Records are written as they happen.",
+	}, "\n")
+	fs.StringVar(&opts.Manifest, "manifest", "", manifestHelp)
+	skipTrashHelp := strings.Join([]string{
+		"Also skip the trash in the comma separated `LIST`, like Thumbs.db, desktop.ini, or .DS_Store.",
+		"Names ending with * are prefixes, like ._*, and names ending with / are directories, like @eaDir/.",
+	}, "\n")
+	fs.StringVar(&opts.skipTrash, "skip-trash", "", skipTrashHelp)
+	fs.BoolVar(&opts.NoSkipTrash, "no-skip-trash", false, "Don't skip the default trash, like .DS_Store, Thumbs.db, and @eaDir directories.\nOnly the -skip-trash LIST is skipped.")
+	fs.BoolVar(&opts.Diff, "diff", false, "Report what would change if the export were run, without modifying anything.")
+	fs.BoolVar(&opts.JSON, "json", false, "Print the -diff report as JSON.")
 }
 
 func (opts *ExporterOptions) Parse(args []string) error {
 	if opts.Err = opts.parse(args); opts.Err != nil {
-		return nil
+		return opts.Err
+	}
+	opts.markExplicit()
+	opts.InRoot = opts.fs.Arg(0)
+	opts.OutRoot = opts.fs.Arg(1)
+	if opts.Err = opts.loadTreeConfig(opts.InRoot); opts.Err != nil {
+		return opts.Err
 	}
 	if opts.noCopyUnknown {
 		opts.CopyUnknown = false
 	}
-	opts.InRoot = opts.fs.Arg(0)
-	opts.OutRoot = opts.fs.Arg(1)
 
 	return nil
 }
 
 func (opts *ExporterOptions) Validate() error {
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
+	// Every file in a tree starting at the same time makes no sense.
+	if opts.start != "" || opts.duration != "" {
+		return fmt.Errorf("-start and -duration are not supported when exporting a tree")
+	}
 	opts.Format = strings.ToLower(opts.Format)
-	switch opts.Format {
-	case "flac", "m4a", "m4r", "mp3":
-	default:
+	if FormatNames != nil && !slices.Contains(FormatNames(), opts.Format) {
 		return fmt.Errorf("unsupported format: %q", opts.Format)
 	}
+	switch opts.LossyPolicy {
+	case LossyConvert, LossyCopy, LossySkip:
+	default:
+		return fmt.Errorf("unsupported -lossy-policy: %q", opts.LossyPolicy)
+	}
+	if opts.Quiet && !opts.isSet("ffmpeg-log") {
+		opts.FFmpegLog = FFmpegLogErrors
+	}
+	switch opts.FFmpegLog {
+	case FFmpegLogNone, FFmpegLogErrors, FFmpegLogFull:
+	default:
+		return fmt.Errorf("unsupported -ffmpeg-log: %q", opts.FFmpegLog)
+	}
+	switch opts.PreserveTime {
+	case PreserveNone, PreserveCopies, PreserveAll:
+	default:
+		return fmt.Errorf("unsupported -preserve-times: %q", opts.PreserveTime)
+	}
+	if opts.layout != "" {
+		var err error
+		if opts.Layout, err = layout.Parse(opts.layout); err != nil {
+			return fmt.Errorf("bad -layout: %w", err)
+		} else if len(opts.Only) > 0 {
+			return fmt.Errorf("-layout cannot be combined with -only")
+		} else if opts.PreserveSymlinks {
+			return fmt.Errorf("-layout cannot be combined with -preserve-symlinks")
+		}
+	}
+	if opts.JSON && !opts.Diff {
+		return fmt.Errorf("-json requires -diff")
+	}
+	if opts.StatusInterval < 0 {
+		return fmt.Errorf("-status-interval cannot be negative")
+	}
+	if opts.Timeout < 0 {
+		return fmt.Errorf("-timeout cannot be negative")
+	}
+	if opts.Watch && opts.Diff {
+		return fmt.Errorf("-watch cannot be used with -diff")
+	}
+	if opts.WatchSettle < 0 {
+		return fmt.Errorf("-watch-settle cannot be negative")
+	}
+	if opts.WatchPoll < 0 {
+		return fmt.Errorf("-watch-poll cannot be negative")
+	}
+	if opts.WatchSpill != "" {
+		dir, err := expandHome(opts.WatchSpill)
+		if err != nil {
+			return fmt.Errorf("-watch-spill: %w", err)
+		} else if fi, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("-watch-spill: %w", err)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("-watch-spill: %q is not a directory", dir)
+		}
+		opts.WatchSpill = dir
+	}
+	if opts.ErrorLogs != "" {
+		dir, err := expandHome(opts.ErrorLogs)
+		if err != nil {
+			return fmt.Errorf("-error-logs: %w", err)
+		} else if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+			return fmt.Errorf("-error-logs: %q is not a directory", dir)
+		}
+		opts.ErrorLogs = dir
+	}
+	var err error
+	if opts.MaxJobs, err = opts.maxJobs.jobs("-j", runtime.NumCPU()); err != nil {
+		return err
+	}
+	if opts.MaxJobs > 1 && !opts.isSet("ffmpeg-threads") {
+		// An ffmpeg per job, each with a thread per core, would oversubscribe
+		// the CPUs many times over.
+		opts.Threads = 1
+	}
+	if opts.isSet("q") {
+		if err := validateJobs("-q", opts.MaxQueue); err != nil {
+			return err
+		}
+	}
+	if opts.IOJobs < 1 {
+		return fmt.Errorf("-io-jobs must be at least 1")
+	}
+	if opts.SizeRatio < 0 {
+		return fmt.Errorf("-size-ratio cannot be negative")
+	}
+	if opts.SpotCheck < 0 {
+		return fmt.Errorf("-spot-check cannot be negative")
+	}
+	if opts.StateFile != "" {
+		name, err := expandHome(opts.StateFile)
+		if err != nil {
+			return fmt.Errorf("-state: %w", err)
+		} else if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+			return fmt.Errorf("-state: %q is a directory", name)
+		}
+		opts.StateFile = name
+	}
+	if opts.Manifest != "" {
+		name, err := expandHome(opts.Manifest)
+		if err != nil {
+			return fmt.Errorf("-manifest: %w", err)
+		} else if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+			return fmt.Errorf("-manifest: %q is a directory", name)
+		}
+		opts.Manifest = name
+	}
+	if opts.CollectPlaylists != "" {
+		if !filepath.IsLocal(opts.CollectPlaylists) || filepath.Clean(opts.CollectPlaylists) == "." {
+			return fmt.Errorf("-collect-playlists must be a directory within the output directory: %q", opts.CollectPlaylists)
+		}
+		opts.CollectPlaylists = filepath.ToSlash(filepath.Clean(opts.CollectPlaylists))
+	}
+	if opts.memoryLimit != "" {
+		n, err := parseByteSize(opts.memoryLimit)
+		if err != nil {
+			return fmt.Errorf("-rlimit-mem: %w", err)
+		}
+		opts.MemoryLimit = n
+	}
+	if opts.Nice < 0 || opts.Nice > proc.MaxNice {
+		return fmt.Errorf("-nice must be between 0 and %d", proc.MaxNice)
+	}
+	switch opts.IONice {
+	case "", proc.IOClassIdle, proc.IOClassBestEffort:
+	default:
+		return fmt.Errorf("-ionice must be %s or %s: %q", proc.IOClassIdle, proc.IOClassBestEffort, opts.IONice)
+	}
+	if opts.bandwidthLimit != "" {
+		n, err := parseByteSize(opts.bandwidthLimit)
+		if err != nil {
+			return fmt.Errorf("-bwlimit: %w", err)
+		}
+		opts.BandwidthLimit = n
+	}
+	for _, pattern := range opts.Excludes {
+		if err := filesystem.ValidateGlob(pattern); err != nil {
+			return fmt.Errorf("-exclude: %w", err)
+		}
+	}
+	for _, pattern := range opts.Includes {
+		if err := filesystem.ValidateGlob(pattern); err != nil {
+			return fmt.Errorf("-include: %w", err)
+		}
+	}
+	if err := validateExtensions("-media-ext", opts.MediaExts); err != nil {
+		return err
+	}
+	if err := validateExtensions("-ignore-ext", opts.IgnoreExts); err != nil {
+		return err
+	}
+	for _, ext := range opts.MediaExts {
+		if slices.Contains(opts.IgnoreExts, ext) {
+			return fmt.Errorf("%q cannot be given to both -media-ext and -ignore-ext", ext)
+		}
+	}
+	if err := validateMaxBytes(opts.MaxNameBytes, opts.MaxPathBytes); err != nil {
+		return err
+	}
+	if err := validateNormalizeNames(opts.NormalizeNames); err != nil {
+		return err
+	}
+	for pattern := range strings.SplitSeq(opts.skipTrash, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		} else if err := filesystem.ValidateTrashPattern(pattern); err != nil {
+			return fmt.Errorf("-skip-trash: %w", err)
+		}
+		opts.SkipTrash = append(opts.SkipTrash, pattern)
+	}
 	for _, c := range opts.CleanPaths {
 		for _, s := range filesystem.ReservedCharacters {
 			if strings.ContainsRune(s, c) {
@@ -93,10 +580,20 @@ func (opts *ExporterOptions) Validate() error {
 
 	// Since we embed ConverterOptions, we need to consider its validations that
 	// apply to us. Basically, all of them but the input/output fields.
-	if opts.stereo {
-		opts.Channels = 2
-	} else if opts.mono {
-		opts.Channels = 1
+	if err := opts.validateChannels(); err != nil {
+		return err
+	}
+	if err := opts.validateThreads(); err != nil {
+		return err
+	}
+	if err := opts.validateCodec(); err != nil {
+		return err
+	}
+	if err := opts.validateBitRate(); err != nil {
+		return err
+	}
+	if err := opts.validateSampleRate(); err != nil {
+		return err
 	}
 	if err := ValidateHeightWidth(opts.Scale); err != nil {
 		return err
@@ -106,21 +603,135 @@ func (opts *ExporterOptions) Validate() error {
 		return fmt.Errorf("must specify input directory")
 	} else if _, err := os.Stat(opts.InRoot); err != nil {
 		return fmt.Errorf("input directory: %w", err)
+	} else if len(opts.dests) > 0 {
+		if err := opts.validateDests(); err != nil {
+			return err
+		}
 	} else if opts.OutRoot == "" {
 		return fmt.Errorf("must specify output directory")
 	} else if _, err := os.Stat(opts.OutRoot); err != nil {
 		return fmt.Errorf("out directory: %w", err)
-	} else if opts.InRoot == opts.OutRoot {
-		return fmt.Errorf("cowardly refusing to export %q into itself", opts.InRoot)
-	} else if strings.HasPrefix(opts.OutRoot, opts.InRoot) {
+	} else if err := checkRoots(opts.InRoot, opts.OutRoot); err != nil {
+		return err
+	}
+
+	return opts.validateOnly()
+}
+
+// Makes sure the input directory in and output directory out are separate,
+// neither being within the other. They're compared by where they really are,
+// so relative paths and symlinks can't hide one within the other, but a
+// sibling sharing a prefix, like "music-export" next to "music", is fine.
+func checkRoots(in, out string) error {
+	realIn, err := realPath(in)
+	if err != nil {
+		return fmt.Errorf("input directory: %w", err)
+	}
+	realOut, err := realPath(out)
+	if err != nil {
+		return fmt.Errorf("output directory: %w", err)
+	}
+	if realIn == realOut {
+		return fmt.Errorf("cowardly refusing to export %q into itself", in)
+	} else if within(realIn, realOut) {
 		return fmt.Errorf("output directory cannot be nested within input directory")
+	} else if within(realOut, realIn) {
+		return fmt.Errorf("input directory cannot be nested within output directory")
+	}
+	return nil
+}
+
+// Parses the -dest values into Dests. Each directory is checked like
+// {outdir}, and must be apart from the others, so that no export lands in
+// another.
+func (opts *ExporterOptions) validateDests() error {
+	if opts.fs.NArg() > 1 {
+		return fmt.Errorf("-dest takes the place of {outdir}, so only {indir} may be given: have %d arguments", opts.fs.NArg())
+	} else if opts.Watch {
+		return fmt.Errorf("-dest cannot be combined with -watch")
+	} else if opts.StateFile != "" {
+		return fmt.Errorf("-dest cannot be combined with -state")
+	}
+	var reals []string
+	for _, value := range opts.dests {
+		format, dir, ok := strings.Cut(value, ":")
+		if format = strings.ToLower(format); !ok || format == "" || dir == "" {
+			return fmt.Errorf("-dest must be FORMAT:DIR, e.g., mp3:/mnt/sd: %q", value)
+		} else if FormatNames != nil && !slices.Contains(FormatNames(), format) {
+			return fmt.Errorf("-dest %q: unsupported format: %q", value, format)
+		}
+		dir, err := expandHome(dir)
+		if err != nil {
+			return fmt.Errorf("-dest %q: %w", value, err)
+		} else if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("-dest %q: %w", value, err)
+		} else if err := checkRoots(opts.InRoot, dir); err != nil {
+			return fmt.Errorf("-dest %q: %w", value, err)
+		}
+		real, err := realPath(dir)
+		if err != nil {
+			return fmt.Errorf("-dest %q: %w", value, err)
+		}
+		for i, other := range reals {
+			if real == other || within(real, other) || within(other, real) {
+				return fmt.Errorf("-dest %q and %q must be separate directories", opts.dests[i], value)
+			}
+		}
+		reals = append(reals, real)
+		opts.Dests = append(opts.Dests, Destination{Format: format, OutRoot: dir})
 	}
+	return nil
+}
 
+// Returns the absolute path of name, with any symlinks resolved.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// Returns true if path is within dir. Both must be clean, absolute paths.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// Normalizes the -only paths to slash separated paths relative to InRoot and
+// makes sure they're directories. Paths within another -only path are dropped,
+// so that nothing is visited twice.
+func (opts *ExporterOptions) validateOnly() error {
+	var only []string
+	for _, dir := range opts.Only {
+		dir = filepath.ToSlash(filepath.Clean(dir))
+		if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return fmt.Errorf("-only %q must be relative to the input directory", dir)
+		}
+		if st, err := os.Stat(filepath.Join(opts.InRoot, dir)); err != nil {
+			return fmt.Errorf("-only: %w", err)
+		} else if !st.IsDir() {
+			return fmt.Errorf("-only %q is not a directory", dir)
+		}
+		only = append(only, dir)
+	}
+	// Sorting puts parents before the paths within them.
+	slices.Sort(only)
+	opts.Only = nil
+	for _, dir := range only {
+		nested := slices.ContainsFunc(opts.Only, func(parent string) bool {
+			return parent == "." || dir == parent || strings.HasPrefix(dir, parent+"/")
+		})
+		if !nested {
+			opts.Only = append(opts.Only, dir)
+		}
+	}
 	return nil
 }
 
 func (opts *ExporterOptions) Usage() {
-	opts.printf("usage: %s [options] {indir} {outdir}\n\n", opts.fs.Name())
+	opts.printf("usage: %s [options] {indir} {outdir}\n", opts.fs.Name())
+	opts.printf("       %s [options] -dest FORMAT:DIR [-dest FORMAT:DIR ...] {indir}\n\n", opts.fs.Name())
 
 	opts.printf("Given a tree of source files %q, export them to the output folder\n", "{inroot}")
 	opts.printf("{outdir} retaining the same structure. For example if the %q is like\n", "{inroot}")
@@ -133,3 +744,79 @@ func (opts *ExporterOptions) Usage() {
 
 	opts.fs.PrintDefaults()
 }
+
+// Parses a positive number of bytes with an optional K, M, or G suffix. The
+// suffixes are powers of 1024, e.g., 2G is 2147483648.
+func parseByteSize(value string) (int64, error) {
+	shift := 0
+	switch strings.ToUpper(value[len(value)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	digits := value
+	if shift > 0 {
+		digits = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	} else if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("size %q is too large", value)
+	}
+	return n << shift, nil
+}
+
+// Returns an error unless the limits for -max-name-bytes and -max-path-bytes
+// are 0 or long enough to hold a shortened name.
+func validateMaxBytes(name, path int) error {
+	if name != 0 && name < minMaxBytes {
+		return fmt.Errorf("-max-name-bytes must be 0 or at least %d", minMaxBytes)
+	} else if path != 0 && path < minMaxBytes {
+		return fmt.Errorf("-max-path-bytes must be 0 or at least %d", minMaxBytes)
+	}
+	return nil
+}
+
+// Returns an error unless form is a valid -normalize-names.
+func validateNormalizeNames(form string) error {
+	switch form {
+	case filesystem.NormalizeNone, filesystem.NormalizeNFC, filesystem.NormalizeNFD:
+		return nil
+	}
+	return fmt.Errorf("unsupported -normalize-names: %q", form)
+}
+
+// The shortest -max-name-bytes or -max-path-bytes, which fits a few bytes of
+// the name along with the hash and extension added when shortening it.
+const minMaxBytes = 32
+
+// Returns an error unless each of exts, given to flag, is a file extension
+// starting with a dot, e.g., .ape. They're lowercased in place.
+func validateExtensions(flag string, exts []string) error {
+	for i, ext := range exts {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext[1:], `./\`) {
+			return fmt.Errorf("%s must be an extension starting with a dot, e.g., .ape: %q", flag, ext)
+		}
+		exts[i] = strings.ToLower(ext)
+	}
+	return nil
+}
+
+// A flag.Value that collects the value of each use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
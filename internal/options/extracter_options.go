@@ -3,50 +3,95 @@
 package options
 
 import (
+	"flag"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"strings"
 )
 
+// The ffmpeg codecs of the image formats that -format takes, which is what
+// ffmpeg would pick from their extensions.
+var imageCodecs = map[string]string{
+	"bmp":  "bmp",
+	"gif":  "gif",
+	"jpeg": "mjpeg",
+	"jpg":  "mjpeg",
+	"png":  "png",
+	"tiff": "tiff",
+	"webp": "libwebp",
+}
+
+// How cover art images are converted, shared by extract_coverart and
+// embed_coverart.
+type ImageOptions struct {
+	Codec string
+	Scale string
+}
+
+// Adds -c, with codecHelp as its usage, and -s and -scale to fs.
+func (opts *ImageOptions) addImageOptions(fs *flag.FlagSet, codecHelp string) {
+	fs.StringVar(&opts.Codec, "c", "", codecHelp)
+	fs.StringVar(&opts.Scale, "s", "", "Alias for -scale `SCALE`")
+	fs.StringVar(&opts.Scale, "scale", "", "Scale image to `SCALE`. Format is HEIGHTxWIDTH. E.g., \"500x500\"")
+}
+
 type ExtracterOptions struct {
 	GlobalOptions
+	ImageOptions
 	InputFile  string
 	OutputFile string
-	Codec      string
-	Scale      string
+	PipeFormat string // The image format to write when OutputFile is "-".
+	CoverName  string
+	MaxJobs    int
+	Recursive  bool
+	PerTrack   bool
+	perAlbum   bool
+	maxJobs    jobsFlag
 }
 
-func NewExtracterOptions(args []string) *ExtracterOptions {
+func NewExtracterOptions(args []string) (*ExtracterOptions, Outcome) {
 	opts := &ExtracterOptions{}
 	opts.AddOptions(args)
-	defer opts.onError() // handle printing if opts.Err != nil
-	if opts.Err = opts.Parse(args[1:]); opts.Err != nil {
-		return nil
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
 	}
-	if opts.Err = opts.Validate(); opts.Err != nil {
-		return nil
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
 	}
-	return opts
+	return opts, OutcomeRun
 }
 
 func (opts *ExtracterOptions) Usage() {
 	opts.printf("%s [options] {input} {output}\n", opts.fs.Name())
+	opts.printf("%s [options] -R {input directory} {output directory}\n", opts.fs.Name())
 	opts.printf("\nExtracts cover art from {input} into {output} using ffmpeg.\n")
 	opts.printf("The format is detected based on the file extension of {output} unless the codec is specified.\n")
+	opts.printf("If {output} is -, the image is written to stdout, which requires -c or -format.\n")
+	opts.printf("With -R, the art of every album under {input directory} is extracted into the same layout under {output directory}.\n")
 	opts.printf("For best compatibility, consider scaling to 500x500 as a jpg.\n\n")
 	opts.fs.PrintDefaults()
 }
 
 func (opts *ExtracterOptions) AddOptions(args []string) {
 	fs := AddGlobalOptions(args, &opts.GlobalOptions)
-	fs.StringVar(&opts.Codec, "c", "", "Override the ffmpeg codec rather than based on {output}.")
-	fs.StringVar(&opts.Scale, "s", "", "Alias for -scale `SCALE`")
-	fs.StringVar(&opts.Scale, "scale", "", "Scale image to `SCALE`. Format is HEIGHTxWIDTH. E.g., \"500x500\"")
+	opts.addImageOptions(fs, "Override the ffmpeg codec rather than based on {output}.")
+	fs.StringVar(&opts.PipeFormat, "format", "", fmt.Sprintf("Write the image as `IMAGE_FORMAT` when {output} is -, since there's no extension to go by.\nOne of %s.", strings.Join(imageFormats(), ", ")))
+	fs.BoolVar(&opts.Recursive, "R", false, "Extract the art of every album in the {input} directory and its subdirectories.\nAlbums that already have a cover are skipped unless -y is given.")
+	fs.BoolVar(&opts.perAlbum, "per-album", false, "With -R, write one -cover-name per directory, from the first track with art. (default)")
+	fs.BoolVar(&opts.PerTrack, "per-track", false, "With -R, write the art of each track, named after the track.")
+	fs.StringVar(&opts.CoverName, "cover-name", "cover.jpg", "With -R, name each album's cover `NAME`. Its extension sets the format of -per-track covers too.")
+	fs.Var(&opts.maxJobs, "j", "With -R, sets the maximum number of concurrent `JOBS`, or a percentage of the CPUs, e.g., 50%.")
 	fs.Usage = opts.Usage
 }
 
 func (opts *ExtracterOptions) Parse(args []string) error {
 	if opts.Err = opts.parse(args); opts.Err != nil {
-		return nil
+		return opts.Err
 	}
 	opts.InputFile = opts.fs.Arg(0)
 	opts.OutputFile = opts.fs.Arg(1)
@@ -54,15 +99,83 @@ func (opts *ExtracterOptions) Parse(args []string) error {
 }
 
 func (opts *ExtracterOptions) Validate() error {
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
 	if err := ValidateFileArgs(opts.InputFile, opts.OutputFile); err != nil {
 		return err
 	}
 	if err := ValidateHeightWidth(opts.Scale); err != nil {
 		return err
 	}
+	if err := opts.validatePipe(); err != nil {
+		return err
+	}
+	return opts.validateRecursive()
+}
+
+// Checks writing to stdout, which leaves no extension for ffmpeg to pick the
+// image format by. The codec of -format is used unless -c is given.
+func (opts *ExtracterOptions) validatePipe() error {
+	if opts.OutputFile != "-" {
+		if opts.PipeFormat != "" {
+			return fmt.Errorf("-format requires {output} to be -")
+		}
+		return nil
+	} else if opts.Recursive {
+		return fmt.Errorf("-R cannot write to stdout")
+	}
+	if opts.PipeFormat != "" {
+		codec, ok := imageCodecs[strings.ToLower(opts.PipeFormat)]
+		if !ok {
+			return fmt.Errorf("unsupported -format: %q", opts.PipeFormat)
+		}
+		if opts.Codec == "" {
+			opts.Codec = codec
+		}
+	}
+	if opts.Codec == "" {
+		return fmt.Errorf("-c or -format is required to write stdout")
+	}
+	return nil
+}
+
+// Checks the options that only make sense with -R, and that {input} is a
+// directory when it's given.
+func (opts *ExtracterOptions) validateRecursive() error {
+	if !opts.Recursive {
+		for _, name := range []string{"per-album", "per-track", "cover-name", "j"} {
+			if opts.isSet(name) {
+				return fmt.Errorf("-%s requires -R", name)
+			}
+		}
+		return nil
+	}
+	if opts.perAlbum && opts.PerTrack {
+		return fmt.Errorf("-per-album and -per-track are mutually exclusive")
+	} else if opts.CoverName != filepath.Base(opts.CoverName) || filepath.Ext(opts.CoverName) == "" {
+		return fmt.Errorf("bad -cover-name %q: must be a file name with an extension", opts.CoverName)
+	}
+	var err error
+	if opts.MaxJobs, err = opts.maxJobs.jobs("-j", runtime.NumCPU()); err != nil {
+		return err
+	}
+	if st, err := os.Stat(opts.InputFile); err != nil {
+		return fmt.Errorf("input directory: %w", err)
+	} else if !st.IsDir() {
+		return fmt.Errorf("input %q must be a directory with -R", opts.InputFile)
+	}
 	return nil
 }
 
+// Returns the image formats that -format takes.
+func imageFormats() []string {
+	return slices.Sorted(maps.Keys(imageCodecs))
+}
+
 func ValidateHeightWidth(value string) error {
 	if matched, err := regexp.MatchString("[[:digit:]]+x[[:digit:]]+", value); err != nil {
 		return err
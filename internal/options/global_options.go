@@ -6,28 +6,105 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 )
 
+// Environment variable naming the ffmpeg to run when -ffmpeg isn't given.
+const FFmpegEnv = "AUDIO_CONVERTER_FFMPEG"
+
+// Choices for -log-format.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// What became of parsing the command line, returned by the constructors along
+// with the options, for the program to act on.
+type Outcome int
+
+const (
+	// The options are good, so the program should run.
+	OutcomeRun Outcome = iota
+	// There's nothing left to do, e.g., after -version. Exit 0.
+	OutcomeExit
+	// The flags couldn't be parsed, or -help was given. The usage was
+	// printed to stderr. Exit 2.
+	OutcomeUsage
+	// The flags didn't validate. The error and usage were printed to stderr.
+	// Exit 1.
+	OutcomeInvalid
+)
+
+// Returns the status the program should exit with for o. OutcomeRun is 0,
+// though it shouldn't exit at all.
+func (o Outcome) ExitCode() int {
+	switch o {
+	case OutcomeUsage:
+		return 2
+	case OutcomeInvalid:
+		return 1
+	}
+	return 0
+}
+
+// An error from parsing the flags, which the flag set has already printed
+// along with the usage.
+type flagError struct {
+	err error
+}
+
+func (e *flagError) Error() string { return e.err.Error() }
+func (e *flagError) Unwrap() error { return e.err }
+
 // Options that are common to every single tool.
 type GlobalOptions struct {
 	fs           *flag.FlagSet
 	Err          error
 	LogFile      string
+	LogLevel     slog.Level
+	LogFormat    string
+	LogAppend    bool
+	LogMaxSize   int64 // Bytes the log file may grow to before it's rotated, or 0 for no limit.
+	LogKeep      int
+	logMaxSize   string
 	NoClobber    bool
 	Overwrite    bool
 	Verbose      bool
+	Quiet        bool
 	PrintVersion bool
+	NoExecHooks  bool
+	FFmpeg       string
+	Config       string
+	dirArgs      bool // The arguments are directories, for -completion.
 }
 
 // Populates opts with a new flag set and the global options. Returns opts.fs.
 func AddGlobalOptions(args []string, opts *GlobalOptions) *flag.FlagSet {
 	fs := flag.NewFlagSet(filepath.Base(args[0]), flag.ContinueOnError)
 	fs.BoolVar(&opts.PrintVersion, "version", false, "Print version and exit")
-	fs.StringVar(&opts.LogFile, "log-file", "", "Log to a file.")
+	fs.StringVar(&opts.LogFile, "log-file", "", "Log to `FILE`, or - for stdout.")
+	fs.TextVar(&opts.LogLevel, "log-level", slog.LevelInfo, "Only log messages at `LEVEL` or above: debug, info, warn, or error.\nThe default is debug with -v.")
+	fs.StringVar(&opts.LogFormat, "log-format", LogFormatText, "Write the log as `FORMAT`: text, or json for a JSON object per line.\nWith json, the log also has an event for each file started and finished, and the summary.")
+	fs.BoolVar(&opts.LogAppend, "log-append", false, "Add to the end of the log file rather than replacing it.")
+	fs.StringVar(&opts.logMaxSize, "log-max-size", "", "Rotate the log file once it reaches `SIZE` bytes, e.g., 50M: FILE becomes FILE.1, and so on.\nSizes may use a K, M, or G suffix.")
+	fs.IntVar(&opts.LogKeep, "log-keep", 5, "Keep up to `N` old log files when rotating them for -log-max-size.")
 	fs.BoolVar(&opts.NoClobber, "n", false, "Set the no clobber flag: don't overwrite files.")
 	fs.BoolVar(&opts.Overwrite, "y", false, "Overwrite files without prompting.")
-	fs.BoolVar(&opts.Verbose, "v", false, "Set verbose mode.")
+	fs.BoolVar(&opts.Verbose, "v", false, "Set verbose mode: also show the log on stdout.")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "Only show ffmpeg's output when it fails.\nexport_audio_tree also defaults -ffmpeg-log to errors, and doesn't show its progress.")
+	// Running as root, a hook from a config file could do anything, so it has
+	// to be asked for. Geteuid is -1 on Windows.
+	fs.BoolVar(&opts.NoExecHooks, "no-exec-hooks", os.Geteuid() == 0, "Log user supplied hook commands instead of running them. FFmpeg still runs.\nThe default is true when running as root.")
+	ffmpeg := os.Getenv(FFmpegEnv)
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	fs.StringVar(&opts.FFmpeg, "ffmpeg", ffmpeg, "Run the ffmpeg at `PATH`, e.g., when the one in $PATH lacks an encoder. ffprobe is run from the same directory.\nThe default comes from $"+FFmpegEnv+" when set.")
+	fs.StringVar(&opts.Config, "config", "", "Read default flag values from `FILE`, instead of audio_converter/config in the user's config directory.\nE.g., ~/.config/audio_converter/config. export_audio_tree also reads "+TreeConfigFile+" in the input directory, unless -config is given.")
 	opts.fs = fs
 	return opts.fs
 }
@@ -39,15 +116,152 @@ func (opts *GlobalOptions) parse(args []string) error {
 	if opts.fs == nil {
 		panic("No flag set")
 	}
+	// Hidden from the usage, since it's only of use to set up a shell.
+	if done, err := opts.completion(args); err != nil {
+		return err
+	} else if done {
+		return ErrCompletion
+	}
+	if err := opts.loadConfig(args); err != nil {
+		return err
+	}
 	// Usage gets called automatically by the opts.fs.Parse after printing the
 	// error, or if the error is flag.ErrHelp.
-	err := opts.fs.Parse(args)
-
+	if err := opts.fs.Parse(args); err != nil {
+		return &flagError{err}
+	}
 	if opts.PrintVersion {
-		err = fmt.Errorf("%s version %s", opts.fs.Name(), Version)
+		writeVersion(os.Stdout, opts.fs.Name())
+		return ErrVersionRequested
+	}
+	if opts.Verbose && !opts.isSet("log-level") {
+		opts.LogLevel = slog.LevelDebug
+	}
+	switch opts.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return fmt.Errorf("unsupported -log-format: %q", opts.LogFormat)
+	}
+	if opts.logMaxSize != "" {
+		n, err := parseByteSize(opts.logMaxSize)
+		if err != nil {
+			return fmt.Errorf("-log-max-size: %w", err)
+		}
+		opts.LogMaxSize = n
+	}
+	if opts.LogKeep < 0 {
+		return fmt.Errorf("-log-keep must be 0 or more")
+	}
+	return nil
+}
+
+// Returns the value of the named flag in args, for the few that have to be
+// found before the rest are parsed, like -config. Stops where parsing would,
+// and skips over the values of other flags.
+func (opts *GlobalOptions) findArg(args []string, name string) (string, bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || arg == "-" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		key, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if key == name {
+			if hasValue {
+				return value, true
+			} else if i+1 < len(args) {
+				return args[i+1], true
+			}
+			return "", false
+		}
+		if f := opts.fs.Lookup(key); f != nil && !hasValue && !isBoolFlag(f) {
+			i++
+		}
+	}
+	return "", false
+}
+
+// Returns true if f is a boolean flag, which doesn't take a separate value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Returns true if the named flag was given on the command line, as opposed to
+// having its default value.
+func (opts *GlobalOptions) isSet(name string) bool {
+	set := false
+	opts.fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Expands a leading "~" in LogFile and checks that the file can be created,
+// so a bad path is reported along with the flag rather than when logging
+// starts. "-" for stdout is left alone.
+func (opts *GlobalOptions) validateLogFile() error {
+	if opts.LogFile == "" || opts.LogFile == "-" {
+		return nil
+	}
+	name, err := expandHome(opts.LogFile)
+	if err != nil {
+		return fmt.Errorf("-log-file: %w", err)
+	}
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		return fmt.Errorf("-log-file: %q is a directory", name)
+	}
+	dir := filepath.Dir(name)
+	if fi, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("-log-file: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("-log-file: %q is not a directory", dir)
+	}
+	opts.LogFile = name
+	return nil
+}
+
+// Expands a leading "~" in FFmpeg. Whether it can actually be run is left to
+// the ffmpeg package, since that means running it.
+func (opts *GlobalOptions) validateFFmpeg() error {
+	if opts.FFmpeg == "" {
+		return fmt.Errorf("-ffmpeg cannot be empty")
+	}
+	name, err := expandHome(opts.FFmpeg)
+	if err != nil {
+		return fmt.Errorf("-ffmpeg: %w", err)
+	}
+	opts.FFmpeg = name
+	return nil
+}
+
+// Returns the ffprobe to run along with FFmpeg: the one next to it when
+// FFmpeg is a path, otherwise the one in $PATH.
+func (opts *GlobalOptions) FFprobe() string {
+	dir, base := filepath.Split(opts.FFmpeg)
+	if dir == "" {
+		return "ffprobe"
+	}
+	name := "ffprobe"
+	if runtime.GOOS == "windows" && strings.EqualFold(filepath.Ext(base), ".exe") {
+		name += filepath.Ext(base)
 	}
+	return filepath.Join(dir, name)
+}
 
-	return err
+// Replaces a leading "~" in name with the user's home directory. Other
+// users' homes, e.g., "~bob", are not supported.
+func expandHome(name string) (string, error) {
+	rest, ok := strings.CutPrefix(name, "~")
+	if !ok || (rest != "" && !os.IsPathSeparator(rest[0])) {
+		return name, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + rest, nil
 }
 
 func (opts *GlobalOptions) printf(format string, a ...any) {
@@ -58,15 +272,24 @@ func (opts *GlobalOptions) println(a ...any) {
 	fmt.Fprintln(opts.fs.Output(), a...)
 }
 
-// Prints opts.Err if it is not nil, followed by usage. You can just defer this
-// in constructors and then return nil on error, provided you make sure opts.Err
-// is set on error :).
-func (opts *GlobalOptions) onError() {
-	// If ErrHelp, opts.fs already took care of this.
-	if opts.Err != nil && !errors.Is(opts.Err, flag.ErrHelp) {
-		fmt.Fprintln(opts.fs.Output(), opts.Err)
-		opts.fs.Usage()
+// Returns the Outcome of opts.Err, printing it and the usage if the flag set
+// hasn't already. Constructors call this once parsing and validation are done,
+// and return nil unless it's OutcomeRun.
+func (opts *GlobalOptions) finish() Outcome {
+	var ferr *flagError
+	switch {
+	case opts.Err == nil:
+		return OutcomeRun
+	case errors.Is(opts.Err, ErrVersionRequested), errors.Is(opts.Err, ErrCompletion):
+		// There's nothing wrong, and nothing left to do.
+		return OutcomeExit
+	case errors.As(opts.Err, &ferr):
+		// opts.fs already took care of printing, including for flag.ErrHelp.
+		return OutcomeUsage
 	}
+	fmt.Fprintln(opts.fs.Output(), opts.Err)
+	opts.fs.Usage()
+	return OutcomeInvalid
 }
 
 func ValidateFileArgs(input string, output string) error {
@@ -74,7 +297,7 @@ func ValidateFileArgs(input string, output string) error {
 		return fmt.Errorf("must specify input file")
 	} else if output == "" {
 		return fmt.Errorf("must specify output file")
-	} else if input == output {
+	} else if input == output && input != "-" {
 		return fmt.Errorf("cowardly refusing to output the input %q to itself", input)
 	}
 	return nil
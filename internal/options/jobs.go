@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The most jobs -j, or queued tasks -q, may ask for. Well past anything
+// useful, so that a typo doesn't start thousands of ffmpegs.
+const maxJobs = 4096
+
+// A flag.Value for -j, which takes a number of jobs, or a percentage of the
+// CPUs, e.g., 50%. The percentage is resolved by jobs, when validating.
+type jobsFlag struct {
+	n       int
+	percent bool
+	set     bool
+}
+
+func (j *jobsFlag) String() string {
+	if j == nil {
+		return "0"
+	} else if j.percent {
+		return strconv.Itoa(j.n) + "%"
+	}
+	return strconv.Itoa(j.n)
+}
+
+func (j *jobsFlag) Set(s string) error {
+	text, percent := strings.CutSuffix(s, "%")
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("must be a number of jobs or a percentage of the CPUs, e.g., 4 or 50%%")
+	}
+	j.n, j.percent, j.set = n, percent, true
+	return nil
+}
+
+// Returns the number of jobs asked for, given cpus CPUs. Percentages are
+// rounded down, but always leave at least one job. Returns 0, which is the
+// default of one per CPU, if the flag wasn't given.
+func (j *jobsFlag) jobs(name string, cpus int) (int, error) {
+	if !j.set {
+		return 0, nil
+	} else if j.n <= 0 {
+		return 0, fmt.Errorf("%s must be at least 1, or 1%%: %s", name, j)
+	}
+	n := j.n
+	if j.percent {
+		n = max(n*cpus/100, 1)
+	}
+	if err := validateJobs(name, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Returns an error unless n, given to the flag name, is between 1 and maxJobs.
+func validateJobs(name string, n int) error {
+	if n <= 0 {
+		return fmt.Errorf("%s must be at least 1: %d", name, n)
+	} else if n > maxJobs {
+		return fmt.Errorf("%s cannot be more than %d: %d", name, maxJobs, n)
+	}
+	return nil
+}
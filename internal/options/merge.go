@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"flag"
+	"slices"
+)
+
+// A set of ConverterOptions fields, including those of its GlobalOptions. Used
+// by Merge to tell a field set to its zero value on purpose, like -n=false,
+// from one that was never set.
+type Field uint64
+
+const (
+	FieldLogFile Field = 1 << iota
+	FieldNoClobber
+	FieldOverwrite
+	FieldVerbose
+	FieldQuiet
+	FieldNoExecHooks
+	FieldFFmpeg
+	FieldInputFile
+	FieldOutputFile
+	FieldArtFile
+	FieldBitRate
+	FieldCodec
+	FieldCoverArtFormat
+	FieldScale
+	FieldInputExtensions
+	FieldOutputExtensions
+	FieldChannels
+	FieldSampleRate
+	FieldMemoryLimit
+	FieldTargetSize
+	FieldArtFallback
+	FieldAtomic
+	FieldPipeFormat
+	FieldStart
+	FieldDuration
+	FieldFadeIn
+	FieldFadeOut
+	FieldGapless
+	FieldMetadata
+	FieldLogLevel
+	FieldLogFormat
+	FieldLogAppend
+	FieldLogMaxSize
+	FieldLogKeep
+	FieldThreads
+	FieldHWAccel
+	FieldNice
+	FieldIONice
+)
+
+// The fields set by each flag, for marking those given on the command line as
+// Explicit.
+var flagFields = map[string]Field{
+	"log-file":       FieldLogFile,
+	"log-level":      FieldLogLevel,
+	"log-format":     FieldLogFormat,
+	"log-append":     FieldLogAppend,
+	"log-max-size":   FieldLogMaxSize,
+	"log-keep":       FieldLogKeep,
+	"n":              FieldNoClobber,
+	"y":              FieldOverwrite,
+	"v":              FieldVerbose,
+	"quiet":          FieldQuiet,
+	"no-exec-hooks":  FieldNoExecHooks,
+	"ffmpeg":         FieldFFmpeg,
+	"b":              FieldBitRate,
+	"c":              FieldCodec,
+	"cover":          FieldCoverArtFormat,
+	"scale":          FieldScale,
+	"s":              FieldChannels,
+	"m":              FieldChannels,
+	"channels":       FieldChannels,
+	"r":              FieldSampleRate,
+	"rlimit-mem":     FieldMemoryLimit,
+	"target-size":    FieldTargetSize,
+	"art-fallback":   FieldArtFallback,
+	"no-atomic":      FieldAtomic,
+	"format":         FieldPipeFormat,
+	"start":          FieldStart,
+	"duration":       FieldDuration,
+	"fade-in":        FieldFadeIn,
+	"fade-out":       FieldFadeOut,
+	"gapless":        FieldGapless,
+	"ffmpeg-threads": FieldThreads,
+	"hwaccel":        FieldHWAccel,
+	"nice":           FieldNice,
+	"ionice":         FieldIONice,
+}
+
+// Marks the fields of the flags given on the command line as Explicit, so that
+// Merge keeps them even when they're zero.
+func (opts *ConverterOptions) markExplicit() {
+	opts.fs.Visit(func(f *flag.Flag) {
+		opts.Explicit |= flagFields[f.Name]
+	})
+}
+
+// Merges options from source into opts. A field is copied when source has it,
+// by being non-zero or Explicit, and opts doesn't. Slices are copied rather
+// than shared, so changing one doesn't change the other. Of the GlobalOptions,
+// only the options themselves are merged, not the flag set or errors.
+func (opts *ConverterOptions) Merge(source *ConverterOptions) {
+	if source == nil {
+		return
+	}
+	g, sg := &opts.GlobalOptions, &source.GlobalOptions
+	mergeField(opts, source, FieldLogFile, &g.LogFile, sg.LogFile)
+	mergeField(opts, source, FieldLogLevel, &g.LogLevel, sg.LogLevel)
+	mergeField(opts, source, FieldLogFormat, &g.LogFormat, sg.LogFormat)
+	mergeField(opts, source, FieldLogAppend, &g.LogAppend, sg.LogAppend)
+	mergeField(opts, source, FieldLogMaxSize, &g.LogMaxSize, sg.LogMaxSize)
+	mergeField(opts, source, FieldLogKeep, &g.LogKeep, sg.LogKeep)
+	mergeField(opts, source, FieldNoClobber, &g.NoClobber, sg.NoClobber)
+	mergeField(opts, source, FieldOverwrite, &g.Overwrite, sg.Overwrite)
+	mergeField(opts, source, FieldVerbose, &g.Verbose, sg.Verbose)
+	mergeField(opts, source, FieldQuiet, &g.Quiet, sg.Quiet)
+	mergeField(opts, source, FieldNoExecHooks, &g.NoExecHooks, sg.NoExecHooks)
+	mergeField(opts, source, FieldFFmpeg, &g.FFmpeg, sg.FFmpeg)
+	mergeField(opts, source, FieldInputFile, &opts.InputFile, source.InputFile)
+	mergeField(opts, source, FieldOutputFile, &opts.OutputFile, source.OutputFile)
+	mergeField(opts, source, FieldArtFile, &opts.ArtFile, source.ArtFile)
+	mergeField(opts, source, FieldPipeFormat, &opts.PipeFormat, source.PipeFormat)
+	mergeField(opts, source, FieldBitRate, &opts.BitRate, source.BitRate)
+	mergeField(opts, source, FieldCodec, &opts.Codec, source.Codec)
+	mergeField(opts, source, FieldCoverArtFormat, &opts.CoverArtFormat, source.CoverArtFormat)
+	mergeField(opts, source, FieldScale, &opts.Scale, source.Scale)
+	mergeSlice(opts, source, FieldInputExtensions, &opts.InputExtensions, source.InputExtensions)
+	mergeSlice(opts, source, FieldOutputExtensions, &opts.OutputExtensions, source.OutputExtensions)
+	mergeSlice(opts, source, FieldMetadata, &opts.Metadata, source.Metadata)
+	mergeField(opts, source, FieldChannels, &opts.Channels, source.Channels)
+	mergeField(opts, source, FieldSampleRate, &opts.SampleRate, source.SampleRate)
+	mergeField(opts, source, FieldMemoryLimit, &opts.MemoryLimit, source.MemoryLimit)
+	mergeField(opts, source, FieldTargetSize, &opts.TargetSize, source.TargetSize)
+	mergeField(opts, source, FieldStart, &opts.Start, source.Start)
+	mergeField(opts, source, FieldDuration, &opts.Duration, source.Duration)
+	mergeField(opts, source, FieldFadeIn, &opts.FadeIn, source.FadeIn)
+	mergeField(opts, source, FieldFadeOut, &opts.FadeOut, source.FadeOut)
+	mergeField(opts, source, FieldArtFallback, &opts.ArtFallback, source.ArtFallback)
+	mergeField(opts, source, FieldGapless, &opts.Gapless, source.Gapless)
+	mergeField(opts, source, FieldThreads, &opts.Threads, source.Threads)
+	mergeField(opts, source, FieldHWAccel, &opts.HWAccel, source.HWAccel)
+	mergeField(opts, source, FieldNice, &opts.Nice, source.Nice)
+	mergeField(opts, source, FieldIONice, &opts.IONice, source.IONice)
+	mergeField(opts, source, FieldAtomic, &opts.Atomic, source.Atomic)
+}
+
+// Copies from to *to for Merge, if dst doesn't have the field and src does.
+func mergeField[T comparable](dst, src *ConverterOptions, field Field, to *T, from T) {
+	var zero T
+	if dst.Explicit&field != 0 || *to != zero {
+		return
+	} else if src.Explicit&field == 0 && from == zero {
+		return
+	}
+	*to = from
+	dst.Explicit |= src.Explicit & field
+}
+
+// Like mergeField, but for slices, which are cloned.
+func mergeSlice[T any](dst, src *ConverterOptions, field Field, to *[]T, from []T) {
+	if dst.Explicit&field != 0 || len(*to) > 0 {
+		return
+	} else if src.Explicit&field == 0 && len(from) == 0 {
+		return
+	}
+	*to = slices.Clone(from)
+	dst.Explicit |= src.Explicit & field
+}
@@ -4,13 +4,21 @@ package options
 
 import (
 	"audio_converter/internal/filesystem"
+	"bytes"
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Type for factory functions.
@@ -219,6 +227,86 @@ func rootTest(t *testing.T, factory factoryFunc) {
 	if opts := factory([]string{prog, sxs, nested}); opts == nil {
 		t.Errorf("Failed to allow side by side within the same parent directory")
 	}
+	if opts := factory([]string{prog, nested, outroot}); opts != nil {
+		t.Errorf("Failed to catch inroot nested within outroot")
+	}
+
+	// Siblings sharing a prefix, e.g., /music and /music-export.
+	music := filepath.Join(t.TempDir(), "music")
+	for _, dir := range []string{music, music + "-export", filepath.Join(music, "sub")} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opts := factory([]string{prog, music, music + "-export"}); opts == nil {
+		t.Errorf("Failed to allow a sibling with a common prefix")
+	}
+	// The same directory, written differently.
+	if opts := factory([]string{prog, music, filepath.Join(music, "sub", "..")}); opts != nil {
+		t.Errorf("Failed to catch outroot = inroot with a different spelling")
+	}
+	if opts := factory([]string{prog, filepath.Join(music, "sub", ".."), filepath.Join(music, "sub")}); opts != nil {
+		t.Errorf("Failed to catch outroot nested within an unclean inroot")
+	}
+
+	// Symlinks hiding where the roots really are.
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(music, link); err != nil {
+		t.Logf("Skipping symlinked roots: %v", err)
+		return
+	}
+	if opts := factory([]string{prog, link, filepath.Join(music, "sub")}); opts != nil {
+		t.Errorf("Failed to catch outroot nested within a symlinked inroot")
+	}
+	if opts := factory([]string{prog, filepath.Join(link, "sub"), music}); opts != nil {
+		t.Errorf("Failed to catch a symlinked inroot nested within outroot")
+	}
+	if opts := factory([]string{prog, music, link}); opts != nil {
+		t.Errorf("Failed to catch outroot linked to inroot")
+	}
+}
+
+// Handles testing -channels and its precedence over the defaults and -s/-m.
+// Since the flags resolve into the Channels field, newOpts should return the
+// embedded ConverterOptions or nil on failure.
+func channelsTest(t *testing.T, newOpts func([]string) *ConverterOptions) {
+	prog, input, output := setup(t)
+	for _, n := range []int{1, 2, 6, 8} {
+		args := []string{prog, "-channels", strconv.Itoa(n), input, output}
+		if opts := newOpts(args); opts == nil {
+			t.Errorf("Failed on %+v", args)
+		} else if opts.Channels != n {
+			t.Errorf("-channels %d did not override the default: opts.Channels: %d", n, opts.Channels)
+		}
+	}
+	for value, n := range map[string]int{"keep": 0, "mono": 1, "stereo": 2} {
+		args := []string{prog, "-channels", value, input, output}
+		if opts := newOpts(args); opts == nil {
+			t.Errorf("Failed on %+v", args)
+		} else if opts.Channels != n {
+			t.Errorf("-channels %s did not override the default: opts.Channels: %d", value, opts.Channels)
+		} else if opts.Explicit&FieldChannels == 0 {
+			t.Errorf("-channels %s should be explicit, so Merge keeps it", value)
+		}
+	}
+	for _, value := range []string{"-1", "0", "9", "nan", "5.1"} {
+		args := []string{prog, "-channels", value, input, output}
+		if opts := newOpts(args); opts != nil {
+			t.Errorf("Failed to reject %+v: opts.Channels: %d", args, opts.Channels)
+		}
+	}
+	for _, alias := range []string{"-s", "-m"} {
+		args := []string{prog, "-channels", "6", alias, input, output}
+		if opts := newOpts(args); opts != nil {
+			t.Errorf("Failed to reject -channels with %s", alias)
+		}
+	}
+	if opts := newOpts([]string{prog, "-s", "-m", input, output}); opts != nil {
+		t.Errorf("Failed to reject -s with -m")
+	}
+	if opts := newOpts([]string{prog, "-m=false", input, output}); opts == nil || opts.Channels != DefaulConverterOptions.Channels {
+		t.Errorf("-m=false should leave the default channels")
+	}
 }
 
 // Adds tests for global options using t.Run() and the provided factory.
@@ -228,10 +316,26 @@ func testGlobalOptions(t *testing.T, factory factoryFunc) {
 		test := FlagTest{
 			factory:    factory,
 			name:       "log-file",
-			goodValues: []string{"-", "/dev/stdin", "somefile", "/ham/spam"},
+			goodValues: []string{"-", "/dev/stdin", "somefile", filepath.Join(os.TempDir(), "spam.log")},
+			// A missing parent, a directory, and a parent that's a file.
+			badValues: []string{"/ham/spam", os.TempDir(), filepath.Join(os.Args[0], "spam.log")},
 		}
 		test.StringFlag(t)
 	})
+	t.Run("log file home", func(t *testing.T) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			t.Skip(err)
+		}
+		prog, input, output := setup(t)
+		fs := factory([]string{prog, "-log-file", "~/spam.log", input, output})
+		if fs == nil {
+			t.Fatal("Failed with -log-file ~/spam.log")
+		}
+		if s := fs.Lookup("log-file").Value.String(); s != filepath.Join(home, "spam.log") {
+			t.Errorf("~ was not expanded: %q", s)
+		}
+	})
 	// Handles testing the no clobber (-n) and overwrite flags (-y)
 	t.Run("noclobber and overwrite", func(t *testing.T) {
 		ft := FlagTest{
@@ -246,10 +350,14 @@ func testGlobalOptions(t *testing.T, factory factoryFunc) {
 	// Handles testing the --version flag.
 	t.Run("version", func(t *testing.T) {
 		prog, input, output := setup(t)
-		opts := factory([]string{prog, "-version", input, output})
-		if opts != nil {
+		if opts := factory([]string{prog, "-version", input, output}); opts != nil {
 			t.Errorf("Failed with --version")
 		}
+		var b bytes.Buffer
+		writeVersion(&b, prog)
+		if !strings.HasPrefix(b.String(), prog+" version "+Version+"\n") {
+			t.Errorf("Bad version: %q", b.String())
+		}
 	})
 	// Handles testing the -v (verbose) flag.
 	t.Run("verbose", func(t *testing.T) {
@@ -260,18 +368,251 @@ func testGlobalOptions(t *testing.T, factory factoryFunc) {
 		}
 		ft.BoolFlag(t)
 	})
+	// Handles testing -log-level, which -v lowers to debug unless given.
+	t.Run("log level", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for _, tc := range []struct {
+			args     []string
+			expected string
+		}{
+			{nil, "INFO"},
+			{[]string{"-v"}, "DEBUG"},
+			{[]string{"-log-level", "warn"}, "WARN"},
+			{[]string{"-v", "-log-level", "error"}, "ERROR"},
+		} {
+			args := append(append([]string{prog}, tc.args...), input, output)
+			if fs := factory(args); fs == nil {
+				t.Errorf("Failed on %q", args)
+			} else if s := fs.Lookup("log-level").Value.String(); s != tc.expected {
+				t.Errorf("%q: -log-level actual: %q expected: %q", args, s, tc.expected)
+			}
+		}
+		if fs := factory([]string{prog, "-log-level", "loud", input, output}); fs != nil {
+			t.Errorf("Failed to reject a bad -log-level")
+		}
+	})
+	// Handles testing the -log-format flag.
+	t.Run("log format", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      factory,
+			name:         "log-format",
+			defaultValue: LogFormatText,
+			goodValues:   []string{LogFormatText, LogFormatJSON},
+			badValues:    []string{"", "xml", "JSON"},
+		}
+		ft.StringFlag(t)
+	})
+	// Handles testing the -log-append, -log-max-size, and -log-keep flags.
+	t.Run("log rotation", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      factory,
+			name:         "log-append",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+		ft = FlagTest{
+			factory:    factory,
+			name:       "log-max-size",
+			goodValues: []string{"50M", "1024", "1G"},
+			badValues:  []string{"0", "-1M", "big"},
+		}
+		ft.StringFlag(t)
+		ft = FlagTest{
+			factory:      factory,
+			name:         "log-keep",
+			defaultValue: "5",
+			goodValues:   []string{"0", "1", "100"},
+			badValues:    []string{"-1", "many"},
+		}
+		ft.IntFlag(t)
+	})
+	// Handles testing the -quiet flag.
+	t.Run("quiet", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      factory,
+			name:         "quiet",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	// Handles testing -ffmpeg, which defaults to $AUDIO_CONVERTER_FFMPEG.
+	t.Run("ffmpeg", func(t *testing.T) {
+		t.Setenv(FFmpegEnv, "")
+		ft := FlagTest{
+			factory:      factory,
+			name:         "ffmpeg",
+			defaultValue: "ffmpeg",
+			goodValues:   []string{"/usr/local/ffmpeg/bin/ffmpeg", "ffmpeg7"},
+			badValues:    []string{""},
+		}
+		ft.StringFlag(t)
+		t.Setenv(FFmpegEnv, "/usr/local/ffmpeg/bin/ffmpeg")
+		prog, input, output := setup(t)
+		if fs := factory([]string{prog, input, output}); fs == nil {
+			t.Errorf("Failed with $%s set", FFmpegEnv)
+		} else {
+			ft.assert(t, ft.lookup(t, fs), "/usr/local/ffmpeg/bin/ffmpeg", "$"+FFmpegEnv+" was ignored")
+		}
+		if fs := factory([]string{prog, "-ffmpeg", "ffmpeg7", input, output}); fs == nil {
+			t.Errorf("Failed with -ffmpeg and $%s set", FFmpegEnv)
+		} else {
+			ft.assert(t, ft.lookup(t, fs), "ffmpeg7", "-ffmpeg should win over $"+FFmpegEnv)
+		}
+	})
+	// Handles testing -no-exec-hooks, which defaults to on for root.
+	t.Run("no exec hooks", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      factory,
+			name:         "no-exec-hooks",
+			defaultValue: strconv.FormatBool(os.Geteuid() == 0),
+		}
+		prog, input, output := setup(t)
+		if fs := factory([]string{prog, input, output}); fs == nil {
+			t.Errorf("Failed on default args")
+		} else {
+			ft.assert(t, ft.lookup(t, fs), ft.defaultValue, "Default args did not yield default value")
+		}
+		for _, value := range []string{"true", "false"} {
+			if fs := factory([]string{prog, "-no-exec-hooks=" + value, input, output}); fs == nil {
+				t.Errorf("Failed with -no-exec-hooks=%s", value)
+			} else {
+				ft.assert(t, ft.lookup(t, fs), value, "-no-exec-hooks was ignored")
+			}
+		}
+	})
+}
+
+// Runs fn with os.Stdout and os.Stderr sent to files, and returns what was
+// written to each.
+func captureOutput(t *testing.T, fn func()) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	defer func(stdout, stderr *os.File) { os.Stdout, os.Stderr = stdout, stderr }(os.Stdout, os.Stderr)
+	os.Stdout, os.Stderr = stdout, stderr
+	fn()
+	out, _ := os.ReadFile(stdout.Name())
+	errs, _ := os.ReadFile(stderr.Name())
+	return string(out), string(errs)
+}
+
+func TestOutcome(t *testing.T) {
+	constructors := map[string]func([]string) Outcome{
+		"converter": func(args []string) Outcome { _, o := NewConverterOptions(args, DefaulConverterOptions); return o },
+		"embedder":  func(args []string) Outcome { _, o := NewEmbedderOptions(args); return o },
+		"exporter":  func(args []string) Outcome { _, o := NewExporterOptions(args, DefaulConverterOptions); return o },
+		"extracter": func(args []string) Outcome { _, o := NewExtracterOptions(args); return o },
+		"prober":    func(args []string) Outcome { _, o := NewProberOptions(args); return o },
+		"verifier":  func(args []string) Outcome { _, o := NewVerifierOptions(args); return o },
+	}
+	for name, construct := range constructors {
+		t.Run(name, func(t *testing.T) {
+			prog, input, output := setup(t)
+			for _, tc := range []struct {
+				flag     string
+				expected Outcome
+				stdout   bool   // Whether anything goes to stdout, rather than stderr.
+				message  string // Printed once to stderr, along with the usage.
+			}{
+				{flag: "-version", expected: OutcomeExit, stdout: true},
+				{flag: "-help", expected: OutcomeUsage},
+				{flag: "-bogus", expected: OutcomeUsage, message: "flag provided but not defined: -bogus"},
+				{flag: "-ffmpeg=", expected: OutcomeInvalid, message: "-ffmpeg cannot be empty"},
+			} {
+				var outcome Outcome
+				stdout, stderr := captureOutput(t, func() {
+					outcome = construct([]string{prog, tc.flag, input, output})
+				})
+				if outcome != tc.expected {
+					t.Errorf("%s: expected outcome %d, got %d", tc.flag, tc.expected, outcome)
+				}
+				if tc.stdout {
+					if !strings.Contains(stdout, " version ") || stderr != "" {
+						t.Errorf("%s: expected the version on stdout only, got %q and %q", tc.flag, stdout, stderr)
+					}
+					continue
+				}
+				if stdout != "" {
+					t.Errorf("%s: nothing should be written to stdout: %q", tc.flag, stdout)
+				}
+				if n := strings.Count(stderr, "Print version and exit"); n != 1 {
+					t.Errorf("%s: expected the usage once, got it %d times: %q", tc.flag, n, stderr)
+				}
+				if tc.message != "" && strings.Count(stderr, tc.message) != 1 {
+					t.Errorf("%s: expected %q once: %q", tc.flag, tc.message, stderr)
+				}
+			}
+		})
+	}
+	for outcome, code := range map[Outcome]int{OutcomeRun: 0, OutcomeExit: 0, OutcomeUsage: 2, OutcomeInvalid: 1} {
+		if outcome.ExitCode() != code {
+			t.Errorf("Outcome %d should exit %d, not %d", outcome, code, outcome.ExitCode())
+		}
+	}
+}
+
+func TestFFprobe(t *testing.T) {
+	for ffmpeg, ffprobe := range map[string]string{
+		"":                             "ffprobe",
+		"ffmpeg":                       "ffprobe",
+		"/usr/local/ffmpeg/bin/ffmpeg": filepath.Join("/usr/local/ffmpeg/bin", "ffprobe"),
+		"bin/ffmpeg7":                  filepath.Join("bin", "ffprobe"),
+	} {
+		opts := GlobalOptions{FFmpeg: ffmpeg}
+		if got := opts.FFprobe(); got != ffprobe {
+			t.Errorf("FFprobe for %q is %q, expected %q", ffmpeg, got, ffprobe)
+		}
+	}
 }
 
 // Adds tests for converter options using t.Run() and the provided factory.
 func testConverterOptions(t *testing.T, factory factoryFunc) {
+	t.Run("ffmpeg threads", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      factory,
+			name:         "ffmpeg-threads",
+			goodValues:   []string{"0", "1", "8"},
+			badValues:    []string{"-1", "all"},
+			defaultValue: "0",
+		}
+		ft.IntFlag(t)
+	})
+	t.Run("hwaccel", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    factory,
+			name:       "hwaccel",
+			goodValues: []string{"auto", "vaapi", "videotoolbox"},
+			badValues:  []string{"-y", "cuda -i evil.flac"},
+		}
+		ft.StringFlag(t)
+	})
 	t.Run("bitrate", func(t *testing.T) {
 		test := FlagTest{
-			factory:      factory,
-			name:         "b",
-			goodValues:   []string{"12345", "128", "256", "320"},
-			defaultValue: DefaulConverterOptions.BitRate,
+			factory:    factory,
+			name:       "b",
+			goodValues: []string{"8k", "128k", "256k", "320k", "1600k"},
+			badValues:  []string{"12345", "4", "1601k", "2M", "320x", "k", "-128", "0x80", "NaN", "1e2", "128.5"},
+			// Normalized to kbit/s.
+			defaultValue: DefaulConverterOptions.BitRate + "k",
 		}
 		test.StringFlag(t)
+		prog, input, output := setup(t)
+		for value, want := range map[string]string{"320": "320k", "320K": "320k", "1M": "1000k", "1.5M": "1500k", "96.0": "96k"} {
+			if fs := factory([]string{prog, "-b", value, input, output}); fs == nil {
+				t.Errorf("Failed on -b %s", value)
+			} else {
+				test.assert(t, test.lookup(t, fs), want, "-b was not normalized")
+			}
+		}
 	})
 	t.Run("codec", func(t *testing.T) {
 		test := FlagTest{
@@ -283,15 +624,47 @@ func testConverterOptions(t *testing.T, factory factoryFunc) {
 		}
 		test.StringFlag(t)
 	})
+	t.Run("codec check", func(t *testing.T) {
+		defer func(check func(*GlobalOptions, string) error) { CheckEncoder = check }(CheckEncoder)
+		var checked []string
+		CheckEncoder = func(_ *GlobalOptions, codec string) error {
+			checked = append(checked, codec)
+			if codec == "bogus" {
+				return fmt.Errorf("no encoder %q", codec)
+			}
+			return nil
+		}
+		prog, input, output := setup(t)
+		if factory([]string{prog, input, output}) == nil {
+			t.Error("Failed on default args")
+		} else if len(checked) != 0 {
+			t.Errorf("Only an explicit -c should be checked: %q", checked)
+		}
+		if factory([]string{prog, "-c", "some_codec", input, output}) == nil {
+			t.Error("Failed on -c some_codec")
+		}
+		if factory([]string{prog, "-c", "bogus", input, output}) != nil {
+			t.Error("Failed to reject -c bogus")
+		}
+	})
 	t.Run("samplerate", func(t *testing.T) {
 		test := FlagTest{
 			factory:      factory,
 			name:         "r",
-			goodValues:   []string{"44100", "48000", "12345"},
-			badValues:    []string{"notnumeric", "3.14"},
+			goodValues:   []string{"8000", "44100", "48000", "192000"},
+			badValues:    []string{"notnumeric", "3.14", "4410", "12345", "0", "-44100"},
 			defaultValue: strconv.Itoa(DefaulConverterOptions.SampleRate),
 		}
 		test.StringFlag(t)
+		prog, input, output := setup(t)
+		if factory([]string{prog, "-force-rate", "-r", "12345", input, output}) == nil {
+			t.Error("Failed on -force-rate -r 12345")
+		}
+		for _, value := range []string{"0", "-44100"} {
+			if factory([]string{prog, "-force-rate", "-r", value, input, output}) != nil {
+				t.Errorf("Failed to reject -force-rate -r %s", value)
+			}
+		}
 	})
 	t.Run("cover art", func(t *testing.T) {
 		ft := FlagTest{
@@ -326,7 +699,7 @@ var DefaulConverterOptions = &ConverterOptions{
 }
 
 func converterOptionsFactory(args []string) *flag.FlagSet {
-	opts := NewConverterOptions(args, DefaulConverterOptions)
+	opts, _ := NewConverterOptions(args, DefaulConverterOptions)
 	if opts != nil {
 		return opts.fs
 	}
@@ -341,32 +714,306 @@ func TestConverterOptions(t *testing.T) {
 	// only meaningful to test them on the actual structure.
 	t.Run("stereo and mono", func(t *testing.T) {
 		prog, input, output := setup(t)
-		opts := NewConverterOptions([]string{prog, input, output}, DefaulConverterOptions)
+		opts, _ := NewConverterOptions([]string{prog, input, output}, DefaulConverterOptions)
 		if opts.Channels != DefaulConverterOptions.Channels {
 			t.Errorf("Failed on default channel config")
 		}
-		opts = NewConverterOptions([]string{prog, "-s", input, output}, DefaulConverterOptions)
+		opts, _ = NewConverterOptions([]string{prog, "-s", input, output}, DefaulConverterOptions)
 		if opts.Channels != 2 {
 			t.Errorf("Failed on -s for stereo: opts.Channels: %d", opts.Channels)
 		}
-		opts = NewConverterOptions([]string{prog, "-m", input, output}, DefaulConverterOptions)
+		opts, _ = NewConverterOptions([]string{prog, "-m", input, output}, DefaulConverterOptions)
 		if opts.Channels != 1 {
 			t.Errorf("Failed on -m for mono: opts.Channels: %d", opts.Channels)
 		}
 	})
+	t.Run("channels", func(t *testing.T) {
+		channelsTest(t, func(args []string) *ConverterOptions {
+			opts, _ := NewConverterOptions(args, DefaulConverterOptions)
+			return opts
+		})
+	})
+	t.Run("no atomic", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewConverterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || !opts.Atomic {
+			t.Errorf("Atomic output should be the default")
+		}
+		if opts, _ := NewConverterOptions([]string{prog, "-no-atomic", input, output}, DefaulConverterOptions); opts == nil || opts.Atomic {
+			t.Errorf("Failed to turn off atomic output with -no-atomic")
+		}
+	})
+	t.Run("output extension", func(t *testing.T) {
+		prog, input, _ := setup(t)
+		defs := *DefaulConverterOptions
+		defs.OutputExtensions = []string{".m4a", ".m4r"}
+		for output, expected := range map[string]string{"song.m4a": "song.m4a", "ring.M4R": "ring.M4R", "song": "song.m4a", "-": "-"} {
+			if opts, _ := NewConverterOptions([]string{prog, input, output}, &defs); opts == nil || opts.OutputFile != expected {
+				t.Errorf("Expected %q to be written as %q: %+v", output, expected, opts)
+			} else if added := opts.AddedExt(); (added != "") != (output == "song") {
+				t.Errorf("Bad extension added to %q: %q", output, added)
+			}
+		}
+		for _, output := range []string{"song.mp3", "song.m4a.tmp", "song."} {
+			if opts, _ := NewConverterOptions([]string{prog, input, output}, &defs); opts != nil {
+				t.Errorf("Failed to reject %q", output)
+			}
+			if opts, _ := NewConverterOptions([]string{prog, "-force-ext", input, output}, &defs); opts == nil || opts.OutputFile != output {
+				t.Errorf("Failed on -force-ext %q", output)
+			}
+		}
+	})
+	t.Run("output directory", func(t *testing.T) {
+		prog, input, dir := setup(t)
+		defs := *DefaulConverterOptions
+		defs.OutputExtensions = []string{".mp3"}
+		expected := filepath.Join(dir, "song.mp3")
+		for _, output := range []string{dir, dir + string(filepath.Separator)} {
+			for _, flag := range []string{"-n", "-y"} {
+				opts, _ := NewConverterOptions([]string{prog, flag, filepath.Join(input, "song.flac"), output}, &defs)
+				if opts == nil || opts.OutputFile != expected || opts.OutputDir() != output || opts.AddedExt() != "" {
+					t.Errorf("Expected %s %q to be written as %q: %+v", flag, output, expected, opts)
+				} else if opts.NoClobber != (flag == "-n") || opts.Overwrite != (flag == "-y") {
+					t.Errorf("Lost %s on %q", flag, output)
+				}
+			}
+		}
+		missing := filepath.Join(dir, "missing")
+		if opts, _ := NewConverterOptions([]string{prog, "song.flac", missing + string(filepath.Separator)}, &defs); opts != nil {
+			t.Errorf("Failed to reject the missing directory %q: %+v", missing, opts)
+		}
+		if opts, _ := NewConverterOptions([]string{prog, "song.flac", missing}, &defs); opts == nil || opts.OutputFile != missing+".mp3" || opts.OutputDir() != "" {
+			t.Errorf("Expected %q to be taken as a file: %+v", missing, opts)
+		}
+		if opts, _ := NewConverterOptions([]string{prog, "-format", "mp3", "-", dir}, &defs); opts != nil {
+			t.Errorf("Failed to reject stdin into a directory")
+		}
+		defs.OutputExtensions = []string{".flac"}
+		if opts, _ := NewConverterOptions([]string{prog, filepath.Join(dir, "song.flac"), dir}, &defs); opts != nil {
+			t.Errorf("Failed to reject writing the input over itself")
+		}
+	})
+	t.Run("multiple inputs", func(t *testing.T) {
+		prog, _, _ := setup(t)
+		dir, out := t.TempDir(), t.TempDir()
+		defs := *DefaulConverterOptions
+		defs.InputExtensions = []string{".wav"}
+		defs.OutputExtensions = []string{".flac"}
+		var inputs []string
+		for _, name := range []string{"a.wav", "b.wav", "notes.txt", filepath.Join("sub", "a.wav")} {
+			name = filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				t.Fatal(err)
+			} else if err := os.WriteFile(name, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			inputs = append(inputs, name)
+		}
+		opts, _ := NewConverterOptions([]string{prog, inputs[0], inputs[1], out}, &defs)
+		if opts == nil || !slices.Equal(opts.InputFiles, inputs[:2]) || opts.OutputFile != out || opts.MaxJobs != 1 {
+			t.Fatalf("Failed on two inputs: %+v", opts)
+		} else if output := opts.OutputFor(inputs[1]); output != filepath.Join(out, "b.flac") {
+			t.Errorf("Expected %q to be written to b.flac: %q", inputs[1], output)
+		}
+		opts, _ = NewConverterOptions([]string{prog, "-j", "2", inputs[0], inputs[1], out}, &defs)
+		if opts == nil || opts.MaxJobs != 2 || opts.Threads != 1 {
+			t.Errorf("Failed on -j 2: %+v", opts)
+		}
+		for _, bad := range [][]string{
+			{inputs[0], inputs[1], inputs[2]},
+			{inputs[0], inputs[1], filepath.Join(out, "missing")},
+			{inputs[0], filepath.Join(dir, "missing.wav"), out},
+			{inputs[0], inputs[2], out},
+			{inputs[0], inputs[3], out},
+			{inputs[0], "-", out},
+			{inputs[0], dir, out},
+			{"-j", "0", inputs[0], inputs[1], out},
+			{"-j", "2", inputs[0], out},
+		} {
+			if opts, _ := NewConverterOptions(append([]string{prog}, bad...), &defs); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("target size", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewConverterOptions([]string{prog, "-target-size", "700M", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.TargetSize != 700<<20 {
+			t.Errorf("Failed on -target-size 700M")
+		}
+		for _, bad := range [][]string{
+			{"-target-size", "big"},
+			{"-target-size", "-1"},
+			{"-target-size", "700M", "-b", "128k"},
+		} {
+			args := append(append([]string{prog}, bad...), input, output)
+			if opts, _ := NewConverterOptions(args, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("trim", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewConverterOptions([]string{prog, "-start", "1:30.5", "-duration", "30", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.Start != 90500*time.Millisecond || opts.Duration != 30*time.Second {
+			t.Errorf("Failed on -start 1:30.5 -duration 30: %+v", opts)
+		}
+		for _, bad := range [][]string{
+			{"-start", "soon"},
+			{"-start", "-5"},
+			{"-duration", "0"},
+			{"-duration", "0:00"},
+		} {
+			args := append(append([]string{prog}, bad...), input, output)
+			if opts, _ := NewConverterOptions(args, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("fades", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewConverterOptions([]string{prog, "-fade-in", "0.5", "-fade-out", "2", "-duration", "30", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.FadeIn != 0.5 || opts.FadeOut != 2 {
+			t.Errorf("Failed on -fade-in 0.5 -fade-out 2 -duration 30: %+v", opts)
+		}
+		if opts, _ := NewConverterOptions([]string{prog, "-fade-in", "0.5", input, output}, DefaulConverterOptions); opts == nil {
+			t.Errorf("Failed on -fade-in without -duration")
+		}
+		for _, bad := range [][]string{
+			{"-fade-in", "-1"},
+			{"-fade-in", "61"},
+			{"-fade-in", "NaN"},
+			{"-fade-out", "2"},
+			{"-fade-out", "61", "-duration", "90"},
+			{"-fade-out", "20", "-duration", "10"},
+		} {
+			args := append(append([]string{prog}, bad...), input, output)
+			if opts, _ := NewConverterOptions(args, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("stdin and stdout", func(t *testing.T) {
+		prog, input, dir := setup(t)
+		// A file, since stdin can't be written into a directory.
+		output := filepath.Join(dir, "song.flac")
+		defer func(f func() []string) { FormatNames = f }(FormatNames)
+		FormatNames = func() []string { return []string{"flac", "m4a"} }
+		for _, args := range [][]string{
+			{"-", output},
+			{input, "-"},
+			{"-format", "FLAC", input, "-"},
+			{"-format", "flac", "-", "-"},
+		} {
+			opts, _ := NewConverterOptions(append([]string{prog}, args...), DefaulConverterOptions)
+			if opts == nil {
+				t.Errorf("Failed on %q", args)
+			} else if opts.Atomic == (opts.OutputFile == "-") {
+				t.Errorf("Atomic output should be used unless writing stdout for %q", args)
+			}
+		}
+		for _, bad := range [][]string{
+			{"-", "-"},
+			{"-format", "ogg", input, "-"},
+			{"-target-size", "700M", "-", output},
+		} {
+			if opts, _ := NewConverterOptions(append([]string{prog}, bad...), DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("gapless", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      converterOptionsFactory,
+			name:         "gapless",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("art fallback", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      converterOptionsFactory,
+			name:         "art-fallback",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
 	t.Run("input and output file", func(t *testing.T) {
 		inputOutputFileTest(t, converterOptionsFactory)
 	})
 }
 
 func extracterOptionsFactory(args []string) *flag.FlagSet {
-	opts := NewExtracterOptions(args)
+	opts, _ := NewExtracterOptions(args)
 	if opts != nil {
 		return opts.fs
 	}
 	return nil
 }
 
+func TestJobsFlag(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		cpus     int
+		expected int
+	}{
+		{"4", 8, 4},
+		{"50%", 8, 4},
+		{"50%", 7, 3},
+		{"100%", 16, 16},
+		{"200%", 4, 8},
+		// Always at least one job.
+		{"10%", 4, 1},
+		{"1%", 1, 1},
+	} {
+		var j jobsFlag
+		if err := j.Set(tc.value); err != nil {
+			t.Errorf("Set(%q) failed: %v", tc.value, err)
+		} else if n, err := j.jobs("-j", tc.cpus); err != nil || n != tc.expected {
+			t.Errorf("-j %s with %d CPUs: expected %d, got %d, %v", tc.value, tc.cpus, tc.expected, n, err)
+		} else if j.String() != tc.value {
+			t.Errorf("-j %s: String() is %q", tc.value, j.String())
+		}
+	}
+	var unset jobsFlag
+	if n, err := unset.jobs("-j", 8); err != nil || n != 0 {
+		t.Errorf("Unset -j should be the default of 0, got %d, %v", n, err)
+	}
+	for _, value := range []string{"0", "-2", "0%", "-50%", "4097", "5000%"} {
+		var j jobsFlag
+		if err := j.Set(value); err != nil {
+			continue
+		}
+		if n, err := j.jobs("-j", 100); err == nil {
+			t.Errorf("-j %s should be rejected, got %d", value, n)
+		}
+	}
+	for _, value := range []string{"", "four", "%50", "5 %", "1e3"} {
+		var j jobsFlag
+		if err := j.Set(value); err == nil {
+			t.Errorf("Set(%q) should fail", value)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"0":           0,
+		"90":          90 * time.Second,
+		"90.5":        90500 * time.Millisecond,
+		"1:30":        90 * time.Second,
+		"01:02:03.25": time.Hour + 2*time.Minute + 3250*time.Millisecond,
+	} {
+		if got, err := ParseTime(value); err != nil || got != want {
+			t.Errorf("ParseTime(%q) = %v, %v, expected %v", value, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "-1", "1e3", "Inf", "1:", "1:60", "1.5:00", "1:2:3:4"} {
+		if got, err := ParseTime(bad); err == nil {
+			t.Errorf("ParseTime(%q) = %v, expected an error", bad, got)
+		}
+	}
+}
+
 func TestExtracterOptions(t *testing.T) {
 	testGlobalOptions(t, extracterOptionsFactory)
 	t.Run("codec", func(t *testing.T) {
@@ -386,82 +1033,1192 @@ func TestExtracterOptions(t *testing.T) {
 		}
 		ft.StringFlag(t)
 	})
+	t.Run("recursive", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      extracterOptionsFactory,
+			name:         "R",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("recursive options", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for _, good := range [][]string{
+			{"-R", "-per-track"},
+			{"-R", "-per-album", "-cover-name", "folder.png", "-j", "4"},
+		} {
+			if extracterOptionsFactory(append(append([]string{prog}, good...), input, output)) == nil {
+				t.Errorf("Failed with %q", good)
+			}
+		}
+		for _, bad := range [][]string{
+			{"-per-track"},
+			{"-j", "4"},
+			{"-R", "-per-album", "-per-track"},
+			{"-R", "-cover-name", "Art/cover.jpg"},
+			{"-R", "-cover-name", "cover"},
+			{"-R", "-j", "-1"},
+		} {
+			if extracterOptionsFactory(append(append([]string{prog}, bad...), input, output)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+		if extracterOptionsFactory([]string{prog, "-R", "options_test.go", output}) != nil {
+			t.Error("Failed to reject a file as the input directory")
+		}
+	})
+	t.Run("stdout", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for args, codec := range map[string]string{
+			"-c png":                 "png",
+			"-format jpg":            "mjpeg",
+			"-format PNG":            "png",
+			"-format jpg -c libwebp": "libwebp",
+		} {
+			opts, _ := NewExtracterOptions(append(append([]string{prog}, strings.Fields(args)...), input, "-"))
+			if opts == nil {
+				t.Errorf("Failed with %s", args)
+			} else if opts.Codec != codec {
+				t.Errorf("%s used codec %q, expected %q", args, opts.Codec, codec)
+			}
+		}
+		for _, bad := range [][]string{
+			{input, "-"},
+			{"-format", "mp3", input, "-"},
+			{"-format", "png", input, output},
+			{"-R", "-c", "png", ".", "-"},
+		} {
+			if extracterOptionsFactory(append([]string{prog}, bad...)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
 	t.Run("input and output file", func(t *testing.T) {
 		inputOutputFileTest(t, extracterOptionsFactory)
 	})
 }
 
-func exporterOptionsFactory(args []string) *flag.FlagSet {
-	opts := NewExporterOptions(args, DefaulConverterOptions)
+func proberOptionsFactory(args []string) *flag.FlagSet {
+	opts, _ := NewProberOptions(args)
 	if opts != nil {
 		return opts.fs
 	}
 	return nil
 }
 
-func TestExporterOptions(t *testing.T) {
-	testGlobalOptions(t, exporterOptionsFactory)
-	testConverterOptions(t, exporterOptionsFactory)
-	// These flags are used to set an actual field from private values. So it's
-	// only meaningful to test them on the actual structure.
-	t.Run("stereo and mono", func(t *testing.T) {
-		prog, input, output := setup(t)
-		opts := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions)
-		if opts.Channels != DefaulConverterOptions.Channels {
-			t.Errorf("Failed on default channel config")
-		}
-		opts = NewExporterOptions([]string{prog, "-s", input, output}, DefaulConverterOptions)
-		if opts.Channels != 2 {
-			t.Errorf("Failed on -s for stereo: opts.Channels: %d", opts.Channels)
-		}
-		opts = NewExporterOptions([]string{prog, "-m", input, output}, DefaulConverterOptions)
-		if opts.Channels != 1 {
-			t.Errorf("Failed on -m for mono: opts.Channels: %d", opts.Channels)
+func TestProberOptions(t *testing.T) {
+	testGlobalOptions(t, proberOptionsFactory)
+	t.Run("json", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      proberOptionsFactory,
+			name:         "json",
+			defaultValue: "false",
 		}
+		ft.BoolFlag(t)
 	})
-	t.Run("copy unknown", func(t *testing.T) {
-		copyUnknownTest(t, exporterOptionsFactory)
-	})
-	t.Run("max jobs", func(t *testing.T) {
-		ft := FlagTest{
-			factory:      exporterOptionsFactory,
-			name:         "j",
-			goodValues:   []string{"1", "4", "8", "32", strconv.Itoa(math.MaxInt)},
-			badValues:    []string{"nan"},
-			defaultValue: "0",
+	t.Run("files", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for _, good := range [][]string{
+			{"options_test.go"},
+			{"options_test.go", "merge.go"},
+			{"-R", input},
+			{"-R", input, output},
+		} {
+			if proberOptionsFactory(append([]string{prog}, good...)) == nil {
+				t.Errorf("Failed with %q", good)
+			}
+		}
+		for _, bad := range [][]string{
+			{},
+			{"-R"},
+			{"-R", "options_test.go"},
+			{"-R", input, filepath.Join(input, "missing")},
+		} {
+			if proberOptionsFactory(append([]string{prog}, bad...)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
 		}
-		ft.IntFlag(t)
 	})
-	t.Run("max queue", func(t *testing.T) {
+}
+
+func verifierOptionsFactory(args []string) *flag.FlagSet {
+	opts, _ := NewVerifierOptions(args)
+	if opts != nil {
+		return opts.fs
+	}
+	return nil
+}
+
+func TestVerifierOptions(t *testing.T) {
+	testGlobalOptions(t, verifierOptionsFactory)
+	t.Run("decode", func(t *testing.T) {
 		ft := FlagTest{
-			factory:      exporterOptionsFactory,
-			name:         "q",
-			goodValues:   []string{"1", "200", "1024", strconv.Itoa(math.MaxInt)},
-			badValues:    []string{"nan"},
-			defaultValue: "0",
+			factory:      verifierOptionsFactory,
+			name:         "decode",
+			defaultValue: "false",
 		}
-		ft.IntFlag(t)
+		ft.BoolFlag(t)
 	})
-	t.Run("format", func(t *testing.T) {
+	t.Run("lossy policy", func(t *testing.T) {
 		ft := FlagTest{
-			factory:      exporterOptionsFactory,
-			name:         "f",
-			goodValues:   []string{"flac", "m4a", "m4r", "mp3"},
-			badValues:    []string{"unknown"},
-			defaultValue: "m4a",
+			factory:      verifierOptionsFactory,
+			name:         "lossy-policy",
+			defaultValue: LossyConvert,
+			goodValues:   []string{LossyConvert, LossyCopy, LossySkip},
+			badValues:    []string{"", "keep"},
 		}
 		ft.StringFlag(t)
 	})
 	t.Run("cleanpaths", func(t *testing.T) {
 		ft := FlagTest{
-			factory:    exporterOptionsFactory,
+			factory:    verifierOptionsFactory,
 			name:       "cleanpaths",
-			goodValues: []string{"_", "foo", " - ", "x"},
-			badValues:  filesystem.ReservedCharacters,
+			goodValues: []string{"_", "-"},
+			badValues:  []string{":", "?"},
 		}
 		ft.StringFlag(t)
 	})
-	t.Run("input and output root", func(t *testing.T) {
-		rootTest(t, exporterOptionsFactory)
+	t.Run("cleanpaths strict", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewVerifierOptions([]string{prog, input, output}); opts == nil || !opts.CleanPathsStrict {
+			t.Error("-cleanpaths-strict should default to true")
+		}
+		if opts, _ := NewVerifierOptions([]string{prog, "-cleanpaths-strict=false", input, output}); opts == nil || opts.CleanPathsStrict {
+			t.Error("Failed on -cleanpaths-strict=false")
+		}
+	})
+	t.Run("jobs", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if verifierOptionsFactory([]string{prog, "-decode", "-j", "4", input, output}) == nil {
+			t.Error("Failed with -decode -j 4")
+		}
+		for _, bad := range [][]string{{"-j", "4"}, {"-decode", "-j", "-1"}} {
+			if verifierOptionsFactory(append(append([]string{prog}, bad...), input, output)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("roots", func(t *testing.T) {
+		rootTest(t, verifierOptionsFactory)
 	})
 }
+
+func embedderOptionsFactory(args []string) *flag.FlagSet {
+	opts, _ := NewEmbedderOptions(args)
+	if opts != nil {
+		return opts.fs
+	}
+	return nil
+}
+
+func TestEmbedderOptions(t *testing.T) {
+	testGlobalOptions(t, embedderOptionsFactory)
+	t.Run("codec", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    embedderOptionsFactory,
+			name:       "c",
+			goodValues: []string{"mjpeg", "png"},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("scale", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    embedderOptionsFactory,
+			name:       "scale",
+			goodValues: []string{"500x500", "1x1"},
+			badValues:  []string{"500xWidth", "HxW"},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("files", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewEmbedderOptions([]string{prog, "cover.jpg", input, output})
+		if opts == nil {
+			t.Fatal("Failed with two audio files")
+		} else if opts.ImageFile != "cover.jpg" || !slices.Equal(opts.AudioFiles, []string{input, output}) {
+			t.Errorf("Bad files: %q %q", opts.ImageFile, opts.AudioFiles)
+		}
+		for _, bad := range [][]string{
+			{},
+			{"cover.jpg"},
+			{"cover.jpg", "cover.jpg"},
+			{"-j", "2", "cover.jpg", "song.m4a"},
+			{"-cover-name", "folder.jpg", "cover.jpg", "song.m4a"},
+		} {
+			if embedderOptionsFactory(append([]string{prog}, bad...)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("recursive", func(t *testing.T) {
+		prog, input, _ := setup(t)
+		opts, _ := NewEmbedderOptions([]string{prog, "-R", "-cover-name", "folder.png", "-j", "2", input})
+		if opts == nil {
+			t.Fatal("Failed with -R")
+		} else if opts.Root != input || opts.CoverName != "folder.png" || opts.MaxJobs != 2 {
+			t.Errorf("Bad options: %+v", opts)
+		}
+		for _, bad := range [][]string{
+			{"-R"},
+			{"-R", input, input},
+			{"-R", "options_test.go"},
+			{"-R", "-cover-name", "Art/cover.jpg", input},
+			{"-R", "-j", "-1", input},
+		} {
+			if embedderOptionsFactory(append([]string{prog}, bad...)) != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+}
+
+func exporterOptionsFactory(args []string) *flag.FlagSet {
+	opts, _ := NewExporterOptions(args, DefaulConverterOptions)
+	if opts != nil {
+		return opts.fs
+	}
+	return nil
+}
+
+func TestExporterOptions(t *testing.T) {
+	testGlobalOptions(t, exporterOptionsFactory)
+	testConverterOptions(t, exporterOptionsFactory)
+	t.Run("trim", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for _, flag := range []string{"-start", "-duration"} {
+			if opts, _ := NewExporterOptions([]string{prog, flag, "30", input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %s", flag)
+			}
+		}
+	})
+	// These flags are used to set an actual field from private values. So it's
+	// only meaningful to test them on the actual structure.
+	t.Run("stereo and mono", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions)
+		if opts.Channels != DefaulConverterOptions.Channels {
+			t.Errorf("Failed on default channel config")
+		}
+		opts, _ = NewExporterOptions([]string{prog, "-s", input, output}, DefaulConverterOptions)
+		if opts.Channels != 2 {
+			t.Errorf("Failed on -s for stereo: opts.Channels: %d", opts.Channels)
+		}
+		opts, _ = NewExporterOptions([]string{prog, "-m", input, output}, DefaulConverterOptions)
+		if opts.Channels != 1 {
+			t.Errorf("Failed on -m for mono: opts.Channels: %d", opts.Channels)
+		}
+	})
+	t.Run("channels", func(t *testing.T) {
+		channelsTest(t, func(args []string) *ConverterOptions {
+			if opts, _ := NewExporterOptions(args, DefaulConverterOptions); opts != nil {
+				return &opts.ConverterOptions
+			}
+			return nil
+		})
+	})
+	t.Run("copy unknown", func(t *testing.T) {
+		copyUnknownTest(t, exporterOptionsFactory)
+	})
+	t.Run("art fallback", func(t *testing.T) {
+		// Unlike the converters, this defaults to on.
+		prog, input, output := setup(t)
+		opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions)
+		if opts == nil || !opts.ArtFallback {
+			t.Errorf("Art fallback should default to on")
+		}
+		opts, _ = NewExporterOptions([]string{prog, "-art-fallback=false", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.ArtFallback {
+			t.Errorf("Failed to turn off art fallback")
+		}
+	})
+	t.Run("max jobs", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "j",
+			goodValues:   []string{"1", "4", "8", "32", "4096", "50%", "100%"},
+			badValues:    []string{"nan", "0", "-1", "4097", strconv.Itoa(math.MaxInt), "0%", "%", "50%%", "1.5"},
+			defaultValue: "0",
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("threads per job", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for _, tc := range []struct {
+			args     []string
+			expected int
+		}{
+			{[]string{"-j", "4"}, 1},
+			{[]string{"-j", "1"}, 0},
+			{[]string{"-j", "4", "-ffmpeg-threads", "0"}, 0},
+			{[]string{"-j", "4", "-ffmpeg-threads", "2"}, 2},
+		} {
+			args := append(append([]string{prog}, tc.args...), input, output)
+			if opts, _ := NewExporterOptions(args, DefaulConverterOptions); opts == nil || opts.Threads != tc.expected {
+				t.Errorf("%q: expected -ffmpeg-threads %d", args, tc.expected)
+			}
+		}
+	})
+	t.Run("status interval", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.StatusInterval != 30*time.Second {
+			t.Errorf("Bad default -status-interval")
+		}
+		for _, value := range []string{"0", "1s", "5m"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-status-interval", value, input, output}, DefaulConverterOptions); opts == nil {
+				t.Errorf("Failed on -status-interval %s", value)
+			}
+		}
+		for _, value := range []string{"-1s", "often", "10"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-status-interval", value, input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject -status-interval %s", value)
+			}
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.Timeout != 0 {
+			t.Errorf("Bad default -timeout")
+		}
+		for value, expected := range map[string]time.Duration{"0": 0, "90s": 90 * time.Second, "1h": time.Hour} {
+			if opts, _ := NewExporterOptions([]string{prog, "-timeout", value, input, output}, DefaulConverterOptions); opts == nil || opts.Timeout != expected {
+				t.Errorf("Failed on -timeout %s", value)
+			}
+		}
+		for _, value := range []string{"-1m", "forever", "30"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-timeout", value, input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject -timeout %s", value)
+			}
+		}
+	})
+	t.Run("io jobs", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "io-jobs",
+			goodValues:   []string{"1", "2", "16"},
+			badValues:    []string{"nan", "0", "-1"},
+			defaultValue: "2",
+		}
+		ft.IntFlag(t)
+	})
+	t.Run("max queue", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "q",
+			goodValues:   []string{"1", "200", "1024", "4096"},
+			badValues:    []string{"nan", "0", "-1", "4097", strconv.Itoa(math.MaxInt)},
+			defaultValue: "0",
+		}
+		ft.IntFlag(t)
+	})
+	t.Run("format", func(t *testing.T) {
+		// The real list lives in the ffmpeg package, which imports this one.
+		defer func(names func() []string) { FormatNames = names }(FormatNames)
+		FormatNames = func() []string {
+			return []string{"flac", "m4a", "m4r", "mp3"}
+		}
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "f",
+			goodValues:   []string{"flac", "m4a", "m4r", "mp3"},
+			badValues:    []string{"unknown"},
+			defaultValue: "m4a",
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("cleanpaths", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "cleanpaths",
+			goodValues: []string{"_", "foo", " - ", "x"},
+			badValues:  filesystem.ReservedCharacters,
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("cleanpaths strict", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || !opts.CleanPathsStrict {
+			t.Error("-cleanpaths-strict should default to true")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-cleanpaths-strict=false", input, output}, DefaulConverterOptions); opts == nil || opts.CleanPathsStrict {
+			t.Error("Failed on -cleanpaths-strict=false")
+		}
+	})
+	t.Run("stats", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "stats",
+			goodValues: []string{"stats.json", "/tmp/stats.json"},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("diff", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, "-diff", input, output}, DefaulConverterOptions); opts == nil || !opts.Diff {
+			t.Errorf("Failed on -diff")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-diff", "-json", input, output}, DefaulConverterOptions); opts == nil || !opts.JSON {
+			t.Errorf("Failed on -diff -json")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-json", input, output}, DefaulConverterOptions); opts != nil {
+			t.Errorf("Failed to reject -json without -diff")
+		}
+	})
+	t.Run("dedupe suffix", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "dedupe-suffix",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("rlimit mem", func(t *testing.T) {
+		prog, input, output := setup(t)
+		for value, expected := range map[string]int64{"1048576": 1 << 20, "512M": 512 << 20, "2G": 2 << 30, "64k": 64 << 10} {
+			if opts, _ := NewExporterOptions([]string{prog, "-rlimit-mem", value, input, output}, DefaulConverterOptions); opts == nil || opts.MemoryLimit != expected {
+				t.Errorf("Failed on -rlimit-mem %s", value)
+			}
+		}
+		for _, value := range []string{"0", "-1", "G", "2T", "lots", "9999999999G"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-rlimit-mem", value, input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject -rlimit-mem %s", value)
+			}
+		}
+	})
+	t.Run("nice", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "nice",
+			goodValues:   []string{"0", "1", "10", "19"},
+			badValues:    []string{"-5", "20", "low"},
+			defaultValue: "0",
+		}
+		ft.IntFlag(t)
+	})
+	t.Run("ionice", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "ionice",
+			goodValues: []string{"idle", "best-effort"},
+			badValues:  []string{"realtime", "Idle", "3"},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("bwlimit", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.BandwidthLimit != 0 {
+			t.Errorf("Copies should be unlimited by default")
+		}
+		for value, expected := range map[string]int64{"1048576": 1 << 20, "20M": 20 << 20, "512k": 512 << 10} {
+			if opts, _ := NewExporterOptions([]string{prog, "-bwlimit", value, input, output}, DefaulConverterOptions); opts == nil || opts.BandwidthLimit != expected {
+				t.Errorf("Failed on -bwlimit %s", value)
+			}
+		}
+		for _, value := range []string{"0", "-1", "M", "fast"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-bwlimit", value, input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject -bwlimit %s", value)
+			}
+		}
+	})
+	t.Run("layout", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.Layout != nil {
+			t.Errorf("The input's structure should be kept by default")
+		}
+		for _, value := range []string{"flatten-discs", "artist-album", "{artist}/{track:02d} {title}"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-layout", value, input, output}, DefaulConverterOptions); opts == nil || opts.Layout == nil {
+				t.Errorf("Failed on -layout %s", value)
+			}
+		}
+		for _, bad := range [][]string{
+			{"-layout", "{artist"},
+			{"-layout", "/{artist}"},
+			{"-layout", "flatten-discs", "-only", "."},
+			{"-layout", "flatten-discs", "-preserve-symlinks"},
+		} {
+			if opts, _ := NewExporterOptions(append(append([]string{prog}, bad...), input, output), DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("dest", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.Dests != nil {
+			t.Errorf("There should be no -dest by default")
+		}
+		sd, phone := t.TempDir(), t.TempDir()
+		opts, _ := NewExporterOptions([]string{prog, "-dest", "M4A:" + phone, "-dest", "mp3:" + sd, input}, DefaulConverterOptions)
+		expected := []Destination{{Format: "m4a", OutRoot: phone}, {Format: "mp3", OutRoot: sd}}
+		if opts == nil {
+			t.Fatalf("Failed on -dest")
+		} else if !slices.Equal(opts.Dests, expected) {
+			t.Errorf("Expected %v, have %v", expected, opts.Dests)
+		}
+		for _, bad := range [][]string{
+			{"-dest", sd, input},
+			{"-dest", "mp3:", input},
+			{"-dest", ":" + sd, input},
+			{"-dest", "mp3:" + filepath.Join(sd, "missing"), input},
+			{"-dest", "mp3:" + sd, input, output},
+			{"-dest", "mp3:" + sd, "-dest", "m4a:" + sd, input},
+			{"-dest", "mp3:" + input, input},
+			{"-dest", "mp3:" + sd, "-watch", input},
+			{"-dest", "mp3:" + sd, "-state", filepath.Join(phone, "state"), input},
+		} {
+			if opts, _ := NewExporterOptions(append([]string{prog}, bad...), DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", bad)
+			}
+		}
+	})
+	t.Run("normalize names", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "normalize-names",
+			goodValues:   []string{"nfc", "nfd", "none"},
+			badValues:    []string{"", "NFC", "nfkc"},
+			defaultValue: "none",
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("max bytes", func(t *testing.T) {
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.MaxNameBytes != 255 || opts.MaxPathBytes != 0 {
+			t.Errorf("Bad default -max-name-bytes or -max-path-bytes: %+v", opts)
+		}
+		for _, args := range [][]string{{"-max-name-bytes", "0"}, {"-max-name-bytes", "143"}, {"-max-path-bytes", "240"}} {
+			if opts, _ := NewExporterOptions(append(append([]string{prog}, args...), input, output), DefaulConverterOptions); opts == nil {
+				t.Errorf("Failed on %q", args)
+			}
+		}
+		for _, args := range [][]string{{"-max-name-bytes", "-1"}, {"-max-name-bytes", "8"}, {"-max-path-bytes", "31"}} {
+			if opts, _ := NewExporterOptions(append(append([]string{prog}, args...), input, output), DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject %q", args)
+			}
+		}
+	})
+	t.Run("skip trash", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "no-skip-trash",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+		prog, input, output := setup(t)
+		args := []string{prog, "-skip-trash", "Folder.jpg, ~*,.@__thumb/,", input, output}
+		if opts, _ := NewExporterOptions(args, DefaulConverterOptions); opts == nil || !slices.Equal(opts.SkipTrash, []string{"Folder.jpg", "~*", ".@__thumb/"}) {
+			t.Errorf("Failed on -skip-trash: %+v", opts)
+		}
+		for _, value := range []string{"*", "a/b", "a*b", "../"} {
+			if opts, _ := NewExporterOptions([]string{prog, "-skip-trash", value, input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject -skip-trash %q", value)
+			}
+		}
+	})
+	t.Run("exclude and include", func(t *testing.T) {
+		prog, input, output := setup(t)
+		args := []string{prog, "-exclude", "__backup/", "-exclude", "**/*.pdf", "-include", "Booklets/**", input, output}
+		opts, _ := NewExporterOptions(args, DefaulConverterOptions)
+		if opts == nil {
+			t.Fatalf("Failed on %q", args)
+		}
+		if expected := []string{"__backup/", "**/*.pdf"}; !slices.Equal(opts.Excludes, expected) {
+			t.Errorf("Excludes: %q expected: %q", opts.Excludes, expected)
+		}
+		if expected := []string{"Booklets/**"}; !slices.Equal(opts.Includes, expected) {
+			t.Errorf("Includes: %q expected: %q", opts.Includes, expected)
+		}
+		for _, flag := range []string{"-exclude", "-include"} {
+			if opts, _ := NewExporterOptions([]string{prog, flag, "[scans", input, output}, DefaulConverterOptions); opts != nil {
+				t.Errorf("Failed to reject bad pattern for %s", flag)
+			}
+		}
+	})
+	t.Run("media-ext and ignore-ext", func(t *testing.T) {
+		prog, input, output := setup(t)
+		args := []string{prog, "-media-ext", ".APE", "-media-ext", ".wv", "-ignore-ext", ".wav", input, output}
+		opts, _ := NewExporterOptions(args, DefaulConverterOptions)
+		if opts == nil {
+			t.Fatalf("Failed on %q", args)
+		}
+		if expected := []string{".ape", ".wv"}; !slices.Equal(opts.MediaExts, expected) {
+			t.Errorf("MediaExts: %q expected: %q", opts.MediaExts, expected)
+		}
+		if expected := []string{".wav"}; !slices.Equal(opts.IgnoreExts, expected) {
+			t.Errorf("IgnoreExts: %q expected: %q", opts.IgnoreExts, expected)
+		}
+		for _, flag := range []string{"-media-ext", "-ignore-ext"} {
+			for _, ext := range []string{"ape", ".", "", ".tar.gz", "./ape"} {
+				if opts, _ := NewExporterOptions([]string{prog, flag, ext, input, output}, DefaulConverterOptions); opts != nil {
+					t.Errorf("Failed to reject %s %q", flag, ext)
+				}
+			}
+		}
+		args = []string{prog, "-media-ext", ".ape", "-ignore-ext", ".APE", input, output}
+		if opts, _ := NewExporterOptions(args, DefaulConverterOptions); opts != nil {
+			t.Errorf("Failed to reject the same extension for both flags")
+		}
+	})
+	t.Run("only", func(t *testing.T) {
+		prog, _, _ := setup(t)
+		// Both temporary, since the input can't be within the output either.
+		input, output := t.TempDir(), t.TempDir()
+		for _, dir := range []string{"Artist A/Album", "Artist B", "Artist Bee"} {
+			if err := os.MkdirAll(path.Join(input, dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(path.Join(input, "song.flac"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		parse := func(only ...string) *ExporterOptions {
+			args := []string{prog}
+			for _, dir := range only {
+				args = append(args, "-only", dir)
+			}
+			opts, _ := NewExporterOptions(append(args, input, output), DefaulConverterOptions)
+			return opts
+		}
+		for _, tc := range []struct {
+			only     []string
+			expected []string
+		}{
+			{[]string{"Artist A"}, []string{"Artist A"}},
+			{[]string{"Artist B/", "Artist A/Album"}, []string{"Artist A/Album", "Artist B"}},
+			{[]string{"Artist A/Album", "Artist A", "Artist Bee", "Artist B"}, []string{"Artist A", "Artist B", "Artist Bee"}},
+		} {
+			if opts := parse(tc.only...); opts == nil || !slices.Equal(opts.Only, tc.expected) {
+				t.Errorf("Failed on -only %q expected %q", tc.only, tc.expected)
+			}
+		}
+		for _, only := range []string{"Artist C", "song.flac", "..", "../Artist A", input} {
+			if opts := parse(only); opts != nil {
+				t.Errorf("Failed to reject -only %q", only)
+			}
+		}
+	})
+	t.Run("spot check", func(t *testing.T) {
+		prog, input, output := setup(t)
+		opts, _ := NewExporterOptions([]string{prog, "-spot-check", "20", "-spot-check-seed", "1234", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.SpotCheck != 20 || opts.SpotCheckSeed != 1234 {
+			t.Errorf("Failed on -spot-check 20 -spot-check-seed 1234")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-spot-check", "-1", input, output}, DefaulConverterOptions); opts != nil {
+			t.Errorf("Failed to reject negative -spot-check")
+		}
+	})
+	t.Run("update", func(t *testing.T) {
+		for _, name := range []string{"update", "ignore-settings-change", "checksum", "report-duplicates"} {
+			ft := FlagTest{
+				factory:      exporterOptionsFactory,
+				name:         name,
+				defaultValue: "false",
+			}
+			ft.BoolFlag(t)
+		}
+	})
+	t.Run("lossy policy", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "lossy-policy",
+			defaultValue: LossyConvert,
+			goodValues:   []string{LossyConvert, LossyCopy, LossySkip},
+			badValues:    []string{"keep", ""},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("ffmpeg log", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "ffmpeg-log",
+			defaultValue: FFmpegLogFull,
+			goodValues:   []string{FFmpegLogNone, FFmpegLogErrors, FFmpegLogFull},
+			badValues:    []string{"some", ""},
+		}
+		ft.StringFlag(t)
+
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, "-quiet", input, output}, DefaulConverterOptions); opts == nil || opts.FFmpegLog != FFmpegLogErrors {
+			t.Errorf("-quiet should default -ffmpeg-log to errors")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-quiet", "-ffmpeg-log", "full", input, output}, DefaulConverterOptions); opts == nil || opts.FFmpegLog != FFmpegLogFull {
+			t.Errorf("-ffmpeg-log should win over -quiet")
+		}
+	})
+	t.Run("preserve times", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "preserve-times",
+			defaultValue: PreserveCopies,
+			goodValues:   []string{PreserveNone, PreserveCopies, PreserveAll},
+			badValues:    []string{"conversions", ""},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("replaygain", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "replaygain",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("split cue", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "split-cue",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("by album", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "by-album",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("case insensitive target", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "case-insensitive-target",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("preserve symlinks", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "preserve-symlinks",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("collect playlists", func(t *testing.T) {
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "collect-playlists",
+			goodValues: []string{"Playlists", "Music/Playlists"},
+			badValues:  []string{"/Playlists", "../Playlists", "."},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("state", func(t *testing.T) {
+		dir := t.TempDir()
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "state",
+			goodValues: []string{filepath.Join(dir, "export.state")},
+			badValues:  []string{dir},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "manifest",
+			goodValues: []string{filepath.Join(dir, "manifest.csv"), filepath.Join(dir, "manifest.jsonl")},
+			badValues:  []string{dir},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("watch", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "watch",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+
+		prog, input, output := setup(t)
+		if opts, _ := NewExporterOptions([]string{prog, "-watch", "-diff", input, output}, DefaulConverterOptions); opts != nil {
+			t.Errorf("Failed to reject -watch with -diff")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions); opts == nil || opts.WatchSettle != 30*time.Second || opts.WatchPoll != 0 {
+			t.Errorf("Bad default -watch-settle or -watch-poll")
+		}
+		for _, name := range []string{"-watch-settle", "-watch-poll"} {
+			for _, value := range []string{"0", "500ms", "2m"} {
+				if opts, _ := NewExporterOptions([]string{prog, name, value, input, output}, DefaulConverterOptions); opts == nil {
+					t.Errorf("Failed on %s %s", name, value)
+				}
+			}
+			for _, value := range []string{"-1s", "soon", "10"} {
+				if opts, _ := NewExporterOptions([]string{prog, name, value, input, output}, DefaulConverterOptions); opts != nil {
+					t.Errorf("Failed to reject %s %s", name, value)
+				}
+			}
+		}
+	})
+	t.Run("watch spill", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "watch-spill",
+			goodValues: []string{dir},
+			badValues:  []string{file, filepath.Join(dir, "missing")},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("error logs", func(t *testing.T) {
+		dir := t.TempDir()
+		file := filepath.Join(dir, "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ft := FlagTest{
+			factory:    exporterOptionsFactory,
+			name:       "error-logs",
+			goodValues: []string{dir, filepath.Join(dir, "created later")},
+			badValues:  []string{file},
+		}
+		ft.StringFlag(t)
+	})
+	t.Run("follow symlinks", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "follow-symlinks",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("sidecar art", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "sidecar-art",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("verify", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "verify",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+	})
+	t.Run("free space", func(t *testing.T) {
+		ft := FlagTest{
+			factory:      exporterOptionsFactory,
+			name:         "ignore-space",
+			defaultValue: "false",
+		}
+		ft.BoolFlag(t)
+		prog, input, output := setup(t)
+		opts, _ := NewExporterOptions([]string{prog, "-size-ratio", "0.5", input, output}, DefaulConverterOptions)
+		if opts == nil || opts.SizeRatio != 0.5 {
+			t.Errorf("Failed on -size-ratio 0.5")
+		}
+		if opts, _ := NewExporterOptions([]string{prog, "-size-ratio", "-1", input, output}, DefaulConverterOptions); opts != nil {
+			t.Errorf("Failed to reject negative -size-ratio")
+		}
+	})
+	t.Run("input and output root", func(t *testing.T) {
+		rootTest(t, exporterOptionsFactory)
+	})
+}
+
+func TestConfig(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	user, err := UserConfigFile()
+	if err != nil || !strings.HasPrefix(user, config) {
+		t.Skipf("The user config isn't under $XDG_CONFIG_HOME: %q %v", user, err)
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prog, input, output := setup(t)
+	bitRate := func(args ...string) string {
+		t.Helper()
+		fs := converterOptionsFactory(append(append([]string{prog}, args...), input, output))
+		if fs == nil {
+			return "failed"
+		}
+		return fs.Lookup("b").Value.String()
+	}
+
+	if b := bitRate(); b != "1024k" {
+		t.Errorf("Without a config, expected the built in default, not %q", b)
+	}
+	write(user, strings.Join([]string{
+		"# Comments and blank lines are ignored.",
+		"",
+		"b = 256",
+		"quiet",
+		`log-file = "-"`,
+		"no-such-flag = 1",
+		"[some_other_tool]",
+		"b = 8k",
+	}, "\n"))
+	fs := converterOptionsFactory([]string{prog, input, output})
+	if fs == nil {
+		t.Fatal("Failed with a user config")
+	}
+	for name, want := range map[string]string{"b": "256k", "quiet": "true", "log-file": "-"} {
+		if f := fs.Lookup(name); f.Value.String() != want {
+			t.Errorf("-%s should come from the config: %q", name, f.Value)
+		}
+	}
+	if f := fs.Lookup("quiet"); f.DefValue != "true" {
+		t.Errorf("-h should show the config's default: %q", f.DefValue)
+	}
+	if b := bitRate("-b", "320"); b != "320k" {
+		t.Errorf("The command line should override the config, not %q", b)
+	}
+
+	forced := filepath.Join(t.TempDir(), "forced")
+	write(forced, "b = 128k\n")
+	if b := bitRate("-config", forced); b != "128k" {
+		t.Errorf("-config should replace the user config, not %q", b)
+	}
+	if b := bitRate("-config="+forced, "-b", "96"); b != "96k" {
+		t.Errorf("The command line should override -config, not %q", b)
+	}
+	if b := bitRate("-config", filepath.Join(config, "missing")); b != "failed" {
+		t.Errorf("A missing -config should fail, not %q", b)
+	}
+	write(forced, "r = fast\n")
+	if b := bitRate("-config", forced); b != "failed" {
+		t.Errorf("A bad value in the config should fail, not %q", b)
+	}
+
+	// The tree config goes between the user config and the command line.
+	root, output := t.TempDir(), t.TempDir()
+	evil := filepath.Join(t.TempDir(), "evil")
+	write(filepath.Join(root, TreeConfigFile), "f = mp3\nb = 192k\nffmpeg = ./evil\nlog-file = "+strconv.Quote(evil)+"\n")
+	write(user, "f = flac\nb = 256k\nv\n")
+	opts, _ := NewExporterOptions([]string{prog, root, output}, DefaulConverterOptions)
+	if opts == nil {
+		t.Fatal("Failed with a tree config")
+	}
+	if opts.Format != "mp3" || opts.BitRate != "192k" || !opts.Verbose {
+		t.Errorf("Expected the tree config over the user config: -f %s -b %s -v %v", opts.Format, opts.BitRate, opts.Verbose)
+	} else if opts.FFmpeg == "./evil" {
+		t.Error("The tree config shouldn't choose what gets run")
+	} else if opts.LogFile != "" {
+		t.Errorf("The tree config shouldn't choose files to write: -log-file %s", opts.LogFile)
+	}
+	opts, _ = NewExporterOptions([]string{prog, "-b", "320k", root, output}, DefaulConverterOptions)
+	if opts == nil || opts.Format != "mp3" || opts.BitRate != "320k" {
+		t.Errorf("Expected the command line over the tree config: %+v", opts)
+	}
+	opts, _ = NewExporterOptions([]string{prog, "-config", forced, root, output}, DefaulConverterOptions)
+	if opts != nil {
+		t.Errorf("Expected the bad -config to fail: %+v", opts)
+	}
+	write(forced, "f = flac\n")
+	opts, _ = NewExporterOptions([]string{prog, "-config", forced, root, output}, DefaulConverterOptions)
+	if opts == nil || opts.Format != "flac" {
+		t.Errorf("-config should skip the tree config: %+v", opts)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fields := map[string]Field{
+		"LogFile":          FieldLogFile,
+		"NoClobber":        FieldNoClobber,
+		"Overwrite":        FieldOverwrite,
+		"Verbose":          FieldVerbose,
+		"Quiet":            FieldQuiet,
+		"NoExecHooks":      FieldNoExecHooks,
+		"FFmpeg":           FieldFFmpeg,
+		"InputFile":        FieldInputFile,
+		"OutputFile":       FieldOutputFile,
+		"ArtFile":          FieldArtFile,
+		"BitRate":          FieldBitRate,
+		"Codec":            FieldCodec,
+		"CoverArtFormat":   FieldCoverArtFormat,
+		"Scale":            FieldScale,
+		"InputExtensions":  FieldInputExtensions,
+		"OutputExtensions": FieldOutputExtensions,
+		"Channels":         FieldChannels,
+		"SampleRate":       FieldSampleRate,
+		"MemoryLimit":      FieldMemoryLimit,
+		"TargetSize":       FieldTargetSize,
+		"ArtFallback":      FieldArtFallback,
+		"Atomic":           FieldAtomic,
+		"PipeFormat":       FieldPipeFormat,
+		"Start":            FieldStart,
+		"Duration":         FieldDuration,
+		"FadeIn":           FieldFadeIn,
+		"FadeOut":          FieldFadeOut,
+		"Gapless":          FieldGapless,
+		"Metadata":         FieldMetadata,
+		"LogLevel":         FieldLogLevel,
+		"LogFormat":        FieldLogFormat,
+		"LogAppend":        FieldLogAppend,
+		"LogMaxSize":       FieldLogMaxSize,
+		"LogKeep":          FieldLogKeep,
+		"Threads":          FieldThreads,
+		"HWAccel":          FieldHWAccel,
+		"Nice":             FieldNice,
+		"IONice":           FieldIONice,
+	}
+	// Not options, only meaningful while parsing, or only used by the to_*
+	// tools, which have nothing to merge, so never merged.
+	notMerged := []string{"GlobalOptions", "Err", "PrintVersion", "Config", "Explicit", "InputFiles", "MaxJobs"}
+	for _, typ := range []reflect.Type{reflect.TypeFor[GlobalOptions](), reflect.TypeFor[ConverterOptions]()} {
+		for i := range typ.NumField() {
+			f := typ.Field(i)
+			if _, ok := fields[f.Name]; f.IsExported() && !ok && !slices.Contains(notMerged, f.Name) {
+				t.Errorf("Merge doesn't handle %s.%s", typ.Name(), f.Name)
+			}
+		}
+	}
+
+	// Sets the named field of opts to a non-zero value that differs by n.
+	set := func(opts *ConverterOptions, name string, n int) {
+		v := reflect.ValueOf(opts).Elem().FieldByName(name)
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(fmt.Sprint("value", n))
+		case reflect.Bool:
+			v.SetBool(true)
+		case reflect.Int, reflect.Int64:
+			v.SetInt(int64(n))
+		case reflect.Float64:
+			v.SetFloat(float64(n))
+		case reflect.Slice:
+			v.Set(reflect.ValueOf([]string{fmt.Sprint("value", n)}))
+		default:
+			t.Fatalf("Unhandled kind for %s: %v", name, v.Kind())
+		}
+	}
+	get := func(opts *ConverterOptions, name string) any {
+		return reflect.ValueOf(opts).Elem().FieldByName(name).Interface()
+	}
+	for name, field := range fields {
+		t.Run(name, func(t *testing.T) {
+			source := &ConverterOptions{}
+			set(source, name, 1)
+
+			// Unset, so it comes from source.
+			opts := &ConverterOptions{}
+			opts.Merge(source)
+			if !reflect.DeepEqual(get(opts, name), get(source, name)) {
+				t.Errorf("Expected %v from source, have %v", get(source, name), get(opts, name))
+			}
+			// Already set, so it's kept.
+			opts = &ConverterOptions{}
+			set(opts, name, 2)
+			want := get(opts, name)
+			opts.Merge(source)
+			if !reflect.DeepEqual(get(opts, name), want) {
+				t.Errorf("Expected %v to be kept, have %v", want, get(opts, name))
+			}
+			// Explicitly zero, so it's kept too.
+			opts = &ConverterOptions{Explicit: field}
+			want = get(opts, name)
+			opts.Merge(source)
+			if !reflect.DeepEqual(get(opts, name), want) {
+				t.Errorf("Expected the explicit zero to be kept, have %v", get(opts, name))
+			}
+			// And the other way around, an explicit zero from source is taken.
+			source, opts = &ConverterOptions{Explicit: field}, &ConverterOptions{}
+			opts.Merge(source)
+			if opts.Explicit&field == 0 {
+				t.Errorf("Expected the explicit zero from source, have %v", get(opts, name))
+			}
+		})
+	}
+
+	source := &ConverterOptions{InputExtensions: []string{".flac"}}
+	opts := &ConverterOptions{}
+	opts.Merge(source)
+	source.InputExtensions[0] = ".wav"
+	if opts.InputExtensions[0] != ".flac" {
+		t.Errorf("Merge should copy slices, not share them: %q", opts.InputExtensions)
+	}
+	opts.Merge(nil)
+}
+
+func TestMarkExplicit(t *testing.T) {
+	prog, input, output := setup(t)
+	opts, _ := NewConverterOptions([]string{prog, "-art-fallback=false", "-m", input, output}, DefaulConverterOptions)
+	if opts == nil {
+		t.Fatal("Failed to parse")
+	}
+	if want := FieldArtFallback | FieldChannels; opts.Explicit != want {
+		t.Errorf("Explicit is %b, expected %b", opts.Explicit, want)
+	}
+	opts.Merge(&ConverterOptions{ArtFallback: true})
+	if opts.ArtFallback {
+		t.Error("Merge overrode -art-fallback=false")
+	}
+}
+
+func TestFindArg(t *testing.T) {
+	prog, input, output := setup(t)
+	opts, _ := NewConverterOptions([]string{prog, input, output}, DefaulConverterOptions)
+	if opts == nil {
+		t.Fatal("Failed on default args")
+	}
+	for _, tc := range []struct {
+		args  []string
+		value string
+		found bool
+	}{
+		{[]string{"-config", "file", input, output}, "file", true},
+		{[]string{"--config=file", input, output}, "file", true},
+		{[]string{"-v", "-b", "256k", "-config", "file", input, output}, "file", true},
+		// The value of another flag, not -config itself.
+		{[]string{"-b", "-config", "-config", "file", input, output}, "file", true},
+		{[]string{"-log-file", "-config", input, output}, "", false},
+		// Parsing stops at the first argument.
+		{[]string{input, "-config", "file", output}, "", false},
+		{[]string{"--", "-config", "file"}, "", false},
+		{[]string{"-config"}, "", false},
+	} {
+		if value, found := opts.findArg(tc.args, "config"); value != tc.value || found != tc.found {
+			t.Errorf("findArg(%q) = %q, %v: expected %q, %v", tc.args, value, found, tc.value, tc.found)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	defer func(names func() []string) { FormatNames = names }(FormatNames)
+	FormatNames = func() []string {
+		return []string{"flac", "m4a"}
+	}
+	prog, input, output := setup(t)
+	opts, _ := NewExporterOptions([]string{prog, input, output}, DefaulConverterOptions)
+	if opts == nil {
+		t.Fatal("Failed on default args")
+	}
+	for shell, want := range map[string][]string{
+		"bash": {"-watch-spill|--watch-spill)", "compgen -W 'flac m4a'", "compgen -W 'copy mjpeg png none'", "complete -o filenames -F _go_test 'go test'"},
+		"fish": {"-o watch-spill", "-o f -d 'Set the output extension/format: flac, m4a.' -x -a 'flac m4a'", "__fish_complete_directories"},
+		"zsh":  {"#compdef", `'-f[Set the output extension/format\: flac, m4a.]:string:(flac m4a)'`, "'-v[Set verbose mode\\: also show the log on stdout.]'", "'-log-file[Log to FILE, or - for stdout.]:FILE:_files'"},
+	} {
+		var b bytes.Buffer
+		if err := opts.writeCompletion(&b, shell); err != nil {
+			t.Errorf("%s: %v", shell, err)
+		}
+		for _, s := range want {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s completion is missing %q:\n%s", shell, s, b.String())
+			}
+		}
+		if strings.Contains(b.String(), "completion") {
+			t.Errorf("%s completion should leave out the hidden -completion flag", shell)
+		}
+	}
+	if err := opts.writeCompletion(io.Discard, "tcsh"); err == nil {
+		t.Error("Expected an error for an unsupported shell")
+	}
+	if opts, _ := NewExporterOptions([]string{prog, "-completion", "tcsh", input, output}, DefaulConverterOptions); opts != nil {
+		t.Error("Expected -completion to stop the program")
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"fmt"
+	"os"
+)
+
+type ProberOptions struct {
+	GlobalOptions
+	Files     []string // Files, or directories with -R.
+	Recursive bool
+	JSON      bool
+}
+
+func NewProberOptions(args []string) (*ProberOptions, Outcome) {
+	opts := &ProberOptions{}
+	opts.AddOptions(args)
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
+	}
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
+	}
+	return opts, OutcomeRun
+}
+
+func (opts *ProberOptions) Usage() {
+	opts.printf("%s [options] {file...}\n", opts.fs.Name())
+	opts.printf("%s [options] -R {directory...}\n", opts.fs.Name())
+	opts.printf("\nPrints the codec, sample rate, channels, bit rate, duration, tags, and cover art of each {file} using ffprobe.\n")
+	opts.printf("With -R, every media file under each {directory} is printed.\n\n")
+	opts.fs.PrintDefaults()
+}
+
+func (opts *ProberOptions) AddOptions(args []string) {
+	fs := AddGlobalOptions(args, &opts.GlobalOptions)
+	fs.BoolVar(&opts.Recursive, "R", false, "Print every media file in each {directory} and its subdirectories.")
+	fs.BoolVar(&opts.JSON, "json", false, "Print JSON rather than a table.")
+	fs.Usage = opts.Usage
+}
+
+func (opts *ProberOptions) Parse(args []string) error {
+	if opts.Err = opts.parse(args); opts.Err != nil {
+		return opts.Err
+	}
+	opts.Files = opts.fs.Args()
+	return nil
+}
+
+func (opts *ProberOptions) Validate() error {
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
+	if len(opts.Files) == 0 {
+		return fmt.Errorf("must specify files to probe")
+	} else if !opts.Recursive {
+		return nil
+	}
+	for _, dir := range opts.Files {
+		if st, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("input directory: %w", err)
+		} else if !st.IsDir() {
+			return fmt.Errorf("input %q must be a directory with -R", dir)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package options
+
+import (
+	"audio_converter/internal/filesystem"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+type VerifierOptions struct {
+	GlobalOptions
+	InRoot      string
+	OutRoot     string
+	Format      string
+	CleanPaths  string
+	LossyPolicy string
+	ReportFile  string
+	MaxJobs     int
+	Decode      bool
+
+	CleanPathsStrict bool
+	NormalizeNames   string
+	MaxNameBytes     int
+	MaxPathBytes     int
+	maxJobs          jobsFlag
+}
+
+func NewVerifierOptions(args []string) (*VerifierOptions, Outcome) {
+	opts := &VerifierOptions{}
+	opts.AddOptions(args)
+	if opts.Err = opts.Parse(args[1:]); opts.Err == nil {
+		opts.Err = opts.Validate()
+	}
+	if outcome := opts.finish(); outcome != OutcomeRun {
+		return nil, outcome
+	}
+	return opts, OutcomeRun
+}
+
+func (opts *VerifierOptions) Usage() {
+	opts.printf("%s [options] {input directory} {output directory}\n", opts.fs.Name())
+	opts.printf("\nChecks that {output directory} is a complete export of {input directory}, as made by export_audio_tree.\n")
+	opts.printf("Every media file must have a non-empty output, named as -f, -cleanpaths, and -lossy-policy would name it.\n")
+	opts.printf("Files in {output directory} without a source are reported as extra. Exits non-zero if anything is wrong.\n\n")
+	opts.fs.PrintDefaults()
+}
+
+func (opts *VerifierOptions) AddOptions(args []string) {
+	fs := AddGlobalOptions(args, &opts.GlobalOptions)
+	opts.dirArgs = true
+	formatHelp := "The output extension/format the media files were exported as."
+	if FormatNames != nil {
+		formatHelp = "The output extension/format the media files were exported as: " + strings.Join(FormatNames(), ", ") + "."
+	}
+	fs.StringVar(&opts.Format, "f", "m4a", formatHelp)
+	fs.StringVar(&opts.CleanPaths, "cleanpaths", "", "The `TEXT` that replaced reserved characters in output file names, if any.")
+	fs.BoolVar(&opts.CleanPathsStrict, "cleanpaths-strict", true, "Whether -cleanpaths also renamed Windows device names and trailing dots and spaces. (default true)")
+	fs.StringVar(&opts.NormalizeNames, "normalize-names", filesystem.NormalizeNone, "The Unicode normalization `FORM` output names were converted to: nfc, nfd, or none.")
+	fs.IntVar(&opts.MaxNameBytes, "max-name-bytes", filesystem.DefaultMaxNameBytes, "The `BYTES` output names were shortened to. 0 if they weren't.")
+	fs.IntVar(&opts.MaxPathBytes, "max-path-bytes", 0, "The `BYTES` output paths were shortened to. 0 if they weren't.")
+	fs.StringVar(&opts.LossyPolicy, "lossy-policy", LossyConvert, "How lossy files like mp3 and m4a were exported: convert, copy, or skip.")
+	fs.BoolVar(&opts.Decode, "decode", false, "Also decode each output with ffmpeg to make sure it's intact.")
+	fs.Var(&opts.maxJobs, "j", "With -decode, sets the maximum number of concurrent `JOBS`, or a percentage of the CPUs, e.g., 50%.")
+	fs.StringVar(&opts.ReportFile, "report", "", "Write the discrepancies found to `FILE` as JSON.")
+	fs.Usage = opts.Usage
+}
+
+func (opts *VerifierOptions) Parse(args []string) error {
+	if opts.Err = opts.parse(args); opts.Err != nil {
+		return opts.Err
+	}
+	opts.InRoot = opts.fs.Arg(0)
+	opts.OutRoot = opts.fs.Arg(1)
+	return nil
+}
+
+func (opts *VerifierOptions) Validate() error {
+	if err := opts.validateLogFile(); err != nil {
+		return err
+	}
+	if err := opts.validateFFmpeg(); err != nil {
+		return err
+	}
+	opts.Format = strings.ToLower(opts.Format)
+	if FormatNames != nil && !slices.Contains(FormatNames(), opts.Format) {
+		return fmt.Errorf("unsupported format: %q", opts.Format)
+	}
+	switch opts.LossyPolicy {
+	case LossyConvert, LossyCopy, LossySkip:
+	default:
+		return fmt.Errorf("unsupported -lossy-policy: %q", opts.LossyPolicy)
+	}
+	for _, c := range opts.CleanPaths {
+		for _, s := range filesystem.ReservedCharacters {
+			if strings.ContainsRune(s, c) {
+				return fmt.Errorf("cannot include reserved character %c in -cleanpaths value", c)
+			}
+		}
+	}
+	if err := validateMaxBytes(opts.MaxNameBytes, opts.MaxPathBytes); err != nil {
+		return err
+	} else if err := validateNormalizeNames(opts.NormalizeNames); err != nil {
+		return err
+	}
+	var err error
+	if opts.MaxJobs, err = opts.maxJobs.jobs("-j", runtime.NumCPU()); err != nil {
+		return err
+	} else if opts.isSet("j") && !opts.Decode {
+		return fmt.Errorf("-j requires -decode")
+	}
+	if opts.ReportFile != "" {
+		name, err := expandHome(opts.ReportFile)
+		if err != nil {
+			return fmt.Errorf("-report: %w", err)
+		}
+		opts.ReportFile = name
+	}
+
+	if opts.InRoot == "" {
+		return fmt.Errorf("must specify input directory")
+	} else if _, err := os.Stat(opts.InRoot); err != nil {
+		return fmt.Errorf("input directory: %w", err)
+	} else if opts.OutRoot == "" {
+		return fmt.Errorf("must specify output directory")
+	} else if _, err := os.Stat(opts.OutRoot); err != nil {
+		return fmt.Errorf("output directory: %w", err)
+	}
+	return checkRoots(opts.InRoot, opts.OutRoot)
+}
@@ -4,8 +4,11 @@ package options
 
 import (
 	"debug/buildinfo"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"runtime/debug"
 )
 
 // This should be something like '<module version>-<date time>-<short
@@ -17,6 +20,13 @@ var Version string = "unknown"
 // Used by the various option parsers to indicate --version / print it and exit.
 var PrintVersion bool
 
+// Returned by the option parsers after printing the version for --version.
+// Like flag.ErrHelp, the constructors return nil, but the program exits 0.
+var ErrVersionRequested = errors.New("version requested")
+
+// The build info read in init, or nil if that failed.
+var buildInfo *debug.BuildInfo
+
 // This avoids having to use go generate as part of the build to generate a
 // value from git and then use go embed to include it. Which would require some
 // conditional compilation based on the shell. It's less flexible, but also 90%
@@ -28,5 +38,26 @@ func init() {
 		fmt.Fprintf(os.Stderr, "buildinfo.ReadFile: err: %v", err)
 	} else {
 		Version = info.Main.Version
+		buildInfo = info
+	}
+}
+
+// Writes the version of prog for --version, along with what it was built from
+// when that's known.
+func writeVersion(w io.Writer, prog string) {
+	fmt.Fprintf(w, "%s version %s\n", prog, Version)
+	if buildInfo == nil {
+		return
+	}
+	fmt.Fprintf(w, "go: %s\n", buildInfo.GoVersion)
+	settings := make(map[string]string)
+	for _, s := range buildInfo.Settings {
+		settings[s.Key] = s.Value
+	}
+	if revision, ok := settings["vcs.revision"]; ok {
+		fmt.Fprintf(w, "revision: %s\n", revision)
+	}
+	if modified, ok := settings["vcs.modified"]; ok {
+		fmt.Fprintf(w, "dirty: %s\n", modified)
 	}
 }
@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+// Package proc lowers the priority of the processes we start, e.g., so that an
+// export doesn't make the rest of the desktop sluggish. Priorities can only be
+// lowered this way, since raising them needs privileges we shouldn't have.
+package proc
+
+// The I/O scheduling classes for SetIOClass.
+const (
+	// Only does I/O when no other process wants the disk.
+	IOClassIdle = "idle"
+	// The normal class, with a priority that follows the niceness.
+	IOClassBestEffort = "best-effort"
+)
+
+// The highest niceness, for the lowest priority.
+const MaxNice = 19
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// Whether SetPriority and SetIOClass are implemented on this platform.
+const (
+	PrioritySupported   = true
+	IOPrioritySupported = true
+)
+
+// From linux/ioprio.h, which x/sys/unix doesn't have.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// Sets the niceness of the process pid to nice. On Linux, the niceness belongs
+// to each thread, so every thread the process has so far is set, and those it
+// starts later inherit it.
+func SetPriority(pid, nice int) error {
+	return eachThread(pid, func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
+
+// Sets the I/O scheduling class of the process pid to class, one of the
+// IOClass constants. Like SetPriority, it's set for every thread.
+func SetIOClass(pid int, class string) error {
+	var prio uintptr
+	switch class {
+	case IOClassIdle:
+		prio = ioprioClassIdle << ioprioClassShift
+	case IOClassBestEffort:
+		prio = ioprioClassBE << ioprioClassShift
+	default:
+		return fmt.Errorf("unsupported I/O class %q", class)
+	}
+	return eachThread(pid, func(tid int) error {
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prio)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	})
+}
+
+// Calls fn with the ID of each thread of the process pid. Threads that exit
+// before fn gets to them are ignored.
+func eachThread(pid int, fn func(tid int) error) error {
+	entries, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		// Without /proc, the main thread is the best that can be done.
+		return fn(pid)
+	}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil && !errors.Is(err, unix.ESRCH) {
+			return fmt.Errorf("thread %d of process %d: %w", tid, pid, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+package proc
+
+import (
+	"os/exec"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Starts a process to change the priority of, which is killed once the test is
+// done.
+func startChild(t *testing.T) int {
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip("sleep isn't available:", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd.Process.Pid
+}
+
+func TestSetPriority(t *testing.T) {
+	pid := startChild(t)
+	if err := SetPriority(pid, 15); err != nil {
+		t.Fatal(err)
+	}
+	// The kernel returns 20 - nice, so that it's never negative.
+	if prio, err := unix.Getpriority(unix.PRIO_PROCESS, pid); err != nil {
+		t.Fatal(err)
+	} else if nice := 20 - prio; nice != 15 {
+		t.Errorf("Expected a niceness of 15, got %d", nice)
+	}
+}
+
+func TestSetIOClass(t *testing.T) {
+	pid := startChild(t)
+	for class, expected := range map[string]uintptr{IOClassIdle: ioprioClassIdle, IOClassBestEffort: ioprioClassBE} {
+		if err := SetIOClass(pid, class); err != nil {
+			t.Fatal(err)
+		}
+		prio, _, errno := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(pid), 0)
+		if errno != 0 {
+			t.Fatal(errno)
+		} else if prio>>ioprioClassShift != expected {
+			t.Errorf("Expected the %s class %d, got %#x", class, expected, prio)
+		}
+	}
+	if err := SetIOClass(pid, "realtime"); err == nil {
+		t.Error("Only idle and best-effort should be supported")
+	}
+}
@@ -0,0 +1,22 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build !linux && !darwin && !freebsd
+
+package proc
+
+import "errors"
+
+// Whether SetPriority and SetIOClass are implemented on this platform.
+const (
+	PrioritySupported   = false
+	IOPrioritySupported = false
+)
+
+func SetPriority(pid, nice int) error {
+	return errors.ErrUnsupported
+}
+
+func SetIOClass(pid int, class string) error {
+	return errors.ErrUnsupported
+}
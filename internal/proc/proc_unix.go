@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+//go:build darwin || freebsd
+
+package proc
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Whether SetPriority and SetIOClass are implemented on this platform.
+const (
+	PrioritySupported   = true
+	IOPrioritySupported = false
+)
+
+// Sets the niceness of the process pid to nice.
+func SetPriority(pid, nice int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, pid, nice)
+}
+
+func SetIOClass(pid int, class string) error {
+	return errors.ErrUnsupported
+}
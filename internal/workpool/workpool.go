@@ -0,0 +1,542 @@
+// SPDX-License-Identifier: Zlib
+// Copyright 2025, Terry M. Poulin.
+
+// Package workpool runs tasks on a bounded, growing set of goroutines, e.g.,
+// the conversions of export_audio_tree.
+package workpool
+
+import (
+	"audio_converter/internal/logging"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Wrapped by the error for a task that panicked.
+var ErrTaskPanicked = errors.New("task panicked")
+
+// Returned by AddContext when the pool is shutting down.
+var ErrPoolStopped = errors.New("work pool is shutting down")
+
+// Defines a work pool for executing callbacks.
+//
+// Work to be done is defined by a simple function, which will execute on the
+// next available worker. If the queue is full, a batch of additional goroutines
+// will be created up to the defined limit.
+//
+// Unlike a channel and infinite goroutines, this places a limit on how many can
+// conversions can concurrently exist. The point is to convert audio, and memory
+// nor process cycles are infinite when users are doing other work at the same
+// time. Likewise, slower I/O devices such as memory cards will choke under
+// heavy concurrent I/O.
+//
+// Cheap tasks can be added with a high priority, so that they aren't stuck
+// behind a queue full of slow ones. Workers take high priority tasks first.
+//
+// Unlike a channel and a fixed number of goroutines, this allows some measure
+// of dynamic scaling. A low limit can restrict resource usage during export. A
+// high limit can ramp up more concurrent exports if you're willing to dedicated
+// excessive resources, but don't always export such a large collection.
+type WorkPool struct {
+	parent context.Context    // Used to derive ctx on each start.
+	ctx    context.Context    // Used for shutdown of the pool.
+	cancel context.CancelFunc // Used for shutdown of the pool.
+	buffer int                // Buffer size for queue.
+	size   int                // Number of goroutines in the pool.
+	limit  int                // Max value for size.
+	wg     sync.WaitGroup     // Used for shutdown of the pool.
+	mutex  sync.Mutex         // Protects the size field.
+	queue  chan poolTask      // Channel of tasks for the goroutines.
+	high   chan poolTask      // Like queue, but taken first.
+	nextID int                // Identifies the next worker. Protected by mutex.
+
+	// Protects queue, high, and ctx, which are replaced when the pool is restarted.
+	// Adds hold the read lock while sending, so the queue can't be closed out
+	// from under them. Acquire before mutex when holding both.
+	queueMutex sync.RWMutex
+
+	// Errors returned by tasks since the pool was started. This has its own
+	// mutex, since Wait holds the other while the workers finish.
+	errMutex sync.Mutex
+	errs     []error
+	panics   atomic.Int32 // Tasks that panicked over the life of the pool.
+
+	// What each worker is running, by worker id, for Running.
+	runMutex sync.Mutex
+	running  map[int]TaskInfo
+
+	// Counters for PoolStats, over the life of the pool. These are atomic, so
+	// that they don't add contention to adding and running tasks.
+	submitted atomic.Int64
+	started   atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
+	maxQueued atomic.Int64
+}
+
+// A task waiting in the queue, with the label it was added with.
+type poolTask struct {
+	name string
+	fn   func() error
+}
+
+// A task being run by a worker, as returned by WorkPool.Running.
+type TaskInfo struct {
+	Worker  int       // Identifies the worker running the task.
+	Name    string    // As given to AddNamed, or empty.
+	Started time.Time // When the worker picked up the task.
+}
+
+// Counts of the tasks handled by a WorkPool over its life, for tuning -j and
+// -q.
+type PoolStats struct {
+	Submitted int64 `json:"submitted"`  // Added to the queue.
+	Started   int64 `json:"started"`    // Picked up by a worker.
+	Completed int64 `json:"completed"`  // Finished, whether or not they failed.
+	Failed    int64 `json:"failed"`     // Returned an error or panicked.
+	MaxQueued int64 `json:"max_queued"` // The most tasks waiting in the queue at once.
+}
+
+// Returns the number of tasks that have started but not completed.
+func (s PoolStats) InFlight() int64 {
+	return s.Started - s.Completed
+}
+
+// Creates a new work pool. Call Start() to spawn the initial workers and use
+// Add() to push callbacks onto the work queue for execution.
+//
+// A maximum number of goroutines can be specified with [limit]. If 0, the limit
+// is [runtime.NumCPU].
+//
+// The queue size will be set to [buffer], or a default value if 0 was provided.
+func NewWorkPool(parent context.Context, limit int, buffer int) *WorkPool {
+	ctx, cancel := context.WithCancel(parent)
+	if limit == 0 {
+		limit = runtime.NumCPU()
+	}
+	if buffer == 0 {
+		buffer = max(limit, 100)
+	}
+	return &WorkPool{
+		parent:  parent,
+		ctx:     ctx,
+		cancel:  cancel,
+		limit:   limit,
+		buffer:  buffer,
+		queue:   make(chan poolTask, buffer),
+		high:    make(chan poolTask, buffer),
+		running: make(map[int]TaskInfo),
+	}
+}
+
+// Spawns a set of workers. Up to [runtime.NumCPU] or the pool limit will be
+// created. Additional goroutines will be generated as necessary up to the
+// limit.
+func (p *WorkPool) Start() {
+	p.queueMutex.Lock()
+	defer p.queueMutex.Unlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.init()
+}
+
+// Peforms initialization of the pool. This must be called while holding
+// p.queueMutex and p.mutex.
+func (p *WorkPool) init() {
+	if p.size > 0 {
+		panic("init called on running WorkPool!")
+	}
+	// Stop() cancels the context, so a restarted pool needs a fresh one.
+	if p.ctx.Err() != nil {
+		p.ctx, p.cancel = context.WithCancel(p.parent)
+	}
+	p.queue = make(chan poolTask, p.buffer)
+	p.high = make(chan poolTask, p.buffer)
+	p.errMutex.Lock()
+	p.errs = nil
+	p.errMutex.Unlock()
+	ncpu := runtime.NumCPU()
+	for i := 0; i < ncpu && i < p.limit; i++ {
+		p.wg.Add(1)
+		p.size++
+		p.nextID++
+		go p.worker(p.ctx, p.nextID, p.queue, p.high)
+	}
+}
+
+// Stop all workers and abort tasks remaining in the queue. This will block
+// until everyone finishes their current item and halts. If the parent context
+// is closed, this occurs automatically. After calling this returns, you must
+// call [Start] before it is possible to add any more items to the queue.
+func (p *WorkPool) Stop() {
+	// Cancel before taking the lock, so an Add blocked on a full queue gives
+	// up rather than holding it forever.
+	p.queueMutex.RLock()
+	p.cancel()
+	p.queueMutex.RUnlock()
+
+	// Acquire the locks to prevent anyone calling Add().
+	p.queueMutex.Lock()
+	defer p.queueMutex.Unlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.size == 0 {
+		panic("WorkPool.Stop called when already stopped")
+	}
+
+	// Halt the workers at their next tick.
+	p.wg.Wait()
+	p.size = 0
+
+	// There may be items remaining in the queue. To ensure they're subject to
+	// GC, they or the queue must go. Adds are locked out until Start replaces
+	// the queue, so we close and drain it.
+	for _, queue := range []chan poolTask{p.queue, p.high} {
+		close(queue)
+		for range queue {
+			// We don't want to execute, just ensure the channel doesn't retain
+			// the memory.
+		}
+	}
+}
+
+// Drain the queue and halt all workers. This can be used to wait for the
+// completion of currently queued callbacks. Returns the errors returned by
+// tasks since the pool was started, joined together, or nil if there were
+// none.
+func (p *WorkPool) Wait() error {
+	// Workers will halt once the queue drains. Adds in progress finish sending
+	// before the lock is granted, and later ones wait for the new queue.
+	p.queueMutex.Lock()
+	defer p.queueMutex.Unlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	close(p.queue)
+	close(p.high)
+	p.wg.Wait()
+	p.size = 0
+	err := errors.Join(p.Errors()...)
+	// Restart the queue and initial goroutines. We perform this with a separate
+	// init method, because if we unlocked in order to call Start(), an Add()
+	// called asyncronously with Wait() could see the pool stopped (p.size==0)
+	// and panic, depending on which goroutine obtained the lock first.
+	p.init()
+	return err
+}
+
+// Add a callback to the work queue. If the queue is full, additional goroutines
+// will be spawned up to the limit. By default, the queue is
+//
+// If the pool is shutting down, e.g., because the parent context was
+// cancelled, fn is discarded rather than blocking forever on a full queue.
+func (p *WorkPool) Add(fn func()) {
+	p.AddE(func() error {
+		fn()
+		return nil
+	})
+}
+
+// Like Add, but with a label for fn that's included in Running and the error
+// if it panics. E.g., the file being exported.
+func (p *WorkPool) AddNamed(name string, fn func()) {
+	p.AddNamedContext(context.Background(), name, func() error {
+		fn()
+		return nil
+	})
+}
+
+// Like Add, but an error returned by fn is collected for Errors and Wait.
+//
+// It is safe to call concurrently with Wait, in which case fn goes to either
+// the queue being drained or the restarted one.
+func (p *WorkPool) AddE(fn func() error) {
+	// The only error is the pool shutting down, and fn is discarded.
+	p.AddContext(context.Background(), fn)
+}
+
+// Like Add, but fn is run before any tasks added with normal priority. Use
+// this for cheap tasks, like copying small files.
+func (p *WorkPool) AddHigh(fn func()) {
+	p.AddHighContext(context.Background(), func() error {
+		fn()
+		return nil
+	})
+}
+
+// Like AddE, but gives up waiting for room in the queue when ctx is done,
+// returning its cause. If the pool is shutting down, fn is discarded and
+// ErrPoolStopped is returned.
+func (p *WorkPool) AddContext(ctx context.Context, fn func() error) error {
+	return p.add(ctx, false, poolTask{fn: fn})
+}
+
+// Like AddContext, but with the high priority of AddHigh.
+func (p *WorkPool) AddHighContext(ctx context.Context, fn func() error) error {
+	return p.add(ctx, true, poolTask{fn: fn})
+}
+
+// Like AddContext, but with a label for fn like AddNamed.
+func (p *WorkPool) AddNamedContext(ctx context.Context, name string, fn func() error) error {
+	return p.add(ctx, false, poolTask{name: name, fn: fn})
+}
+
+// Like AddHighContext, but with a label for fn like AddNamed.
+func (p *WorkPool) AddHighNamedContext(ctx context.Context, name string, fn func() error) error {
+	return p.add(ctx, true, poolTask{name: name, fn: fn})
+}
+
+// Does the work of the Add methods, using the high priority queue if high is
+// set.
+func (p *WorkPool) add(ctx context.Context, high bool, task poolTask) error {
+	if err := ctx.Err(); err != nil {
+		return context.Cause(ctx)
+	}
+	p.queueMutex.RLock()
+	defer p.queueMutex.RUnlock()
+	p.expand()
+	queue := p.queue
+	if high {
+		queue = p.high
+	}
+	select {
+	case queue <- task:
+		p.submitted.Add(1)
+		p.noteQueued()
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-p.ctx.Done():
+		return ErrPoolStopped
+	}
+}
+
+// Like AddE, but returns false rather than blocking when the queue is full and
+// the pool can't grow, or is shutting down.
+func (p *WorkPool) TryAdd(fn func() error) bool {
+	return p.tryAdd(false, poolTask{fn: fn})
+}
+
+// Like TryAdd, but with a label for fn like AddNamed. If high is set, fn has
+// the high priority of AddHigh.
+func (p *WorkPool) TryAddNamed(name string, high bool, fn func() error) bool {
+	return p.tryAdd(high, poolTask{name: name, fn: fn})
+}
+
+// Does the work of the TryAdd methods, using the high priority queue if high
+// is set.
+func (p *WorkPool) tryAdd(high bool, task poolTask) bool {
+	p.queueMutex.RLock()
+	defer p.queueMutex.RUnlock()
+	p.expand()
+	if p.ctx.Err() != nil {
+		return false
+	}
+	queue := p.queue
+	if high {
+		queue = p.high
+	}
+	select {
+	case queue <- task:
+		p.submitted.Add(1)
+		p.noteQueued()
+		return true
+	default:
+		return false
+	}
+}
+
+// Possibly expands the work pool. Up to 4 workers are created if the queue is
+// full, provided the limit has not been reached. This must be called while
+// holding p.queueMutex for reading.
+func (p *WorkPool) expand() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.size == 0 {
+		panic("WorkPool is not running")
+	}
+	if p.size == p.limit {
+		// Pool can't grow any further.
+		return
+	}
+
+	if p.remaining() > 0 {
+		return
+	}
+
+	// Up to the limit, or this many new go routines.
+	growth := min(4, p.limit-p.size)
+
+	for range growth {
+		p.wg.Add(1)
+		p.size++
+		p.nextID++
+		go p.worker(p.ctx, p.nextID, p.queue, p.high)
+	}
+}
+
+// Runs task on the worker with the given id, turning a panic into an error so
+// that one bad task doesn't take down the whole process.
+func (p *WorkPool) run(id int, task poolTask) (err error) {
+	p.runMutex.Lock()
+	p.running[id] = TaskInfo{Worker: id, Name: task.name, Started: time.Now()}
+	p.runMutex.Unlock()
+	defer func() {
+		p.runMutex.Lock()
+		delete(p.running, id)
+		p.runMutex.Unlock()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			p.panics.Add(1)
+			if task.name != "" {
+				err = fmt.Errorf("%w: %s: %v\n%s", ErrTaskPanicked, task.name, r, debug.Stack())
+			} else {
+				err = fmt.Errorf("%w: %v\n%s", ErrTaskPanicked, r, debug.Stack())
+			}
+			logging.Println(err)
+		}
+	}()
+	return task.fn()
+}
+
+// Returns the tasks being run right now, longest running first. Useful for
+// finding one that's hung.
+func (p *WorkPool) Running() []TaskInfo {
+	p.runMutex.Lock()
+	defer p.runMutex.Unlock()
+	tasks := slices.Collect(maps.Values(p.running))
+	slices.SortFunc(tasks, func(a, b TaskInfo) int {
+		return a.Started.Compare(b.Started)
+	})
+	return tasks
+}
+
+// Raises the high-water mark of the queue, if it's longer than ever. This must
+// be called while holding p.queueMutex for reading.
+func (p *WorkPool) noteQueued() {
+	n := int64(p.queued())
+	for old := p.maxQueued.Load(); n > old; old = p.maxQueued.Load() {
+		if p.maxQueued.CompareAndSwap(old, n) {
+			break
+		}
+	}
+}
+
+// Returns counts of the tasks handled since the pool was created.
+func (p *WorkPool) Stats() PoolStats {
+	return PoolStats{
+		Submitted: p.submitted.Load(),
+		Started:   p.started.Load(),
+		Completed: p.completed.Load(),
+		Failed:    p.failed.Load(),
+		MaxQueued: p.maxQueued.Load(),
+	}
+}
+
+// Returns the number of tasks that have panicked since the pool was created.
+func (p *WorkPool) Panics() int {
+	return int(p.panics.Load())
+}
+
+// Returns the errors returned by tasks since the pool was started.
+func (p *WorkPool) Errors() []error {
+	p.errMutex.Lock()
+	defer p.errMutex.Unlock()
+	return append([]error(nil), p.errs...)
+}
+
+// Returns the approximate amount of queue space remaining. Tasks of either
+// priority count against the same buffer size.
+func (p *WorkPool) Remaining() int {
+	p.queueMutex.RLock()
+	defer p.queueMutex.RUnlock()
+	return p.remaining()
+}
+
+// Like Remaining, but must be called while holding p.queueMutex.
+func (p *WorkPool) remaining() int {
+	return max(0, p.buffer-p.queued())
+}
+
+// Returns the number of tasks of either priority in the queue. This must be
+// called while holding p.queueMutex.
+func (p *WorkPool) queued() int {
+	return len(p.queue) + len(p.high)
+}
+
+// Returns a percentage of how full the queue is.
+//
+// Like Remaining, but expressed as a percentage of usage rather than the count
+// of available slots. E.g., "6.0" means the queue is 6% full but Remaining()
+// might be saying 94 slots out of a 100 are free.
+func (p *WorkPool) PercentFull() float64 {
+	p.queueMutex.RLock()
+	defer p.queueMutex.RUnlock()
+	return min(float64(p.queued())/float64(p.buffer)*100.0, 100.0)
+}
+
+// Return the current size of the work pool.
+func (p *WorkPool) Size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.size
+}
+
+// Returns the maximum number of workers allowed.
+func (p *WorkPool) Limit() int {
+	return p.limit
+}
+
+// Runs tasks from high, then queue, until both are closed or ctx is done.
+// These are passed in, rather than read from p, since they're replaced when
+// the pool is restarted.
+func (p *WorkPool) worker(ctx context.Context, id int, queue, high <-chan poolTask) {
+	defer p.wg.Done()
+	for queue != nil || high != nil {
+		// A closed queue is set to nil, so that it's never selected again.
+		var task poolTask
+		var ok bool
+		select {
+		case <-ctx.Done():
+			// Pool is shutting down.
+			return
+		case task, ok = <-high:
+			if !ok {
+				high = nil
+				continue
+			}
+		default:
+			// Nothing urgent, so take whatever comes first.
+			select {
+			case <-ctx.Done():
+				return
+			case task, ok = <-high:
+				if !ok {
+					high = nil
+					continue
+				}
+			case task, ok = <-queue:
+				if !ok {
+					queue = nil
+					continue
+				}
+			}
+		}
+		p.started.Add(1)
+		err := p.run(id, task)
+		if err != nil {
+			p.failed.Add(1)
+			p.errMutex.Lock()
+			p.errs = append(p.errs, err)
+			p.errMutex.Unlock()
+		}
+		p.completed.Add(1)
+	}
+}
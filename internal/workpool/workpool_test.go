@@ -0,0 +1,442 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type task struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	tid    int
+}
+
+func newTask(parent context.Context, tid int) *task {
+	ctx, cancel := context.WithTimeout(parent, 10*time.Second)
+	return &task{
+		tid:    tid,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func (task *task) String() string {
+	return fmt.Sprintln("task", task.tid)
+}
+
+func (task *task) run(t *testing.T) {
+	t.Logf("+++ %s started", task)
+	defer t.Logf("--- %s stopped", task)
+	defer task.cancel()
+	for {
+		select {
+		case <-t.Context().Done():
+			t.Logf("=== Test exiting")
+			return
+		case <-task.ctx.Done():
+			t.Logf("=== Task %d complete", task.tid)
+			return
+		}
+	}
+}
+
+func TestWorkPool(t *testing.T) {
+	t.Run("Basic attributes", func(t *testing.T) {
+		limit := 1024
+		buffer := 10
+		t.Logf("NewWorkPool(_, %d, %d)", limit, buffer)
+		p := NewWorkPool(t.Context(), limit, buffer)
+		if n := p.Limit(); n != limit {
+			t.Errorf("Bad limit: actual: %d expected: %d", n, limit)
+		}
+		if n := p.Remaining(); n != buffer {
+			t.Errorf("Bad buffer remaining: actual: %d expected: %d", n, buffer)
+		}
+		if f := p.PercentFull(); f < -0.001 || f > +0.001 {
+			t.Errorf("Bad percent full: actual: %f expected 0.0", f)
+		}
+		if s := p.Size(); s != 0 {
+			t.Errorf("Bad size: actual: %d expected: 0", s)
+		}
+	})
+	t.Run("Start stop", func(t *testing.T) {
+		t.Logf("Creating pool")
+		pool := NewWorkPool(t.Context(), 0, 0)
+		t.Logf("Starting pool")
+		pool.Start()
+
+		size := min(runtime.NumCPU()*2, pool.Remaining())
+		var tasks []*task
+		t.Logf("Adding some tasks")
+		for i := range size {
+			task := newTask(t.Context(), i)
+			tasks = append(tasks, task)
+			// Because items in the queue may or may not run, we can't include
+			// them in the wait group -- if they don't run, obviously they won't
+			// call done.
+			pool.Add(func() {
+				task.run(t)
+			})
+		}
+
+		var wg sync.WaitGroup
+
+		// Put the stop in its own goroutine.
+		wg.Add(1)
+		go func() {
+			t.Logf("Stopping pool")
+			pool.Stop()
+			t.Logf("All tasks completed")
+			wg.Done()
+		}()
+
+		// Mark each task complete after a random interval in microseconds.
+		for _, task := range tasks {
+			time.Sleep(time.Microsecond * time.Duration(rand.Intn(100)))
+			t.Logf("Marking %s complete", task)
+			task.cancel()
+		}
+		t.Logf("Waiting for all goroutines to finish")
+		wg.Wait()
+
+		// Mostly for completeness sake, let's make sure we can restart the pool
+		t.Logf("Restarting the pool")
+		pool.Start()
+		wg.Add(1)
+		pool.Add(func() {
+			t.Log("Restarting the pool worked")
+			wg.Done()
+		})
+		// Stop aborts whatever is left in the queue, so wait for the task first.
+		wg.Wait()
+		pool.Stop()
+	})
+
+	t.Run("start wait", func(t *testing.T) {
+		t.Logf("Create pool")
+		pool := NewWorkPool(t.Context(), 0, 0)
+		t.Logf("Start pool")
+		pool.Start()
+		defer pool.Stop()
+
+		ctx, done := context.WithCancel(t.Context())
+		task := newTask(ctx, 1)
+		pool.Add(func() { task.run(t) })
+
+		interval := time.Duration(rand.Intn(500)) * time.Microsecond
+		t.Logf("Marking %s done in %v", task, interval)
+		go func() {
+			time.Sleep(interval)
+			done()
+		}()
+
+		t.Logf("Waiting for %s to finish", task)
+		pool.Wait()
+
+		t.Logf("Restarting pool")
+		var wg sync.WaitGroup
+		wg.Add(1)
+		pool.Add(func() {
+			t.Log("Verifying add after wait doesn't crash")
+			wg.Done()
+		})
+		wg.Wait()
+		t.Logf("Adding task after wait worked")
+	})
+
+	t.Run("add after cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		pool := NewWorkPool(ctx, 1, 1)
+		pool.Start()
+
+		// Block the only worker, then fill the queue.
+		block := make(chan struct{})
+		pool.Add(func() { <-block })
+		pool.Add(func() {})
+		cancel()
+
+		done := make(chan struct{})
+		go func() {
+			pool.Add(func() { t.Error("Task added after cancel was executed") })
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Add blocked after the context was cancelled")
+		}
+		if err := pool.AddContext(t.Context(), func() error { return nil }); !errors.Is(err, ErrPoolStopped) {
+			t.Errorf("AddContext should fail once the pool is stopping: %v", err)
+		}
+		if pool.TryAdd(func() error { return nil }) {
+			t.Error("TryAdd should fail once the pool is stopping")
+		}
+		close(block)
+		pool.Wait()
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 1, 1)
+		pool.Start()
+		defer pool.Stop()
+
+		// Saturate the only worker, then fill the queue.
+		block := make(chan struct{})
+		started := make(chan struct{})
+		pool.Add(func() {
+			close(started)
+			<-block
+		})
+		<-started
+		if !pool.TryAdd(func() error { return nil }) {
+			t.Fatal("TryAdd failed with room in the queue")
+		}
+		if pool.TryAdd(func() error { return nil }) {
+			t.Error("TryAdd succeeded with a full queue")
+		}
+		if !pool.TryAddNamed("copy", true, func() error { return nil }) {
+			t.Error("TryAddNamed failed with room in the high priority queue")
+		}
+		if pool.TryAddNamed("copy", true, func() error { return nil }) {
+			t.Error("TryAddNamed succeeded with a full high priority queue")
+		}
+		ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+		defer cancel()
+		if err := pool.AddContext(ctx, func() error { return nil }); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("AddContext should give up when ctx is done: %v", err)
+		}
+
+		close(block)
+		if err := pool.Wait(); err != nil {
+			t.Error(err)
+		}
+		if err := pool.AddContext(t.Context(), func() error { return nil }); err != nil {
+			t.Errorf("AddContext failed with room in the queue: %v", err)
+		}
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 1, 10)
+		pool.Start()
+		defer pool.Stop()
+
+		// Hold the only worker while the queue fills up.
+		block := make(chan struct{})
+		started := make(chan struct{})
+		pool.Add(func() {
+			close(started)
+			<-block
+		})
+		<-started
+
+		var mutex sync.Mutex
+		var order []string
+		record := func(s string) func() {
+			return func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				order = append(order, s)
+			}
+		}
+		pool.Add(record("slow 1"))
+		pool.Add(record("slow 2"))
+		pool.AddHigh(record("quick 1"))
+		pool.AddHigh(record("quick 2"))
+		if n := pool.Remaining(); n != 6 {
+			t.Errorf("Both priorities should count against the buffer: %d remaining", n)
+		}
+		close(block)
+		pool.Wait()
+		expected := []string{"quick 1", "quick 2", "slow 1", "slow 2"}
+		if !slices.Equal(order, expected) {
+			t.Errorf("Bad order:\nactual  : %q\nexpected: %q", order, expected)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 4, 0)
+		pool.Start()
+		defer pool.Stop()
+
+		bad := errors.New("bad")
+		for i := range 10 {
+			pool.AddE(func() error {
+				if i%3 == 0 {
+					return fmt.Errorf("task %d: %w", i, bad)
+				}
+				return nil
+			})
+		}
+		pool.Add(func() {})
+		err := pool.Wait()
+		if !errors.Is(err, bad) {
+			t.Fatalf("Wait should return the task errors: %v", err)
+		}
+		if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 4 {
+			t.Errorf("Expected 4 errors, got %d: %v", n, err)
+		}
+
+		// Waiting restarts the pool, which starts over.
+		if errs := pool.Errors(); len(errs) != 0 {
+			t.Errorf("Errors should be cleared by restarting: %v", errs)
+		}
+		pool.AddE(func() error { return nil })
+		if err := pool.Wait(); err != nil {
+			t.Errorf("Expected no errors: %v", err)
+		}
+	})
+	t.Run("add during wait", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 4, 8)
+		pool.Start()
+		defer pool.Stop()
+
+		// Every task added lands in a queue that some Wait drains, so all of
+		// them run by the final Wait.
+		const adders, perAdder = 8, 500
+		var ran atomic.Int32
+		var adding sync.WaitGroup
+		stop := make(chan struct{})
+		waiting := make(chan struct{})
+		go func() {
+			defer close(waiting)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					pool.Wait()
+				}
+			}
+		}()
+		for range adders {
+			adding.Add(1)
+			go func() {
+				defer adding.Done()
+				for range perAdder {
+					pool.Add(func() { ran.Add(1) })
+				}
+			}()
+		}
+		adding.Wait()
+		close(stop)
+		<-waiting
+		pool.Wait()
+		if n := ran.Load(); n != adders*perAdder {
+			t.Errorf("Expected %d tasks to run, got %d", adders*perAdder, n)
+		}
+	})
+	t.Run("stats", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 2, 10)
+		pool.Start()
+		defer pool.Stop()
+
+		// Hold the workers, so that the queue backs up.
+		block := make(chan struct{})
+		for i := range 8 {
+			pool.AddE(func() error {
+				<-block
+				if i%4 == 0 {
+					return errors.New("bad")
+				}
+				return nil
+			})
+		}
+		if !pool.TryAdd(func() error { return nil }) {
+			t.Fatal("TryAdd failed with room in the queue")
+		}
+		close(block)
+		pool.Wait()
+
+		stats := pool.Stats()
+		if stats.Submitted != 9 || stats.Started != 9 || stats.Completed != 9 {
+			t.Errorf("Every submitted task should have completed: %+v", stats)
+		}
+		if stats.Failed != 2 || stats.InFlight() != 0 {
+			t.Errorf("Bad failed or in flight: %+v", stats)
+		}
+		if stats.MaxQueued < 7 || stats.MaxQueued > 9 {
+			t.Errorf("Bad high-water mark: %+v", stats)
+		}
+	})
+	t.Run("panics", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 1, 0)
+		pool.Start()
+
+		var ran sync.WaitGroup
+		ran.Add(3)
+		pool.Add(func() { panic("oops") })
+		for range 3 {
+			pool.Add(ran.Done)
+		}
+		ran.Wait()
+		if n := pool.Panics(); n != 1 {
+			t.Errorf("Expected 1 panic, got %d", n)
+		}
+		if err := pool.Wait(); !errors.Is(err, ErrTaskPanicked) {
+			t.Errorf("Wait should return the panic: %v", err)
+		} else if !strings.Contains(err.Error(), "oops") {
+			t.Errorf("The error should include the panic value: %v", err)
+		}
+
+		pool.AddNamed("bad.flac", func() { panic("oops") })
+		if err := pool.Wait(); !errors.Is(err, ErrTaskPanicked) || !strings.Contains(err.Error(), "bad.flac") {
+			t.Errorf("The error should include the task name: %v", err)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			pool.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop hung after a task panicked")
+		}
+	})
+	t.Run("running", func(t *testing.T) {
+		pool := NewWorkPool(t.Context(), 2, 0)
+		pool.Start()
+		defer pool.Stop()
+
+		// The pool only grows when the queue is full, so run as many tasks as
+		// there are workers. That's 1 on a single CPU.
+		names := []string{"one", "two"}[:pool.Size()]
+		block := make(chan struct{})
+		var started sync.WaitGroup
+		started.Add(len(names))
+		for _, name := range names {
+			pool.AddNamed(name, func() {
+				started.Done()
+				<-block
+			})
+		}
+		started.Wait()
+		running := pool.Running()
+		actual := make([]string, 0, len(running))
+		for _, task := range running {
+			actual = append(actual, task.Name)
+			if task.Started.IsZero() || task.Worker == 0 {
+				t.Errorf("Bad task info: %+v", task)
+			}
+		}
+		slices.Sort(actual)
+		if !slices.Equal(actual, names) {
+			t.Errorf("Bad running tasks: %+v", running)
+		}
+		close(block)
+		pool.Wait()
+		if running := pool.Running(); len(running) != 0 {
+			t.Errorf("Nothing should be running after Wait: %+v", running)
+		}
+	})
+}